@@ -0,0 +1,334 @@
+package activedirectory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"f0oster/adspy/config"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ConnectionState describes where the connection manager is in its lifecycle.
+type ConnectionState string
+
+const (
+	StateDisconnected ConnectionState = "disconnected"
+	StateConnecting   ConnectionState = "connecting"
+	StateConnected    ConnectionState = "connected"
+	StateBackoff      ConnectionState = "backoff"
+)
+
+// connectionManager owns the LDAP connection used by an ActiveDirectoryInstance. It detects
+// dead connections, reconnects with exponential backoff and fails over across domain controllers.
+type connectionManager struct {
+	cfg  config.DomainConfiguration
+	dcs  []string                            // candidate domain controllers in preference order
+	dial func(dc string) (*ldap.Conn, error) // connects and binds to a domain controller
+	ctx  context.Context                     // cancelled by close, ending any backoff in get
+	stop context.CancelFunc
+
+	mu         sync.Mutex
+	conn       *ldap.Conn
+	current    string // domain controller conn is bound to
+	next       int    // index into dcs of the next domain controller to try
+	generation uint64 // incremented on every successful bind, so a reconnect can be detected
+	state      ConnectionState
+	backoff    time.Duration
+}
+
+// lookupSRV resolves DNS SRV records; replaced in tests.
+var lookupSRV = net.LookupSRV
+
+func newConnectionManager(ctx context.Context, cfg config.DomainConfiguration) *connectionManager {
+	dcs := cfg.DomainControllers
+	if len(dcs) == 0 {
+		dcs = []string{cfg.DcFQDN}
+	}
+
+	if cfg.DiscoverDCs {
+		discovered, err := discoverDomainControllers(cfg.BaseDN)
+		if err != nil {
+			log.Printf("Warning: domain controller discovery failed: %v", err)
+		}
+		dcs = appendUnique(dcs, discovered...)
+	}
+
+	log.Printf("Domain controller candidates: %s", strings.Join(dcs, ", "))
+
+	m := &connectionManager{
+		cfg:   cfg,
+		dcs:   dcs,
+		state: StateDisconnected,
+	}
+	m.dial = m.dialAndBind
+	m.ctx, m.stop = context.WithCancel(ctx)
+	return m
+}
+
+// connect makes a single pass over the candidate domain controllers. It is used at startup so
+// that a misconfiguration fails fast rather than retrying forever.
+func (m *connectionManager) connect() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.tryAll() {
+		return nil
+	}
+	m.transition(StateDisconnected, "no domain controller could be reached")
+	return fmt.Errorf("failed to connect to any of %d domain controllers", len(m.dcs))
+}
+
+// get returns a live connection, reconnecting with backoff if the previous one died. It fails
+// once every domain controller has failed Reconnect.Attempts rounds in a row, or when the
+// manager is closed.
+func (m *connectionManager) get() (*ldap.Conn, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for round := 1; ; round++ {
+		// another caller may have reconnected while the lock was released for the backoff
+		if m.conn != nil && !m.conn.IsClosing() {
+			return m.conn, nil
+		}
+
+		if m.conn != nil {
+			m.drop("connection to %s closed", m.current)
+		}
+
+		if m.tryAll() {
+			return m.conn, nil
+		}
+
+		if attempts := m.cfg.Reconnect.Attempts; attempts > 0 && round >= attempts {
+			m.transition(StateDisconnected, fmt.Sprintf("giving up after %d rounds", round))
+			return nil, fmt.Errorf("failed to connect to any of %d domain controllers after %d rounds", len(m.dcs), round)
+		}
+
+		m.backoff = min(max(m.backoff*2, m.cfg.Reconnect.InitialBackoff), m.cfg.Reconnect.MaxBackoff)
+		m.transition(StateBackoff, fmt.Sprintf("all %d domain controllers unreachable, retrying in %s", len(m.dcs), m.backoff))
+		if err := m.wait(m.backoff); err != nil {
+			return nil, fmt.Errorf("reconnect cancelled: %w", err)
+		}
+	}
+}
+
+// wait releases the lock for d, so the state can be read during the backoff, or until the
+// manager is closed. The caller must hold the lock.
+func (m *connectionManager) wait(d time.Duration) error {
+	m.mu.Unlock()
+	defer m.mu.Lock()
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-m.ctx.Done():
+		return m.ctx.Err()
+	}
+}
+
+// checkError drops the connection if err indicates it is no longer usable, so the next
+// call to get reconnects.
+func (m *connectionManager) checkError(err error) {
+	if err == nil || !isConnectionError(err) {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.conn != nil {
+		m.drop("connection to %s failed: %v", m.current, err)
+	}
+}
+
+// connectionGeneration returns the number of connections made so far. It changes whenever the
+// connection is re-established, possibly to another domain controller.
+func (m *connectionManager) connectionGeneration() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.generation
+}
+
+// domainController returns the domain controller the current connection is bound to.
+func (m *connectionManager) domainController() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.current
+}
+
+func (m *connectionManager) close() {
+	m.stop()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.conn != nil {
+		m.conn.Close()
+		m.conn = nil
+		m.transition(StateDisconnected, "connection closed")
+	}
+}
+
+// tryAll attempts each candidate once, starting with the most recently working one.
+func (m *connectionManager) tryAll() bool {
+	for i := range m.dcs {
+		index := (m.next + i) % len(m.dcs)
+		dc := m.dcs[index]
+
+		m.transition(StateConnecting, "trying "+dc)
+		conn, err := m.dial(dc)
+		if err != nil {
+			log.Printf("LDAP connection to %s failed: %v", dc, err)
+			continue
+		}
+
+		if m.current != "" && m.current != dc {
+			log.Printf("Warning: failed over from %s to %s; USN watermarks are local to each domain controller", m.current, dc)
+		}
+
+		m.conn, m.current, m.next, m.backoff = conn, dc, index, 0
+		m.generation++
+		m.transition(StateConnected, "bound to "+dc)
+		return true
+	}
+	return false
+}
+
+// dialAndBind connects and binds to dc, then confirms the bound identity with a WhoAmI.
+func (m *connectionManager) dialAndBind(dc string) (*ldap.Conn, error) {
+	conn, bindURL, err := Connect(dc, m.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := conn.WhoAmI(nil)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("WhoAmI failed: %w", err)
+	}
+	log.Printf("Authenticated to %s as %s", bindURL, res.AuthzID)
+
+	return conn, nil
+}
+
+func (m *connectionManager) drop(format string, args ...any) {
+	m.conn.Close()
+	m.conn = nil
+	m.transition(StateDisconnected, fmt.Sprintf(format, args...))
+}
+
+func (m *connectionManager) transition(state ConnectionState, reason string) {
+	log.Printf("LDAP connection state: %s -> %s (%s)", m.state, state, reason)
+	m.state = state
+}
+
+// ManagedConnection is a connection kept by a connection manager, for callers such as the web
+// server that search a domain without loading an ActiveDirectoryInstance. It reconnects, failing
+// over across domain controllers, whenever the connection dies.
+type ManagedConnection struct {
+	connection *connectionManager
+}
+
+// NewManagedConnection returns a managed connection to the domain described by cfg. It does not
+// connect until Connect or the first search.
+func NewManagedConnection(ctx context.Context, cfg config.DomainConfiguration) *ManagedConnection {
+	return &ManagedConnection{connection: newConnectionManager(ctx, cfg)}
+}
+
+// Connect makes a single pass over the candidate domain controllers.
+func (c *ManagedConnection) Connect() error {
+	return c.connection.connect()
+}
+
+// Search runs a search, reconnecting first if the connection has died.
+func (c *ManagedConnection) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	conn, err := c.connection.get()
+	if err != nil {
+		return nil, err
+	}
+	result, err := conn.Search(request)
+	c.connection.checkError(err)
+	return result, err
+}
+
+// Close releases the connection.
+func (c *ManagedConnection) Close() {
+	c.connection.close()
+}
+
+// isConnectionError reports whether err means the underlying connection is unusable,
+// as opposed to an LDAP-level failure of a single operation.
+func isConnectionError(err error) bool {
+	if ldap.IsErrorAnyOf(err, ldap.ErrorNetwork, ldap.LDAPResultServerDown, ldap.LDAPResultUnavailable) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// discoverDomainControllers looks up the DCs of the domain named by baseDN via DNS SRV records.
+func discoverDomainControllers(baseDN string) ([]string, error) {
+	domain, err := domainFromBaseDN(baseDN)
+	if err != nil {
+		return nil, err
+	}
+
+	_, records, err := lookupSRV("ldap", "tcp", "dc._msdcs."+domain)
+	if err != nil {
+		return nil, fmt.Errorf("SRV lookup for %s failed: %w", domain, err)
+	}
+
+	// LookupSRV returns records ordered by priority and randomized by weight
+	dcs := make([]string, 0, len(records))
+	for _, record := range records {
+		dcs = append(dcs, strings.TrimSuffix(record.Target, "."))
+	}
+	return dcs, nil
+}
+
+// domainFromBaseDN converts DC=example,DC=com to example.com.
+func domainFromBaseDN(baseDN string) (string, error) {
+	dn, err := ldap.ParseDN(baseDN)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse base DN %q: %w", baseDN, err)
+	}
+
+	var labels []string
+	for _, rdn := range dn.RDNs {
+		for _, attr := range rdn.Attributes {
+			if strings.EqualFold(attr.Type, "DC") {
+				labels = append(labels, attr.Value)
+			}
+		}
+	}
+	if len(labels) == 0 {
+		return "", fmt.Errorf("base DN %q has no DC components", baseDN)
+	}
+	return strings.Join(labels, "."), nil
+}
+
+func appendUnique(dcs []string, more ...string) []string {
+	for _, dc := range more {
+		found := false
+		for _, existing := range dcs {
+			if strings.EqualFold(existing, dc) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			dcs = append(dcs, dc)
+		}
+	}
+	return dcs
+}
@@ -0,0 +1,279 @@
+package activedirectory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"f0oster/adspy/config"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// fakeDialer connects to the domain controllers marked up, and records every attempt.
+type fakeDialer struct {
+	t     *testing.T
+	up    map[string]bool
+	dials []string
+
+	afterDial func(attempt int) // called after each attempt, e.g. to bring a DC back up
+}
+
+func (d *fakeDialer) dial(dc string) (*ldap.Conn, error) {
+	d.dials = append(d.dials, dc)
+	if d.afterDial != nil {
+		defer d.afterDial(len(d.dials))
+	}
+	if !d.up[dc] {
+		return nil, fmt.Errorf("dial %s: connection refused", dc)
+	}
+
+	client, server := net.Pipe()
+	d.t.Cleanup(func() { server.Close() })
+	conn := ldap.NewConn(client, false)
+	conn.Start()
+	return conn, nil
+}
+
+func newTestConnectionManager(t *testing.T, reconnect config.ReconnectPolicy, up ...string) (*connectionManager, *fakeDialer) {
+	t.Helper()
+
+	m := newConnectionManager(context.Background(), config.DomainConfiguration{
+		BaseDN:            "DC=example,DC=com",
+		DomainControllers: []string{"dc1", "dc2", "dc3"},
+		Reconnect:         reconnect,
+	})
+	dialer := &fakeDialer{t: t, up: make(map[string]bool)}
+	for _, dc := range up {
+		dialer.up[dc] = true
+	}
+	m.dial = dialer.dial
+	t.Cleanup(m.close)
+	return m, dialer
+}
+
+var fastReconnect = config.ReconnectPolicy{InitialBackoff: time.Millisecond, MaxBackoff: 4 * time.Millisecond, Attempts: 3}
+
+func TestConnectionManager_FailoverOrder(t *testing.T) {
+	tests := []struct {
+		name      string
+		up        []string
+		next      int // index of the domain controller that worked last
+		wantDC    string
+		wantDials []string
+	}{
+		{"first candidate up", []string{"dc1", "dc2", "dc3"}, 0, "dc1", []string{"dc1"}},
+		{"fails over in order", []string{"dc3"}, 0, "dc3", []string{"dc1", "dc2", "dc3"}},
+		{"starts with the last working DC", []string{"dc1", "dc2", "dc3"}, 1, "dc2", []string{"dc2"}},
+		{"wraps around the candidates", []string{"dc1"}, 1, "dc1", []string{"dc2", "dc3", "dc1"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, dialer := newTestConnectionManager(t, fastReconnect, test.up...)
+			m.next = test.next
+
+			if _, err := m.get(); err != nil {
+				t.Fatalf("get failed: %v", err)
+			}
+			if dc := m.domainController(); dc != test.wantDC {
+				t.Errorf("bound to %s, want %s", dc, test.wantDC)
+			}
+			if !slices.Equal(dialer.dials, test.wantDials) {
+				t.Errorf("dialed %v, want %v", dialer.dials, test.wantDials)
+			}
+		})
+	}
+}
+
+func TestConnectionManager_ReconnectsDeadConnection(t *testing.T) {
+	m, dialer := newTestConnectionManager(t, fastReconnect, "dc1", "dc2")
+
+	conn, err := m.get()
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if again, _ := m.get(); again != conn {
+		t.Error("get replaced a live connection")
+	}
+	if generation := m.connectionGeneration(); generation != 1 {
+		t.Errorf("generation = %d after the first connection, want 1", generation)
+	}
+
+	// dc1 goes away along with its connection
+	dialer.up["dc1"] = false
+	conn.Close()
+
+	if _, err := m.get(); err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if dc := m.domainController(); dc != "dc2" {
+		t.Errorf("bound to %s after failover, want dc2", dc)
+	}
+	if generation := m.connectionGeneration(); generation != 2 {
+		t.Errorf("generation = %d after reconnecting, want 2", generation)
+	}
+	if want := []string{"dc1", "dc1", "dc2"}; !slices.Equal(dialer.dials, want) {
+		t.Errorf("dialed %v, want %v", dialer.dials, want)
+	}
+}
+
+func TestConnectionManager_Backoff(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      config.ReconnectPolicy
+		recoverAt   int // dial attempt after which dc2 comes up; 0 never
+		wantErr     bool
+		wantDials   int
+		wantBackoff time.Duration
+	}{
+		{"gives up after the configured rounds", fastReconnect, 0, true, 9, 2 * time.Millisecond},
+		{"backoff is capped", config.ReconnectPolicy{InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond, Attempts: 4}, 0, true, 12, 2 * time.Millisecond},
+		{"backoff doubles each round", config.ReconnectPolicy{InitialBackoff: time.Millisecond, MaxBackoff: time.Second, Attempts: 4}, 0, true, 12, 4 * time.Millisecond},
+		{"recovers and resets the backoff", fastReconnect, 4, false, 5, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, dialer := newTestConnectionManager(t, test.policy)
+			if test.recoverAt > 0 {
+				dialer.afterDial = func(attempt int) {
+					if attempt == test.recoverAt {
+						dialer.up["dc2"] = true
+					}
+				}
+			}
+
+			_, err := m.get()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("get error = %v, want error %v", err, test.wantErr)
+			}
+			if len(dialer.dials) != test.wantDials {
+				t.Errorf("dialed %d times, want %d", len(dialer.dials), test.wantDials)
+			}
+			if m.backoff != test.wantBackoff {
+				t.Errorf("backoff = %s, want %s", m.backoff, test.wantBackoff)
+			}
+		})
+	}
+}
+
+func TestConnectionManager_BackoffReleasesLock(t *testing.T) {
+	m, _ := newTestConnectionManager(t, config.ReconnectPolicy{InitialBackoff: time.Hour, MaxBackoff: time.Hour})
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := m.get()
+		result <- err
+	}()
+
+	// the state is readable while get waits out the backoff
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		m.mu.Lock()
+		state := m.state
+		m.mu.Unlock()
+		if state == StateBackoff {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("get never entered the backoff")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if dc := m.domainController(); dc != "" {
+		t.Errorf("domainController = %q while disconnected", dc)
+	}
+
+	m.close()
+	select {
+	case err := <-result:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("get error = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("get did not return after close")
+	}
+}
+
+func TestConnectionManager_DiscoverDCs(t *testing.T) {
+	defer func(original func(string, string, string) (string, []*net.SRV, error)) { lookupSRV = original }(lookupSRV)
+
+	var queried string
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		queried = fmt.Sprintf("_%s._%s.%s", service, proto, name)
+		return "", []*net.SRV{{Target: "DC1.example.com."}, {Target: "dc2.example.com."}}, nil
+	}
+
+	m := newConnectionManager(context.Background(), config.DomainConfiguration{
+		BaseDN:            "OU=Ignored,DC=example,DC=com",
+		DomainControllers: []string{"dc1.example.com", "dc9.example.com"},
+		DiscoverDCs:       true,
+	})
+
+	if queried != "_ldap._tcp.dc._msdcs.example.com" {
+		t.Errorf("queried %s, want _ldap._tcp.dc._msdcs.example.com", queried)
+	}
+	// configured DCs come first, discovered ones are appended without duplicates
+	if want := []string{"dc1.example.com", "dc9.example.com", "dc2.example.com"}; !slices.Equal(m.dcs, want) {
+		t.Errorf("candidates = %v, want %v", m.dcs, want)
+	}
+
+	// a failed lookup leaves the configured DCs
+	lookupSRV = func(string, string, string) (string, []*net.SRV, error) {
+		return "", nil, errors.New("no such host")
+	}
+	m = newConnectionManager(context.Background(), config.DomainConfiguration{
+		BaseDN:      "DC=example,DC=com",
+		DcFQDN:      "dc1.example.com",
+		DiscoverDCs: true,
+	})
+	if want := []string{"dc1.example.com"}; !slices.Equal(m.dcs, want) {
+		t.Errorf("candidates = %v, want %v", m.dcs, want)
+	}
+}
+
+func TestDomainFromBaseDN(t *testing.T) {
+	tests := []struct {
+		baseDN  string
+		want    string
+		wantErr bool
+	}{
+		{"DC=example,DC=com", "example.com", false},
+		{"OU=Users,dc=child,dc=example,dc=com", "child.example.com", false},
+		{"CN=Users", "", true},
+		{"not a DN", "", true},
+	}
+
+	for _, test := range tests {
+		got, err := domainFromBaseDN(test.baseDN)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("domainFromBaseDN(%q) = %q, %v, want %q", test.baseDN, got, err, test.want)
+		}
+	}
+}
+
+func TestManagedConnection_ReconnectsForSearch(t *testing.T) {
+	m, dialer := newTestConnectionManager(t, config.ReconnectPolicy{Attempts: 1}, "dc1")
+	conn := &ManagedConnection{connection: m}
+
+	if err := conn.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+
+	// every domain controller goes away along with the connection
+	dialer.up["dc1"] = false
+	m.conn.Close()
+
+	request := ldap.NewSearchRequest("DC=example,DC=com", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 1, false, "(objectClass=*)", nil, nil)
+	if _, err := conn.Search(request); err == nil {
+		t.Fatal("Search succeeded without a domain controller")
+	}
+	if want := []string{"dc1", "dc1", "dc2", "dc3"}; !slices.Equal(dialer.dials, want) {
+		t.Errorf("dialed %v, want a single reconnect round for the search", dialer.dials)
+	}
+}
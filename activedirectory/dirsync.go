@@ -0,0 +1,168 @@
+package activedirectory
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"f0oster/adspy/activedirectory/ldaphelpers"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// dirSyncLookupBatch caps the number of objectGUID clauses in one lookup filter.
+const dirSyncLookupBatch = 100
+
+// dirSyncLocalAttributes are not replicated, so DirSync never returns them, but snapshots
+// and versioning depend on them. They are read from the replica after each DirSync response.
+var dirSyncLocalAttributes = []string{"objectGUID", "uSNChanged", "uSNCreated", "whenChanged", "objectCategory", "isDeleted", replAttributeMetaData, replValueMetaData}
+
+// DirSyncResult is a single response to a DirSync request.
+type DirSyncResult struct {
+	Entries []*ldap.Entry
+	Cookie  []byte // passed to the next DirSync request
+	More    bool   // the server has further changes ready for Cookie
+}
+
+// DirSync returns the objects in namingContext that changed since cookie was issued. Only changed attributes are returned, with a removed attribute carrying no values.
+// An empty cookie returns every object in full.
+//
+// DirSync requires the "Replicating Directory Changes" right on the naming context.
+func (ad *ActiveDirectoryInstance) DirSync(namingContext string, cookie []byte) (*DirSyncResult, error) {
+	request := ldap.NewSearchRequest(
+		namingContext,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		"(objectClass=*)",
+		[]string{}, // Fetch all attributes
+		[]ldap.Control{ldap.NewControlMicrosoftSDFlags()},
+	)
+
+	conn, err := ad.connection.get()
+	if err != nil {
+		return nil, err
+	}
+	ad.DomainControllerFQDN = ad.connection.domainController()
+
+	// Parents are returned before their children so a new subtree is versioned top-down
+	searchResults, err := conn.DirSync(request, ldap.DirSyncAncestorsFirstOrder, 0, cookie)
+	if err != nil {
+		ad.connection.checkError(err)
+		return nil, fmt.Errorf("DirSync search failed: %w", err)
+	}
+
+	control, ok := ldap.FindControl(searchResults.Controls, ldap.ControlTypeDirSync).(*ldap.ControlDirSync)
+	if !ok {
+		return nil, fmt.Errorf("DirSync response from %s carried no DirSync control", ad.DomainControllerFQDN)
+	}
+
+	entries, err := ad.addLocalAttributes(namingContext, searchResults.Entries)
+	if err != nil {
+		return nil, err
+	}
+	if err := ad.expandRangedAttributes(entries); err != nil {
+		return nil, err
+	}
+
+	return &DirSyncResult{
+		Entries: entries,
+		Cookie:  control.Cookie,
+		More:    control.Flags != 0,
+	}, nil
+}
+
+// localAttributeLookup returns the dirSyncLocalAttributes of the objects matching filter.
+type localAttributeLookup func(filter string) ([]*ldap.Entry, error)
+
+// addLocalAttributes reads dirSyncLocalAttributes for each DirSync entry from the replica and
+// merges them into the entry.
+func (ad *ActiveDirectoryInstance) addLocalAttributes(namingContext string, entries []*ldap.Entry) ([]*ldap.Entry, error) {
+	lookup := func(filter string) ([]*ldap.Entry, error) {
+		request := ldap.NewSearchRequest(
+			namingContext,
+			ldap.ScopeWholeSubtree,
+			ldap.NeverDerefAliases,
+			0, 0, false,
+			filter,
+			dirSyncLocalAttributes,
+			[]ldap.Control{ldap.NewControlMicrosoftShowDeleted()},
+		)
+
+		results, err := ad.search(request)
+		if err != nil {
+			return nil, err
+		}
+		return results.Entries, nil
+	}
+
+	return mergeLocalAttributes(entries, lookup)
+}
+
+// mergeLocalAttributes looks up the local attributes of entries by objectGUID and replaces
+// whatever each entry carried under those names. Objects that no longer exist on the replica
+// (deleted and garbage collected since the DirSync response) are dropped.
+func mergeLocalAttributes(entries []*ldap.Entry, lookup localAttributeLookup) ([]*ldap.Entry, error) {
+	local := make(map[string]*ldap.Entry, len(entries))
+
+	for start := 0; start < len(entries); start += dirSyncLookupBatch {
+		batch := entries[start:min(start+dirSyncLookupBatch, len(entries))]
+
+		filters := make([]ldaphelpers.Filter, 0, len(batch))
+		for _, entry := range batch {
+			filters = append(filters, ldaphelpers.EqBytes("objectGUID", entry.GetRawAttributeValue("objectGUID")))
+		}
+
+		results, err := lookup(ldaphelpers.Or(filters...).String())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read local attributes for DirSync entries: %w", err)
+		}
+		for _, entry := range results {
+			local[string(entry.GetRawAttributeValue("objectGUID"))] = entry
+		}
+	}
+
+	merged := make([]*ldap.Entry, 0, len(entries))
+	for _, entry := range entries {
+		localEntry, ok := local[string(entry.GetRawAttributeValue("objectGUID"))]
+		if !ok {
+			continue
+		}
+
+		replaced := make(map[string]bool, len(localEntry.Attributes))
+		for _, attr := range localEntry.Attributes {
+			replaced[strings.ToLower(attr.Name)] = true
+		}
+
+		attributes := make([]*ldap.EntryAttribute, 0, len(entry.Attributes)+len(localEntry.Attributes))
+		for _, attr := range entry.Attributes {
+			// parentGUID is constructed and only returned by DirSync, so it is dropped to keep
+			// snapshots comparable with those taken by the USN source. The local read is
+			// authoritative for every local attribute, including those it no longer returns.
+			if strings.EqualFold(attr.Name, "parentGUID") || isLocalAttribute(attr.Name) {
+				continue
+			}
+			attributes = append(attributes, attr)
+		}
+		attributes = append(attributes, localEntry.Attributes...)
+
+		// An attribute the replica no longer holds (isDeleted after a restore) is returned without
+		// values, the way DirSync reports removed attributes, so it is cleared from the snapshot
+		for _, name := range dirSyncLocalAttributes {
+			if !replaced[strings.ToLower(name)] {
+				attributes = append(attributes, &ldap.EntryAttribute{Name: name})
+			}
+		}
+
+		merged = append(merged, &ldap.Entry{DN: entry.DN, Attributes: attributes})
+	}
+
+	return merged, nil
+}
+
+// isLocalAttribute reports whether name is one of dirSyncLocalAttributes.
+func isLocalAttribute(name string) bool {
+	return slices.ContainsFunc(dirSyncLocalAttributes, func(local string) bool {
+		return strings.EqualFold(local, name)
+	})
+}
@@ -0,0 +1,162 @@
+package activedirectory
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"f0oster/adspy/activedirectory/ldaphelpers"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func testAttribute(name string, values ...string) *ldap.EntryAttribute {
+	byteValues := make([][]byte, len(values))
+	for i, v := range values {
+		byteValues[i] = []byte(v)
+	}
+	return &ldap.EntryAttribute{Name: name, Values: values, ByteValues: byteValues}
+}
+
+func testEntry(guid string, attributes ...*ldap.EntryAttribute) *ldap.Entry {
+	return &ldap.Entry{
+		DN:         "CN=" + guid + ",DC=example,DC=com",
+		Attributes: append([]*ldap.EntryAttribute{testAttribute("objectGUID", guid)}, attributes...),
+	}
+}
+
+// localReplica answers local attribute lookups from the objects it holds.
+type localReplica struct {
+	objects []*ldap.Entry
+	lookups int
+}
+
+func (r *localReplica) lookup(filter string) ([]*ldap.Entry, error) {
+	r.lookups++
+	var results []*ldap.Entry
+	for _, object := range r.objects {
+		if strings.Contains(filter, ldaphelpers.EqBytes("objectGUID", object.GetRawAttributeValue("objectGUID")).String()) {
+			results = append(results, object)
+		}
+	}
+	return results, nil
+}
+
+func TestMergeLocalAttributes(t *testing.T) {
+	tests := []struct {
+		name     string
+		dirSync  *ldap.Entry
+		local    *ldap.Entry
+		want     map[string][]string // attributes of the merged entry, nil values for cleared ones
+		dropped  bool
+		excluded []string // attributes the merged entry must not carry
+	}{
+		{
+			name:    "changed local attributes replace the DirSync values",
+			dirSync: testEntry("a", testAttribute("uSNChanged", "10"), testAttribute("description", "new")),
+			local:   testEntry("a", testAttribute("uSNChanged", "42"), testAttribute("whenChanged", "20240101000000.0Z")),
+			want: map[string][]string{
+				"uSNChanged":  {"42"},
+				"whenChanged": {"20240101000000.0Z"},
+				"description": {"new"},
+			},
+		},
+		{
+			name:    "local attributes the replica no longer holds are cleared",
+			dirSync: testEntry("b", testAttribute("isDeleted", "TRUE")),
+			local:   testEntry("b", testAttribute("uSNChanged", "43")),
+			want: map[string][]string{
+				"uSNChanged":     {"43"},
+				"isDeleted":      nil,
+				"objectCategory": nil,
+			},
+		},
+		{
+			name:     "replicated attributes are untouched and parentGUID is dropped",
+			dirSync:  testEntry("c", testAttribute("description", "kept"), testAttribute("member", "CN=x", "CN=y"), testAttribute("parentGUID", "p")),
+			local:    testEntry("c", testAttribute("uSNChanged", "44")),
+			want:     map[string][]string{"description": {"kept"}, "member": {"CN=x", "CN=y"}},
+			excluded: []string{"parentGUID"},
+		},
+		{
+			name:    "objects gone from the replica are dropped",
+			dirSync: testEntry("d", testAttribute("description", "gone")),
+			dropped: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			replica := &localReplica{}
+			if test.local != nil {
+				replica.objects = append(replica.objects, test.local)
+			}
+
+			merged, err := mergeLocalAttributes([]*ldap.Entry{test.dirSync}, replica.lookup)
+			if err != nil {
+				t.Fatalf("mergeLocalAttributes failed: %v", err)
+			}
+			if test.dropped {
+				if len(merged) != 0 {
+					t.Fatalf("merged %d entries, want the entry dropped", len(merged))
+				}
+				return
+			}
+			if len(merged) != 1 {
+				t.Fatalf("merged %d entries, want 1", len(merged))
+			}
+
+			entry := merged[0]
+			if entry.DN != test.dirSync.DN {
+				t.Errorf("DN = %s, want %s", entry.DN, test.dirSync.DN)
+			}
+			for name, want := range test.want {
+				var found []*ldap.EntryAttribute
+				for _, attr := range entry.Attributes {
+					if strings.EqualFold(attr.Name, name) {
+						found = append(found, attr)
+					}
+				}
+				if len(found) != 1 {
+					t.Errorf("%s appears %d times, want once", name, len(found))
+					continue
+				}
+				if !slices.Equal(found[0].Values, want) {
+					t.Errorf("%s = %v, want %v", name, found[0].Values, want)
+				}
+			}
+			for _, name := range test.excluded {
+				if values := entry.GetAttributeValues(name); len(values) != 0 {
+					t.Errorf("%s = %v, want it dropped", name, values)
+				}
+			}
+		})
+	}
+}
+
+func TestMergeLocalAttributes_BatchesLookups(t *testing.T) {
+	replica := &localReplica{}
+	var entries []*ldap.Entry
+	for i := range 2*dirSyncLookupBatch + 1 {
+		guid := fmt.Sprintf("object-%03d", i)
+		entries = append(entries, testEntry(guid))
+		replica.objects = append(replica.objects, testEntry(guid, testAttribute("uSNChanged", fmt.Sprint(i))))
+	}
+
+	merged, err := mergeLocalAttributes(entries, replica.lookup)
+	if err != nil {
+		t.Fatalf("mergeLocalAttributes failed: %v", err)
+	}
+	if replica.lookups != 3 {
+		t.Errorf("looked up local attributes %d times, want 3", replica.lookups)
+	}
+	if len(merged) != len(entries) {
+		t.Fatalf("merged %d entries, want %d", len(merged), len(entries))
+	}
+	for i, entry := range merged {
+		if got := entry.GetAttributeValue("uSNChanged"); got != fmt.Sprint(i) {
+			t.Errorf("%s has uSNChanged %s, want %d", entry.DN, got, i)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package activedirectory
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/google/uuid"
+)
+
+// validAccesses of a controlAccessRight, which decide how the right is used in an ACE.
+const (
+	ValidAccessesValidatedWrite = 8   // ADS_RIGHT_DS_SELF
+	ValidAccessesPropertySet    = 48  // ADS_RIGHT_DS_READ_PROP | ADS_RIGHT_DS_WRITE_PROP
+	ValidAccessesExtendedRight  = 256 // ADS_RIGHT_DS_CONTROL_ACCESS
+)
+
+// ExtendedRight is a controlAccessRight object: an extended right, a validated write or a
+// property set, identified in ACEs by its rightsGuid.
+type ExtendedRight struct {
+	ObjectGUID    uuid.UUID
+	Name          string // cn
+	DisplayName   string
+	RightsGUID    uuid.UUID
+	ValidAccesses int
+	AppliesTo     []string // schemaIDGUIDs of the classes the right applies to
+}
+
+// ReadExtendedRights reads every controlAccessRight from CN=Extended-Rights in the Configuration
+// partition. Unlike schemaIDGUID, rightsGuid and appliesTo are stored as strings.
+func (ad *ActiveDirectoryInstance) ReadExtendedRights() ([]*ExtendedRight, error) {
+	rightsRequest := ldap.NewSearchRequest(
+		"CN=Extended-Rights,"+ad.configNamingContext,
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		"(objectClass=controlAccessRight)",
+		[]string{"objectGUID", "cn", "displayName", "rightsGuid", "validAccesses", "appliesTo"},
+		nil,
+	)
+
+	rightsResults, err := ad.searchWithPaging(rightsRequest, ad.PageSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search for extended rights: %v", err)
+	}
+
+	rights := make([]*ExtendedRight, 0, len(rightsResults.Entries))
+	for _, entry := range rightsResults.Entries {
+		right, err := parseExtendedRight(entry)
+		if err != nil {
+			return nil, err
+		}
+		rights = append(rights, right)
+	}
+
+	return rights, nil
+}
+
+// parseExtendedRight reads a controlAccessRight entry.
+func parseExtendedRight(entry *ldap.Entry) (*ExtendedRight, error) {
+	objectGUID, err := uuid.FromBytes(entry.GetRawAttributeValue("objectGUID"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse objectGUID of %s: %v", entry.DN, err)
+	}
+
+	rightsGUID, err := uuid.Parse(entry.GetAttributeValue("rightsGuid"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse rightsGuid of %s: %v", entry.DN, err)
+	}
+
+	validAccesses, err := strconv.Atoi(entry.GetAttributeValue("validAccesses"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid validAccesses of %s: %v", entry.DN, err)
+	}
+
+	return &ExtendedRight{
+		ObjectGUID:    objectGUID,
+		Name:          entry.GetAttributeValue("cn"),
+		DisplayName:   entry.GetAttributeValue("displayName"),
+		RightsGUID:    rightsGUID,
+		ValidAccesses: validAccesses,
+		AppliesTo:     entry.GetAttributeValues("appliesTo"),
+	}, nil
+}
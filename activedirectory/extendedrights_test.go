@@ -0,0 +1,72 @@
+package activedirectory
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/google/uuid"
+)
+
+func controlAccessRight(objectGUID []byte, attributes map[string][]string) *ldap.Entry {
+	entry := ldap.NewEntry("CN=User-Force-Change-Password,CN=Extended-Rights,CN=Configuration,DC=example,DC=com", attributes)
+	entry.Attributes = append(entry.Attributes, &ldap.EntryAttribute{Name: "objectGUID", ByteValues: [][]byte{objectGUID}})
+	return entry
+}
+
+func TestParseExtendedRight(t *testing.T) {
+	objectGUID := uuid.MustParse("d2b1d8c8-5a57-4b3c-9f1a-2d6c1e8f4b21")
+	userClass := "bf967aba-0de6-11d0-a285-00aa003049e2"
+	valid := map[string][]string{
+		"cn":            {"User-Force-Change-Password"},
+		"displayName":   {"Reset Password"},
+		"rightsGuid":    {"00299570-246d-11d0-a768-00aa006e0529"},
+		"validAccesses": {"256"},
+		"appliesTo":     {userClass, "4828cc14-1437-45bc-9b07-ad6f015e5f28"},
+	}
+	with := func(key string, values ...string) map[string][]string {
+		attributes := make(map[string][]string, len(valid))
+		for k, v := range valid {
+			attributes[k] = v
+		}
+		attributes[key] = values
+		return attributes
+	}
+
+	right, err := parseExtendedRight(controlAccessRight(objectGUID[:], valid))
+	if err != nil {
+		t.Fatalf("parseExtendedRight failed: %v", err)
+	}
+	if right.ObjectGUID != objectGUID {
+		t.Errorf("ObjectGUID = %s, want %s", right.ObjectGUID, objectGUID)
+	}
+	if right.Name != "User-Force-Change-Password" || right.DisplayName != "Reset Password" {
+		t.Errorf("Name, DisplayName = %q, %q", right.Name, right.DisplayName)
+	}
+	if right.RightsGUID != uuid.MustParse("00299570-246d-11d0-a768-00aa006e0529") {
+		t.Errorf("RightsGUID = %s", right.RightsGUID)
+	}
+	if right.ValidAccesses != ValidAccessesExtendedRight {
+		t.Errorf("ValidAccesses = %d, want %d", right.ValidAccesses, ValidAccessesExtendedRight)
+	}
+	if !slices.Equal(right.AppliesTo, valid["appliesTo"]) {
+		t.Errorf("AppliesTo = %v, want %v", right.AppliesTo, valid["appliesTo"])
+	}
+
+	tests := []struct {
+		name       string
+		objectGUID []byte
+		attributes map[string][]string
+		wantErr    string
+	}{
+		{"truncated objectGUID", objectGUID[:8], valid, "failed to parse objectGUID"},
+		{"invalid rightsGuid", objectGUID[:], with("rightsGuid", "not-a-guid"), "failed to parse rightsGuid"},
+		{"missing validAccesses", objectGUID[:], with("validAccesses"), "invalid validAccesses"},
+	}
+	for _, test := range tests {
+		if _, err := parseExtendedRight(controlAccessRight(test.objectGUID, test.attributes)); err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%s: error = %v, want %q", test.name, err, test.wantErr)
+		}
+	}
+}
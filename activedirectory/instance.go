@@ -1,11 +1,17 @@
 package activedirectory
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
+	"f0oster/adspy/activedirectory/ldaphelpers"
 	"f0oster/adspy/activedirectory/schema"
+	"f0oster/adspy/activedirectory/transformers"
 	"f0oster/adspy/config"
 
 	"github.com/go-ldap/ldap/v3"
@@ -14,7 +20,7 @@ import (
 
 // TODO: Separate related functionality into their own files
 // TODO: Separate exported types (ie: PesistableADObject?) to a model package
-func NewActiveDirectoryInstance(config config.ADSpyConfiguration) (*ActiveDirectoryInstance, error) {
+func NewActiveDirectoryInstance(ctx context.Context, config config.DomainConfiguration) (*ActiveDirectoryInstance, error) {
 
 	ad := &ActiveDirectoryInstance{
 		BaseDn:               config.BaseDN,
@@ -23,10 +29,18 @@ func NewActiveDirectoryInstance(config config.ADSpyConfiguration) (*ActiveDirect
 		SchemaRegistry:       schema.NewSchemaRegistry(),
 	}
 
-	ok := ad.connect(config.Username, config.Password)
+	if err := ad.SchemaRegistry.CheckInterpreters(); err != nil {
+		return nil, fmt.Errorf("schema registry self-check failed: %w", err)
+	}
+
+	ad.connection = newConnectionManager(ctx, config)
+	if err := ad.connection.connect(); err != nil {
+		return nil, fmt.Errorf("failed to connect to the Active Directory Domain: %w", err)
+	}
+	ad.DomainControllerFQDN = ad.connection.domainController()
 
-	if !ok {
-		return nil, fmt.Errorf("failed to connect to the Active Directory Domain")
+	if err := ad.fetchNamingContexts(config.NamingContexts); err != nil {
+		return nil, fmt.Errorf("failed to discover naming contexts: %w", err)
 	}
 
 	err := ad.loadSchema()
@@ -48,62 +62,177 @@ func NewActiveDirectoryInstance(config config.ADSpyConfiguration) (*ActiveDirect
 
 }
 
-// Connect to the Active Directory Domain Controller
-func (ad *ActiveDirectoryInstance) connect(username, password string) bool {
-	var err error
+// search runs a single search on the managed connection, dropping the connection if it has died.
+func (ad *ActiveDirectoryInstance) search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	conn, err := ad.connection.get()
+	if err != nil {
+		return nil, err
+	}
+	ad.DomainControllerFQDN = ad.connection.domainController()
+
+	result, err := conn.Search(request)
+	ad.connection.checkError(err)
+	return result, err
+}
+
+// searchWithPaging runs a paged search on the managed connection, dropping the connection if it has died.
+func (ad *ActiveDirectoryInstance) searchWithPaging(request *ldap.SearchRequest, pagingSize uint32) (*ldap.SearchResult, error) {
+	conn, err := ad.connection.get()
+	if err != nil {
+		return nil, err
+	}
+	ad.DomainControllerFQDN = ad.connection.domainController()
+
+	result, err := conn.SearchWithPaging(request, pagingSize)
+	ad.connection.checkError(err)
+	return result, err
+}
+
+// Close releases the LDAP connection.
+func (ad *ActiveDirectoryInstance) Close() {
+	ad.connection.close()
+}
 
-	bindString := fmt.Sprintf("ldap://%s:389", ad.DomainControllerFQDN)
-	ad.ldapConnection, err = ldap.DialURL(bindString)
+// Load AttributeSchema data dynamically from the Schema partition
+func (ad *ActiveDirectoryInstance) loadSchema() error {
+	// Read the time of the last schema update first, so an update made while the schema is
+	// being read triggers a reload
+	modified, err := ad.readSchemaModified()
 	if err != nil {
-		log.Printf("Failed to connect to LDAP server: %v\n", err)
-		return false
+		return err
 	}
 
-	// TODO: LDAPS, IWA/GSSAPI, etc
-	err = ad.ldapConnection.Bind(username, password)
+	schemas, err := ad.readAttributeSchemas()
 	if err != nil {
-		ad.ldapConnection.Close()
-		log.Printf("Failed to bind to LDAP server: %v\n", err)
-		return false
+		return err
 	}
 
-	res, err := ad.ldapConnection.WhoAmI(nil)
+	classes, err := ad.readClassSchemas()
 	if err != nil {
-		log.Printf("Failed to call WhoAmI(): %s\n", err)
-		return false
+		return err
 	}
-	fmt.Printf("Authenticated to %s as %s\n", bindString, res.AuthzID)
 
-	return true
+	for _, schemaEntry := range schemas {
+		ad.SchemaRegistry.RegisterAttributeSchema(schemaEntry)
+	}
+	for _, class := range classes {
+		ad.SchemaRegistry.RegisterClassSchema(class)
+	}
+	ad.SchemaModified = modified
+
+	return nil
 }
 
-// Load AttributeSchema data dynamically from the Schema partition
-func (ad *ActiveDirectoryInstance) loadSchema() error {
+// SchemaUpdate is a schema update read from the directory, not yet applied to the SchemaRegistry.
+type SchemaUpdate struct {
+	Modified time.Time // modifyTimeStamp of the subschema entry
+	Change   schema.SchemaChange
+	Schemas  []*schema.AttributeSchema // every attribute schema after the update
+	Classes  []*schema.ClassSchema     // every class schema after the update
+}
+
+// ReadSchemaUpdate reads the attribute and class schemas again when the schema has been updated since it
+// was last loaded, and compares them with the SchemaRegistry. It returns nil when the schema
+// has not changed.
+//
+// Active Directory updates the modifyTimeStamp of the subschema entry (CN=Aggregate) whenever
+// the schema cache is refreshed after a schema change, so one base search per poll suffices.
+func (ad *ActiveDirectoryInstance) ReadSchemaUpdate() (*SchemaUpdate, error) {
+	modified, err := ad.readSchemaModified()
+	if err != nil {
+		return nil, err
+	}
+	if !modified.After(ad.SchemaModified) {
+		return nil, nil
+	}
 
-	schemaBaseDN := "CN=Schema,CN=Configuration," + ad.BaseDn
+	schemas, err := ad.readAttributeSchemas()
+	if err != nil {
+		return nil, fmt.Errorf("failed to reload schema: %w", err)
+	}
+	classes, err := ad.readClassSchemas()
+	if err != nil {
+		return nil, fmt.Errorf("failed to reload schema: %w", err)
+	}
+
+	change := ad.SchemaRegistry.CompareAttributeSchemas(schemas)
+	classChange := ad.SchemaRegistry.CompareClassSchemas(classes)
+	change.AddedClasses = classChange.AddedClasses
+	change.ModifiedClasses = classChange.ModifiedClasses
+	change.RemovedClasses = classChange.RemovedClasses
+
+	return &SchemaUpdate{
+		Modified: modified,
+		Change:   change,
+		Schemas:  schemas,
+		Classes:  classes,
+	}, nil
+}
 
+// ApplySchemaUpdate hot-swaps the attribute and class schemas in the SchemaRegistry, so
+// attributes and classes added by a schema extension are parsed without restarting the poller.
+func (ad *ActiveDirectoryInstance) ApplySchemaUpdate(update *SchemaUpdate) {
+	ad.SchemaRegistry.ReplaceAttributeSchemas(update.Schemas)
+	ad.SchemaRegistry.ReplaceClassSchemas(update.Classes)
+	ad.SchemaModified = update.Modified
+}
+
+// readSchemaModified reads the modifyTimeStamp of the subschema entry.
+func (ad *ActiveDirectoryInstance) readSchemaModified() (time.Time, error) {
+	request := ldap.NewSearchRequest(
+		ad.subschemaSubentry,
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		"(objectClass=*)",
+		[]string{"modifyTimeStamp"},
+		nil,
+	)
+
+	results, err := ad.search(request)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read modifyTimeStamp of %s: %v", ad.subschemaSubentry, err)
+	}
+	if len(results.Entries) == 0 {
+		return time.Time{}, fmt.Errorf("subschema entry not found: %s", ad.subschemaSubentry)
+	}
+
+	value := results.Entries[0].GetAttributeValue("modifyTimeStamp")
+	modified, err := time.Parse("20060102150405.0Z", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse modifyTimeStamp %q of %s: %v", value, ad.subschemaSubentry, err)
+	}
+	return modified, nil
+}
+
+// readAttributeSchemas reads every attributeSchema object from the Schema partition.
+func (ad *ActiveDirectoryInstance) readAttributeSchemas() ([]*schema.AttributeSchema, error) {
 	attributesRequest := ldap.NewSearchRequest(
-		schemaBaseDN,
+		ad.schemaNamingContext,
 		ldap.ScopeWholeSubtree,
 		ldap.NeverDerefAliases,
 		0, 0, false,
 		"(objectClass=attributeSchema)", // Searching for attributeSchema
-		[]string{"objectGUID", "cn", "lDAPDisplayName", "attributeID", "attributeSyntax", "oMSyntax", "isSingleValued"},
+		[]string{
+			"objectGUID", "cn", "lDAPDisplayName", "attributeID", "attributeSyntax", "oMSyntax", "isSingleValued",
+			"schemaIDGUID", "attributeSecurityGUID",
+		},
 		nil, // no control
 	)
 
 	// Perform paged search for attributes
-	attributesResults, err := ad.ldapConnection.SearchWithPaging(attributesRequest, ad.PageSize)
+	attributesResults, err := ad.searchWithPaging(attributesRequest, ad.PageSize)
 	if err != nil {
-		return fmt.Errorf("failed to search for attributes: %v", err)
+		return nil, fmt.Errorf("failed to search for attributes: %v", err)
 	}
 
 	// Process the objectAttributes and store schema data
+	schemas := make([]*schema.AttributeSchema, 0, len(attributesResults.Entries))
 	for _, entry := range attributesResults.Entries {
 		objectGUIDBytes := entry.GetRawAttributeValue("objectGUID")
 		objectGUID, err := uuid.FromBytes(objectGUIDBytes)
 		if err != nil {
-			return fmt.Errorf("failed to parse objectGUID: %v", err)
+			return nil, fmt.Errorf("failed to parse objectGUID: %v", err)
 		}
 
 		attributeName := entry.GetAttributeValue("cn")
@@ -114,17 +243,26 @@ func (ad *ActiveDirectoryInstance) loadSchema() error {
 		isSingleValued := entry.GetAttributeValue("isSingleValued")
 
 		if isSingleValued != "TRUE" && isSingleValued != "FALSE" {
-			return fmt.Errorf("invalid isSingleValued value: %q", isSingleValued)
+			return nil, fmt.Errorf("invalid isSingleValued value: %q", isSingleValued)
 		}
 		singleValued := isSingleValued == "TRUE"
 
 		attributeFieldType, err := ad.SchemaRegistry.Lookup(attributeSyntax, oMSyntax, ldapDisplayName)
 
 		if err != nil {
-			return fmt.Errorf("error mapping schema to types: %v", err)
+			return nil, fmt.Errorf("error mapping schema to types: %v", err)
 		}
 
-		schemaEntry := schema.AttributeSchema{
+		schemaIDGUID, err := parseADGUID(entry.GetRawAttributeValue("schemaIDGUID"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse schemaIDGUID of %s: %v", ldapDisplayName, err)
+		}
+		attributeSecurityGUID, err := parseADGUID(entry.GetRawAttributeValue("attributeSecurityGUID"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse attributeSecurityGUID of %s: %v", ldapDisplayName, err)
+		}
+
+		schemas = append(schemas, &schema.AttributeSchema{
 			ObjectGUID:              objectGUID,
 			AttributeName:           attributeName,
 			AttributeLDAPName:       ldapDisplayName,
@@ -133,16 +271,156 @@ func (ad *ActiveDirectoryInstance) loadSchema() error {
 			AttributeOMSyntax:       oMSyntax,
 			AttributeFieldType:      *attributeFieldType,
 			AttributeIsSingleValued: singleValued,
+			SchemaIDGUID:            schemaIDGUID,
+			AttributeSecurityGUID:   attributeSecurityGUID,
+		})
+	}
+
+	return schemas, nil
+}
+
+// readClassSchemas reads every classSchema object from the Schema partition.
+func (ad *ActiveDirectoryInstance) readClassSchemas() ([]*schema.ClassSchema, error) {
+	classesRequest := ldap.NewSearchRequest(
+		ad.schemaNamingContext,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		"(objectClass=classSchema)",
+		[]string{
+			"objectGUID", "cn", "lDAPDisplayName", "governsID", "schemaIDGUID", "subClassOf",
+			"objectClassCategory", "auxiliaryClass", "systemAuxiliaryClass",
+			"mustContain", "systemMustContain", "mayContain", "systemMayContain",
+			"defaultSecurityDescriptor", "defaultObjectCategory",
+		},
+		nil,
+	)
+
+	classesResults, err := ad.searchWithPaging(classesRequest, ad.PageSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search for classes: %v", err)
+	}
+
+	classes := make([]*schema.ClassSchema, 0, len(classesResults.Entries))
+	for _, entry := range classesResults.Entries {
+		objectGUID, err := uuid.FromBytes(entry.GetRawAttributeValue("objectGUID"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse objectGUID of %s: %v", entry.DN, err)
+		}
+
+		schemaIDGUID, err := parseADGUID(entry.GetRawAttributeValue("schemaIDGUID"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse schemaIDGUID of %s: %v", entry.DN, err)
+		}
+
+		category, err := strconv.Atoi(entry.GetAttributeValue("objectClassCategory"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid objectClassCategory of %s: %v", entry.DN, err)
+		}
+
+		classes = append(classes, &schema.ClassSchema{
+			ObjectGUID:                objectGUID,
+			DN:                        entry.DN,
+			ClassName:                 entry.GetAttributeValue("cn"),
+			LDAPDisplayName:           entry.GetAttributeValue("lDAPDisplayName"),
+			GovernsID:                 entry.GetAttributeValue("governsID"),
+			SchemaIDGUID:              schemaIDGUID,
+			SubClassOf:                entry.GetAttributeValue("subClassOf"),
+			ObjectClassCategory:       category,
+			AuxiliaryClasses:          combinedValues(entry, "auxiliaryClass", "systemAuxiliaryClass"),
+			MustContain:               combinedValues(entry, "mustContain", "systemMustContain"),
+			MayContain:                combinedValues(entry, "mayContain", "systemMayContain"),
+			DefaultSecurityDescriptor: entry.GetAttributeValue("defaultSecurityDescriptor"),
+			DefaultObjectCategory:     entry.GetAttributeValue("defaultObjectCategory"),
+		})
+	}
+
+	return classes, nil
+}
+
+// parseADGUID converts a GUID in the byte order Active Directory stores it in. An absent value
+// is uuid.Nil.
+func parseADGUID(raw []byte) (uuid.UUID, error) {
+	if len(raw) == 0 {
+		return uuid.Nil, nil
+	}
+	guids, err := transformers.ADGuidFormatter{}.Normalize([][]byte{raw})
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return uuid.Parse(guids[0])
+}
+
+// combinedValues returns the values of both attributes, sorted so that reloads compare equal.
+func combinedValues(entry *ldap.Entry, attribute, systemAttribute string) []string {
+	values := append(entry.GetAttributeValues(attribute), entry.GetAttributeValues(systemAttribute)...)
+	slices.Sort(values)
+	return slices.Compact(values)
+}
+
+// fetchNamingContexts reads the naming contexts held by the domain controller from the Root DSE
+// and selects the enabled ones. The schema is polled first and the domain last, so schema
+// changes are stored before objects that may use them.
+func (ad *ActiveDirectoryInstance) fetchNamingContexts(enabled []config.NamingContextType) error {
+	rootDSERequest := ldap.NewSearchRequest(
+		"", // Root DSE
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		"(objectClass=*)",
+		[]string{"namingContexts", "configurationNamingContext", "schemaNamingContext", "subschemaSubentry"},
+		nil,
+	)
+
+	rootDSEResults, err := ad.search(rootDSERequest)
+	if err != nil {
+		return fmt.Errorf("failed to fetch namingContexts from Root DSE: %v", err)
+	}
+	if len(rootDSEResults.Entries) == 0 {
+		return fmt.Errorf("Root DSE returned no entries")
+	}
+	rootDSE := rootDSEResults.Entries[0]
+	ad.subschemaSubentry = rootDSE.GetAttributeValue("subschemaSubentry")
+	if ad.subschemaSubentry == "" {
+		return fmt.Errorf("subschemaSubentry not found in the Root DSE")
+	}
+
+	held := make(map[string]string) // lowercased DN -> DN as published
+	for _, dn := range rootDSE.GetAttributeValues("namingContexts") {
+		held[strings.ToLower(dn)] = dn
+	}
+
+	// Application partitions (such as the DNS zones) are held but not monitored
+	candidates := []NamingContext{
+		{DN: rootDSE.GetAttributeValue("schemaNamingContext"), Type: config.NamingContextSchema},
+		{DN: rootDSE.GetAttributeValue("configurationNamingContext"), Type: config.NamingContextConfiguration},
+		{DN: ad.BaseDn, Type: config.NamingContextDomain},
+	}
+
+	ad.NamingContexts = nil
+	for _, nc := range candidates {
+		dn, ok := held[strings.ToLower(nc.DN)]
+		if nc.DN == "" || !ok {
+			return fmt.Errorf("%s naming context %q is not held by %s", nc.Type, nc.DN, ad.DomainControllerFQDN)
+		}
+		nc.DN = dn
+
+		switch nc.Type {
+		case config.NamingContextSchema:
+			ad.schemaNamingContext = nc.DN
+		case config.NamingContextConfiguration:
+			ad.configNamingContext = nc.DN
+		}
+		if slices.Contains(enabled, nc.Type) {
+			ad.NamingContexts = append(ad.NamingContexts, nc)
 		}
-		// fmt.Printf("Adding type: %s\n", ldapDisplayName) // for debugging
-		ad.SchemaRegistry.RegisterAttributeSchema(&schemaEntry)
 	}
 
 	return nil
 }
 
-// fetch the highest committed USN from the target domain controller
-func (ad *ActiveDirectoryInstance) FetchHighestUSN() error {
+// read the highest committed USN from the target domain controller without moving the poll watermark
+func (ad *ActiveDirectoryInstance) ReadHighestUSN() (int64, error) {
 	highestCommittedUsnSearchRequest := ldap.NewSearchRequest(
 		"", // Root DSE
 		ldap.ScopeBaseObject,
@@ -153,26 +431,113 @@ func (ad *ActiveDirectoryInstance) FetchHighestUSN() error {
 		nil,
 	)
 
-	highestCommittedUsnSearchResults, err := ad.ldapConnection.Search(highestCommittedUsnSearchRequest)
+	highestCommittedUsnSearchResults, err := ad.search(highestCommittedUsnSearchRequest)
 	if err != nil {
-		return fmt.Errorf("failed to fetch highestCommittedUSN from Root DSE: %v", err)
+		return 0, fmt.Errorf("failed to fetch highestCommittedUSN from Root DSE: %v", err)
 	}
 
 	// Check if the attribute was found
-	if len(highestCommittedUsnSearchResults.Entries) > 0 {
-		entry := highestCommittedUsnSearchResults.Entries[0].GetAttributeValue("highestCommittedUSN")
-		ad.HighestCommittedUSN, err = strconv.ParseInt(entry, 10, 64)
-		if err != nil {
-			return fmt.Errorf("error converting highestCommittedUSN to int: %v", err)
-		}
-		fmt.Printf("Highest Committed USN: %d\n", ad.HighestCommittedUSN)
-	} else {
-		return fmt.Errorf("highestCommittedUSN not found in the Root DSE: %s", ad.BaseDn)
+	if len(highestCommittedUsnSearchResults.Entries) == 0 {
+		return 0, fmt.Errorf("highestCommittedUSN not found in the Root DSE: %s", ad.BaseDn)
+	}
+
+	entry := highestCommittedUsnSearchResults.Entries[0].GetAttributeValue("highestCommittedUSN")
+	highestCommittedUSN, err := strconv.ParseInt(entry, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("error converting highestCommittedUSN to int: %v", err)
+	}
+
+	return highestCommittedUSN, nil
+}
+
+// fetch the dsServiceName and invocationId of the domain controller currently serving requests
+func (ad *ActiveDirectoryInstance) FetchReplicaIdentity() error {
+	rootDSERequest := ldap.NewSearchRequest(
+		"", // Root DSE
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		"(objectClass=*)",
+		[]string{"dsServiceName"},
+		nil,
+	)
+
+	rootDSEResults, err := ad.search(rootDSERequest)
+	if err != nil {
+		return fmt.Errorf("failed to fetch dsServiceName from Root DSE: %v", err)
+	}
+	if len(rootDSEResults.Entries) == 0 {
+		return fmt.Errorf("Root DSE returned no entries")
+	}
+
+	dsServiceName := rootDSEResults.Entries[0].GetAttributeValue("dsServiceName")
+	if dsServiceName == "" {
+		return fmt.Errorf("dsServiceName not found in the Root DSE")
+	}
+
+	// invocationId lives on the NTDS Settings object named by dsServiceName
+	ntdsSettingsRequest := ldap.NewSearchRequest(
+		dsServiceName,
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		"(objectClass=*)",
+		[]string{"invocationId"},
+		nil,
+	)
+
+	ntdsSettingsResults, err := ad.search(ntdsSettingsRequest)
+	if err != nil {
+		return fmt.Errorf("failed to fetch invocationId from %s: %v", dsServiceName, err)
+	}
+	if len(ntdsSettingsResults.Entries) == 0 {
+		return fmt.Errorf("NTDS Settings object not found: %s", dsServiceName)
+	}
+
+	invocationIDs, err := transformers.ADGuidFormatter{}.Normalize([][]byte{ntdsSettingsResults.Entries[0].GetRawAttributeValue("invocationId")})
+	if err != nil {
+		return fmt.Errorf("failed to parse invocationId: %v", err)
+	}
+	invocationID, err := uuid.Parse(invocationIDs[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse invocationId: %v", err)
+	}
+
+	ad.Replica = ReplicaIdentity{
+		DsServiceName: dsServiceName,
+		InvocationID:  invocationID,
 	}
 
 	return nil
 }
 
+// CurrentReplica returns the identity of the replica the connection is bound to, and the
+// connection generation it applies to. A connection stays bound to one replica, and a database
+// restore restarts the domain controller, so the identity is only read again after a reconnect.
+func (ad *ActiveDirectoryInstance) CurrentReplica() (ReplicaIdentity, uint64, error) {
+	for {
+		generation := ad.connection.connectionGeneration()
+		if generation == ad.replicaGeneration {
+			return ad.Replica, generation, nil
+		}
+
+		if err := ad.FetchReplicaIdentity(); err != nil {
+			return ReplicaIdentity{}, 0, err
+		}
+
+		// a reconnect while reading means the identity may be of the previous replica
+		if ad.connection.connectionGeneration() == generation {
+			ad.replicaGeneration = generation
+		}
+	}
+}
+
+// ConnectionGeneration returns a counter that changes whenever the connection is re-established,
+// so callers can tell whether a sequence of requests was answered by the same replica.
+func (ad *ActiveDirectoryInstance) ConnectionGeneration() uint64 {
+	return ad.connection.connectionGeneration()
+}
+
 func (ad *ActiveDirectoryInstance) fetchDomainGUID() error {
 	domainGUIDSearchRequest := ldap.NewSearchRequest(
 		ad.BaseDn,
@@ -184,7 +549,7 @@ func (ad *ActiveDirectoryInstance) fetchDomainGUID() error {
 		nil,
 	)
 
-	domainGUIDSearchResults, err := ad.ldapConnection.Search(domainGUIDSearchRequest)
+	domainGUIDSearchResults, err := ad.search(domainGUIDSearchRequest)
 	if err != nil {
 		return fmt.Errorf("failed to fetch domainGUID from Root DSE: %v", err)
 	}
@@ -200,9 +565,28 @@ func (ad *ActiveDirectoryInstance) fetchDomainGUID() error {
 	return nil
 }
 
-// perform a paged LDAP query and callback per page
+// page through live and deleted objects in namingContext with lowerUSN <= uSNChanged <= upperUSN
+func (ad *ActiveDirectoryInstance) SearchUSNRange(
+	namingContext string, lowerUSN, upperUSN int64, pageSize uint32, pageHandler func(entries []*ldap.Entry) error,
+) error {
+	ldapFilter := ldaphelpers.And(
+		ldaphelpers.Or(
+			ldaphelpers.Eq("objectCategory", "*"), // Live objects
+			ldaphelpers.Eq("isDeleted", "TRUE"),   // Deleted objects
+		),
+		ldaphelpers.Ge("uSNChanged", lowerUSN),
+		ldaphelpers.Le("uSNChanged", upperUSN),
+	).String()
+
+	return ad.ForEachLDAPPage(namingContext, ldapFilter, pageSize,
+		func(_ *ActiveDirectoryInstance, entries []*ldap.Entry) error {
+			return pageHandler(entries)
+		})
+}
+
+// perform a paged LDAP query below baseDN and callback per page
 func (ad *ActiveDirectoryInstance) ForEachLDAPPage(
-	filter string, pageSize uint32, pageHandlerCallback func(adInstance *ActiveDirectoryInstance, entries []*ldap.Entry) error,
+	baseDN string, filter string, pageSize uint32, pageHandlerCallback func(adInstance *ActiveDirectoryInstance, entries []*ldap.Entry) error,
 ) error {
 
 	log.Println("LDAPFilter:", filter)
@@ -211,22 +595,34 @@ func (ad *ActiveDirectoryInstance) ForEachLDAPPage(
 	pageControl := ldap.NewControlPaging(pageSize)
 	showDeletedControl := ldap.NewControlMicrosoftShowDeleted()
 	pageRequest := ldap.NewSearchRequest(
-		ad.BaseDn,
+		baseDN,
 		ldap.ScopeWholeSubtree,
 		ldap.NeverDerefAliases,
 		0, 0, false,
 		filter,
 		// []string{"memberOf", "objectGUID", "userPrincipalName", "objectCategory"},
-		[]string{}, // Fetch all attributes
+		[]string{"*", replAttributeMetaData, replValueMetaData}, // Fetch all attributes and who last wrote each attribute and linked value
 		[]ldap.Control{pageControl, sdFlagsControl, showDeletedControl},
 	)
 
+	// Paging cookies are bound to the connection, so every page must come from the same one
+	conn, err := ad.connection.get()
+	if err != nil {
+		return err
+	}
+	ad.DomainControllerFQDN = ad.connection.domainController()
+
 	for {
-		searchResults, err := ad.ldapConnection.Search(pageRequest)
+		searchResults, err := conn.Search(pageRequest)
 		if err != nil {
+			ad.connection.checkError(err)
 			return fmt.Errorf("LDAP search failed: %w", err)
 		}
 
+		if err := ad.expandRangedAttributes(searchResults.Entries); err != nil {
+			return err
+		}
+
 		// Process the current page of entries
 		if err := pageHandlerCallback(ad, searchResults.Entries); err != nil {
 			return fmt.Errorf("processing page failed: %w", err)
@@ -243,7 +639,6 @@ func (ad *ActiveDirectoryInstance) ForEachLDAPPage(
 	return nil
 }
 
-
 func (obj *ActiveDirectoryObject) GetNormalizedAttribute(attrName string) (string, bool) {
 	attr, ok := obj.AttributeValues[attrName]
 	if !ok || attr == nil || attr.NormalizedValue == nil {
@@ -255,5 +650,3 @@ func (obj *ActiveDirectoryObject) GetNormalizedAttribute(attrName string) (strin
 	}
 	return val, true
 }
-
-
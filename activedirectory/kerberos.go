@@ -0,0 +1,236 @@
+package activedirectory
+
+import (
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"f0oster/adspy/config"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
+	"github.com/jcmturner/gokrb5/v8/client"
+	krb5config "github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/chksumtype"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+// GSS-API token ID for a KRB_AP_REQ inside an InitialContextToken (RFC 4121 section 4.1)
+var tokenIDAPReq = []byte{0x01, 0x00}
+
+// gssapiClient implements ldap.GSSAPIClient on top of gokrb5 and, unlike the client shipped
+// with go-ldap, negotiates a SASL security layer so that binds succeed against DCs that
+// require LDAP signing.
+type gssapiClient struct {
+	krb        *client.Client
+	protection config.SASLProtection
+
+	sessionKey     types.EncryptionKey
+	acceptorSubkey types.EncryptionKey
+	sendSeq        uint64
+	recvSeq        uint64 // the acceptor's initial sequence number, from the AP-REP
+
+	// layer is populated by NegotiateSaslAuth when a signing or sealing layer was selected
+	layer *securityLayer
+}
+
+var _ ldap.GSSAPIClient = (*gssapiClient)(nil)
+
+// newKerberosClient builds a Kerberos client from a keytab or credential cache.
+func newKerberosClient(username string, kerberos config.KerberosConfiguration) (*client.Client, error) {
+	krb5conf, err := krb5config.Load(kerberos.Krb5ConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load krb5 config %s: %w", kerberos.Krb5ConfigFile, err)
+	}
+
+	// AD does not support FAST pre-authentication for service accounts by default
+	settings := client.DisablePAFXFAST(true)
+
+	switch {
+	case kerberos.KeytabFile != "":
+		kt, err := keytab.Load(kerberos.KeytabFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load keytab %s: %w", kerberos.KeytabFile, err)
+		}
+
+		principal, realm := username, kerberos.Realm
+		if user, domain, ok := strings.Cut(username, "@"); ok {
+			principal = user
+			if realm == "" {
+				realm = strings.ToUpper(domain)
+			}
+		}
+
+		krb := client.NewWithKeytab(principal, realm, kt, krb5conf, settings)
+		if err := krb.Login(); err != nil {
+			return nil, fmt.Errorf("kerberos login as %s@%s failed: %w", principal, realm, err)
+		}
+		return krb, nil
+
+	case kerberos.CCacheFile != "":
+		ccache, err := credentials.LoadCCache(kerberos.CCacheFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load credential cache %s: %w", kerberos.CCacheFile, err)
+		}
+		return client.NewFromCCache(ccache, krb5conf, settings)
+
+	default:
+		return nil, fmt.Errorf("GSSAPI bind requires KRB5_KEYTAB or KRB5_CCACHE")
+	}
+}
+
+func newGSSAPIClient(krb *client.Client, protection config.SASLProtection) *gssapiClient {
+	return &gssapiClient{
+		krb:        krb,
+		protection: protection,
+	}
+}
+
+func (c *gssapiClient) InitSecContext(target string, token []byte) ([]byte, bool, error) {
+	return c.InitSecContextWithOptions(target, token, nil)
+}
+
+// InitSecContextWithOptions builds the AP-REQ on the first call and processes the
+// AP-REP (mutual authentication) on the second. See RFC 4752 section 3.1.
+func (c *gssapiClient) InitSecContextWithOptions(target string, token []byte, options []int) ([]byte, bool, error) {
+	if token == nil {
+		tkt, sessionKey, err := c.krb.GetServiceTicket(target)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to get service ticket for %s: %w", target, err)
+		}
+		c.sessionKey = sessionKey
+
+		auth, err := types.NewAuthenticator(c.krb.Credentials.Domain(), c.krb.Credentials.CName())
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to build authenticator: %w", err)
+		}
+		auth.Cksum = types.Checksum{
+			CksumType: chksumtype.GSSAPI,
+			Checksum: authenticatorChecksum(
+				gssapi.ContextFlagInteg | gssapi.ContextFlagConf | gssapi.ContextFlagMutual | gssapi.ContextFlagSequence,
+			),
+		}
+		c.sendSeq = uint64(auth.SeqNumber)
+
+		apReq, err := messages.NewAPReq(tkt, sessionKey, auth)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to build AP-REQ: %w", err)
+		}
+		types.SetFlag(&apReq.APOptions, flags.APOptionMutualRequired)
+		for _, option := range options {
+			types.SetFlag(&apReq.APOptions, option)
+		}
+
+		apReqBytes, err := apReq.Marshal()
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to marshal AP-REQ: %w", err)
+		}
+		oid, err := asn1.Marshal(asn1.ObjectIdentifier(gssapi.OIDKRB5.OID()))
+		if err != nil {
+			return nil, false, err
+		}
+
+		initialToken := append(oid, tokenIDAPReq...)
+		initialToken = append(initialToken, apReqBytes...)
+		return asn1tools.AddASNAppTag(initialToken, 0), true, nil
+	}
+
+	var response spnego.KRB5Token
+	if err := response.Unmarshal(token); err != nil {
+		return nil, false, fmt.Errorf("failed to parse GSSAPI response token: %w", err)
+	}
+	if response.IsKRBError() {
+		return nil, false, response.KRBError
+	}
+	if !response.IsAPRep() {
+		return nil, false, fmt.Errorf("expected AP-REP from server")
+	}
+
+	encPart, err := crypto.DecryptEncPart(response.APRep.EncPart, c.sessionKey, keyusage.AP_REP_ENCPART)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to decrypt AP-REP: %w", err)
+	}
+	var apRepPart messages.EncAPRepPart
+	if err := apRepPart.Unmarshal(encPart); err != nil {
+		return nil, false, fmt.Errorf("failed to parse AP-REP: %w", err)
+	}
+	c.acceptorSubkey = apRepPart.Subkey
+	c.recvSeq = uint64(apRepPart.SequenceNumber)
+
+	return []byte{}, false, nil
+}
+
+// NegotiateSaslAuth unwraps the server's security layer offer, selects the configured
+// protection and returns the wrapped selection. See RFC 4752 section 3.1.
+func (c *gssapiClient) NegotiateSaslAuth(token []byte, authzid string) ([]byte, error) {
+	key, subkey := c.sessionKey, false
+	if len(c.acceptorSubkey.KeyValue) > 0 {
+		key, subkey = c.acceptorSubkey, true
+	}
+
+	seal := c.protection == config.ProtectionSeal
+	layer, err := newSecurityLayer(key, subkey, seal, true, c.sendSeq, c.recvSeq)
+	if err != nil {
+		return nil, err
+	}
+
+	offer, err := layer.unwrap(token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap security layer offer: %w", err)
+	}
+	if len(offer) != 4 {
+		return nil, fmt.Errorf("server sent a malformed security layer offer")
+	}
+
+	var selected byte
+	switch c.protection {
+	case config.ProtectionSeal:
+		selected = saslLayerConfident
+	case config.ProtectionSign:
+		selected = saslLayerIntegrity
+	default:
+		selected = saslLayerNone
+	}
+	if offer[0]&selected == 0 {
+		return nil, fmt.Errorf("server does not offer SASL protection %q (offered 0x%02x)", c.protection, offer[0])
+	}
+
+	reply := make([]byte, 4, 4+len(authzid))
+	if selected != saslLayerNone {
+		maxSendLen := int(binary.BigEndian.Uint32(offer) & maxSASLBufferSize)
+		if maxSendLen > 0 && maxSendLen <= wrapTokenOverhead {
+			return nil, fmt.Errorf("server offered a SASL buffer of %d bytes, too small for a wrap token", maxSendLen)
+		}
+		binary.BigEndian.PutUint32(reply, maxSASLBufferSize)
+		layer.maxSendLen = maxSendLen
+		c.layer = layer
+	}
+	reply[0] = selected
+	reply = append(reply, authzid...)
+
+	// The selection itself is always integrity protected only (RFC 4752 section 3.1)
+	return layer.wrapWithSeq(reply, layer.nextSeq(), false)
+}
+
+// DeleteSecContext is called by go-ldap when the bind finishes. The negotiated security
+// layer keeps its own copy of the key, so there is nothing to release here.
+func (c *gssapiClient) DeleteSecContext() error {
+	return nil
+}
+
+// authenticatorChecksum builds the GSS-API checksum carried in the authenticator (RFC 4121 section 4.1.1).
+func authenticatorChecksum(contextFlags uint32) []byte {
+	checksum := make([]byte, 24)
+	binary.LittleEndian.PutUint32(checksum[:4], 16)
+	binary.LittleEndian.PutUint32(checksum[20:24], contextFlags)
+	return checksum
+}
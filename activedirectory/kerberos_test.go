@@ -0,0 +1,532 @@
+package activedirectory
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"f0oster/adspy/config"
+
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/asnAppTag"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
+	"github.com/jcmturner/gokrb5/v8/iana/patype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+const (
+	testRealm   = "TEST.LOCAL"
+	testDC      = "dc.test.local"
+	testUser    = "svc-adspy"
+	testSPN     = "ldap/" + testDC
+	testEncType = etypeID.AES256_CTS_HMAC_SHA1_96
+)
+
+// kdcStandIn is a minimal TCP KDC that issues a TGT for any AS-REQ and a service ticket
+// for any TGS-REQ, using keys from a single keytab.
+type kdcStandIn struct {
+	keys     *keytab.Keytab
+	listener net.Listener
+}
+
+func newKDCStandIn(t *testing.T, keys *keytab.Keytab) *kdcStandIn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	kdc := &kdcStandIn{keys: keys, listener: listener}
+	go kdc.serve()
+	return kdc
+}
+
+func (k *kdcStandIn) serve() {
+	for {
+		conn, err := k.listener.Accept()
+		if err != nil {
+			return
+		}
+		go k.handle(conn)
+	}
+}
+
+func (k *kdcStandIn) handle(conn net.Conn) {
+	defer conn.Close()
+
+	var size uint32
+	if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+		return
+	}
+	request := make([]byte, size)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return
+	}
+
+	var reply []byte
+	var err error
+	switch request[0] & 0x1f {
+	case asnAppTag.ASREQ:
+		reply, err = k.asExchange(request)
+	case asnAppTag.TGSREQ:
+		reply, err = k.tgsExchange(request)
+	default:
+		err = fmt.Errorf("unexpected message tag %d", request[0]&0x1f)
+	}
+	if err != nil {
+		return
+	}
+
+	binary.Write(conn, binary.BigEndian, uint32(len(reply)))
+	conn.Write(reply)
+}
+
+func (k *kdcStandIn) asExchange(request []byte) ([]byte, error) {
+	var req messages.ASReq
+	if err := req.Unmarshal(request); err != nil {
+		return nil, err
+	}
+
+	clientKey, _, err := k.keys.GetEncryptionKey(req.ReqBody.CName, testRealm, 0, testEncType)
+	if err != nil {
+		return nil, err
+	}
+
+	tkt, encPart, err := k.issue(req.ReqBody.CName, req.ReqBody.SName, req.ReqBody.Nonce, clientKey, keyusage.AS_REP_ENCPART)
+	if err != nil {
+		return nil, err
+	}
+
+	rep := messages.ASRep{KDCRepFields: messages.KDCRepFields{
+		PVNO:    5,
+		MsgType: msgtype.KRB_AS_REP,
+		CRealm:  testRealm,
+		CName:   req.ReqBody.CName,
+		Ticket:  tkt,
+		EncPart: encPart,
+	}}
+	return rep.Marshal()
+}
+
+func (k *kdcStandIn) tgsExchange(request []byte) ([]byte, error) {
+	var req messages.TGSReq
+	if err := req.Unmarshal(request); err != nil {
+		return nil, err
+	}
+
+	var apReq messages.APReq
+	for _, pa := range req.PAData {
+		if pa.PADataType == patype.PA_TGS_REQ {
+			if err := apReq.Unmarshal(pa.PADataValue); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := apReq.Ticket.DecryptEncPart(k.keys, nil); err != nil {
+		return nil, err
+	}
+	tgt := apReq.Ticket.DecryptedEncPart
+
+	tkt, encPart, err := k.issue(tgt.CName, req.ReqBody.SName, req.ReqBody.Nonce, tgt.Key, keyusage.TGS_REP_ENCPART_SESSION_KEY)
+	if err != nil {
+		return nil, err
+	}
+
+	rep := messages.TGSRep{KDCRepFields: messages.KDCRepFields{
+		PVNO:    5,
+		MsgType: msgtype.KRB_TGS_REP,
+		CRealm:  testRealm,
+		CName:   tgt.CName,
+		Ticket:  tkt,
+		EncPart: encPart,
+	}}
+	return rep.Marshal()
+}
+
+// issue mints a ticket for sname and the matching reply part encrypted under replyKey.
+func (k *kdcStandIn) issue(cname, sname types.PrincipalName, nonce int, replyKey types.EncryptionKey, usage uint32) (messages.Ticket, types.EncryptedData, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	end := now.Add(time.Hour)
+
+	tkt, sessionKey, err := messages.NewTicket(cname, testRealm, sname, testRealm, types.NewKrbFlags(), k.keys, testEncType, 1, now, now, end, end)
+	if err != nil {
+		return tkt, types.EncryptedData{}, err
+	}
+
+	part := messages.EncKDCRepPart{
+		Key:       sessionKey,
+		LastReqs:  []messages.LastReq{},
+		Nonce:     nonce,
+		Flags:     types.NewKrbFlags(),
+		AuthTime:  now,
+		StartTime: now,
+		EndTime:   end,
+		RenewTill: end,
+		SRealm:    testRealm,
+		SName:     sname,
+	}
+	b, err := part.Marshal()
+	if err != nil {
+		return tkt, types.EncryptedData{}, err
+	}
+	encPart, err := crypto.GetEncryptedData(b, replyKey, usage, 1)
+	return tkt, encPart, err
+}
+
+// acceptorStandIn plays the directory server's side of the GSSAPI exchange.
+type acceptorStandIn struct {
+	keys      *keytab.Keytab
+	layer     *securityLayer
+	maxBuffer uint32 // buffer size offered to the initiator; 0 offers 1024
+}
+
+// accept verifies the initiator's AP-REQ and returns an AP-REP carrying a fresh acceptor subkey.
+func (a *acceptorStandIn) accept(t *testing.T, token []byte) []byte {
+	t.Helper()
+
+	var initial spnego.KRB5Token
+	if err := initial.Unmarshal(token); err != nil {
+		t.Fatalf("failed to parse initial context token: %v", err)
+	}
+	if !initial.IsAPReq() {
+		t.Fatalf("initial context token is not an AP-REQ")
+	}
+
+	apReq := initial.APReq
+	if err := apReq.Ticket.DecryptEncPart(a.keys, nil); err != nil {
+		t.Fatalf("failed to decrypt service ticket: %v", err)
+	}
+	sessionKey := apReq.Ticket.DecryptedEncPart.Key
+	if err := apReq.DecryptAuthenticator(sessionKey); err != nil {
+		t.Fatalf("failed to decrypt authenticator: %v", err)
+	}
+
+	contextFlags := binary.LittleEndian.Uint32(apReq.Authenticator.Cksum.Checksum[20:24])
+	if contextFlags&gssapi.ContextFlagInteg == 0 || contextFlags&gssapi.ContextFlagConf == 0 {
+		t.Fatalf("initiator did not request integrity and confidentiality: flags 0x%x", contextFlags)
+	}
+
+	et, err := crypto.GetEtype(sessionKey.KeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subkey, err := types.GenerateEncryptionKey(et)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encAPRepPart, err := asn1.Marshal(messages.EncAPRepPart{
+		CTime:          apReq.Authenticator.CTime,
+		Cusec:          apReq.Authenticator.Cusec,
+		Subkey:         subkey,
+		SequenceNumber: 7,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encPart, err := crypto.GetEncryptedData(asn1tools.AddASNAppTag(encAPRepPart, asnAppTag.EncAPRepPart), sessionKey, keyusage.AP_REP_ENCPART, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apRep, err := asn1.Marshal(messages.APRep{PVNO: 5, MsgType: msgtype.KRB_AP_REP, EncPart: encPart})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.layer, err = newSecurityLayer(subkey, true, false, false, 7, uint64(apReq.Authenticator.SeqNumber))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oid, _ := asn1.Marshal(asn1.ObjectIdentifier(gssapi.OIDKRB5.OID()))
+	response := append(oid, 0x02, 0x00)
+	response = append(response, asn1tools.AddASNAppTag(apRep, asnAppTag.APREP)...)
+	return asn1tools.AddASNAppTag(response, 0)
+}
+
+// offer returns the wrapped security layer offer and buffer size.
+func (a *acceptorStandIn) offer(t *testing.T, layers byte, maxBuffer uint32) []byte {
+	t.Helper()
+
+	offer := make([]byte, 4)
+	binary.BigEndian.PutUint32(offer, maxBuffer)
+	offer[0] = layers
+
+	token, err := a.layer.wrapWithSeq(offer, a.layer.nextSeq(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func newTestKerberos(t *testing.T) (*keytab.Keytab, config.KerberosConfiguration) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	kdcKeys := keytab.New()
+	clientKeys := keytab.New()
+	for _, principal := range []string{"krbtgt/" + testRealm, testSPN} {
+		if err := kdcKeys.AddEntry(principal, testRealm, principal+"-secret", now, 1, testEncType); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, kt := range []*keytab.Keytab{kdcKeys, clientKeys} {
+		if err := kt.AddEntry(testUser, testRealm, "client-secret", now, 1, testEncType); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	kdc := newKDCStandIn(t, kdcKeys)
+
+	keytabFile := filepath.Join(dir, "adspy.keytab")
+	ktBytes, err := clientKeys.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keytabFile, ktBytes, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	krb5Conf := fmt.Sprintf(`[libdefaults]
+  default_realm = %[1]s
+  dns_lookup_kdc = false
+  dns_lookup_realm = false
+  udp_preference_limit = 1
+  default_tkt_enctypes = aes256-cts-hmac-sha1-96
+  default_tgs_enctypes = aes256-cts-hmac-sha1-96
+  permitted_enctypes = aes256-cts-hmac-sha1-96
+
+[realms]
+  %[1]s = {
+    kdc = %[2]s
+  }
+
+[domain_realm]
+  .test.local = %[1]s
+  test.local = %[1]s
+`, testRealm, kdc.listener.Addr().String())
+	krb5ConfFile := filepath.Join(dir, "krb5.conf")
+	if err := os.WriteFile(krb5ConfFile, []byte(krb5Conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return kdcKeys, config.KerberosConfiguration{
+		Krb5ConfigFile: krb5ConfFile,
+		KeytabFile:     keytabFile,
+		Realm:          testRealm,
+	}
+}
+
+// bindStandIn drives the client through the GSSAPI handshake the way go-ldap's GSSAPIBind does,
+// with the acceptor stand-in answering each step.
+func bindStandIn(t *testing.T, kerberos config.KerberosConfiguration, acceptor *acceptorStandIn, offered byte) (*gssapiClient, error) {
+	t.Helper()
+
+	krb, err := newKerberosClient(testUser+"@test.local", kerberos)
+	if err != nil {
+		t.Fatalf("keytab login failed: %v", err)
+	}
+	defer krb.Destroy()
+
+	client := newGSSAPIClient(krb, kerberos.Protection)
+
+	token, _, err := client.InitSecContext(testSPN, nil)
+	if err != nil {
+		t.Fatalf("InitSecContext failed: %v", err)
+	}
+	if _, _, err := client.InitSecContext(testSPN, acceptor.accept(t, token)); err != nil {
+		t.Fatalf("mutual authentication failed: %v", err)
+	}
+
+	maxBuffer := acceptor.maxBuffer
+	if maxBuffer == 0 {
+		maxBuffer = 1024
+	}
+	reply, err := client.NegotiateSaslAuth(acceptor.offer(t, offered, maxBuffer), "")
+	if err != nil {
+		return nil, err
+	}
+
+	selection, err := acceptor.layer.unwrap(reply)
+	if err != nil {
+		t.Fatalf("acceptor failed to unwrap layer selection: %v", err)
+	}
+	if len(selection) != 4 {
+		t.Fatalf("layer selection has %d bytes, want 4", len(selection))
+	}
+
+	want := map[config.SASLProtection]byte{
+		config.ProtectionNone: saslLayerNone,
+		config.ProtectionSign: saslLayerIntegrity,
+		config.ProtectionSeal: saslLayerConfident,
+	}[kerberos.Protection]
+	if selection[0] != want {
+		t.Fatalf("client selected layer 0x%02x, want 0x%02x", selection[0], want)
+	}
+
+	return client, client.DeleteSecContext()
+}
+
+func TestGSSAPIBind_SecurityLayers(t *testing.T) {
+	kdcKeys, kerberos := newTestKerberos(t)
+
+	for _, protection := range []config.SASLProtection{config.ProtectionSign, config.ProtectionSeal} {
+		t.Run(string(protection), func(t *testing.T) {
+			kerberos.Protection = protection
+			acceptor := &acceptorStandIn{keys: kdcKeys}
+
+			client, err := bindStandIn(t, kerberos, acceptor, saslLayerNone|saslLayerIntegrity|saslLayerConfident)
+			if err != nil {
+				t.Fatalf("NegotiateSaslAuth failed: %v", err)
+			}
+			if client.layer == nil {
+				t.Fatalf("no security layer negotiated for %s", protection)
+			}
+			if client.layer.maxSendLen != 1024 {
+				t.Errorf("maxSendLen = %d, want 1024", client.layer.maxSendLen)
+			}
+
+			// The acceptor seals or signs according to what the client selected
+			acceptor.layer.seal = protection == config.ProtectionSeal
+
+			clientSide, serverSide := net.Pipe()
+			defer clientSide.Close()
+			defer serverSide.Close()
+
+			clientConn, serverConn := newSASLConn(clientSide), newSASLConn(serverSide)
+			clientConn.install(client.layer)
+			serverConn.install(acceptor.layer)
+
+			// Larger than the negotiated buffer size, so the write is split across several SASL buffers
+			request := bytes.Repeat([]byte("search request "), 200)
+			go clientConn.Write(request)
+
+			received := make([]byte, len(request))
+			if _, err := io.ReadFull(serverConn, received); err != nil {
+				t.Fatalf("acceptor read failed: %v", err)
+			}
+			if !bytes.Equal(received, request) {
+				t.Fatalf("acceptor received corrupted request")
+			}
+
+			response := []byte("search result done")
+			go serverConn.Write(response)
+
+			received = make([]byte, len(response))
+			if _, err := io.ReadFull(clientConn, received); err != nil {
+				t.Fatalf("client read failed: %v", err)
+			}
+			if !bytes.Equal(received, response) {
+				t.Fatalf("client received %q, want %q", received, response)
+			}
+		})
+	}
+}
+
+func TestGSSAPIBind_ProtectionNotOffered(t *testing.T) {
+	kdcKeys, kerberos := newTestKerberos(t)
+	kerberos.Protection = config.ProtectionSeal
+
+	_, err := bindStandIn(t, kerberos, &acceptorStandIn{keys: kdcKeys}, saslLayerNone|saslLayerIntegrity)
+	if err == nil {
+		t.Fatal("expected an error when the server does not offer sealing")
+	}
+}
+
+func TestSecurityLayer_RejectsTamperedToken(t *testing.T) {
+	key := types.EncryptionKey{KeyType: testEncType, KeyValue: bytes.Repeat([]byte{0x42}, 32)}
+
+	for _, seal := range []bool{false, true} {
+		initiator, err := newSecurityLayer(key, true, seal, true, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		acceptor, err := newSecurityLayer(key, true, seal, false, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		token, err := initiator.wrap([]byte("modify request"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payload, err := acceptor.unwrap(token); err != nil || string(payload) != "modify request" {
+			t.Fatalf("seal=%v: unwrap = %q, %v", seal, payload, err)
+		}
+
+		token[len(token)-1] ^= 0xff
+		if _, err := acceptor.unwrap(token); err == nil {
+			t.Errorf("seal=%v: tampered token was accepted", seal)
+		}
+
+		// A token must not be accepted by the side that produced it
+		token, _ = initiator.wrap([]byte("modify request"))
+		if _, err := initiator.unwrap(token); err == nil {
+			t.Errorf("seal=%v: reflected token was accepted", seal)
+		}
+	}
+}
+
+func TestGSSAPIBind_RejectsTinyBuffer(t *testing.T) {
+	kdcKeys, kerberos := newTestKerberos(t)
+	kerberos.Protection = config.ProtectionSeal
+
+	acceptor := &acceptorStandIn{keys: kdcKeys, maxBuffer: wrapTokenOverhead}
+	_, err := bindStandIn(t, kerberos, acceptor, saslLayerNone|saslLayerIntegrity|saslLayerConfident)
+	if err == nil || !strings.Contains(err.Error(), "too small") {
+		t.Fatalf("NegotiateSaslAuth error = %v, want a rejected buffer size", err)
+	}
+}
+
+func TestSecurityLayer_RejectsOutOfSequenceToken(t *testing.T) {
+	key := types.EncryptionKey{KeyType: testEncType, KeyValue: bytes.Repeat([]byte{0x42}, 32)}
+
+	for _, seal := range []bool{false, true} {
+		initiator, err := newSecurityLayer(key, true, seal, true, 100, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		acceptor, err := newSecurityLayer(key, true, seal, false, 0, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var tokens [][]byte
+		for _, response := range []string{"first", "second", "third"} {
+			token, err := initiator.wrap([]byte(response))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tokens = append(tokens, token)
+		}
+
+		if _, err := acceptor.unwrap(tokens[0]); err != nil {
+			t.Fatalf("seal=%v: first token rejected: %v", seal, err)
+		}
+		if _, err := acceptor.unwrap(tokens[0]); err == nil {
+			t.Errorf("seal=%v: replayed token was accepted", seal)
+		}
+		if _, err := acceptor.unwrap(tokens[2]); err == nil {
+			t.Errorf("seal=%v: token was accepted after one was dropped", seal)
+		}
+		// rejected tokens do not move the expected sequence number
+		if payload, err := acceptor.unwrap(tokens[1]); err != nil || string(payload) != "second" {
+			t.Errorf("seal=%v: unwrap of the next token = %q, %v", seal, payload, err)
+		}
+	}
+}
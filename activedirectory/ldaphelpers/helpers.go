@@ -62,6 +62,28 @@ func Ge(attr string, value int64) Filter {
 	return geFilter{attr: attr, value: value}
 }
 
+type leFilter struct {
+	attr  string
+	value int64
+}
+
+func (f leFilter) String() string {
+	return fmt.Sprintf("(%s<=%d)", f.attr, f.value)
+}
+
+func Le(attr string, value int64) Filter {
+	return leFilter{attr: attr, value: value}
+}
+
 func Eq(attr, value string) Filter {
 	return rawFilter("(" + attr + "=" + value + ")")
 }
+
+// EqBytes matches a binary attribute such as objectGUID, escaping every byte of value.
+func EqBytes(attr string, value []byte) Filter {
+	var escaped strings.Builder
+	for _, b := range value {
+		fmt.Fprintf(&escaped, "\\%02x", b)
+	}
+	return rawFilter("(" + attr + "=" + escaped.String() + ")")
+}
@@ -3,6 +3,7 @@ package ldaphelpers
 import (
 	"f0oster/adspy/activedirectory/schema"
 	"f0oster/adspy/activedirectory/schema/accessors"
+	"f0oster/adspy/activedirectory/transformers"
 	"fmt"
 	"log"
 
@@ -23,7 +24,9 @@ func ParseAttribute(
 	// --- Normalize ---
 	normalizedStrings, normErr := fieldType.Normalizer.Normalize(byteValues)
 	if normErr != nil {
-		log.Printf("Failed to normalize %s: %v", attr.Name, normErr)
+		// a value that cannot be normalized is stored raw rather than dropped
+		log.Printf("Failed to normalize %s, storing the raw value: %v", attr.Name, normErr)
+		normalizedStrings = normalizeRaw(byteValues)
 	}
 	normalized := &accessors.NormalizedValue{Values: normalizedStrings}
 
@@ -62,3 +65,12 @@ func ParseAttribute(
 		&attributeSchema,
 	), nil
 }
+
+// normalizeRaw normalizes values as text, or as tagged base64 if any of them is not UTF-8.
+func normalizeRaw(values [][]byte) []string {
+	if text, err := (transformers.SimpleStringFormatter{}).Normalize(values); err == nil {
+		return text
+	}
+	encoded, _ := transformers.BinaryFormatter{}.Normalize(values)
+	return encoded
+}
@@ -0,0 +1,90 @@
+package activedirectory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ErrNotificationRefused is returned by WatchChanges when the domain controller will not
+// register a change notification search.
+var ErrNotificationRefused = errors.New("change notification control refused")
+
+// WatchChanges registers a change notification persistent search (LDAP_SERVER_NOTIFICATION_OID)
+// on each monitored naming context and signals notify whenever an object in one of them changes.
+// Signals are coalesced: if one is already pending, further changes do not block the searches.
+//
+// The searches run on a dedicated connection to the domain controller currently in use, because
+// they stay outstanding until ctx is cancelled or the connection fails. When one search ends the
+// others are cancelled, so the caller can register them again together.
+func (ad *ActiveDirectoryInstance) WatchChanges(ctx context.Context, notify chan<- struct{}) error {
+	dc := ad.connection.domainController()
+	conn, err := ad.connection.dial(dc)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s for change notifications: %w", dc, err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, len(ad.NamingContexts))
+	for _, nc := range ad.NamingContexts {
+		go func() {
+			errs <- watchNamingContext(ctx, conn, dc, nc.DN, notify)
+			cancel()
+		}()
+	}
+	log.Printf("Registered change notification searches for %d naming contexts on %s", len(ad.NamingContexts), dc)
+
+	var first error
+	for range ad.NamingContexts {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// watchNamingContext runs the notification search for one naming context until it ends.
+func watchNamingContext(ctx context.Context, conn *ldap.Conn, dc, namingContext string, notify chan<- struct{}) error {
+	// The control must be critical, otherwise a DC that ignores it returns a normal search.
+	// The base must be the head of the naming context.
+	request := ldap.NewSearchRequest(
+		namingContext,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		"(objectClass=*)", // the only filter AD accepts for change notifications
+		[]string{"objectGUID"},
+		[]ldap.Control{
+			ldap.NewControlString(ldap.ControlTypeMicrosoftNotification, true, ""),
+			ldap.NewControlMicrosoftShowDeleted(), // also notify when objects are deleted
+		},
+	)
+
+	response := conn.SearchAsync(ctx, request, 0)
+	for response.Next() {
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	}
+
+	err := response.Err()
+	switch {
+	case err == nil || ctx.Err() != nil:
+		return nil
+	case ldap.IsErrorAnyOf(err,
+		ldap.LDAPResultUnavailableCriticalExtension,
+		ldap.LDAPResultUnwillingToPerform,
+		ldap.LDAPResultAdminLimitExceeded, // the per-connection notification limit
+		ldap.LDAPResultInsufficientAccessRights):
+		return fmt.Errorf("%w by %s on %s: %v", ErrNotificationRefused, dc, namingContext, err)
+	default:
+		return fmt.Errorf("change notification search on %s for %s failed: %w", dc, namingContext, err)
+	}
+}
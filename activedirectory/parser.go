@@ -61,9 +61,30 @@ func (p *Parser) parseEntry(entry *ldap.Entry) (*ActiveDirectoryObject, error) {
 		objectGUID           uuid.UUID
 		primaryObjectClass   string
 		nTSecurityDescriptor *gontsd.SecurityDescriptor
+		replicationMetadata  ReplicationMetadata
+		valueMetadata        LinkedValueMetadata
 	)
 
 	for _, attr := range entry.Attributes {
+		// Replication metadata describes the other attributes rather than the object itself
+		if isReplAttributeMetaData(attr.Name) {
+			var err error
+			replicationMetadata, err = parseReplAttributeMetaData(attr)
+			if err != nil {
+				// attribution is best effort, the change itself is still recorded
+				log.Printf("failed to parse replication metadata for DN %s: %v\n", entry.DN, err)
+			}
+			continue
+		}
+		if isReplValueMetaData(attr.Name) {
+			var err error
+			valueMetadata, err = parseReplValueMetaData(attr)
+			if err != nil {
+				log.Printf("failed to parse linked value metadata for DN %s: %v\n", entry.DN, err)
+			}
+			continue
+		}
+
 		attributeSchema, ok := p.schemaRegistry.GetAttributeSchema(attr.Name)
 		if !ok {
 			log.Printf("Skipping parsing for unknown attribute: %s\n", attr.Name)
@@ -114,5 +135,7 @@ func (p *Parser) parseEntry(entry *ldap.Entry) (*ActiveDirectoryObject, error) {
 		PrimaryObjectClass:   primaryObjectClass,
 		NTSecurityDescriptor: nTSecurityDescriptor,
 		AttributeValues:      objectAttributes,
+		ReplicationMetadata:  replicationMetadata,
+		ValueMetadata:        valueMetadata,
 	}, nil
 }
@@ -0,0 +1,127 @@
+package activedirectory
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// rangeFetcher reads the values of attr (including its ;range= option) from the object at dn.
+// It returns nil when the object no longer carries the attribute.
+type rangeFetcher func(dn, attr string) (*ldap.EntryAttribute, error)
+
+// expandRangedAttributes replaces ranged attributes on each entry with the complete value set.
+// Active Directory returns at most MaxValRange values of a multi-valued attribute per search,
+// naming the attribute member;range=0-1499 instead of member; the remaining values have to be
+// requested range by range.
+func (ad *ActiveDirectoryInstance) expandRangedAttributes(entries []*ldap.Entry) error {
+	fetch := func(dn, attr string) (*ldap.EntryAttribute, error) {
+		request := ldap.NewSearchRequest(
+			dn,
+			ldap.ScopeBaseObject,
+			ldap.NeverDerefAliases,
+			0, 0, false,
+			"(objectClass=*)",
+			[]string{attr},
+			[]ldap.Control{ldap.NewControlMicrosoftShowDeleted()},
+		)
+
+		results, err := ad.search(request)
+		if err != nil {
+			return nil, err
+		}
+		if len(results.Entries) == 0 || len(results.Entries[0].Attributes) == 0 {
+			return nil, nil
+		}
+		return results.Entries[0].Attributes[0], nil
+	}
+
+	for _, entry := range entries {
+		if err := expandEntryRanges(entry, fetch); err != nil {
+			return fmt.Errorf("failed to fetch ranged attributes for %s: %w", entry.DN, err)
+		}
+	}
+	return nil
+}
+
+// expandEntryRanges replaces every ranged attribute on entry with one named after the base
+// attribute, holding the values of all ranges.
+func expandEntryRanges(entry *ldap.Entry, fetch rangeFetcher) error {
+	for i, attr := range entry.Attributes {
+		name, _, end, ok := parseRange(attr.Name)
+		if !ok {
+			continue
+		}
+
+		values := append([]string(nil), attr.Values...)
+		byteValues := append([][]byte(nil), attr.ByteValues...)
+
+		for end >= 0 {
+			next, err := fetch(entry.DN, fmt.Sprintf("%s;range=%d-*", name, end+1))
+			if err != nil {
+				return err
+			}
+			if next == nil {
+				break
+			}
+
+			nextName, start, nextEnd, ok := parseRange(next.Name)
+			if !ok || !strings.EqualFold(nextName, name) {
+				return fmt.Errorf("unexpected attribute %q in response to a ranged read of %s", next.Name, name)
+			}
+			if start != end+1 {
+				return fmt.Errorf("ranged read of %s returned range starting at %d, expected %d", name, start, end+1)
+			}
+
+			values = append(values, next.Values...)
+			byteValues = append(byteValues, next.ByteValues...)
+			end = nextEnd
+		}
+
+		entry.Attributes[i] = &ldap.EntryAttribute{
+			Name:       name,
+			Values:     values,
+			ByteValues: byteValues,
+		}
+	}
+	return nil
+}
+
+// parseRange splits an attribute description such as member;range=0-1499 into the attribute
+// name and the range bounds. end is -1 for the final range (member;range=1500-*).
+func parseRange(description string) (name string, start, end int, ok bool) {
+	options := strings.Split(description, ";")
+
+	var kept []string
+	found := false
+	for _, option := range options {
+		bounds, isRange := strings.CutPrefix(strings.ToLower(option), "range=")
+		if !isRange {
+			kept = append(kept, option)
+			continue
+		}
+
+		low, high, hasDash := strings.Cut(bounds, "-")
+		if !hasDash {
+			return "", 0, 0, false
+		}
+
+		var err error
+		if start, err = strconv.Atoi(low); err != nil {
+			return "", 0, 0, false
+		}
+		if high == "*" {
+			end = -1
+		} else if end, err = strconv.Atoi(high); err != nil {
+			return "", 0, 0, false
+		}
+		found = true
+	}
+
+	if !found {
+		return "", 0, 0, false
+	}
+	return strings.Join(kept, ";"), start, end, true
+}
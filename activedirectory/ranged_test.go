@@ -0,0 +1,125 @@
+package activedirectory
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// rangedGroup serves the member attribute of a group the way AD does, maxValRange values at a time.
+type rangedGroup struct {
+	members     []string
+	maxValRange int
+	requests    []string
+}
+
+func (g *rangedGroup) rangeFrom(start int) *ldap.EntryAttribute {
+	end := min(start+g.maxValRange, len(g.members)) - 1
+	high := strconv.Itoa(end)
+	if end == len(g.members)-1 {
+		high = "*"
+	}
+
+	values := g.members[start : end+1]
+	byteValues := make([][]byte, len(values))
+	for i, v := range values {
+		byteValues[i] = []byte(v)
+	}
+	return &ldap.EntryAttribute{
+		Name:       fmt.Sprintf("member;range=%d-%s", start, high),
+		Values:     values,
+		ByteValues: byteValues,
+	}
+}
+
+func (g *rangedGroup) fetch(dn, attr string) (*ldap.EntryAttribute, error) {
+	g.requests = append(g.requests, attr)
+
+	var start int
+	if _, err := fmt.Sscanf(attr, "member;range=%d-*", &start); err != nil {
+		return nil, err
+	}
+	return g.rangeFrom(start), nil
+}
+
+func TestExpandEntryRanges_FetchesEveryRange(t *testing.T) {
+	group := &rangedGroup{maxValRange: 1500}
+	for i := range 3200 {
+		group.members = append(group.members, fmt.Sprintf("CN=User%d,OU=Users,DC=example,DC=com", i))
+	}
+
+	entry := &ldap.Entry{
+		DN: "CN=Large Group,OU=Groups,DC=example,DC=com",
+		Attributes: []*ldap.EntryAttribute{
+			{Name: "cn", Values: []string{"Large Group"}, ByteValues: [][]byte{[]byte("Large Group")}},
+			group.rangeFrom(0),
+		},
+	}
+
+	if err := expandEntryRanges(entry, group.fetch); err != nil {
+		t.Fatalf("expandEntryRanges: %v", err)
+	}
+
+	members := entry.GetAttributeValues("member")
+	if len(members) != len(group.members) {
+		t.Fatalf("got %d members, want %d", len(members), len(group.members))
+	}
+	for i, member := range members {
+		if member != group.members[i] {
+			t.Fatalf("member %d = %q, want %q", i, member, group.members[i])
+		}
+	}
+	if got := len(entry.GetRawAttributeValues("member")); got != len(group.members) {
+		t.Fatalf("got %d raw member values, want %d", got, len(group.members))
+	}
+
+	want := []string{"member;range=1500-*", "member;range=3000-*"}
+	if fmt.Sprint(group.requests) != fmt.Sprint(want) {
+		t.Fatalf("requests = %v, want %v", group.requests, want)
+	}
+}
+
+func TestExpandEntryRanges_LeavesCompleteAttributes(t *testing.T) {
+	entry := &ldap.Entry{
+		DN: "CN=Small Group,OU=Groups,DC=example,DC=com",
+		Attributes: []*ldap.EntryAttribute{
+			{Name: "member", Values: []string{"CN=User0,OU=Users,DC=example,DC=com"}},
+		},
+	}
+
+	fetch := func(dn, attr string) (*ldap.EntryAttribute, error) {
+		t.Fatalf("unexpected ranged read of %s", attr)
+		return nil, nil
+	}
+	if err := expandEntryRanges(entry, fetch); err != nil {
+		t.Fatalf("expandEntryRanges: %v", err)
+	}
+	if got := entry.GetAttributeValues("member"); len(got) != 1 {
+		t.Fatalf("got %d members, want 1", len(got))
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		description string
+		name        string
+		start, end  int
+		ok          bool
+	}{
+		{"member;range=0-1499", "member", 0, 1499, true},
+		{"member;Range=1500-*", "member", 1500, -1, true},
+		{"msDS-RevealedUsers;binary;range=0-99", "msDS-RevealedUsers;binary", 0, 99, true},
+		{"member", "", 0, 0, false},
+		{"member;range=abc-*", "", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		name, start, end, ok := parseRange(tt.description)
+		if name != tt.name || start != tt.start || end != tt.end || ok != tt.ok {
+			t.Errorf("parseRange(%q) = %q, %d, %d, %v; want %q, %d, %d, %v",
+				tt.description, name, start, end, ok, tt.name, tt.start, tt.end, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package activedirectory
+
+import (
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"f0oster/adspy/activedirectory/transformers"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/google/uuid"
+)
+
+// replAttributeMetaData is the constructed attribute holding per-attribute replication metadata.
+// It is read alongside each changed object but kept out of the object's attributes, since it
+// changes with every write.
+const replAttributeMetaData = "msDS-ReplAttributeMetaData"
+
+// AttributeMetadata describes the last originating write of one attribute of an object
+// (DS_REPL_ATTR_META_DATA).
+type AttributeMetadata struct {
+	AttributeName           string
+	Version                 int32
+	OriginatingChangeTime   time.Time
+	OriginatingInvocationID uuid.UUID
+	OriginatingUSN          int64
+	LocalUSN                int64
+	OriginatingDsaDN        string // empty when the originating DC has since been removed
+}
+
+// ReplicationMetadata maps lower-cased attribute names to their replication metadata.
+type ReplicationMetadata map[string]AttributeMetadata
+
+// Get returns the replication metadata recorded for attrName.
+func (m ReplicationMetadata) Get(attrName string) (AttributeMetadata, bool) {
+	metadata, ok := m[strings.ToLower(attrName)]
+	return metadata, ok
+}
+
+// isReplAttributeMetaData reports whether an attribute description names msDS-ReplAttributeMetaData,
+// in either its XML or ;binary form.
+func isReplAttributeMetaData(description string) bool {
+	name, _, _ := strings.Cut(description, ";")
+	return strings.EqualFold(name, replAttributeMetaData)
+}
+
+// parseReplAttributeMetaData decodes every value of msDS-ReplAttributeMetaData.
+func parseReplAttributeMetaData(attr *ldap.EntryAttribute) (ReplicationMetadata, error) {
+	binaryForm := false
+	for _, option := range strings.Split(attr.Name, ";")[1:] {
+		binaryForm = binaryForm || strings.EqualFold(option, "binary")
+	}
+
+	metadata := make(ReplicationMetadata, len(attr.ByteValues))
+	for _, value := range attr.ByteValues {
+		var (
+			entry AttributeMetadata
+			err   error
+		)
+		if binaryForm {
+			entry, err = parseAttributeMetadataBlob(value)
+		} else {
+			entry, err = parseAttributeMetadataXML(value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		metadata[strings.ToLower(entry.AttributeName)] = entry
+	}
+	return metadata, nil
+}
+
+// attributeMetadataXML is the XML form of DS_REPL_ATTR_META_DATA returned by default.
+type attributeMetadataXML struct {
+	AttributeName           string `xml:"pszAttributeName"`
+	Version                 int32  `xml:"dwVersion"`
+	OriginatingChangeTime   string `xml:"ftimeLastOriginatingChange"`
+	OriginatingInvocationID string `xml:"uuidLastOriginatingDsaInvocationID"`
+	OriginatingUSN          int64  `xml:"usnOriginatingChange"`
+	LocalUSN                int64  `xml:"usnLocalChange"`
+	OriginatingDsaDN        string `xml:"pszLastOriginatingDsaDN"`
+}
+
+func parseAttributeMetadataXML(value []byte) (AttributeMetadata, error) {
+	// Values are NUL terminated
+	value = []byte(strings.TrimRight(string(value), "\x00"))
+
+	var raw attributeMetadataXML
+	if err := xml.Unmarshal(value, &raw); err != nil {
+		return AttributeMetadata{}, fmt.Errorf("failed to parse %s value: %w", replAttributeMetaData, err)
+	}
+
+	changeTime, err := time.Parse(time.RFC3339, raw.OriginatingChangeTime)
+	if err != nil {
+		return AttributeMetadata{}, fmt.Errorf("failed to parse originating change time of %s: %w", raw.AttributeName, err)
+	}
+
+	invocationID, err := uuid.Parse(raw.OriginatingInvocationID)
+	if err != nil {
+		return AttributeMetadata{}, fmt.Errorf("failed to parse originating invocationId of %s: %w", raw.AttributeName, err)
+	}
+
+	return AttributeMetadata{
+		AttributeName:           raw.AttributeName,
+		Version:                 raw.Version,
+		OriginatingChangeTime:   changeTime.UTC(),
+		OriginatingInvocationID: invocationID,
+		OriginatingUSN:          raw.OriginatingUSN,
+		LocalUSN:                raw.LocalUSN,
+		OriginatingDsaDN:        raw.OriginatingDsaDN,
+	}, nil
+}
+
+// attributeMetadataBlobSize is the fixed part of DS_REPL_ATTR_META_DATA_BLOB; the strings it
+// references by offset follow it.
+const attributeMetadataBlobSize = 52
+
+// parseAttributeMetadataBlob decodes DS_REPL_ATTR_META_DATA_BLOB, the ;binary form.
+func parseAttributeMetadataBlob(value []byte) (AttributeMetadata, error) {
+	if len(value) < attributeMetadataBlobSize {
+		return AttributeMetadata{}, fmt.Errorf("%s;binary value is %d bytes, expected at least %d", replAttributeMetaData, len(value), attributeMetadataBlobSize)
+	}
+
+	le := binary.LittleEndian
+
+	name, err := blobString(value, le.Uint32(value[0:4]))
+	if err != nil {
+		return AttributeMetadata{}, fmt.Errorf("failed to read attribute name: %w", err)
+	}
+
+	// Invocation IDs are stored in the same mixed-endian layout as objectGUID
+	invocationIDs, err := transformers.ADGuidFormatter{}.Normalize([][]byte{value[16:32]})
+	if err != nil {
+		return AttributeMetadata{}, fmt.Errorf("failed to parse originating invocationId of %s: %w", name, err)
+	}
+	invocationID, err := uuid.Parse(invocationIDs[0])
+	if err != nil {
+		return AttributeMetadata{}, fmt.Errorf("failed to parse originating invocationId of %s: %w", name, err)
+	}
+
+	dsaDN, err := blobString(value, le.Uint32(value[48:52]))
+	if err != nil {
+		return AttributeMetadata{}, fmt.Errorf("failed to read originating DSA DN of %s: %w", name, err)
+	}
+
+	return AttributeMetadata{
+		AttributeName:           name,
+		Version:                 int32(le.Uint32(value[4:8])),
+		OriginatingChangeTime:   fileTimeToTime(le.Uint64(value[8:16])),
+		OriginatingInvocationID: invocationID,
+		OriginatingUSN:          int64(le.Uint64(value[32:40])),
+		LocalUSN:                int64(le.Uint64(value[40:48])),
+		OriginatingDsaDN:        dsaDN,
+	}, nil
+}
+
+// blobString reads the NUL terminated UTF-16LE string at offset; offset 0 means no string.
+func blobString(blob []byte, offset uint32) (string, error) {
+	if offset == 0 {
+		return "", nil
+	}
+	if int(offset) >= len(blob) {
+		return "", fmt.Errorf("string offset %d is outside the %d byte value", offset, len(blob))
+	}
+
+	var units []uint16
+	for i := int(offset); i+1 < len(blob); i += 2 {
+		unit := binary.LittleEndian.Uint16(blob[i:])
+		if unit == 0 {
+			return string(utf16.Decode(units)), nil
+		}
+		units = append(units, unit)
+	}
+	return "", fmt.Errorf("string at offset %d is not terminated", offset)
+}
+
+// fileTimeToTime converts a FILETIME (100ns intervals since 1601-01-01 UTC) to a time.Time.
+func fileTimeToTime(fileTime uint64) time.Time {
+	const epochDelta = 116444736000000000 // 1601-01-01 to 1970-01-01 in 100ns intervals
+	if fileTime < epochDelta {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(fileTime-epochDelta)*100).UTC()
+}
+
+// replValueMetaData is the constructed attribute holding per-value replication metadata for
+// linked attributes such as member.
+const replValueMetaData = "msDS-ReplValueMetaData"
+
+// ValueMetadata describes the last originating write of one value of a linked attribute
+// (DS_REPL_VALUE_META_DATA).
+//
+// Values written before the forest reached the Windows Server 2003 functional level are
+// "legacy" values: they are replicated with the whole attribute and carry no metadata of their
+// own, so they are reported with zero USNs and times.
+type ValueMetadata struct {
+	AttributeName           string
+	ObjectDN                string    // the linked object, i.e. the value
+	Created                 time.Time // zero for legacy values
+	Deleted                 time.Time // zero while the value is present
+	Version                 int32
+	OriginatingChangeTime   time.Time
+	OriginatingInvocationID uuid.UUID
+	OriginatingUSN          int64
+	LocalUSN                int64
+	OriginatingDsaDN        string
+}
+
+// Legacy reports whether the value predates linked value replication (LVR).
+func (v ValueMetadata) Legacy() bool {
+	return v.OriginatingUSN == 0
+}
+
+// Removed reports whether the value has been removed. LVR keeps removed values as absent
+// values until the tombstone lifetime expires, which is how their removal is attributed.
+func (v ValueMetadata) Removed() bool {
+	return !v.Deleted.IsZero()
+}
+
+// LinkedValueMetadata maps lower-cased linked attribute names to the metadata of their values.
+type LinkedValueMetadata map[string][]ValueMetadata
+
+// Get returns the value metadata recorded for attrName.
+func (m LinkedValueMetadata) Get(attrName string) []ValueMetadata {
+	return m[strings.ToLower(attrName)]
+}
+
+// isReplValueMetaData reports whether an attribute description names msDS-ReplValueMetaData.
+func isReplValueMetaData(description string) bool {
+	name, _, _ := strings.Cut(description, ";")
+	return strings.EqualFold(name, replValueMetaData)
+}
+
+// valueMetadataXML is the XML form of DS_REPL_VALUE_META_DATA.
+type valueMetadataXML struct {
+	AttributeName           string `xml:"pszAttributeName"`
+	ObjectDN                string `xml:"pszObjectDn"`
+	Deleted                 string `xml:"ftimeDeleted"`
+	Created                 string `xml:"ftimeCreated"`
+	Version                 int32  `xml:"dwVersion"`
+	OriginatingChangeTime   string `xml:"ftimeLastOriginatingChange"`
+	OriginatingInvocationID string `xml:"uuidLastOriginatingDsaInvocationID"`
+	OriginatingUSN          int64  `xml:"usnOriginatingChange"`
+	LocalUSN                int64  `xml:"usnLocalChange"`
+	OriginatingDsaDN        string `xml:"pszLastOriginatingDsaDN"`
+}
+
+// parseReplValueMetaData decodes every value of msDS-ReplValueMetaData. Only the XML form is
+// supported; it is the form returned unless ;binary is requested.
+func parseReplValueMetaData(attr *ldap.EntryAttribute) (LinkedValueMetadata, error) {
+	for _, option := range strings.Split(attr.Name, ";")[1:] {
+		if strings.EqualFold(option, "binary") {
+			return nil, fmt.Errorf("%s is not supported", attr.Name)
+		}
+	}
+
+	metadata := make(LinkedValueMetadata)
+	for _, value := range attr.ByteValues {
+		value = []byte(strings.TrimRight(string(value), "\x00"))
+
+		var raw valueMetadataXML
+		if err := xml.Unmarshal(value, &raw); err != nil {
+			return nil, fmt.Errorf("failed to parse %s value: %w", replValueMetaData, err)
+		}
+
+		entry := ValueMetadata{
+			AttributeName:    raw.AttributeName,
+			ObjectDN:         raw.ObjectDN,
+			Version:          raw.Version,
+			OriginatingUSN:   raw.OriginatingUSN,
+			LocalUSN:         raw.LocalUSN,
+			OriginatingDsaDN: raw.OriginatingDsaDN,
+		}
+
+		var err error
+		if entry.Deleted, err = parseMetadataTime(raw.Deleted); err != nil {
+			return nil, fmt.Errorf("failed to parse deletion time of %s value %s: %w", raw.AttributeName, raw.ObjectDN, err)
+		}
+		if entry.Created, err = parseMetadataTime(raw.Created); err != nil {
+			return nil, fmt.Errorf("failed to parse creation time of %s value %s: %w", raw.AttributeName, raw.ObjectDN, err)
+		}
+		if entry.OriginatingChangeTime, err = parseMetadataTime(raw.OriginatingChangeTime); err != nil {
+			return nil, fmt.Errorf("failed to parse originating change time of %s value %s: %w", raw.AttributeName, raw.ObjectDN, err)
+		}
+		if raw.OriginatingInvocationID != "" {
+			if entry.OriginatingInvocationID, err = uuid.Parse(raw.OriginatingInvocationID); err != nil {
+				return nil, fmt.Errorf("failed to parse originating invocationId of %s value %s: %w", raw.AttributeName, raw.ObjectDN, err)
+			}
+		}
+
+		key := strings.ToLower(entry.AttributeName)
+		metadata[key] = append(metadata[key], entry)
+	}
+	return metadata, nil
+}
+
+// parseMetadataTime parses an XML metadata timestamp. The FILETIME epoch (1601-01-01), used
+// for "never", becomes the zero time.
+func parseMetadataTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if parsed.Year() <= 1601 {
+		return time.Time{}, nil
+	}
+	return parsed.UTC(), nil
+}
@@ -0,0 +1,177 @@
+package activedirectory
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/google/uuid"
+)
+
+const (
+	testDsaDN        = "CN=NTDS Settings,CN=DC1,CN=Servers,CN=Default-First-Site-Name,CN=Sites,CN=Configuration,DC=example,DC=com"
+	testInvocationID = "6f3b4f2a-1c2d-4e5f-8a9b-0c1d2e3f4a5b"
+)
+
+var testChangeTime = time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+
+func TestParseReplAttributeMetaData_XML(t *testing.T) {
+	value := "<DS_REPL_ATTR_META_DATA>\n" +
+		"\t<pszAttributeName>description</pszAttributeName>\n" +
+		"\t<dwVersion>3</dwVersion>\n" +
+		"\t<ftimeLastOriginatingChange>2024-05-06T07:08:09Z</ftimeLastOriginatingChange>\n" +
+		"\t<uuidLastOriginatingDsaInvocationID>" + testInvocationID + "</uuidLastOriginatingDsaInvocationID>\n" +
+		"\t<usnOriginatingChange>40123</usnOriginatingChange>\n" +
+		"\t<usnLocalChange>40125</usnLocalChange>\n" +
+		"\t<pszLastOriginatingDsaDN>" + testDsaDN + "</pszLastOriginatingDsaDN>\n" +
+		"</DS_REPL_ATTR_META_DATA>\n\x00"
+
+	metadata, err := parseReplAttributeMetaData(&ldap.EntryAttribute{
+		Name:       "msDS-ReplAttributeMetaData",
+		ByteValues: [][]byte{[]byte(value)},
+	})
+	if err != nil {
+		t.Fatalf("parseReplAttributeMetaData: %v", err)
+	}
+
+	assertDescriptionMetadata(t, metadata)
+}
+
+func TestParseReplAttributeMetaData_Binary(t *testing.T) {
+	metadata, err := parseReplAttributeMetaData(&ldap.EntryAttribute{
+		Name:       "msDS-ReplAttributeMetaData;binary",
+		ByteValues: [][]byte{attributeMetadataBlob(t, "description", 3, testChangeTime, 40123, 40125, testDsaDN)},
+	})
+	if err != nil {
+		t.Fatalf("parseReplAttributeMetaData: %v", err)
+	}
+
+	assertDescriptionMetadata(t, metadata)
+}
+
+func TestParseReplAttributeMetaData_RejectsTruncatedBlob(t *testing.T) {
+	_, err := parseReplAttributeMetaData(&ldap.EntryAttribute{
+		Name:       "msDS-ReplAttributeMetaData;binary",
+		ByteValues: [][]byte{make([]byte, 20)},
+	})
+	if err == nil {
+		t.Fatal("expected an error for a truncated blob")
+	}
+}
+
+func assertDescriptionMetadata(t *testing.T, metadata ReplicationMetadata) {
+	t.Helper()
+
+	got, ok := metadata.Get("Description")
+	if !ok {
+		t.Fatalf("no metadata for description in %v", metadata)
+	}
+
+	want := AttributeMetadata{
+		AttributeName:           "description",
+		Version:                 3,
+		OriginatingChangeTime:   testChangeTime,
+		OriginatingInvocationID: uuid.MustParse(testInvocationID),
+		OriginatingUSN:          40123,
+		LocalUSN:                40125,
+		OriginatingDsaDN:        testDsaDN,
+	}
+	if got != want {
+		t.Fatalf("metadata = %+v, want %+v", got, want)
+	}
+}
+
+// attributeMetadataBlob encodes a DS_REPL_ATTR_META_DATA_BLOB with its strings after the fixed part.
+func attributeMetadataBlob(t *testing.T, name string, version uint32, changeTime time.Time, originatingUSN, localUSN uint64, dsaDN string) []byte {
+	t.Helper()
+
+	le := binary.LittleEndian
+	blob := make([]byte, attributeMetadataBlobSize)
+
+	appendString := func(s string) uint32 {
+		offset := uint32(len(blob))
+		for _, unit := range append(utf16.Encode([]rune(s)), 0) {
+			blob = le.AppendUint16(blob, unit)
+		}
+		return offset
+	}
+
+	nameOffset := appendString(name)
+	dsaOffset := appendString(dsaDN)
+
+	le.PutUint32(blob[0:4], nameOffset)
+	le.PutUint32(blob[4:8], version)
+	le.PutUint64(blob[8:16], uint64(changeTime.UnixNano()/100)+116444736000000000)
+
+	// Windows GUIDs store the first three fields little-endian
+	id := uuid.MustParse(testInvocationID)
+	copy(blob[16:32], []byte{
+		id[3], id[2], id[1], id[0],
+		id[5], id[4],
+		id[7], id[6],
+		id[8], id[9], id[10], id[11], id[12], id[13], id[14], id[15],
+	})
+
+	le.PutUint64(blob[32:40], originatingUSN)
+	le.PutUint64(blob[40:48], localUSN)
+	le.PutUint32(blob[48:52], dsaOffset)
+
+	return blob
+}
+
+func TestParseReplValueMetaData(t *testing.T) {
+	removed := "<DS_REPL_VALUE_META_DATA>\n" +
+		"\t<pszAttributeName>member</pszAttributeName>\n" +
+		"\t<pszObjectDn>CN=Alice,OU=Users,DC=example,DC=com</pszObjectDn>\n" +
+		"\t<cbData>0</cbData>\n" +
+		"\t<pbData></pbData>\n" +
+		"\t<ftimeDeleted>2024-05-06T07:08:09Z</ftimeDeleted>\n" +
+		"\t<ftimeCreated>2023-01-02T03:04:05Z</ftimeCreated>\n" +
+		"\t<dwVersion>2</dwVersion>\n" +
+		"\t<ftimeLastOriginatingChange>2024-05-06T07:08:09Z</ftimeLastOriginatingChange>\n" +
+		"\t<uuidLastOriginatingDsaInvocationID>" + testInvocationID + "</uuidLastOriginatingDsaInvocationID>\n" +
+		"\t<usnOriginatingChange>40123</usnOriginatingChange>\n" +
+		"\t<usnLocalChange>40125</usnLocalChange>\n" +
+		"\t<pszLastOriginatingDsaDN>" + testDsaDN + "</pszLastOriginatingDsaDN>\n" +
+		"</DS_REPL_VALUE_META_DATA>\n\x00"
+	legacy := "<DS_REPL_VALUE_META_DATA>\n" +
+		"\t<pszAttributeName>member</pszAttributeName>\n" +
+		"\t<pszObjectDn>CN=Bob,OU=Users,DC=example,DC=com</pszObjectDn>\n" +
+		"\t<ftimeDeleted>1601-01-01T00:00:00Z</ftimeDeleted>\n" +
+		"\t<ftimeCreated>1601-01-01T00:00:00Z</ftimeCreated>\n" +
+		"\t<dwVersion>0</dwVersion>\n" +
+		"\t<ftimeLastOriginatingChange>1601-01-01T00:00:00Z</ftimeLastOriginatingChange>\n" +
+		"\t<uuidLastOriginatingDsaInvocationID>00000000-0000-0000-0000-000000000000</uuidLastOriginatingDsaInvocationID>\n" +
+		"\t<usnOriginatingChange>0</usnOriginatingChange>\n" +
+		"\t<usnLocalChange>0</usnLocalChange>\n" +
+		"\t<pszLastOriginatingDsaDN></pszLastOriginatingDsaDN>\n" +
+		"</DS_REPL_VALUE_META_DATA>\n\x00"
+
+	metadata, err := parseReplValueMetaData(&ldap.EntryAttribute{
+		Name:       "msDS-ReplValueMetaData",
+		ByteValues: [][]byte{[]byte(removed), []byte(legacy)},
+	})
+	if err != nil {
+		t.Fatalf("parseReplValueMetaData: %v", err)
+	}
+
+	values := metadata.Get("Member")
+	if len(values) != 2 {
+		t.Fatalf("got %d member values, want 2", len(values))
+	}
+
+	alice := values[0]
+	if alice.Legacy() || !alice.Removed() {
+		t.Errorf("Alice: legacy=%v removed=%v, want an LVR value that was removed", alice.Legacy(), alice.Removed())
+	}
+	if !alice.Deleted.Equal(testChangeTime) || alice.OriginatingDsaDN != testDsaDN || alice.Version != 2 {
+		t.Errorf("Alice metadata = %+v", alice)
+	}
+
+	bob := values[1]
+	if !bob.Legacy() || bob.Removed() || !bob.Created.IsZero() {
+		t.Errorf("Bob: legacy=%v removed=%v created=%s, want a present legacy value", bob.Legacy(), bob.Removed(), bob.Created)
+	}
+}
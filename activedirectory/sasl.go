@@ -0,0 +1,323 @@
+package activedirectory
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/crypto/etype"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+// RFC 4121 section 4.2.2 wrap token flags
+const (
+	wrapFlagSentByAcceptor = 0x01
+	wrapFlagSealed         = 0x02
+	wrapFlagAcceptorSubkey = 0x04
+)
+
+// SASL security layer bits offered and selected during the GSSAPI handshake (RFC 4752 section 3.3)
+const (
+	saslLayerNone      = 0x01
+	saslLayerIntegrity = 0x02
+	saslLayerConfident = 0x04
+)
+
+// maxSASLBufferSize is the largest SASL buffer adSpy will accept or advertise.
+const maxSASLBufferSize = 0xFFFFFF
+
+// wrapTokenOverhead is the room left in each SASL buffer for the token header, confounder and
+// checksum. A peer buffer limit no larger than this cannot carry any payload.
+const wrapTokenOverhead = 64
+
+// securityLayer wraps and unwraps RFC 4121 tokens for an established Kerberos context.
+type securityLayer struct {
+	key        types.EncryptionKey
+	etype      etype.EType
+	seal       bool
+	initiator  bool
+	sendFlags  byte
+	sendUsage  uint32
+	recvUsage  uint32
+	maxSendLen int
+
+	mu      sync.Mutex
+	sendSeq uint64
+	recvSeq uint64 // sequence number the peer's next token must carry
+}
+
+func newSecurityLayer(key types.EncryptionKey, acceptorSubkey bool, seal, initiator bool, sendSeq, recvSeq uint64) (*securityLayer, error) {
+	et, err := crypto.GetEtype(key.KeyType)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported session key type %d: %w", key.KeyType, err)
+	}
+
+	l := &securityLayer{
+		key:       key,
+		etype:     et,
+		seal:      seal,
+		initiator: initiator,
+		sendSeq:   sendSeq,
+		recvSeq:   recvSeq,
+		sendUsage: keyusage.GSSAPI_INITIATOR_SEAL,
+		recvUsage: keyusage.GSSAPI_ACCEPTOR_SEAL,
+	}
+	if !initiator {
+		l.sendFlags |= wrapFlagSentByAcceptor
+		l.sendUsage, l.recvUsage = l.recvUsage, l.sendUsage
+	}
+	if acceptorSubkey {
+		l.sendFlags |= wrapFlagAcceptorSubkey
+	}
+	return l, nil
+}
+
+// wrap protects payload with the next send sequence number.
+func (l *securityLayer) wrap(payload []byte) ([]byte, error) {
+	return l.wrapWithSeq(payload, l.nextSeq(), l.seal)
+}
+
+func (l *securityLayer) nextSeq() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	seq := l.sendSeq
+	l.sendSeq++
+	return seq
+}
+
+func (l *securityLayer) wrapWithSeq(payload []byte, seq uint64, seal bool) ([]byte, error) {
+	if !seal {
+		token := gssapi.WrapToken{
+			Flags:     l.sendFlags,
+			EC:        uint16(l.etype.GetHMACBitLength() / 8),
+			SndSeqNum: seq,
+			Payload:   payload,
+		}
+		if err := token.SetCheckSum(l.key, l.sendUsage); err != nil {
+			return nil, fmt.Errorf("failed to sign wrap token: %w", err)
+		}
+		return token.Marshal()
+	}
+
+	header := wrapTokenHeader(l.sendFlags|wrapFlagSealed, seq)
+
+	// The encrypted copy of the header carries EC and RRC as zero (RFC 4121 section 4.2.4)
+	plaintext := make([]byte, 0, len(payload)+len(header))
+	plaintext = append(plaintext, payload...)
+	plaintext = append(plaintext, header...)
+
+	_, ciphertext, err := l.etype.EncryptMessage(l.key.KeyValue, plaintext, l.sendUsage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to seal wrap token: %w", err)
+	}
+
+	return append(header, ciphertext...), nil
+}
+
+// unwrap verifies (and decrypts, if sealed) a token received from the peer. Tokens must arrive
+// in sequence, so a replayed, reordered or dropped response is rejected.
+func (l *securityLayer) unwrap(token []byte) ([]byte, error) {
+	payload, seq, err := l.verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if seq != l.recvSeq {
+		return nil, fmt.Errorf("wrap token out of sequence: got %d, want %d", seq, l.recvSeq)
+	}
+	l.recvSeq++
+	return payload, nil
+}
+
+// verify checks a token's header and integrity and returns its payload and sequence number.
+func (l *securityLayer) verify(token []byte) ([]byte, uint64, error) {
+	if len(token) < gssapi.HdrLen {
+		return nil, 0, fmt.Errorf("wrap token too short: %d bytes", len(token))
+	}
+	if token[0] != 0x05 || token[1] != 0x04 || token[3] != gssapi.FillerByte {
+		return nil, 0, fmt.Errorf("malformed wrap token header")
+	}
+
+	flags := token[2]
+	if fromAcceptor := flags&wrapFlagSentByAcceptor != 0; fromAcceptor != l.initiator {
+		return nil, 0, fmt.Errorf("wrap token was not sent by the peer")
+	}
+
+	ec := int(binary.BigEndian.Uint16(token[4:6]))
+	rrc := int(binary.BigEndian.Uint16(token[6:8]))
+	seq := binary.BigEndian.Uint64(token[8:16])
+	data := unrotate(token[gssapi.HdrLen:], rrc)
+
+	if flags&wrapFlagSealed != 0 {
+		plaintext, err := l.etype.DecryptMessage(l.key.KeyValue, data, l.recvUsage)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to unseal wrap token: %w", err)
+		}
+		if len(plaintext) < gssapi.HdrLen+ec {
+			return nil, 0, fmt.Errorf("sealed wrap token too short")
+		}
+		trailer := plaintext[len(plaintext)-gssapi.HdrLen:]
+		if trailer[2] != flags || binary.BigEndian.Uint64(trailer[8:16]) != seq {
+			return nil, 0, fmt.Errorf("sealed wrap token header mismatch")
+		}
+		return plaintext[:len(plaintext)-gssapi.HdrLen-ec], seq, nil
+	}
+
+	if len(data) < ec {
+		return nil, 0, fmt.Errorf("signed wrap token too short")
+	}
+	wrapped := gssapi.WrapToken{
+		Flags:     flags,
+		EC:        uint16(ec),
+		RRC:       uint16(rrc),
+		SndSeqNum: seq,
+		Payload:   data[:len(data)-ec],
+		CheckSum:  data[len(data)-ec:],
+	}
+	if _, err := wrapped.Verify(l.key, l.recvUsage); err != nil {
+		return nil, 0, fmt.Errorf("failed to verify wrap token: %w", err)
+	}
+	return wrapped.Payload, seq, nil
+}
+
+func wrapTokenHeader(flags byte, seq uint64) []byte {
+	header := make([]byte, gssapi.HdrLen)
+	header[0], header[1], header[2], header[3] = 0x05, 0x04, flags, gssapi.FillerByte
+	binary.BigEndian.PutUint64(header[8:16], seq)
+	return header
+}
+
+// unrotate reverses the right rotation applied by the sender (RFC 4121 section 4.2.5).
+func unrotate(data []byte, rrc int) []byte {
+	if len(data) == 0 {
+		return data
+	}
+	rrc %= len(data)
+	if rrc == 0 {
+		return data
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, data[rrc:]...)
+	return append(out, data[:rrc]...)
+}
+
+// saslConn passes traffic through untouched until a security layer is installed,
+// after which every read and write is framed as SASL buffers (RFC 4422 section 3.7).
+type saslConn struct {
+	net.Conn
+
+	layer atomic.Pointer[securityLayer]
+
+	writeMu sync.Mutex
+	inbound []byte // raw bytes received but not yet unwrapped
+	pending []byte // unwrapped bytes not yet returned to the reader
+}
+
+func newSASLConn(conn net.Conn) *saslConn {
+	return &saslConn{Conn: conn}
+}
+
+// install switches the connection to the given security layer. It must only be called
+// once the bind has completed and before any further request is written.
+func (c *saslConn) install(layer *securityLayer) {
+	c.layer.Store(layer)
+}
+
+func (c *saslConn) Read(p []byte) (int, error) {
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+
+	if c.layer.Load() == nil {
+		n, err := c.Conn.Read(p)
+		if c.layer.Load() == nil || n == 0 {
+			return n, err
+		}
+		// The layer was installed while this read was blocked, so these bytes are already framed
+		c.inbound = append(c.inbound, p[:n]...)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err := c.readBuffer(); err != nil {
+		return 0, err
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// readBuffer reads and unwraps the next complete SASL buffer into pending.
+func (c *saslConn) readBuffer() error {
+	chunk := make([]byte, 4096)
+	for {
+		if len(c.inbound) >= 4 {
+			size := int(binary.BigEndian.Uint32(c.inbound[:4]))
+			if size > maxSASLBufferSize {
+				return fmt.Errorf("SASL buffer of %d bytes exceeds limit", size)
+			}
+			if len(c.inbound) >= 4+size {
+				payload, err := c.layer.Load().unwrap(c.inbound[4 : 4+size])
+				if err != nil {
+					return err
+				}
+				c.inbound = c.inbound[4+size:]
+				c.pending = append(c.pending, payload...)
+				return nil
+			}
+		}
+
+		n, err := c.Conn.Read(chunk)
+		c.inbound = append(c.inbound, chunk[:n]...)
+		if err != nil {
+			if err == io.EOF && len(c.inbound) > 0 {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+}
+
+func (c *saslConn) Write(p []byte) (int, error) {
+	layer := c.layer.Load()
+	if layer == nil {
+		return c.Conn.Write(p)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	// leave room for the token header, confounder and checksum within the peer's buffer limit;
+	// NegotiateSaslAuth rejects limits too small to leave any
+	chunkSize := len(p)
+	if layer.maxSendLen > 0 && chunkSize > layer.maxSendLen-wrapTokenOverhead {
+		chunkSize = layer.maxSendLen - wrapTokenOverhead
+	}
+
+	for offset := 0; offset < len(p); offset += chunkSize {
+		end := min(offset+chunkSize, len(p))
+		token, err := layer.wrap(p[offset:end])
+		if err != nil {
+			return offset, err
+		}
+		frame := make([]byte, 4, 4+len(token))
+		binary.BigEndian.PutUint32(frame, uint32(len(token)))
+		if _, err := c.Conn.Write(append(frame, token...)); err != nil {
+			return offset, err
+		}
+	}
+
+	return len(p), nil
+}
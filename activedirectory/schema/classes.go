@@ -0,0 +1,180 @@
+package schema
+
+import (
+	"slices"
+	"strings"
+)
+
+func (r *SchemaRegistry) RegisterClassSchema(class *ClassSchema) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.classSchemas[class.LDAPDisplayName] = class
+	r.objectCategories[strings.ToLower(class.DN)] = class
+}
+
+func (r *SchemaRegistry) GetClassSchema(ldapName string) (*ClassSchema, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	class, ok := r.classSchemas[ldapName]
+	return class, ok
+}
+
+func (r *SchemaRegistry) GetAllClassSchemas() []*ClassSchema {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	classes := make([]*ClassSchema, 0, len(r.classSchemas))
+	for _, class := range r.classSchemas {
+		classes = append(classes, class)
+	}
+	return classes
+}
+
+// ResolveObjectCategory returns the class an objectCategory DN refers to. DNs are compared
+// case-insensitively.
+func (r *SchemaRegistry) ResolveObjectCategory(dn string) (*ClassSchema, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	class, ok := r.objectCategories[strings.ToLower(dn)]
+	return class, ok
+}
+
+// ClassHierarchy returns the class followed by its superclasses, ending with top. Classes
+// missing from the registry end the chain.
+func (r *SchemaRegistry) ClassHierarchy(ldapName string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var hierarchy []string
+	for name := ldapName; name != "" && !slices.Contains(hierarchy, name); {
+		class, ok := r.classSchemas[name]
+		if !ok {
+			break
+		}
+		hierarchy = append(hierarchy, name)
+		name = class.SubClassOf // top is a subclass of itself
+	}
+	return hierarchy
+}
+
+// ClassAttributes returns the attributes an object of the class must and may have, inherited
+// from its superclasses and auxiliary classes included. Both lists are sorted; an attribute
+// that is mandatory is not also listed as optional.
+func (r *SchemaRegistry) ClassAttributes(ldapName string) (must, may []string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	mustSet := make(map[string]bool)
+	maySet := make(map[string]bool)
+	r.collectClassAttributes(ldapName, make(map[string]bool), mustSet, maySet)
+
+	for name := range mustSet {
+		must = append(must, name)
+	}
+	for name := range maySet {
+		if !mustSet[name] {
+			may = append(may, name)
+		}
+	}
+	slices.Sort(must)
+	slices.Sort(may)
+	return must, may
+}
+
+// AttributeAllowed reports whether the schema permits the attribute on an object with the
+// given objectClass values. Attribute names are compared case-insensitively, as LDAP does.
+// objectClass lists the structural class chain and any auxiliary classes added to the object
+// itself, so the attributes of every listed class are permitted.
+func (r *SchemaRegistry) AttributeAllowed(objectClasses []string, attribute string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	attributes := make(map[string]bool)
+	visited := make(map[string]bool)
+	for _, objectClass := range objectClasses {
+		r.collectClassAttributes(objectClass, visited, attributes, attributes)
+	}
+	for name := range attributes {
+		if strings.EqualFold(name, attribute) {
+			return true
+		}
+	}
+	return false
+}
+
+// collectClassAttributes adds the must and may attributes of the class, its superclasses and
+// its auxiliary classes to must and may. The caller holds r.mu.
+func (r *SchemaRegistry) collectClassAttributes(ldapName string, visited, must, may map[string]bool) {
+	if visited[ldapName] {
+		return
+	}
+	visited[ldapName] = true
+
+	class, ok := r.classSchemas[ldapName]
+	if !ok {
+		return
+	}
+	for _, name := range class.MustContain {
+		must[name] = true
+	}
+	for _, name := range class.MayContain {
+		may[name] = true
+	}
+	for _, auxiliary := range class.AuxiliaryClasses {
+		r.collectClassAttributes(auxiliary, visited, must, may)
+	}
+	r.collectClassAttributes(class.SubClassOf, visited, must, may)
+}
+
+// CompareClassSchemas reports how classes differ from the registered class schemas. Only the
+// class fields of the returned SchemaChange are set.
+func (r *SchemaRegistry) CompareClassSchemas(classes []*ClassSchema) SchemaChange {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return compareClassSchemas(r.classSchemas, classes)
+}
+
+// ReplaceClassSchemas swaps the registered class schemas for classes in one step and reports
+// what changed, like ReplaceAttributeSchemas.
+func (r *SchemaRegistry) ReplaceClassSchemas(classes []*ClassSchema) SchemaChange {
+	replacement := make(map[string]*ClassSchema, len(classes))
+	categories := make(map[string]*ClassSchema, len(classes))
+	for _, class := range classes {
+		replacement[class.LDAPDisplayName] = class
+		categories[strings.ToLower(class.DN)] = class
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	change := compareClassSchemas(r.classSchemas, classes)
+	r.classSchemas = replacement
+	r.objectCategories = categories
+	return change
+}
+
+func compareClassSchemas(previous map[string]*ClassSchema, classes []*ClassSchema) SchemaChange {
+	current := make(map[string]*ClassSchema, len(classes))
+	for _, class := range classes {
+		current[class.LDAPDisplayName] = class
+	}
+
+	var change SchemaChange
+	change.AddedClasses, change.ModifiedClasses, change.RemovedClasses = compareDefinitions(previous, current, sameClassDefinition)
+	return change
+}
+
+// sameClassDefinition compares every schema-defined property of two classes. The attribute and
+// class lists are sorted when the schema is read.
+func sameClassDefinition(a, b *ClassSchema) bool {
+	return a.ObjectGUID == b.ObjectGUID &&
+		a.DN == b.DN &&
+		a.ClassName == b.ClassName &&
+		a.GovernsID == b.GovernsID &&
+		a.SchemaIDGUID == b.SchemaIDGUID &&
+		a.SubClassOf == b.SubClassOf &&
+		a.ObjectClassCategory == b.ObjectClassCategory &&
+		slices.Equal(a.AuxiliaryClasses, b.AuxiliaryClasses) &&
+		slices.Equal(a.MustContain, b.MustContain) &&
+		slices.Equal(a.MayContain, b.MayContain) &&
+		a.DefaultSecurityDescriptor == b.DefaultSecurityDescriptor &&
+		a.DefaultObjectCategory == b.DefaultObjectCategory
+}
@@ -0,0 +1,42 @@
+package schema
+
+import (
+	"reflect"
+
+	"f0oster/adspy/activedirectory/transformers"
+)
+
+// flagFormatter is a FlagFormatter or an EnumFormatter.
+type flagFormatter interface {
+	transformers.Interpreter
+	transformers.Normalizer
+}
+
+// flagAttributes are the Integer attributes whose values are bitmasks or enumerations, keyed
+// by lDAPDisplayName.
+var flagAttributes = map[string]flagFormatter{
+	"userAccountControl":                 transformers.FlagFormatter{Flags: transformers.UserAccountControlFlags},
+	"msDS-User-Account-Control-Computed": transformers.FlagFormatter{Flags: transformers.UserAccountControlComputedFlags},
+	"groupType":                          transformers.FlagFormatter{Flags: transformers.GroupTypeFlags},
+	"sAMAccountType":                     transformers.EnumFormatter{Values: transformers.SAMAccountTypeValues},
+	"systemFlags":                        transformers.FlagFormatter{Flags: transformers.SystemFlags},
+	"searchFlags":                        transformers.FlagFormatter{Flags: transformers.SearchFlags},
+	"msDS-SupportedEncryptionTypes":      transformers.FlagFormatter{Flags: transformers.SupportedEncryptionTypeFlags},
+	"trustAttributes":                    transformers.FlagFormatter{Flags: transformers.TrustAttributeFlags},
+}
+
+func (r *SchemaRegistry) registerFlagAttributes() {
+	for ldapName, formatter := range flagAttributes {
+		syntaxName := "Integer (Flags)"
+		if _, ok := formatter.(transformers.EnumFormatter); ok {
+			syntaxName = "Enumeration"
+		}
+		r.OverrideAttribute(ldapName, &AttributeFieldType{
+			GoType:      reflect.TypeOf(transformers.Flags{}),
+			SyntaxName:  syntaxName,
+			Syntax:      integerSyntax,
+			Interpreter: formatter,
+			Normalizer:  formatter,
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package schema
+
+import (
+	"reflect"
+	"time"
+
+	"f0oster/adspy/activedirectory/transformers"
+)
+
+// LargeIntegerKind is what the value of a Large Integer (2.5.5.16) attribute measures. The
+// syntax only says the value is a 64-bit integer, so the kind is looked up by attribute.
+type LargeIntegerKind int
+
+const (
+	LargeIntegerFiletime LargeIntegerKind = iota // point in time, in 100ns intervals since 1601
+	LargeIntegerInterval                         // duration in 100ns intervals
+	LargeIntegerCounter                          // plain number: USNs, counts, sizes and RID pools
+)
+
+// fieldType returns how attributes of the kind are typed and formatted.
+func (k LargeIntegerKind) fieldType() *AttributeFieldType {
+	switch k {
+	case LargeIntegerInterval:
+		return &AttributeFieldType{
+			GoType:      reflect.TypeOf((*time.Duration)(nil)),
+			SyntaxName:  "Large Integer (Interval)",
+			Syntax:      largeIntegerSyntax,
+			Interpreter: transformers.ADIntervalFormatter{},
+			Normalizer:  transformers.ADIntervalFormatter{},
+		}
+	case LargeIntegerCounter:
+		return &AttributeFieldType{
+			GoType:      reflect.TypeOf(int64(0)),
+			SyntaxName:  "Large Integer",
+			Syntax:      largeIntegerSyntax,
+			Interpreter: transformers.LargeIntegerFormatter{},
+			Normalizer:  transformers.LargeIntegerFormatter{},
+		}
+	default:
+		return &AttributeFieldType{
+			GoType:      reflect.TypeOf((*time.Time)(nil)),
+			SyntaxName:  "Large Integer (FILETIME)",
+			Syntax:      largeIntegerSyntax,
+			Interpreter: transformers.ADFiletimeFormatter{},
+			Normalizer:  transformers.ADFiletimeFormatter{},
+		}
+	}
+}
+
+// largeIntegerCatalogue classifies the Large Integer attributes of the base schema and of
+// common extensions. Attributes missing from it are read as plain numbers, so a counter is never
+// shown as a made-up date; FILETIMEs and durations have to be listed.
+var largeIntegerCatalogue = map[string]LargeIntegerKind{
+	// Points in time
+	"accountExpires":                          LargeIntegerFiletime,
+	"badPasswordTime":                         LargeIntegerFiletime,
+	"creationTime":                            LargeIntegerFiletime,
+	"lastLogoff":                              LargeIntegerFiletime,
+	"lastLogon":                               LargeIntegerFiletime,
+	"lastLogonTimestamp":                      LargeIntegerFiletime,
+	"lastSetTime":                             LargeIntegerFiletime,
+	"lockoutTime":                             LargeIntegerFiletime,
+	"msDS-ApproximateLastLogonTimeStamp":      LargeIntegerFiletime,
+	"priorSetTime":                            LargeIntegerFiletime,
+	"pwdLastSet":                              LargeIntegerFiletime,
+	"msDS-Cached-Membership-Time-Stamp":       LargeIntegerFiletime,
+	"msDS-LastFailedInteractiveLogonTime":     LargeIntegerFiletime,
+	"msDS-LastSuccessfulInteractiveLogonTime": LargeIntegerFiletime,
+	"msDS-UserPasswordExpiryTimeComputed":     LargeIntegerFiletime,
+	"ms-Mcs-AdmPwdExpirationTime":             LargeIntegerFiletime,
+	"msLAPS-PasswordExpirationTime":           LargeIntegerFiletime,
+
+	// Durations; domain and fine-grained password policies store them negated
+	"forceLogoff":                   LargeIntegerInterval,
+	"lockoutDuration":               LargeIntegerInterval,
+	"lockOutObservationWindow":      LargeIntegerInterval,
+	"maxPwdAge":                     LargeIntegerInterval,
+	"minPwdAge":                     LargeIntegerInterval,
+	"msDS-LockoutDuration":          LargeIntegerInterval,
+	"msDS-LockoutObservationWindow": LargeIntegerInterval,
+	"msDS-MaximumPasswordAge":       LargeIntegerInterval,
+	"msDS-MinimumPasswordAge":       LargeIntegerInterval,
+	"msDS-ComputerTGTLifetime":      LargeIntegerInterval,
+	"msDS-ServiceTGTLifetime":       LargeIntegerInterval,
+	"msDS-UserTGTLifetime":          LargeIntegerInterval,
+	"pekKeyChangeInterval":          LargeIntegerInterval,
+
+	// Plain numbers
+	"uSNChanged":                LargeIntegerCounter,
+	"uSNCreated":                LargeIntegerCounter,
+	"uSNDSALastObjRemoved":      LargeIntegerCounter,
+	"uSNLastObjRem":             LargeIntegerCounter,
+	"modifiedCount":             LargeIntegerCounter,
+	"modifiedCountAtLastProm":   LargeIntegerCounter,
+	"maxStorage":                LargeIntegerCounter,
+	"rIDAllocationPool":         LargeIntegerCounter,
+	"rIDAvailablePool":          LargeIntegerCounter,
+	"rIDPreviousAllocationPool": LargeIntegerCounter,
+	"rIDUsedPool":               LargeIntegerCounter,
+}
+
+// RegisterLargeIntegerAttribute classifies a Large Integer attribute, such as one added by a
+// schema extension, replacing any earlier classification of it.
+func (r *SchemaRegistry) RegisterLargeIntegerAttribute(ldapName string, kind LargeIntegerKind) {
+	r.OverrideAttribute(ldapName, kind.fieldType())
+}
+
+func (r *SchemaRegistry) registerLargeIntegerCatalogue() {
+	for ldapName, kind := range largeIntegerCatalogue {
+		r.RegisterLargeIntegerAttribute(ldapName, kind)
+	}
+}
@@ -3,6 +3,9 @@ package schema
 import (
 	"fmt"
 	"reflect"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"f0oster/adspy/activedirectory/transformers"
@@ -12,7 +15,10 @@ import (
 )
 
 type SchemaRegistry struct {
+	mu               sync.RWMutex // guards the attribute and class schemas, which are replaced on a schema reload
 	attributeSchemas map[string]*AttributeSchema
+	classSchemas     map[string]*ClassSchema                   // lDAPDisplayName -> class
+	objectCategories map[string]*ClassSchema                   // lowercased DN -> class
 	typeMap          map[string]map[string]*AttributeFieldType // syntax -> omsyntax
 	attributeHooks   map[string]*AttributeFieldType            // ldapDisplayName -> handler
 }
@@ -22,6 +28,8 @@ func NewSchemaRegistry() *SchemaRegistry {
 		typeMap:          make(map[string]map[string]*AttributeFieldType),
 		attributeHooks:   make(map[string]*AttributeFieldType),
 		attributeSchemas: make(map[string]*AttributeSchema),
+		classSchemas:     make(map[string]*ClassSchema),
+		objectCategories: make(map[string]*ClassSchema),
 	}
 	r.init()
 	return r
@@ -34,11 +42,35 @@ func (r *SchemaRegistry) Register(attributeSyntax, oMSyntax string, goType refle
 	r.typeMap[attributeSyntax][oMSyntax] = &AttributeFieldType{
 		GoType:      goType,
 		SyntaxName:  syntaxName,
+		Syntax:      SyntaxKey{AttributeSyntax: attributeSyntax, OMSyntax: oMSyntax},
 		Normalizer:  normalizer,
 		Interpreter: interpreter,
 	}
 }
 
+// SyntaxKey identifies a registered syntax by its attributeSyntax and oMSyntax.
+type SyntaxKey struct {
+	AttributeSyntax string
+	OMSyntax        string
+}
+
+// RegisteredSyntaxes returns every registered syntax, sorted.
+func (r *SchemaRegistry) RegisteredSyntaxes() []SyntaxKey {
+	var keys []SyntaxKey
+	for attributeSyntax, omMap := range r.typeMap {
+		for oMSyntax := range omMap {
+			keys = append(keys, SyntaxKey{AttributeSyntax: attributeSyntax, OMSyntax: oMSyntax})
+		}
+	}
+	slices.SortFunc(keys, func(a, b SyntaxKey) int {
+		if a.AttributeSyntax != b.AttributeSyntax {
+			return strings.Compare(a.AttributeSyntax, b.AttributeSyntax)
+		}
+		return strings.Compare(a.OMSyntax, b.OMSyntax)
+	})
+	return keys
+}
+
 func (r *SchemaRegistry) OverrideAttribute(ldapName string, fieldType *AttributeFieldType) {
 	r.attributeHooks[ldapName] = fieldType
 }
@@ -56,15 +88,21 @@ func (r *SchemaRegistry) Lookup(attributeSyntax, oMSyntax, ldapName string) (*At
 }
 
 func (r *SchemaRegistry) GetAttributeSchema(ldapName string) (*AttributeSchema, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	schema, ok := r.attributeSchemas[ldapName]
 	return schema, ok
 }
 
 func (r *SchemaRegistry) RegisterAttributeSchema(schema *AttributeSchema) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.attributeSchemas[schema.AttributeLDAPName] = schema
 }
 
 func (r *SchemaRegistry) GetAllSchemas() []*AttributeSchema {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	schemas := make([]*AttributeSchema, 0, len(r.attributeSchemas))
 	for _, schema := range r.attributeSchemas {
 		schemas = append(schemas, schema)
@@ -72,21 +110,107 @@ func (r *SchemaRegistry) GetAllSchemas() []*AttributeSchema {
 	return schemas
 }
 
+// SchemaChange lists the attributes and classes, by lDAPDisplayName, that differ between two
+// loads of the schema.
+type SchemaChange struct {
+	Added    []string
+	Modified []string // syntax, single-valuedness, name, property set or identity changed
+	Removed  []string
+
+	AddedClasses    []string
+	ModifiedClasses []string // hierarchy, content rules, defaults or identity changed
+	RemovedClasses  []string
+}
+
+// Empty reports whether the two loads defined the same attributes and classes.
+func (c SchemaChange) Empty() bool {
+	return len(c.Added) == 0 && len(c.Modified) == 0 && len(c.Removed) == 0 &&
+		len(c.AddedClasses) == 0 && len(c.ModifiedClasses) == 0 && len(c.RemovedClasses) == 0
+}
+
+// CompareAttributeSchemas reports how schemas differ from the registered attribute schemas.
+func (r *SchemaRegistry) CompareAttributeSchemas(schemas []*AttributeSchema) SchemaChange {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return compareAttributeSchemas(r.attributeSchemas, schemas)
+}
+
+// ReplaceAttributeSchemas swaps the registered attribute schemas for schemas in one step, so
+// entries parsed concurrently see either the old or the new schema, and reports what changed.
+func (r *SchemaRegistry) ReplaceAttributeSchemas(schemas []*AttributeSchema) SchemaChange {
+	replacement := make(map[string]*AttributeSchema, len(schemas))
+	for _, schema := range schemas {
+		replacement[schema.AttributeLDAPName] = schema
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	change := compareAttributeSchemas(r.attributeSchemas, schemas)
+	r.attributeSchemas = replacement
+	return change
+}
+
+func compareAttributeSchemas(previous map[string]*AttributeSchema, schemas []*AttributeSchema) SchemaChange {
+	current := make(map[string]*AttributeSchema, len(schemas))
+	for _, schema := range schemas {
+		current[schema.AttributeLDAPName] = schema
+	}
+
+	var change SchemaChange
+	change.Added, change.Modified, change.Removed = compareDefinitions(previous, current, sameAttributeDefinition)
+	return change
+}
+
+// compareDefinitions reports the names added to, modified in and removed from previous, each sorted.
+func compareDefinitions[T any](previous, current map[string]T, same func(a, b T) bool) (added, modified, removed []string) {
+	for name, definition := range current {
+		old, ok := previous[name]
+		switch {
+		case !ok:
+			added = append(added, name)
+		case !same(old, definition):
+			modified = append(modified, name)
+		}
+	}
+	for name := range previous {
+		if _, ok := current[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	slices.Sort(added)
+	slices.Sort(modified)
+	slices.Sort(removed)
+	return added, modified, removed
+}
+
+// sameAttributeDefinition compares the schema-defined properties of two attributes, ignoring
+// the Go type adSpy maps them to.
+func sameAttributeDefinition(a, b *AttributeSchema) bool {
+	return a.ObjectGUID == b.ObjectGUID &&
+		a.AttributeName == b.AttributeName &&
+		a.AttributeID == b.AttributeID &&
+		a.AttributeSyntax == b.AttributeSyntax &&
+		a.AttributeOMSyntax == b.AttributeOMSyntax &&
+		a.AttributeIsSingleValued == b.AttributeIsSingleValued &&
+		a.SchemaIDGUID == b.SchemaIDGUID &&
+		a.AttributeSecurityGUID == b.AttributeSecurityGUID
+}
+
 func (r *SchemaRegistry) registerSchemaSyntax() {
 	// Boolean
-	r.Register("2.5.5.8", "1", reflect.TypeOf(true), transformers.SimpleStringFormatter{}, transformers.SimpleStringFormatter{}, "Boolean")
+	r.Register("2.5.5.8", "1", reflect.TypeOf(true), transformers.BooleanFormatter{}, transformers.BooleanFormatter{}, "Boolean")
 
 	// Integer types
-	r.Register("2.5.5.9", "2", reflect.TypeOf(int(0)), transformers.SimpleStringFormatter{}, transformers.SimpleStringFormatter{}, "Integer")
-	r.Register("2.5.5.9", "10", reflect.TypeOf(int(0)), transformers.SimpleStringFormatter{}, transformers.SimpleStringFormatter{}, "Enumeration")
+	r.Register("2.5.5.9", "2", reflect.TypeOf(int(0)), transformers.IntegerFormatter{}, transformers.IntegerFormatter{}, "Integer")
+	r.Register("2.5.5.9", "10", reflect.TypeOf(int(0)), transformers.IntegerFormatter{}, transformers.IntegerFormatter{}, "Enumeration")
 
-	// Large Integer (as int64)
-	r.Register("2.5.5.16", "65", reflect.TypeOf((*time.Time)(nil)), transformers.ADFiletimeFormatter{}, transformers.ADFiletimeFormatter{}, "Large Integer (FILETIME)")
+	// Large Integer, read as a plain number unless the attribute is classified otherwise (see largeIntegerCatalogue)
+	r.Register("2.5.5.16", "65", reflect.TypeOf(int64(0)), transformers.LargeIntegerFormatter{}, transformers.LargeIntegerFormatter{}, "Large Integer")
 
 	// String representations
 	r.Register("2.5.5.13", "127", reflect.TypeOf(""), transformers.SimpleStringFormatter{}, transformers.SimpleStringFormatter{}, "Presentation Address")
-	r.Register("2.5.5.14", "127", reflect.TypeOf(""), transformers.SimpleStringFormatter{}, transformers.SimpleStringFormatter{}, "Access Point / DN-String")
-	r.Register("2.5.5.7", "127", reflect.TypeOf(""), transformers.SimpleStringFormatter{}, transformers.SimpleStringFormatter{}, "DN-Binary / OR-Name")
+	r.Register("2.5.5.14", "127", reflect.TypeOf(transformers.DNString{}), transformers.DNStringFormatter{}, transformers.DNStringFormatter{}, "Access Point / DN-String")
+	r.Register("2.5.5.7", "127", reflect.TypeOf(transformers.DNBinary{}), transformers.DNBinaryFormatter{}, transformers.DNBinaryFormatter{}, "DN-Binary / OR-Name")
 	r.Register("2.5.5.1", "127", reflect.TypeOf(""), transformers.SimpleStringFormatter{}, transformers.SimpleStringFormatter{}, "DS-DN")
 	r.Register("2.5.5.5", "19", reflect.TypeOf(""), transformers.SimpleStringFormatter{}, transformers.SimpleStringFormatter{}, "Printable String")
 	r.Register("2.5.5.5", "22", reflect.TypeOf(""), transformers.SimpleStringFormatter{}, transformers.SimpleStringFormatter{}, "IA5 String")
@@ -96,39 +220,33 @@ func (r *SchemaRegistry) registerSchemaSyntax() {
 	r.Register("2.5.5.12", "64", reflect.TypeOf(""), transformers.SimpleStringFormatter{}, transformers.SimpleStringFormatter{}, "Unicode String")
 
 	// Octet / Binary blobs
-	r.Register("2.5.5.10", "4", reflect.TypeOf([]byte{}), transformers.Base64Formatter{}, transformers.SimpleStringFormatter{}, "Octet String")
-	r.Register("2.5.5.10", "127", reflect.TypeOf([]byte{}), transformers.SimpleStringFormatter{}, transformers.SimpleStringFormatter{}, "Replica-Link")
+	r.Register("2.5.5.10", "4", reflect.TypeOf([]byte{}), transformers.Base64Formatter{}, transformers.Base64Formatter{}, "Octet String")
+	r.Register("2.5.5.10", "127", reflect.TypeOf([]byte{}), transformers.Base64Formatter{}, transformers.Base64Formatter{}, "Replica-Link")
 
 	// Time
 	r.Register("2.5.5.11", "23", reflect.TypeOf(""), transformers.SimpleStringFormatter{}, transformers.SimpleStringFormatter{}, "UTC-Time") // kept as string
-	r.Register("2.5.5.11", "24", reflect.TypeOf(time.Time{}), transformers.LDAPTimeFormatter{
-		Layout: "20060102150405.0Z",
-	}, transformers.SimpleStringFormatter{}, "Generalized-Time")
+	r.Register("2.5.5.11", "24", reflect.TypeOf(time.Time{}), transformers.LDAPTimeFormatter{}, transformers.LDAPTimeFormatter{}, "Generalized-Time")
 
 	// Security descriptor and SID
 	r.Register("2.5.5.15", "66", reflect.TypeOf(&gontsd.SecurityDescriptor{}), transformers.NTSecurityDescriptorFormatter{}, transformers.NTSecurityDescriptorFormatter{}, "NT-Sec-Desc")
-	r.Register("2.5.5.17", "4", reflect.TypeOf([]byte{}), transformers.SimpleStringFormatter{}, transformers.SimpleStringFormatter{}, "SID")
+	r.Register("2.5.5.17", "4", reflect.TypeOf(""), transformers.SIDFormatter{}, transformers.SIDFormatter{}, "SID")
 
 }
 
-func (r *SchemaRegistry) registerAttributeOverrides() {
-	r.OverrideAttribute("uSNCreated", &AttributeFieldType{
-		GoType:      reflect.TypeOf(int64(0)),
-		SyntaxName:  "Large Integer",
-		Interpreter: transformers.SimpleStringFormatter{},
-		Normalizer:  transformers.SimpleStringFormatter{},
-	})
-
-	r.OverrideAttribute("uSNChanged", &AttributeFieldType{
-		GoType:      reflect.TypeOf(int64(0)),
-		SyntaxName:  "Large Integer",
-		Interpreter: transformers.SimpleStringFormatter{},
-		Normalizer:  transformers.SimpleStringFormatter{},
-	})
+// Syntaxes of the attributes whose type is overridden.
+var (
+	dnBinarySyntax     = SyntaxKey{AttributeSyntax: "2.5.5.7", OMSyntax: "127"}
+	integerSyntax      = SyntaxKey{AttributeSyntax: "2.5.5.9", OMSyntax: "2"}
+	largeIntegerSyntax = SyntaxKey{AttributeSyntax: "2.5.5.16", OMSyntax: "65"}
+	octetStringSyntax  = SyntaxKey{AttributeSyntax: "2.5.5.10", OMSyntax: "4"}
+	sidSyntax          = SyntaxKey{AttributeSyntax: "2.5.5.17", OMSyntax: "4"}
+)
 
+func (r *SchemaRegistry) registerAttributeOverrides() {
 	r.OverrideAttribute("objectGUID", &AttributeFieldType{
-		GoType:      reflect.TypeOf([]uuid.UUID{}),
+		GoType:      reflect.TypeOf(uuid.UUID{}),
 		SyntaxName:  "Octet String",
+		Syntax:      octetStringSyntax,
 		Interpreter: transformers.ADGuidFormatter{},
 		Normalizer:  transformers.ADGuidFormatter{},
 	})
@@ -136,6 +254,7 @@ func (r *SchemaRegistry) registerAttributeOverrides() {
 	r.OverrideAttribute("objectSid", &AttributeFieldType{
 		GoType:      reflect.TypeOf(""),
 		SyntaxName:  "SID",
+		Syntax:      sidSyntax,
 		Interpreter: transformers.SIDFormatter{},
 		Normalizer:  transformers.SIDFormatter{},
 	})
@@ -143,6 +262,7 @@ func (r *SchemaRegistry) registerAttributeOverrides() {
 	r.OverrideAttribute("tokenGroups", &AttributeFieldType{
 		GoType:      reflect.TypeOf(""),
 		SyntaxName:  "SID",
+		Syntax:      sidSyntax,
 		Interpreter: transformers.SIDFormatter{},
 		Normalizer:  transformers.SIDFormatter{},
 	})
@@ -150,13 +270,25 @@ func (r *SchemaRegistry) registerAttributeOverrides() {
 	r.OverrideAttribute("msRTCSIP-OriginatorSid", &AttributeFieldType{
 		GoType:      reflect.TypeOf(""),
 		SyntaxName:  "SID",
+		Syntax:      octetStringSyntax, // stored as an Octet String
 		Interpreter: transformers.SIDFormatter{},
 		Normalizer:  transformers.SIDFormatter{},
 	})
+
+	// Shadow credentials: each value is a key that can be used to sign in as the object
+	r.OverrideAttribute("msDS-KeyCredentialLink", &AttributeFieldType{
+		GoType:      reflect.TypeOf(&transformers.KeyCredential{}),
+		SyntaxName:  "DN-Binary (KeyCredentialLink)",
+		Syntax:      dnBinarySyntax,
+		Interpreter: transformers.KeyCredentialLinkFormatter{},
+		Normalizer:  transformers.KeyCredentialLinkFormatter{},
+	})
 }
 
 func (r *SchemaRegistry) init() {
 	// See MS documentation: https://learn.microsoft.com/en-us/windows/win32/adschema/syntaxes
 	r.registerSchemaSyntax()
 	r.registerAttributeOverrides()
+	r.registerLargeIntegerCatalogue()
+	r.registerFlagAttributes()
 }
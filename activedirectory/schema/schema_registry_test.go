@@ -2,6 +2,7 @@ package schema_test
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -26,8 +27,13 @@ func TestSchemaRegistry_Lookup_BuiltIn(t *testing.T) {
 	tests := []testCase{
 		{"2.5.5.8", "1", "someBoolean", reflect.TypeOf(true), "Boolean"},
 		{"2.5.5.9", "2", "someInteger", reflect.TypeOf(int(0)), "Integer"},
-		{"2.5.5.16", "65", "whenCreated", reflect.TypeOf((*time.Time)(nil)), "Large Integer (FILETIME)"},
+		{"2.5.5.16", "65", "someLargeInteger", reflect.TypeOf(int64(0)), "Large Integer"},
 		{"2.5.5.15", "66", "ntSecurityDescriptor", reflect.TypeOf(&gontsd.SecurityDescriptor{}), "NT-Sec-Desc"},
+		{"2.5.5.7", "127", "wellKnownObjects", reflect.TypeOf(transformers.DNBinary{}), "DN-Binary / OR-Name"},
+		{"2.5.5.7", "127", "msDS-KeyCredentialLink", reflect.TypeOf(&transformers.KeyCredential{}), "DN-Binary (KeyCredentialLink)"},
+		{"2.5.5.14", "127", "someDNString", reflect.TypeOf(transformers.DNString{}), "Access Point / DN-String"},
+		{"2.5.5.9", "2", "userAccountControl", reflect.TypeOf(transformers.Flags{}), "Integer (Flags)"},
+		{"2.5.5.9", "2", "sAMAccountType", reflect.TypeOf(transformers.Flags{}), "Enumeration"},
 	}
 
 	for _, test := range tests {
@@ -51,7 +57,7 @@ func TestSchemaRegistry_Lookup_Override(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Expected override for objectGUID, got error: %v", err)
 	}
-	if fieldType.GoType != reflect.TypeOf([]uuid.UUID{}) {
+	if fieldType.GoType != reflect.TypeOf(uuid.UUID{}) {
 		t.Errorf("Unexpected GoType for objectGUID: got %v", fieldType.GoType)
 	}
 	if _, ok := fieldType.Normalizer.(transformers.ADGuidFormatter); !ok {
@@ -115,3 +121,280 @@ func TestSchemaRegistry_AttributeNotFound(t *testing.T) {
 		t.Errorf("Expected false for unregistered attribute schema, got true")
 	}
 }
+
+func TestSchemaRegistry_ReplaceAttributeSchemas(t *testing.T) {
+	r := schema.NewSchemaRegistry()
+
+	description := &schema.AttributeSchema{ObjectGUID: uuid.New(), AttributeLDAPName: "description", AttributeSyntax: "2.5.5.12", AttributeOMSyntax: "64"}
+	info := &schema.AttributeSchema{ObjectGUID: uuid.New(), AttributeLDAPName: "info", AttributeSyntax: "2.5.5.12", AttributeOMSyntax: "64", AttributeIsSingleValued: true}
+	legacy := &schema.AttributeSchema{ObjectGUID: uuid.New(), AttributeLDAPName: "legacyAttribute"}
+	r.RegisterAttributeSchema(description)
+	r.RegisterAttributeSchema(info)
+	r.RegisterAttributeSchema(legacy)
+
+	// info became multi-valued, legacyAttribute is gone and a schema extension added contosoBadge
+	multiValuedInfo := *info
+	multiValuedInfo.AttributeIsSingleValued = false
+	badge := &schema.AttributeSchema{ObjectGUID: uuid.New(), AttributeLDAPName: "contosoBadge", AttributeSyntax: "2.5.5.12", AttributeOMSyntax: "64"}
+	unchanged := *description
+
+	change := r.ReplaceAttributeSchemas([]*schema.AttributeSchema{&unchanged, &multiValuedInfo, badge})
+
+	want := schema.SchemaChange{
+		Added:    []string{"contosoBadge"},
+		Modified: []string{"info"},
+		Removed:  []string{"legacyAttribute"},
+	}
+	if !reflect.DeepEqual(change, want) {
+		t.Errorf("change = %+v, want %+v", change, want)
+	}
+
+	if fetched, ok := r.GetAttributeSchema("contosoBadge"); !ok || fetched != badge {
+		t.Error("Expected the added attribute to be registered")
+	}
+	if _, ok := r.GetAttributeSchema("legacyAttribute"); ok {
+		t.Error("Expected the removed attribute to be unregistered")
+	}
+	if !r.ReplaceAttributeSchemas([]*schema.AttributeSchema{&unchanged, &multiValuedInfo, badge}).Empty() {
+		t.Error("Expected reloading the same schema to report no change")
+	}
+}
+
+// registerUserClasses registers a cut-down user class hierarchy:
+// top <- person <- organizationalPerson <- user, with securityPrincipal as an auxiliary class of user.
+func registerUserClasses(r *schema.SchemaRegistry) {
+	const schemaNC = ",CN=Schema,CN=Configuration,DC=contoso,DC=com"
+	r.RegisterClassSchema(&schema.ClassSchema{LDAPDisplayName: "top", DN: "CN=Top" + schemaNC, SubClassOf: "top", ObjectClassCategory: 2,
+		MustContain: []string{"objectClass"}, MayContain: []string{"description", "objectGUID"}})
+	r.RegisterClassSchema(&schema.ClassSchema{LDAPDisplayName: "person", DN: "CN=Person" + schemaNC, SubClassOf: "top", ObjectClassCategory: 1,
+		MustContain: []string{"cn"}, MayContain: []string{"sn", "telephoneNumber"}})
+	r.RegisterClassSchema(&schema.ClassSchema{LDAPDisplayName: "organizationalPerson", DN: "CN=Organizational-Person" + schemaNC, SubClassOf: "person", ObjectClassCategory: 1,
+		MayContain: []string{"title"}})
+	r.RegisterClassSchema(&schema.ClassSchema{LDAPDisplayName: "securityPrincipal", DN: "CN=Security-Principal" + schemaNC, SubClassOf: "top", ObjectClassCategory: 3,
+		MustContain: []string{"objectSid", "sAMAccountName"}})
+	r.RegisterClassSchema(&schema.ClassSchema{LDAPDisplayName: "user", DN: "CN=User" + schemaNC, SubClassOf: "organizationalPerson", ObjectClassCategory: 1,
+		AuxiliaryClasses: []string{"securityPrincipal"}, MayContain: []string{"userAccountControl"}})
+}
+
+func TestSchemaRegistry_ClassHierarchy(t *testing.T) {
+	r := schema.NewSchemaRegistry()
+	registerUserClasses(r)
+
+	got := r.ClassHierarchy("user")
+	want := []string{"user", "organizationalPerson", "person", "top"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ClassHierarchy(user) = %v, want %v", got, want)
+	}
+	if got := r.ClassHierarchy("notRegistered"); len(got) != 0 {
+		t.Errorf("ClassHierarchy(notRegistered) = %v, want empty", got)
+	}
+}
+
+func TestSchemaRegistry_ClassAttributes(t *testing.T) {
+	r := schema.NewSchemaRegistry()
+	registerUserClasses(r)
+
+	must, may := r.ClassAttributes("user")
+	wantMust := []string{"cn", "objectClass", "objectSid", "sAMAccountName"}
+	wantMay := []string{"description", "objectGUID", "sn", "telephoneNumber", "title", "userAccountControl"}
+	if !reflect.DeepEqual(must, wantMust) {
+		t.Errorf("must = %v, want %v", must, wantMust)
+	}
+	if !reflect.DeepEqual(may, wantMay) {
+		t.Errorf("may = %v, want %v", may, wantMay)
+	}
+
+	objectClasses := []string{"top", "person", "organizationalPerson", "user"}
+	if !r.AttributeAllowed(objectClasses, "samaccountname") {
+		t.Error("Expected sAMAccountName, from an auxiliary class, to be allowed on a user")
+	}
+	if r.AttributeAllowed([]string{"top", "person"}, "sAMAccountName") {
+		t.Error("Expected sAMAccountName not to be allowed on a person")
+	}
+}
+
+func TestSchemaRegistry_ResolveObjectCategory(t *testing.T) {
+	r := schema.NewSchemaRegistry()
+	registerUserClasses(r)
+
+	class, ok := r.ResolveObjectCategory("cn=organizational-person,cn=schema,cn=configuration,dc=contoso,dc=com")
+	if !ok || class.LDAPDisplayName != "organizationalPerson" {
+		t.Errorf("ResolveObjectCategory = %v, %v; want organizationalPerson", class, ok)
+	}
+	if _, ok := r.ResolveObjectCategory("CN=Computer,CN=Schema,CN=Configuration,DC=contoso,DC=com"); ok {
+		t.Error("Expected an unregistered objectCategory not to resolve")
+	}
+}
+
+func TestSchemaRegistry_ReplaceClassSchemas(t *testing.T) {
+	r := schema.NewSchemaRegistry()
+	registerUserClasses(r)
+
+	// person gained an attribute, securityPrincipal is gone and a schema extension added contosoDevice
+	person, _ := r.GetClassSchema("person")
+	extendedPerson := *person
+	extendedPerson.MayContain = []string{"contosoBadge", "sn", "telephoneNumber"}
+	device := &schema.ClassSchema{LDAPDisplayName: "contosoDevice", DN: "CN=Contoso-Device,CN=Schema,CN=Configuration,DC=contoso,DC=com", SubClassOf: "top"}
+
+	var classes []*schema.ClassSchema
+	for _, name := range []string{"top", "organizationalPerson", "user"} {
+		class, _ := r.GetClassSchema(name)
+		unchanged := *class
+		classes = append(classes, &unchanged)
+	}
+	classes = append(classes, &extendedPerson, device)
+
+	change := r.ReplaceClassSchemas(classes)
+
+	want := schema.SchemaChange{
+		AddedClasses:    []string{"contosoDevice"},
+		ModifiedClasses: []string{"person"},
+		RemovedClasses:  []string{"securityPrincipal"},
+	}
+	if !reflect.DeepEqual(change, want) {
+		t.Errorf("change = %+v, want %+v", change, want)
+	}
+
+	if class, ok := r.ResolveObjectCategory(device.DN); !ok || class != device {
+		t.Error("Expected the objectCategory of the added class to resolve")
+	}
+	if _, ok := r.GetClassSchema("securityPrincipal"); ok {
+		t.Error("Expected the removed class to be unregistered")
+	}
+	if !r.ReplaceClassSchemas(classes).Empty() {
+		t.Error("Expected reloading the same classes to report no change")
+	}
+}
+
+func TestSchemaRegistry_LargeIntegerCatalogue(t *testing.T) {
+	r := schema.NewSchemaRegistry()
+
+	tests := []struct {
+		ldapName       string
+		expectedType   reflect.Type
+		expectedSyntax string
+	}{
+		{"pwdLastSet", reflect.TypeOf((*time.Time)(nil)), "Large Integer (FILETIME)"},
+		{"accountExpires", reflect.TypeOf((*time.Time)(nil)), "Large Integer (FILETIME)"},
+		{"maxPwdAge", reflect.TypeOf((*time.Duration)(nil)), "Large Integer (Interval)"},
+		{"lockoutDuration", reflect.TypeOf((*time.Duration)(nil)), "Large Integer (Interval)"},
+		{"uSNChanged", reflect.TypeOf(int64(0)), "Large Integer"},
+		{"rIDAvailablePool", reflect.TypeOf(int64(0)), "Large Integer"},
+		{"notCatalogued", reflect.TypeOf(int64(0)), "Large Integer"},
+	}
+
+	for _, test := range tests {
+		fieldType, err := r.Lookup("2.5.5.16", "65", test.ldapName)
+		if err != nil {
+			t.Fatalf("Lookup failed for %s: %v", test.ldapName, err)
+		}
+		if fieldType.GoType != test.expectedType {
+			t.Errorf("Unexpected GoType for %s: got %v, want %v", test.ldapName, fieldType.GoType, test.expectedType)
+		}
+		if fieldType.SyntaxName != test.expectedSyntax {
+			t.Errorf("Unexpected SyntaxName for %s: got %s, want %s", test.ldapName, fieldType.SyntaxName, test.expectedSyntax)
+		}
+	}
+
+	// A schema extension's counter can be classified at runtime
+	r.RegisterLargeIntegerAttribute("contosoBadgeSwipes", schema.LargeIntegerCounter)
+	fieldType, err := r.Lookup("2.5.5.16", "65", "contosoBadgeSwipes")
+	if err != nil {
+		t.Fatalf("Lookup failed for contosoBadgeSwipes: %v", err)
+	}
+	if _, ok := fieldType.Normalizer.(transformers.LargeIntegerFormatter); !ok {
+		t.Errorf("contosoBadgeSwipes Normalizer is %T, want LargeIntegerFormatter", fieldType.Normalizer)
+	}
+}
+
+func TestSchemaRegistry_NormalizeEverySyntax(t *testing.T) {
+	r := schema.NewSchemaRegistry()
+
+	// S-1-5-32-544 (BUILTIN\Administrators)
+	adminsSID := []byte{1, 2, 0, 0, 0, 0, 0, 5, 32, 0, 0, 0, 32, 2, 0, 0}
+	// Octet String and Replica-Link values are arbitrary bytes and need not be valid UTF-8
+	blob := []byte{0x00, 0xff, 0xfe, 0x10}
+
+	samples := map[schema.SyntaxKey]struct {
+		raw  []byte
+		want string
+	}{
+		{"2.5.5.8", "1"}:    {[]byte("TRUE"), "TRUE"},
+		{"2.5.5.9", "2"}:    {[]byte("-2147483646"), "-2147483646"},
+		{"2.5.5.9", "10"}:   {[]byte("3"), "3"},
+		{"2.5.5.16", "65"}:  {[]byte("133485408000000000"), "133485408000000000"},
+		{"2.5.5.13", "127"}: {[]byte("#ncacn_ip_tcp:dc1.example.com"), "#ncacn_ip_tcp:dc1.example.com"},
+		{"2.5.5.14", "127"}: {[]byte("S:5:hello:CN=Bob,DC=example,DC=com"), "S:5:hello:CN=Bob,DC=example,DC=com"},
+		{"2.5.5.7", "127"}:  {[]byte("B:4:ABCD:CN=Users,DC=example,DC=com"), "B:4:ABCD:CN=Users,DC=example,DC=com"},
+		{"2.5.5.1", "127"}:  {[]byte("CN=Bob,DC=example,DC=com"), "CN=Bob,DC=example,DC=com"},
+		{"2.5.5.5", "19"}:   {[]byte("Printable"), "Printable"},
+		{"2.5.5.5", "22"}:   {[]byte("bob@example.com"), "bob@example.com"},
+		{"2.5.5.6", "18"}:   {[]byte("0123456789"), "0123456789"},
+		{"2.5.5.2", "6"}:    {[]byte("1.2.840.113556.1.5.9"), "1.2.840.113556.1.5.9"},
+		{"2.5.5.4", "20"}:   {[]byte("Teletex"), "Teletex"},
+		{"2.5.5.12", "64"}:  {[]byte("Ünïcödé"), "Ünïcödé"},
+		{"2.5.5.10", "4"}:   {blob, "AP/+EA=="},
+		{"2.5.5.10", "127"}: {blob, "AP/+EA=="},
+		{"2.5.5.11", "23"}:  {[]byte("240102030405Z"), "240102030405Z"},
+		{"2.5.5.11", "24"}:  {[]byte("20240102030405.0Z"), "2024-01-02 03:04:05 +0000 UTC"},
+		{"2.5.5.15", "66"}:  {blob, "AP/+EA=="},
+		{"2.5.5.17", "4"}:   {adminsSID, "S-1-5-32-544"},
+	}
+
+	syntaxes := r.RegisteredSyntaxes()
+	if len(syntaxes) != len(samples) {
+		t.Errorf("%d syntaxes are registered but %d have samples", len(syntaxes), len(samples))
+	}
+
+	for _, syntax := range syntaxes {
+		sample, ok := samples[syntax]
+		if !ok {
+			t.Errorf("No sample value for syntax %s/%s", syntax.AttributeSyntax, syntax.OMSyntax)
+			continue
+		}
+
+		fieldType, err := r.Lookup(syntax.AttributeSyntax, syntax.OMSyntax, "someAttribute")
+		if err != nil {
+			t.Fatalf("Lookup failed for %s/%s: %v", syntax.AttributeSyntax, syntax.OMSyntax, err)
+		}
+		normalized, err := fieldType.Normalizer.Normalize([][]byte{sample.raw})
+		if err != nil {
+			t.Errorf("Normalize failed for %s (%s/%s): %v", fieldType.SyntaxName, syntax.AttributeSyntax, syntax.OMSyntax, err)
+			continue
+		}
+		if len(normalized) != 1 || normalized[0] != sample.want {
+			t.Errorf("Normalize for %s = %q, want %q", fieldType.SyntaxName, normalized, sample.want)
+		}
+	}
+}
+
+func TestSchemaRegistry_CheckInterpreters(t *testing.T) {
+	r := schema.NewSchemaRegistry()
+	if err := r.CheckInterpreters(); err != nil {
+		t.Fatalf("CheckInterpreters failed: %v", err)
+	}
+
+	// A Boolean declared as a string is caught
+	r.OverrideAttribute("someBoolean", &schema.AttributeFieldType{
+		GoType:      reflect.TypeOf(""),
+		SyntaxName:  "Boolean",
+		Syntax:      schema.SyntaxKey{AttributeSyntax: "2.5.5.8", OMSyntax: "1"},
+		Interpreter: transformers.BooleanFormatter{},
+		Normalizer:  transformers.BooleanFormatter{},
+	})
+	if err := r.CheckInterpreters(); err == nil {
+		t.Error("Expected an error for an interpreter that does not produce its GoType")
+	}
+
+	// An override that does not name its syntax has no sample, whatever its display name
+	r = schema.NewSchemaRegistry()
+	r.OverrideAttribute("someInteger", &schema.AttributeFieldType{
+		GoType:      reflect.TypeOf(int(0)),
+		SyntaxName:  "Integer",
+		Interpreter: transformers.IntegerFormatter{},
+		Normalizer:  transformers.IntegerFormatter{},
+	})
+	if err := r.CheckInterpreters(); err == nil || !strings.Contains(err.Error(), "no sample value") {
+		t.Errorf("CheckInterpreters error = %v, want a missing sample", err)
+	}
+}
@@ -0,0 +1,75 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// interpreterSamples hold a valid raw value for each syntax, keyed by attributeSyntax and
+// oMSyntax, used to check that the interpreter of the syntax and of every attribute override of
+// it produces values of the declared GoType. Each sample is valid for all of those interpreters.
+var interpreterSamples = map[SyntaxKey][]byte{
+	{"2.5.5.8", "1"}:    []byte("TRUE"),
+	{"2.5.5.9", "2"}:    []byte("512"),
+	{"2.5.5.9", "10"}:   []byte("805306368"),
+	{"2.5.5.16", "65"}:  []byte("36288000000000"), // in range as a FILETIME, an interval and a counter
+	{"2.5.5.13", "127"}: []byte("#ncacn_ip_tcp:dc1.example.com"),
+	{"2.5.5.14", "127"}: []byte("S:5:hello:CN=Sample,DC=example,DC=com"),
+	{"2.5.5.7", "127"}:  []byte("B:8:00020000:CN=Sample,DC=example,DC=com"), // a key credential version 2 header without entries
+	{"2.5.5.1", "127"}:  []byte("CN=Sample,DC=example,DC=com"),
+	{"2.5.5.5", "19"}:   []byte("sample"),
+	{"2.5.5.5", "22"}:   []byte("sample"),
+	{"2.5.5.6", "18"}:   []byte("12345"),
+	{"2.5.5.2", "6"}:    []byte("1.2.840.113556.1.5.9"),
+	{"2.5.5.4", "20"}:   []byte("sample"),
+	{"2.5.5.12", "64"}:  []byte("sample"),
+	{"2.5.5.10", "4"}:   make([]byte, 16), // 16 bytes so objectGUID and SID overrides read it too
+	{"2.5.5.10", "127"}: {0x00, 0xff, 0xfe, 0x10},
+	{"2.5.5.11", "23"}:  []byte("240102030405Z"),
+	{"2.5.5.11", "24"}:  []byte("20240102030405.0Z"),
+	{"2.5.5.15", "66"}:  {0x01, 0x00, 0x00, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, // empty self-relative descriptor
+	{"2.5.5.17", "4"}:   {0x01, 0x02, 0, 0, 0, 0, 0, 0x05, 0x20, 0, 0, 0, 0x20, 0x02, 0, 0},       // S-1-5-32-544
+}
+
+// CheckInterpreters interprets a sample value of every registered syntax and attribute
+// override, and reports those whose interpreted values are not of their declared GoType.
+func (r *SchemaRegistry) CheckInterpreters() error {
+	var errs []error
+	for _, syntax := range r.RegisteredSyntaxes() {
+		fieldType := r.typeMap[syntax.AttributeSyntax][syntax.OMSyntax]
+		if err := checkInterpreter(fieldType); err != nil {
+			errs = append(errs, fmt.Errorf("syntax %s/%s: %w", syntax.AttributeSyntax, syntax.OMSyntax, err))
+		}
+	}
+	for ldapName, fieldType := range r.attributeHooks {
+		if err := checkInterpreter(fieldType); err != nil {
+			errs = append(errs, fmt.Errorf("attribute %s: %w", ldapName, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func checkInterpreter(fieldType *AttributeFieldType) error {
+	sample, ok := interpreterSamples[fieldType.Syntax]
+	if !ok {
+		return fmt.Errorf("no sample value for %s (%s/%s)", fieldType.SyntaxName, fieldType.Syntax.AttributeSyntax, fieldType.Syntax.OMSyntax)
+	}
+
+	interpreted, err := fieldType.Interpreter.Interpret([][]byte{sample})
+	if err != nil {
+		return fmt.Errorf("failed to interpret a sample %s value: %w", fieldType.SyntaxName, err)
+	}
+
+	// a single result is one value, the same as ParseAttribute reads it
+	values, ok := interpreted.([]interface{})
+	if !ok {
+		values = []interface{}{interpreted}
+	}
+	for _, v := range values {
+		if got := reflect.TypeOf(v); got != fieldType.GoType {
+			return fmt.Errorf("%s interprets values as %v, but declares %v", fieldType.SyntaxName, got, fieldType.GoType)
+		}
+	}
+	return nil
+}
@@ -12,6 +12,7 @@ import (
 type AttributeFieldType struct {
 	GoType      reflect.Type
 	SyntaxName  string
+	Syntax      SyntaxKey // attributeSyntax and oMSyntax of the values the type reads
 	Normalizer  transformers.Normalizer
 	Interpreter transformers.Interpreter
 }
@@ -25,6 +26,26 @@ type AttributeSchema struct {
 	AttributeOMSyntax       string
 	AttributeFieldType      AttributeFieldType
 	AttributeIsSingleValued bool
+	SchemaIDGUID            uuid.UUID
+	AttributeSecurityGUID   uuid.UUID // property set the attribute belongs to, or uuid.Nil
+}
+
+// ClassSchema is a classSchema object. The must and may attributes, and the auxiliary classes,
+// combine the system-only and the administrator-extensible forms of each.
+type ClassSchema struct {
+	ObjectGUID                uuid.UUID
+	DN                        string // also the objectCategory of objects of this class
+	ClassName                 string // cn
+	LDAPDisplayName           string
+	GovernsID                 string
+	SchemaIDGUID              uuid.UUID
+	SubClassOf                string
+	ObjectClassCategory       int // 0 = 88 class, 1 = structural, 2 = abstract, 3 = auxiliary
+	AuxiliaryClasses          []string
+	MustContain               []string
+	MayContain                []string
+	DefaultSecurityDescriptor string // SDDL
+	DefaultObjectCategory     string
 }
 
 // AttributeValue represents a runtime-loaded AD attribute for a specific object.
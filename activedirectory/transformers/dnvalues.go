@@ -0,0 +1,146 @@
+package transformers
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// DNBinary is an Object(DN-Binary) value (2.5.5.7): binary data tied to an object, such as the
+// well-known GUID of a wellKnownObjects entry or the key credential of msDS-KeyCredentialLink.
+type DNBinary struct {
+	Binary []byte
+	DN     string
+}
+
+// DNString is an Object(DN-String) value (2.5.5.14): a string tied to an object.
+type DNString struct {
+	String string
+	DN     string
+}
+
+// ParseDNBinary parses the LDAP form of a DN-Binary value, B:<hex digit count>:<hex>:<DN>.
+func ParseDNBinary(value string) (DNBinary, error) {
+	count, rest, err := splitDNValue(value, "B:")
+	if err != nil {
+		return DNBinary{}, fmt.Errorf("invalid DN-Binary value: %w", err)
+	}
+	if count%2 != 0 || count > len(rest) {
+		return DNBinary{}, fmt.Errorf("invalid DN-Binary value: bad hex digit count %d", count)
+	}
+	if rest[count:] == "" || rest[count] != ':' {
+		return DNBinary{}, fmt.Errorf("invalid DN-Binary value: missing DN")
+	}
+
+	binary, err := hex.DecodeString(rest[:count])
+	if err != nil {
+		return DNBinary{}, fmt.Errorf("invalid DN-Binary value: %w", err)
+	}
+	return DNBinary{Binary: binary, DN: rest[count+1:]}, nil
+}
+
+// ParseDNString parses the LDAP form of a DN-String value, S:<character count>:<string>:<DN>.
+func ParseDNString(value string) (DNString, error) {
+	count, rest, err := splitDNValue(value, "S:")
+	if err != nil {
+		return DNString{}, fmt.Errorf("invalid DN-String value: %w", err)
+	}
+
+	// the count is in characters, not bytes
+	end := 0
+	for i := 0; i < count; i++ {
+		if end >= len(rest) {
+			return DNString{}, fmt.Errorf("invalid DN-String value: bad character count %d", count)
+		}
+		_, size := utf8.DecodeRuneInString(rest[end:])
+		end += size
+	}
+	if rest[end:] == "" || rest[end] != ':' {
+		return DNString{}, fmt.Errorf("invalid DN-String value: missing DN")
+	}
+	return DNString{String: rest[:end], DN: rest[end+1:]}, nil
+}
+
+// splitDNValue strips prefix and the count that follows it, returning the count and the rest
+// of the value.
+func splitDNValue(value, prefix string) (int, string, error) {
+	rest, ok := strings.CutPrefix(value, prefix)
+	if !ok {
+		return 0, "", fmt.Errorf("expected %q prefix", prefix)
+	}
+	countStr, rest, ok := strings.Cut(rest, ":")
+	if !ok {
+		return 0, "", fmt.Errorf("missing count")
+	}
+	count, err := strconv.Atoi(countStr)
+	if err != nil || count < 0 {
+		return 0, "", fmt.Errorf("invalid count %q", countStr)
+	}
+	return count, rest, nil
+}
+
+// DNBinaryFormatter formats DN-Binary values. Values are normalized to their LDAP form
+// unchanged, so they diff the same as before they were decoded.
+type DNBinaryFormatter struct{}
+
+func (t DNBinaryFormatter) Normalize(values [][]byte) ([]string, error) {
+	return SimpleStringFormatter{}.transform(values)
+}
+
+func (t DNBinaryFormatter) Interpret(values [][]byte) (interface{}, error) {
+	result := make([]interface{}, len(values))
+	for i, b := range values {
+		v, err := ParseDNBinary(string(b))
+		if err != nil {
+			return nil, err
+		}
+		result[i] = v
+	}
+	return result, nil
+}
+
+// DNStringFormatter formats DN-String values, normalized to their LDAP form like DN-Binary.
+type DNStringFormatter struct{}
+
+func (t DNStringFormatter) Normalize(values [][]byte) ([]string, error) {
+	return SimpleStringFormatter{}.transform(values)
+}
+
+func (t DNStringFormatter) Interpret(values [][]byte) (interface{}, error) {
+	result := make([]interface{}, len(values))
+	for i, b := range values {
+		v, err := ParseDNString(string(b))
+		if err != nil {
+			return nil, err
+		}
+		result[i] = v
+	}
+	return result, nil
+}
+
+// KeyCredentialLinkFormatter formats msDS-KeyCredentialLink, a DN-Binary attribute whose binary
+// data is a KEYCREDENTIALLINK_BLOB, interpreting each value as a KeyCredential.
+type KeyCredentialLinkFormatter struct{}
+
+func (t KeyCredentialLinkFormatter) Normalize(values [][]byte) ([]string, error) {
+	return SimpleStringFormatter{}.transform(values)
+}
+
+func (t KeyCredentialLinkFormatter) Interpret(values [][]byte) (interface{}, error) {
+	result := make([]interface{}, len(values))
+	for i, b := range values {
+		v, err := ParseDNBinary(string(b))
+		if err != nil {
+			return nil, err
+		}
+		credential, err := ParseKeyCredential(v.Binary)
+		if err != nil {
+			return nil, fmt.Errorf("failed to interpret key credential of %s: %w", v.DN, err)
+		}
+		credential.DN = v.DN
+		result[i] = credential
+	}
+	return result, nil
+}
@@ -0,0 +1,305 @@
+package transformers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Flag names a bit of a bitmask attribute, or a value of an enumeration.
+type Flag struct {
+	Value uint32
+	Name  string
+}
+
+// Flags is the value of a bitmask or enumeration attribute with the names it stands for: the
+// set bits of a bitmask, or the single name of an enumeration value. Bits and values without
+// a name are rendered in hex.
+type Flags struct {
+	Value uint32
+	Names []string
+}
+
+// FlagFormatter formats Integer attributes that are bitmasks, such as userAccountControl.
+// Values are normalized to the stored decimal unchanged; Interpret names the set bits.
+type FlagFormatter struct {
+	Flags []Flag
+}
+
+func (t FlagFormatter) Normalize(values [][]byte) ([]string, error) {
+	return SimpleStringFormatter{}.transform(values)
+}
+
+func (t FlagFormatter) Interpret(values [][]byte) (interface{}, error) {
+	integers, err := parseFlagValues(values)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]interface{}, len(integers))
+	for i, v := range integers {
+		result[i] = Flags{Value: v, Names: t.names(v)}
+	}
+	return result, nil
+}
+
+// names returns the names of the bits set in v, in the order of t.Flags.
+func (t FlagFormatter) names(v uint32) []string {
+	names := []string{}
+	for _, flag := range t.Flags {
+		if v&flag.Value != 0 {
+			names = append(names, flag.Name)
+			v &^= flag.Value
+		}
+	}
+	for bit := uint32(1); v != 0; bit <<= 1 {
+		if v&bit != 0 {
+			names = append(names, fmt.Sprintf("0x%X", bit))
+			v &^= bit
+		}
+	}
+	return names
+}
+
+// DescribeChange reports the bits set and cleared between two normalized values, e.g.
+// "flag DONT_REQ_PREAUTH set, ACCOUNTDISABLE cleared". A missing value counts as no bits set.
+func (t FlagFormatter) DescribeChange(oldValues, newValues []string) (string, error) {
+	oldValue, err := singleFlagValue(oldValues)
+	if err != nil {
+		return "", err
+	}
+	newValue, err := singleFlagValue(newValues)
+	if err != nil {
+		return "", err
+	}
+
+	var parts []string
+	for _, name := range t.names(newValue &^ oldValue) {
+		parts = append(parts, name+" set")
+	}
+	for _, name := range t.names(oldValue &^ newValue) {
+		parts = append(parts, name+" cleared")
+	}
+	if len(parts) == 0 {
+		return "", nil
+	}
+	return "flag " + strings.Join(parts, ", "), nil
+}
+
+// EnumFormatter formats Integer attributes whose value is one of a set of named values, such
+// as sAMAccountType.
+type EnumFormatter struct {
+	Values []Flag
+}
+
+func (t EnumFormatter) Normalize(values [][]byte) ([]string, error) {
+	return SimpleStringFormatter{}.transform(values)
+}
+
+func (t EnumFormatter) Interpret(values [][]byte) (interface{}, error) {
+	integers, err := parseFlagValues(values)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]interface{}, len(integers))
+	for i, v := range integers {
+		result[i] = Flags{Value: v, Names: []string{t.name(v)}}
+	}
+	return result, nil
+}
+
+func (t EnumFormatter) name(v uint32) string {
+	for _, value := range t.Values {
+		if value.Value == v {
+			return value.Name
+		}
+	}
+	return fmt.Sprintf("0x%X", v)
+}
+
+// DescribeChange reports the named values before and after, e.g.
+// "SAM_USER_OBJECT -> SAM_MACHINE_ACCOUNT".
+func (t EnumFormatter) DescribeChange(oldValues, newValues []string) (string, error) {
+	describe := func(values []string) (string, error) {
+		if len(values) == 0 {
+			return "none", nil
+		}
+		v, err := singleFlagValue(values)
+		if err != nil {
+			return "", err
+		}
+		return t.name(v), nil
+	}
+
+	oldName, err := describe(oldValues)
+	if err != nil {
+		return "", err
+	}
+	newName, err := describe(newValues)
+	if err != nil {
+		return "", err
+	}
+	return oldName + " -> " + newName, nil
+}
+
+// parseFlagValues parses 32-bit integers. AD returns them signed, so a value with the top bit
+// set, such as the groupType of a security group, is negative.
+func parseFlagValues(values [][]byte) ([]uint32, error) {
+	integers := make([]uint32, len(values))
+	for i, b := range values {
+		v, err := parseFlagValue(string(b))
+		if err != nil {
+			return nil, err
+		}
+		integers[i] = v
+	}
+	return integers, nil
+}
+
+func parseFlagValue(s string) (uint32, error) {
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v < -1<<31 || v > 1<<32-1 {
+		return 0, fmt.Errorf("invalid 32-bit flag value %q", s)
+	}
+	return uint32(v), nil
+}
+
+// singleFlagValue parses the value of a single-valued flag attribute; no value is 0.
+func singleFlagValue(values []string) (uint32, error) {
+	switch len(values) {
+	case 0:
+		return 0, nil
+	case 1:
+		return parseFlagValue(values[0])
+	default:
+		return 0, fmt.Errorf("expected a single flag value, got %d", len(values))
+	}
+}
+
+// UserAccountControlFlags are the bits of userAccountControl (ADS_USER_FLAG_ENUM).
+var UserAccountControlFlags = []Flag{
+	{0x00000001, "SCRIPT"},
+	{0x00000002, "ACCOUNTDISABLE"},
+	{0x00000008, "HOMEDIR_REQUIRED"},
+	{0x00000010, "LOCKOUT"},
+	{0x00000020, "PASSWD_NOTREQD"},
+	{0x00000040, "PASSWD_CANT_CHANGE"},
+	{0x00000080, "ENCRYPTED_TEXT_PWD_ALLOWED"},
+	{0x00000100, "TEMP_DUPLICATE_ACCOUNT"},
+	{0x00000200, "NORMAL_ACCOUNT"},
+	{0x00000800, "INTERDOMAIN_TRUST_ACCOUNT"},
+	{0x00001000, "WORKSTATION_TRUST_ACCOUNT"},
+	{0x00002000, "SERVER_TRUST_ACCOUNT"},
+	{0x00010000, "DONT_EXPIRE_PASSWORD"},
+	{0x00020000, "MNS_LOGON_ACCOUNT"},
+	{0x00040000, "SMARTCARD_REQUIRED"},
+	{0x00080000, "TRUSTED_FOR_DELEGATION"},
+	{0x00100000, "NOT_DELEGATED"},
+	{0x00200000, "USE_DES_KEY_ONLY"},
+	{0x00400000, "DONT_REQ_PREAUTH"},
+	{0x00800000, "PASSWORD_EXPIRED"},
+	{0x01000000, "TRUSTED_TO_AUTH_FOR_DELEGATION"},
+	{0x04000000, "PARTIAL_SECRETS_ACCOUNT"},
+}
+
+// UserAccountControlComputedFlags are the bits of msDS-User-Account-Control-Computed, the
+// account states computed by the DC.
+var UserAccountControlComputedFlags = []Flag{
+	{0x00000010, "LOCKOUT"},
+	{0x00800000, "PASSWORD_EXPIRED"},
+	{0x04000000, "PARTIAL_SECRETS_ACCOUNT"},
+	{0x08000000, "USE_AES_KEYS"},
+}
+
+// GroupTypeFlags are the bits of groupType (GROUP_TYPE_*).
+var GroupTypeFlags = []Flag{
+	{0x00000001, "BUILTIN_LOCAL_GROUP"},
+	{0x00000002, "ACCOUNT_GROUP"},
+	{0x00000004, "RESOURCE_GROUP"},
+	{0x00000008, "UNIVERSAL_GROUP"},
+	{0x00000010, "APP_BASIC_GROUP"},
+	{0x00000020, "APP_QUERY_GROUP"},
+	{0x80000000, "SECURITY_ENABLED"},
+}
+
+// SAMAccountTypeValues are the values of sAMAccountType (SAM_*), an enumeration.
+var SAMAccountTypeValues = []Flag{
+	{0x00000000, "SAM_DOMAIN_OBJECT"},
+	{0x10000000, "SAM_GROUP_OBJECT"},
+	{0x10000001, "SAM_NON_SECURITY_GROUP_OBJECT"},
+	{0x20000000, "SAM_ALIAS_OBJECT"},
+	{0x20000001, "SAM_NON_SECURITY_ALIAS_OBJECT"},
+	{0x30000000, "SAM_USER_OBJECT"},
+	{0x30000001, "SAM_MACHINE_ACCOUNT"},
+	{0x30000002, "SAM_TRUST_ACCOUNT"},
+	{0x40000000, "SAM_APP_BASIC_GROUP"},
+	{0x40000001, "SAM_APP_QUERY_GROUP"},
+}
+
+// SystemFlags are the bits of systemFlags (FLAG_*). The low bits mean one thing on
+// attributeSchema objects and another on crossRef objects, so both names are given.
+var SystemFlags = []Flag{
+	{0x00000001, "ATTR_NOT_REPLICATED/CR_NTDS_NC"},
+	{0x00000002, "ATTR_REQ_PARTIAL_SET_MEMBER/CR_NTDS_DOMAIN"},
+	{0x00000004, "ATTR_IS_CONSTRUCTED/CR_NTDS_NOT_GC_REPLICATED"},
+	{0x00000008, "ATTR_IS_OPERATIONAL"},
+	{0x00000010, "SCHEMA_BASE_OBJECT"},
+	{0x00000020, "ATTR_IS_RDN"},
+	{0x02000000, "DISALLOW_MOVE_ON_DELETE"},
+	{0x04000000, "DOMAIN_DISALLOW_MOVE"},
+	{0x08000000, "DOMAIN_DISALLOW_RENAME"},
+	{0x10000000, "CONFIG_ALLOW_LIMITED_MOVE"},
+	{0x20000000, "CONFIG_ALLOW_MOVE"},
+	{0x40000000, "CONFIG_ALLOW_RENAME"},
+	{0x80000000, "DISALLOW_DELETE"},
+}
+
+// SearchFlags are the bits of the searchFlags of attributeSchema objects.
+var SearchFlags = []Flag{
+	{0x00000001, "fATTINDEX"},
+	{0x00000002, "fPDNTATTINDEX"},
+	{0x00000004, "fANR"},
+	{0x00000008, "fPRESERVEONDELETE"},
+	{0x00000010, "fCOPY"},
+	{0x00000020, "fTUPLEINDEX"},
+	{0x00000040, "fSUBTREEATTINDEX"},
+	{0x00000080, "fCONFIDENTIAL"},
+	{0x00000100, "fNEVERVALUEAUDIT"},
+	{0x00000200, "fRODCFilteredAttribute"},
+	{0x00000400, "fEXTENDEDLINKTRACKING"},
+	{0x00000800, "fBASEONLY"},
+	{0x00001000, "fPARTITIONSECRET"},
+}
+
+// SupportedEncryptionTypeFlags are the Kerberos encryption types and features of
+// msDS-SupportedEncryptionTypes.
+var SupportedEncryptionTypeFlags = []Flag{
+	{0x00000001, "DES_CBC_CRC"},
+	{0x00000002, "DES_CBC_MD5"},
+	{0x00000004, "RC4_HMAC"},
+	{0x00000008, "AES128_CTS_HMAC_SHA1_96"},
+	{0x00000010, "AES256_CTS_HMAC_SHA1_96"},
+	{0x00000020, "AES256_CTS_HMAC_SHA1_96_SK"},
+	{0x00010000, "FAST_SUPPORTED"},
+	{0x00020000, "COMPOUND_IDENTITY_SUPPORTED"},
+	{0x00040000, "CLAIMS_SUPPORTED"},
+	{0x00080000, "RESOURCE_SID_COMPRESSION_DISABLED"},
+}
+
+// TrustAttributeFlags are the bits of the trustAttributes of trustedDomain objects
+// (TRUST_ATTRIBUTE_*).
+var TrustAttributeFlags = []Flag{
+	{0x00000001, "NON_TRANSITIVE"},
+	{0x00000002, "UPLEVEL_ONLY"},
+	{0x00000004, "QUARANTINED_DOMAIN"},
+	{0x00000008, "FOREST_TRANSITIVE"},
+	{0x00000010, "CROSS_ORGANIZATION"},
+	{0x00000020, "WITHIN_FOREST"},
+	{0x00000040, "TREAT_AS_EXTERNAL"},
+	{0x00000080, "USES_RC4_ENCRYPTION"},
+	{0x00000200, "CROSS_ORGANIZATION_NO_TGT_DELEGATION"},
+	{0x00000400, "PIM_TRUST"},
+	{0x00000800, "CROSS_ORGANIZATION_ENABLE_TGT_DELEGATION"},
+	{0x00001000, "DISABLE_AUTH_TARGET_VALIDATION"},
+}
@@ -52,3 +52,21 @@ func parseInt64(s string) (int64, error) {
 	_, err := fmt.Sscan(s, &v)
 	return v, err
 }
+
+// interfaces copies values into the []interface{} an Interpreter returns, one element per value.
+func interfaces[T any](values []T) []interface{} {
+	result := make([]interface{}, len(values))
+	for i, v := range values {
+		result[i] = v
+	}
+	return result
+}
+
+// cloneValues copies raw values, so interpreted values do not alias the LDAP response.
+func cloneValues(values [][]byte) [][]byte {
+	result := make([][]byte, len(values))
+	for i, b := range values {
+		result[i] = bytes.Clone(b)
+	}
+	return result
+}
@@ -0,0 +1,130 @@
+package transformers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// keyCredentialVersion2 is the only KEYCREDENTIALLINK_BLOB version written by current DCs.
+const keyCredentialVersion2 = 0x00000200
+
+// KEYCREDENTIALLINK_ENTRY identifiers (MS-ADTS 2.2.20.6)
+const (
+	keyCredentialKeyID         = 0x01
+	keyCredentialKeyHash       = 0x02
+	keyCredentialKeyMaterial   = 0x03
+	keyCredentialKeyUsage      = 0x04
+	keyCredentialKeySource     = 0x05
+	keyCredentialDeviceID      = 0x06
+	keyCredentialCustomKeyInfo = 0x07
+	keyCredentialLastLogon     = 0x08
+	keyCredentialCreationTime  = 0x09
+)
+
+// KeyCredential is a key registered for passwordless sign-in in msDS-KeyCredentialLink, by
+// Windows Hello for Business, FIDO or - in a shadow credentials attack - by whoever can write
+// the attribute. Unexpected keys, keys without a DeviceID of a registered device, and keys
+// created outside enrolment are the signs to look for.
+type KeyCredential struct {
+	KeyID         []byte // SHA-256 of KeyMaterial
+	KeyHash       []byte // SHA-256 of the entries that follow it
+	KeyMaterial   []byte
+	KeyUsage      string // NGC (Windows Hello), FIDO or FEK
+	KeySource     string // AD or AzureAD
+	DeviceID      uuid.UUID
+	CustomKeyInfo []byte
+	LastLogonTime *time.Time // approximate
+	CreationTime  *time.Time
+	DN            string // the object the key belongs to
+}
+
+var keyUsageNames = map[byte]string{
+	0x01: "NGC",
+	0x07: "FIDO",
+	0x08: "FEK",
+}
+
+var keySourceNames = map[byte]string{
+	0x00: "AD",
+	0x01: "AzureAD",
+}
+
+// ParseKeyCredential parses a version 2 KEYCREDENTIALLINK_BLOB: a 4-byte version followed by
+// entries of a 2-byte length, a 1-byte identifier and the value, all little-endian.
+func ParseKeyCredential(blob []byte) (*KeyCredential, error) {
+	if len(blob) < 4 {
+		return nil, fmt.Errorf("key credential too short: %d bytes", len(blob))
+	}
+	if version := binary.LittleEndian.Uint32(blob); version != keyCredentialVersion2 {
+		return nil, fmt.Errorf("unsupported key credential version 0x%x", version)
+	}
+
+	credential := &KeyCredential{}
+	for rest := blob[4:]; len(rest) > 0; {
+		if len(rest) < 3 {
+			return nil, fmt.Errorf("truncated key credential entry")
+		}
+		length := int(binary.LittleEndian.Uint16(rest))
+		identifier := rest[2]
+		if len(rest) < 3+length {
+			return nil, fmt.Errorf("truncated value of key credential entry 0x%02x", identifier)
+		}
+		value := rest[3 : 3+length]
+		rest = rest[3+length:]
+
+		var err error
+		switch identifier {
+		case keyCredentialKeyID:
+			credential.KeyID = value
+		case keyCredentialKeyHash:
+			credential.KeyHash = value
+		case keyCredentialKeyMaterial:
+			credential.KeyMaterial = value
+		case keyCredentialKeyUsage:
+			credential.KeyUsage, err = keyCredentialName(identifier, value, keyUsageNames)
+		case keyCredentialKeySource:
+			credential.KeySource, err = keyCredentialName(identifier, value, keySourceNames)
+		case keyCredentialDeviceID:
+			guids, err := adGuidToRFC4122Uuid([][]byte{value})
+			if err != nil {
+				return nil, fmt.Errorf("invalid key credential DeviceID: %w", err)
+			}
+			credential.DeviceID = guids[0]
+		case keyCredentialCustomKeyInfo:
+			credential.CustomKeyInfo = value
+		case keyCredentialLastLogon:
+			credential.LastLogonTime, err = keyCredentialTime(identifier, value)
+		case keyCredentialCreationTime:
+			credential.CreationTime, err = keyCredentialTime(identifier, value)
+		default:
+			// entries added by later versions of the format are skipped
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return credential, nil
+}
+
+// keyCredentialName names the one-byte value of a KeyUsage or KeySource entry.
+func keyCredentialName(identifier byte, value []byte, names map[byte]string) (string, error) {
+	if len(value) != 1 {
+		return "", fmt.Errorf("key credential entry 0x%02x has %d bytes, want 1", identifier, len(value))
+	}
+	if name, ok := names[value[0]]; ok {
+		return name, nil
+	}
+	return fmt.Sprintf("unknown (0x%02x)", value[0]), nil
+}
+
+// keyCredentialTime reads the FILETIME value of a timestamp entry.
+func keyCredentialTime(identifier byte, value []byte) (*time.Time, error) {
+	if len(value) != 8 {
+		return nil, fmt.Errorf("key credential entry 0x%02x has %d bytes, want 8", identifier, len(value))
+	}
+	return filetimeToTime(int64(binary.LittleEndian.Uint64(value))), nil
+}
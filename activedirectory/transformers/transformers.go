@@ -2,7 +2,11 @@ package transformers
 
 import (
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 	"unicode/utf8"
 
@@ -25,22 +29,103 @@ func (t SimpleStringFormatter) Normalize(values [][]byte) ([]string, error) {
 }
 
 func (t SimpleStringFormatter) Interpret(values [][]byte) (interface{}, error) {
-	return t.transform(values)
+	strs, err := t.transform(values)
+	if err != nil {
+		return nil, err
+	}
+	return interfaces(strs), nil
 }
 
 func (t SimpleStringFormatter) transform(values [][]byte) ([]string, error) {
 	result := make([]string, len(values))
 	for i, b := range values {
 		if !utf8.Valid(b) {
-			return nil, fmt.Errorf("warning: [SimpleStringFormatter] transform performed base64 encode - data was a binary blob and not a valid utf8 string")
-			// result[i] = base64.StdEncoding.EncodeToString(b)
+			return nil, fmt.Errorf("[SimpleStringFormatter] value is a binary blob, not a valid utf8 string")
+		}
+		result[i] = string(b)
+	}
+	return result, nil
+}
+
+// BooleanFormatter formats Boolean values, which AD returns as "TRUE" or "FALSE".
+type BooleanFormatter struct{}
+
+func (t BooleanFormatter) Normalize(values [][]byte) ([]string, error) {
+	return SimpleStringFormatter{}.transform(values)
+}
+
+func (t BooleanFormatter) Interpret(values [][]byte) (interface{}, error) {
+	result := make([]bool, len(values))
+	for i, b := range values {
+		switch string(b) {
+		case "TRUE":
+			result[i] = true
+		case "FALSE":
+			result[i] = false
+		default:
+			return nil, fmt.Errorf("invalid boolean %q", b)
+		}
+	}
+	return interfaces(result), nil
+}
+
+// IntegerFormatter formats 32-bit Integer and Enumeration values.
+type IntegerFormatter struct{}
+
+func (t IntegerFormatter) Normalize(values [][]byte) ([]string, error) {
+	return SimpleStringFormatter{}.transform(values)
+}
+
+func (t IntegerFormatter) Interpret(values [][]byte) (interface{}, error) {
+	result := make([]int, len(values))
+	for i, b := range values {
+		v, err := strconv.ParseInt(string(b), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer: %w", err)
+		}
+		result[i] = int(v)
+	}
+	return interfaces(result), nil
+}
+
+// BinaryEncoding selects how BinaryFormatter renders bytes as text.
+type BinaryEncoding int
+
+const (
+	BinaryBase64 BinaryEncoding = iota
+	BinaryHex
+)
+
+// Type tags of values normalized by BinaryFormatter
+const (
+	binaryBase64Tag = "base64:"
+	binaryHexTag    = "hex:"
+)
+
+// BinaryFormatter formats arbitrary bytes held by an attribute whose syntax is text, such as a
+// value its formatter could not parse. Values are normalized with the encoding as a type tag,
+// e.g. "base64:AQID" or "hex:010203", so they are never mistaken for text, and normalizing never
+// fails. Values of binary syntaxes are normalized by Base64Formatter instead.
+type BinaryFormatter struct {
+	Encoding BinaryEncoding
+}
+
+func (t BinaryFormatter) Normalize(values [][]byte) ([]string, error) {
+	result := make([]string, len(values))
+	for i, b := range values {
+		if t.Encoding == BinaryHex {
+			result[i] = binaryHexTag + hex.EncodeToString(b)
 		} else {
-			result[i] = string(b)
+			result[i] = binaryBase64Tag + base64.StdEncoding.EncodeToString(b)
 		}
 	}
 	return result, nil
 }
 
+func (t BinaryFormatter) Interpret(values [][]byte) (interface{}, error) {
+	return interfaces(cloneValues(values)), nil
+}
+
 type SIDFormatter struct{}
 
 func (t SIDFormatter) Normalize(values [][]byte) ([]string, error) {
@@ -48,7 +133,11 @@ func (t SIDFormatter) Normalize(values [][]byte) ([]string, error) {
 }
 
 func (t SIDFormatter) Interpret(values [][]byte) (interface{}, error) {
-	return t.transform(values)
+	sids, err := t.transform(values)
+	if err != nil {
+		return nil, err
+	}
+	return interfaces(sids), nil
 }
 
 func (t SIDFormatter) transform(values [][]byte) ([]string, error) {
@@ -119,12 +208,7 @@ func (t ADGuidFormatter) Interpret(values [][]byte) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-
-	result := make([]interface{}, len(uuids))
-	for i, u := range uuids {
-		result[i] = u
-	}
-	return result, nil
+	return interfaces(uuids), nil
 }
 
 type ADFiletimeFormatter struct{}
@@ -154,18 +238,23 @@ func fromFileDateTime(values [][]byte) ([]*time.Time, error) {
 			return nil, fmt.Errorf("invalid FILETIME integer: %w", err)
 		}
 
-		if ftVal == 0 || ftVal == filetimeNever {
-			times[i] = nil
-			continue
-		}
-
-		nsSinceUnix := (ftVal - filetimeEpochOffset) * 100
-		t := time.Unix(0, nsSinceUnix).UTC()
-		times[i] = &t
+		times[i] = filetimeToTime(ftVal)
 	}
 	return times, nil
 }
 
+// filetimeToTime converts a FILETIME to UTC, or nil for 0 and the largest int64, which AD
+// uses for "never".
+func filetimeToTime(ftVal int64) *time.Time {
+	if ftVal == 0 || ftVal == filetimeNever {
+		return nil
+	}
+
+	nsSinceUnix := (ftVal - filetimeEpochOffset) * 100
+	t := time.Unix(0, nsSinceUnix).UTC()
+	return &t
+}
+
 func (t ADFiletimeFormatter) Interpret(values [][]byte) (interface{}, error) {
 
 	times, err := fromFileDateTime(values)
@@ -173,7 +262,7 @@ func (t ADFiletimeFormatter) Interpret(values [][]byte) (interface{}, error) {
 		return nil, fmt.Errorf("failed to interpret filetime integer: %w", err)
 	}
 
-	return times, nil
+	return interfaces(times), nil
 }
 
 func (t ADFiletimeFormatter) Normalize(values [][]byte) ([]string, error) {
@@ -195,9 +284,132 @@ func (t ADFiletimeFormatter) Normalize(values [][]byte) ([]string, error) {
 	return timeStrings, nil
 }
 
+// LargeIntegerFormatter formats Large Integer values that are plain numbers, such as USNs,
+// counters, sizes and RID pools.
+type LargeIntegerFormatter struct{}
+
+func parseLargeIntegers(values [][]byte) ([]int64, error) {
+	integers := make([]int64, len(values))
+	for i, b := range values {
+		v, err := strconv.ParseInt(string(b), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid large integer: %w", err)
+		}
+		integers[i] = v
+	}
+	return integers, nil
+}
+
+func (t LargeIntegerFormatter) Interpret(values [][]byte) (interface{}, error) {
+	integers, err := parseLargeIntegers(values)
+	if err != nil {
+		return nil, err
+	}
+	return interfaces(integers), nil
+}
+
+func (t LargeIntegerFormatter) Normalize(values [][]byte) ([]string, error) {
+	integers, err := parseLargeIntegers(values)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, len(integers))
+	for i, v := range integers {
+		result[i] = strconv.FormatInt(v, 10)
+	}
+	return result, nil
+}
+
+// ADIntervalFormatter formats Large Integer values that are durations in 100-nanosecond
+// intervals, such as maxPwdAge and lockoutDuration. Domain policy stores them as negative
+// numbers, other attributes as positive ones; both are read as the same duration.
+type ADIntervalFormatter struct{}
+
+// intervalNever is the smallest int64, used by domain policy for "never" or "forever".
+const intervalNever = math.MinInt64
+
+func fromInterval(values [][]byte) ([]*time.Duration, error) {
+	integers, err := parseLargeIntegers(values)
+	if err != nil {
+		return nil, fmt.Errorf("invalid interval: %w", err)
+	}
+
+	durations := make([]*time.Duration, len(integers))
+	for i, v := range integers {
+		if v == intervalNever {
+			continue // nil
+		}
+		if v < 0 {
+			v = -v
+		}
+		// time.Duration is in nanoseconds; the largest interval that fits is about 29000 years
+		if v > math.MaxInt64/100 {
+			return nil, fmt.Errorf("interval %d out of range", integers[i])
+		}
+		d := time.Duration(v * 100)
+		durations[i] = &d
+	}
+	return durations, nil
+}
+
+func (t ADIntervalFormatter) Interpret(values [][]byte) (interface{}, error) {
+	durations, err := fromInterval(values)
+	if err != nil {
+		return nil, fmt.Errorf("failed to interpret interval: %w", err)
+	}
+	return interfaces(durations), nil
+}
+
+func (t ADIntervalFormatter) Normalize(values [][]byte) ([]string, error) {
+	durations, err := fromInterval(values)
+	if err != nil {
+		return nil, fmt.Errorf("failed to interpret interval: %w", err)
+	}
+
+	result := make([]string, len(durations))
+	for i, d := range durations {
+		if d == nil {
+			result[i] = "never"
+			continue
+		}
+		result[i] = formatInterval(*d)
+	}
+	return result, nil
+}
+
+// formatInterval renders d in days, hours, minutes and seconds, omitting zero components,
+// e.g. "42d" or "1h 30m". Sub-second remainders are kept as a fraction of the seconds.
+func formatInterval(d time.Duration) string {
+	if d == 0 {
+		return "0s"
+	}
+
+	var parts []string
+	if days := d / (24 * time.Hour); days > 0 {
+		parts = append(parts, fmt.Sprintf("%dd", days))
+		d -= days * 24 * time.Hour
+	}
+	if hours := d / time.Hour; hours > 0 {
+		parts = append(parts, fmt.Sprintf("%dh", hours))
+		d -= hours * time.Hour
+	}
+	if minutes := d / time.Minute; minutes > 0 {
+		parts = append(parts, fmt.Sprintf("%dm", minutes))
+		d -= minutes * time.Minute
+	}
+	if d > 0 {
+		parts = append(parts, strconv.FormatFloat(d.Seconds(), 'f', -1, 64)+"s")
+	}
+	return strings.Join(parts, " ")
+}
+
 type NTSecurityDescriptorFormatter struct{}
 
 func (t NTSecurityDescriptorFormatter) Interpret(values [][]byte) (interface{}, error) {
+	if len(values) == 0 {
+		return nil, fmt.Errorf("failed to interpret nTSecurityDescriptor: no value")
+	}
 
 	ntSecurityDescriptor, err := gontsd.Parse(values[0], nil)
 
@@ -212,9 +424,6 @@ func (t NTSecurityDescriptorFormatter) Normalize(values [][]byte) ([]string, err
 
 	b64EncodednTSecurityDescriptor := make([]string, len(values))
 	for i, b := range values {
-		if utf8.Valid(b) {
-			return nil, fmt.Errorf("ntSecurityDescriptor field should not contain a valid utf8 string")
-		}
 		b64EncodednTSecurityDescriptor[i] = base64.StdEncoding.EncodeToString(b)
 	}
 
@@ -222,24 +431,53 @@ func (t NTSecurityDescriptorFormatter) Normalize(values [][]byte) ([]string, err
 	return b64EncodednTSecurityDescriptor, nil
 }
 
+// LDAPTimeFormatter formats Generalized-Time values such as whenCreated. AD writes them as
+// "20240102030405.0Z", but other fractions and UTC offsets are valid generalized time too.
 type LDAPTimeFormatter struct {
-	Layout string
+	Layout string // parses only this layout when set
+}
+
+// generalizedTimeLayouts accept an optional fraction of a second after the seconds, which
+// time.Parse allows without a layout element, and either Z or an offset of hours and minutes
+// or hours only.
+var generalizedTimeLayouts = []string{
+	"20060102150405Z0700",
+	"20060102150405Z07",
 }
 
 func (t LDAPTimeFormatter) parseLDAPTime(values [][]byte) ([]time.Time, error) {
+	layouts := generalizedTimeLayouts
+	if t.Layout != "" {
+		layouts = []string{t.Layout}
+	}
+
 	times := make([]time.Time, len(values))
 	for i, b := range values {
 		s := string(b)
-		ts, err := time.Parse(t.Layout, s)
+		var err error
+		for _, layout := range layouts {
+			if times[i], err = time.Parse(layout, s); err == nil {
+				break
+			}
+		}
 		if err != nil {
 			return nil, err
 		}
-		times[i] = ts
 	}
 
 	return times, nil
 }
 
+func (t LDAPTimeFormatter) Interpret(values [][]byte) (interface{}, error) {
+	times, err := t.parseLDAPTime(values)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse LDAP times: %w", err)
+	}
+	return interfaces(times), nil
+}
+
+// Normalize formats times in UTC, so the same instant written with different offsets
+// normalizes to the same value.
 func (t LDAPTimeFormatter) Normalize(values [][]byte) ([]string, error) {
 	times, err := t.parseLDAPTime(values)
 	if err != nil {
@@ -248,12 +486,14 @@ func (t LDAPTimeFormatter) Normalize(values [][]byte) ([]string, error) {
 
 	strTimes := make([]string, len(times))
 	for i, tm := range times {
-		strTimes[i] = tm.String()
+		strTimes[i] = tm.UTC().String()
 	}
 
 	return strTimes, nil
 }
 
+// Base64Formatter formats values of binary syntaxes as base64. The syntax already marks them as
+// bytes, so unlike BinaryFormatter the values carry no type tag.
 type Base64Formatter struct {
 	Layout string
 }
@@ -265,3 +505,7 @@ func (t Base64Formatter) Normalize(values [][]byte) ([]string, error) {
 	}
 	return sanitized, nil
 }
+
+func (t Base64Formatter) Interpret(values [][]byte) (interface{}, error) {
+	return interfaces(cloneValues(values)), nil
+}
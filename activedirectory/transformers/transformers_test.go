@@ -0,0 +1,370 @@
+package transformers_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"f0oster/adspy/activedirectory/transformers"
+)
+
+func bytesOf(values ...string) [][]byte {
+	result := make([][]byte, len(values))
+	for i, v := range values {
+		result[i] = []byte(v)
+	}
+	return result
+}
+
+func TestADFiletimeFormatter(t *testing.T) {
+	// 133485408000000000 is 2024-01-01T00:00:00Z; 0 and the largest int64 mean "never"
+	values := bytesOf("133485408000000000", "0", "9223372036854775807")
+
+	normalized, err := transformers.ADFiletimeFormatter{}.Normalize(values)
+	if err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+	want := []string{"2024-01-01 00:00:00 +0000 UTC", "N/A", "N/A"}
+	if !reflect.DeepEqual(normalized, want) {
+		t.Errorf("Normalize = %q, want %q", normalized, want)
+	}
+
+	interpreted, err := transformers.ADFiletimeFormatter{}.Interpret(values)
+	if err != nil {
+		t.Fatalf("Interpret failed: %v", err)
+	}
+	results, ok := interpreted.([]interface{})
+	if !ok || len(results) != 3 {
+		t.Fatalf("Interpret = %#v, want three *time.Time", interpreted)
+	}
+	times := make([]*time.Time, len(results))
+	for i, v := range results {
+		if times[i], ok = v.(*time.Time); !ok {
+			t.Fatalf("Interpret value %d is %T, want *time.Time", i, v)
+		}
+	}
+	if !times[0].Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) || times[1] != nil || times[2] != nil {
+		t.Errorf("Interpret = %v, want 2024-01-01 and two nils", times)
+	}
+}
+
+func TestADIntervalFormatter(t *testing.T) {
+	tests := []struct {
+		value      string
+		normalized string
+		duration   *time.Duration
+	}{
+		{"-36288000000000", "42d", durationOf(42 * 24 * time.Hour)}, // maxPwdAge of the default domain policy
+		{"-18000000000", "30m", durationOf(30 * time.Minute)},       // lockoutDuration
+		{"36000000000", "1h", durationOf(time.Hour)},                // msDS-UserTGTLifetime, stored positive
+		{"-900015000000", "1d 1h 1.5s", durationOf(25*time.Hour + 1500*time.Millisecond)},
+		{"0", "0s", durationOf(0)},
+		{"-9223372036854775808", "never", nil},
+	}
+
+	for _, test := range tests {
+		normalized, err := transformers.ADIntervalFormatter{}.Normalize(bytesOf(test.value))
+		if err != nil {
+			t.Fatalf("Normalize(%s) failed: %v", test.value, err)
+		}
+		if normalized[0] != test.normalized {
+			t.Errorf("Normalize(%s) = %q, want %q", test.value, normalized[0], test.normalized)
+		}
+
+		interpreted, err := transformers.ADIntervalFormatter{}.Interpret(bytesOf(test.value))
+		if err != nil {
+			t.Fatalf("Interpret(%s) failed: %v", test.value, err)
+		}
+		got := interpreted.([]interface{})[0].(*time.Duration)
+		if !reflect.DeepEqual(got, test.duration) {
+			t.Errorf("Interpret(%s) = %v, want %v", test.value, got, test.duration)
+		}
+	}
+
+	if _, err := (transformers.ADIntervalFormatter{}).Normalize(bytesOf("not a number")); err == nil {
+		t.Error("Expected an error for a non-numeric interval")
+	}
+}
+
+func durationOf(d time.Duration) *time.Duration {
+	return &d
+}
+
+func TestLargeIntegerFormatter(t *testing.T) {
+	// A RID pool packs two 32-bit RIDs; it must not be read as a date
+	values := bytesOf("4611686014132422708", "12345", "-1")
+
+	normalized, err := transformers.LargeIntegerFormatter{}.Normalize(values)
+	if err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+	if want := []string{"4611686014132422708", "12345", "-1"}; !reflect.DeepEqual(normalized, want) {
+		t.Errorf("Normalize = %q, want %q", normalized, want)
+	}
+
+	interpreted, err := transformers.LargeIntegerFormatter{}.Interpret(values)
+	if err != nil {
+		t.Fatalf("Interpret failed: %v", err)
+	}
+	if want := []interface{}{int64(4611686014132422708), int64(12345), int64(-1)}; !reflect.DeepEqual(interpreted, want) {
+		t.Errorf("Interpret = %v, want %v", interpreted, want)
+	}
+
+	if _, err := (transformers.LargeIntegerFormatter{}).Normalize(bytesOf("12.5")); err == nil {
+		t.Error("Expected an error for a non-integer value")
+	}
+}
+
+func TestLDAPTimeFormatter(t *testing.T) {
+	tests := []struct {
+		value      string
+		normalized string
+		time       time.Time
+	}{
+		{"20240102030405.0Z", "2024-01-02 03:04:05 +0000 UTC", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{"20240102030405Z", "2024-01-02 03:04:05 +0000 UTC", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{"20240102030405.125Z", "2024-01-02 03:04:05.125 +0000 UTC", time.Date(2024, 1, 2, 3, 4, 5, 125e6, time.UTC)},
+		{"20240102030405.0-0500", "2024-01-02 08:04:05 +0000 UTC", time.Date(2024, 1, 2, 8, 4, 5, 0, time.UTC)},
+		{"20240102030405+02", "2024-01-02 01:04:05 +0000 UTC", time.Date(2024, 1, 2, 1, 4, 5, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		normalized, err := transformers.LDAPTimeFormatter{}.Normalize(bytesOf(test.value))
+		if err != nil {
+			t.Fatalf("Normalize(%s) failed: %v", test.value, err)
+		}
+		if normalized[0] != test.normalized {
+			t.Errorf("Normalize(%s) = %q, want %q", test.value, normalized[0], test.normalized)
+		}
+
+		interpreted, err := transformers.LDAPTimeFormatter{}.Interpret(bytesOf(test.value))
+		if err != nil {
+			t.Fatalf("Interpret(%s) failed: %v", test.value, err)
+		}
+		if got := interpreted.([]interface{})[0].(time.Time); !got.Equal(test.time) {
+			t.Errorf("Interpret(%s) = %v, want %v", test.value, got, test.time)
+		}
+	}
+
+	if _, err := (transformers.LDAPTimeFormatter{}).Normalize(bytesOf("2024-01-02")); err == nil {
+		t.Error("Expected an error for a value that is not generalized time")
+	}
+}
+
+func TestBooleanAndIntegerFormatters(t *testing.T) {
+	interpreted, err := transformers.BooleanFormatter{}.Interpret(bytesOf("TRUE", "FALSE"))
+	if err != nil {
+		t.Fatalf("Interpret failed: %v", err)
+	}
+	if want := []interface{}{true, false}; !reflect.DeepEqual(interpreted, want) {
+		t.Errorf("Interpret = %v, want %v", interpreted, want)
+	}
+	if _, err := (transformers.BooleanFormatter{}).Interpret(bytesOf("yes")); err == nil {
+		t.Error("Expected an error for a value that is not TRUE or FALSE")
+	}
+
+	interpreted, err = transformers.IntegerFormatter{}.Interpret(bytesOf("512", "-2147483646"))
+	if err != nil {
+		t.Fatalf("Interpret failed: %v", err)
+	}
+	if want := []interface{}{512, -2147483646}; !reflect.DeepEqual(interpreted, want) {
+		t.Errorf("Interpret = %v, want %v", interpreted, want)
+	}
+	if _, err := (transformers.IntegerFormatter{}).Interpret(bytesOf("4294967296")); err == nil {
+		t.Error("Expected an error for a value out of the 32-bit range")
+	}
+}
+
+func TestBinaryFormatter(t *testing.T) {
+	// Not valid UTF-8, so it would be lost as text
+	values := [][]byte{{0x00, 0xff, 0xfe, 0x10}, {}}
+
+	normalized, err := transformers.BinaryFormatter{}.Normalize(values)
+	if err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+	if want := []string{"base64:AP/+EA==", "base64:"}; !reflect.DeepEqual(normalized, want) {
+		t.Errorf("Normalize = %q, want %q", normalized, want)
+	}
+
+	normalized, err = transformers.BinaryFormatter{Encoding: transformers.BinaryHex}.Normalize(values)
+	if err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+	if want := []string{"hex:00fffe10", "hex:"}; !reflect.DeepEqual(normalized, want) {
+		t.Errorf("Normalize = %q, want %q", normalized, want)
+	}
+
+	interpreted, err := transformers.BinaryFormatter{}.Interpret(values)
+	if err != nil {
+		t.Fatalf("Interpret failed: %v", err)
+	}
+	if want := []interface{}{[]byte{0x00, 0xff, 0xfe, 0x10}, []byte{}}; !reflect.DeepEqual(interpreted, want) {
+		t.Errorf("Interpret = %v, want %v", interpreted, want)
+	}
+}
+
+func TestDNBinaryFormatter(t *testing.T) {
+	// The well-known Users container of wellKnownObjects
+	value := "B:32:A9D1CA15768811D1ADED00C04FD8D5CD:CN=Users,DC=example,DC=com"
+
+	interpreted, err := transformers.DNBinaryFormatter{}.Interpret(bytesOf(value))
+	if err != nil {
+		t.Fatalf("Interpret failed: %v", err)
+	}
+	got := interpreted.([]interface{})[0].(transformers.DNBinary)
+	if got.DN != "CN=Users,DC=example,DC=com" || len(got.Binary) != 16 || got.Binary[0] != 0xA9 {
+		t.Errorf("Interpret = %+v, want 16 bytes of binary for CN=Users", got)
+	}
+
+	normalized, err := transformers.DNBinaryFormatter{}.Normalize(bytesOf(value))
+	if err != nil || normalized[0] != value {
+		t.Errorf("Normalize = %q, %v, want the value unchanged", normalized, err)
+	}
+
+	for _, invalid := range []string{"CN=Users,DC=example,DC=com", "B:3:ABC:CN=Users", "B:4:ABCD", "B:4:ZZZZ:CN=Users", "B:40:AB:CN=Users"} {
+		if _, err := transformers.ParseDNBinary(invalid); err == nil {
+			t.Errorf("ParseDNBinary(%q) succeeded, want an error", invalid)
+		}
+	}
+}
+
+func TestDNStringFormatter(t *testing.T) {
+	// The count is in characters; the string may contain colons
+	value := "S:6:Zürich:CN=Site,DC=example,DC=com"
+
+	interpreted, err := transformers.DNStringFormatter{}.Interpret(bytesOf(value, "S:0::CN=Empty"))
+	if err != nil {
+		t.Fatalf("Interpret failed: %v", err)
+	}
+	want := []interface{}{
+		transformers.DNString{String: "Zürich", DN: "CN=Site,DC=example,DC=com"},
+		transformers.DNString{String: "", DN: "CN=Empty"},
+	}
+	if !reflect.DeepEqual(interpreted, want) {
+		t.Errorf("Interpret = %+v, want %+v", interpreted, want)
+	}
+
+	if v, err := transformers.ParseDNString("S:3:a:b:CN=X"); err != nil || v.String != "a:b" || v.DN != "CN=X" {
+		t.Errorf("ParseDNString = %+v, %v, want a:b for CN=X", v, err)
+	}
+	if _, err := transformers.ParseDNString("S:9:short:CN=X"); err == nil {
+		t.Error("Expected an error for a count past the end of the value")
+	}
+}
+
+// keyCredentialEntry encodes a KEYCREDENTIALLINK_ENTRY.
+func keyCredentialEntry(identifier byte, value []byte) []byte {
+	entry := binary.LittleEndian.AppendUint16(nil, uint16(len(value)))
+	entry = append(entry, identifier)
+	return append(entry, value...)
+}
+
+func TestKeyCredentialLinkFormatter(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	filetime := binary.LittleEndian.AppendUint64(nil, uint64(created.UnixNano()/100+116444736000000000))
+	deviceID := []byte{0x33, 0x22, 0x11, 0x00, 0x55, 0x44, 0x77, 0x66, 0x88, 0x99, 0xAA, 0xBB, 0xCC, 0xDD, 0xEE, 0xFF}
+
+	blob := binary.LittleEndian.AppendUint32(nil, 0x200)
+	blob = append(blob, keyCredentialEntry(0x01, []byte{0x01, 0x02})...)
+	blob = append(blob, keyCredentialEntry(0x03, []byte("RSA1"))...)
+	blob = append(blob, keyCredentialEntry(0x04, []byte{0x01})...)
+	blob = append(blob, keyCredentialEntry(0x05, []byte{0x00})...)
+	blob = append(blob, keyCredentialEntry(0x06, deviceID)...)
+	blob = append(blob, keyCredentialEntry(0x09, filetime)...)
+	blob = append(blob, keyCredentialEntry(0x7F, []byte{0xFF})...) // unknown entries are skipped
+
+	value := fmt.Sprintf("B:%d:%X:CN=Alice,OU=Users,DC=example,DC=com", len(blob)*2, blob)
+	interpreted, err := transformers.KeyCredentialLinkFormatter{}.Interpret(bytesOf(value))
+	if err != nil {
+		t.Fatalf("Interpret failed: %v", err)
+	}
+	got := interpreted.([]interface{})[0].(*transformers.KeyCredential)
+
+	if got.DN != "CN=Alice,OU=Users,DC=example,DC=com" || got.KeyUsage != "NGC" || got.KeySource != "AD" {
+		t.Errorf("KeyCredential = %+v, want an NGC key from AD for Alice", got)
+	}
+	if !bytes.Equal(got.KeyID, []byte{0x01, 0x02}) || string(got.KeyMaterial) != "RSA1" {
+		t.Errorf("KeyID = %x, KeyMaterial = %q", got.KeyID, got.KeyMaterial)
+	}
+	if got.DeviceID.String() != "00112233-4455-6677-8899-aabbccddeeff" {
+		t.Errorf("DeviceID = %s, want 00112233-4455-6677-8899-aabbccddeeff", got.DeviceID)
+	}
+	if got.CreationTime == nil || !got.CreationTime.Equal(created) || got.LastLogonTime != nil {
+		t.Errorf("CreationTime = %v, LastLogonTime = %v, want %s and nil", got.CreationTime, got.LastLogonTime, created)
+	}
+
+	if _, err := transformers.ParseKeyCredential([]byte{0x00, 0x01, 0x00, 0x00}); err == nil {
+		t.Error("Expected an error for an unsupported version")
+	}
+	if _, err := transformers.ParseKeyCredential(append(binary.LittleEndian.AppendUint32(nil, 0x200), 0x10, 0x00, 0x03)); err == nil {
+		t.Error("Expected an error for a truncated entry")
+	}
+}
+
+func TestFlagFormatter(t *testing.T) {
+	uac := transformers.FlagFormatter{Flags: transformers.UserAccountControlFlags}
+
+	// 66048 is NORMAL_ACCOUNT | DONT_EXPIRE_PASSWORD; 0x20000000 has no name
+	interpreted, err := uac.Interpret(bytesOf("66048", "536871426"))
+	if err != nil {
+		t.Fatalf("Interpret failed: %v", err)
+	}
+	want := []interface{}{
+		transformers.Flags{Value: 66048, Names: []string{"NORMAL_ACCOUNT", "DONT_EXPIRE_PASSWORD"}},
+		transformers.Flags{Value: 536871426, Names: []string{"ACCOUNTDISABLE", "NORMAL_ACCOUNT", "0x20000000"}},
+	}
+	if !reflect.DeepEqual(interpreted, want) {
+		t.Errorf("Interpret = %+v, want %+v", interpreted, want)
+	}
+
+	// A disabled account enabled with Kerberos pre-authentication turned off (AS-REP roastable)
+	summary, err := uac.DescribeChange([]string{"66050"}, []string{"4260352"})
+	if err != nil {
+		t.Fatalf("DescribeChange failed: %v", err)
+	}
+	if want := "flag DONT_REQ_PREAUTH set, ACCOUNTDISABLE cleared"; summary != want {
+		t.Errorf("DescribeChange = %q, want %q", summary, want)
+	}
+	if summary, _ := uac.DescribeChange(nil, []string{"514"}); summary != "flag ACCOUNTDISABLE set, NORMAL_ACCOUNT set" {
+		t.Errorf("DescribeChange from no value = %q", summary)
+	}
+
+	// groupType is returned signed: a global security group is -2147483646
+	groupType := transformers.FlagFormatter{Flags: transformers.GroupTypeFlags}
+	interpreted, err = groupType.Interpret(bytesOf("-2147483646"))
+	if err != nil {
+		t.Fatalf("Interpret failed: %v", err)
+	}
+	if got := interpreted.([]interface{})[0].(transformers.Flags); !reflect.DeepEqual(got.Names, []string{"ACCOUNT_GROUP", "SECURITY_ENABLED"}) {
+		t.Errorf("groupType names = %v, want ACCOUNT_GROUP and SECURITY_ENABLED", got.Names)
+	}
+
+	if _, err := uac.Interpret(bytesOf("4294967296")); err == nil {
+		t.Error("Expected an error for a value wider than 32 bits")
+	}
+}
+
+func TestEnumFormatter(t *testing.T) {
+	samAccountType := transformers.EnumFormatter{Values: transformers.SAMAccountTypeValues}
+
+	interpreted, err := samAccountType.Interpret(bytesOf("805306368", "7"))
+	if err != nil {
+		t.Fatalf("Interpret failed: %v", err)
+	}
+	want := []interface{}{
+		transformers.Flags{Value: 0x30000000, Names: []string{"SAM_USER_OBJECT"}},
+		transformers.Flags{Value: 7, Names: []string{"0x7"}},
+	}
+	if !reflect.DeepEqual(interpreted, want) {
+		t.Errorf("Interpret = %+v, want %+v", interpreted, want)
+	}
+
+	summary, err := samAccountType.DescribeChange([]string{"805306368"}, []string{"805306369"})
+	if err != nil || summary != "SAM_USER_OBJECT -> SAM_MACHINE_ACCOUNT" {
+		t.Errorf("DescribeChange = %q, %v", summary, err)
+	}
+}
@@ -0,0 +1,167 @@
+package activedirectory
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"f0oster/adspy/config"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Connect dials host using the configured transport and binds with the configured credentials.
+// It is shared by the poller and the web resolver so both honour the same security settings.
+func Connect(host string, cfg config.DomainConfiguration) (*ldap.Conn, string, error) {
+	conn, sasl, url, err := dial(host, cfg.Transport)
+	if err != nil {
+		return nil, url, err
+	}
+
+	switch cfg.Auth {
+	case config.AuthGSSAPI:
+		if err := bindGSSAPI(conn, sasl, host, cfg); err != nil {
+			conn.Close()
+			return nil, url, fmt.Errorf("failed to bind to %s: %w", url, err)
+		}
+	default:
+		if cfg.Transport.Mode == config.TransportPlain {
+			if !cfg.AllowInsecureBind {
+				conn.Close()
+				return nil, url, fmt.Errorf("refusing a simple bind to %s over an unencrypted connection", url)
+			}
+			log.Printf("Warning: binding to %s over an unencrypted connection", url)
+		}
+		if err := conn.Bind(cfg.Username, cfg.Password); err != nil {
+			conn.Close()
+			return nil, url, fmt.Errorf("failed to bind to %s: %w", url, err)
+		}
+	}
+
+	return conn, url, nil
+}
+
+// bindGSSAPI performs a SASL/GSSAPI bind and installs the negotiated security layer, if any.
+func bindGSSAPI(conn *ldap.Conn, sasl *saslConn, host string, cfg config.DomainConfiguration) error {
+	krb, err := newKerberosClient(cfg.Username, cfg.Kerberos)
+	if err != nil {
+		return err
+	}
+	defer krb.Destroy()
+
+	spn := cfg.Kerberos.ServicePrincipal
+	if spn == "" {
+		spn = "ldap/" + host
+	}
+
+	client := newGSSAPIClient(krb, cfg.Kerberos.Protection)
+	if err := conn.GSSAPIBind(client, spn, ""); err != nil {
+		return err
+	}
+
+	if client.layer != nil {
+		sasl.install(client.layer)
+	} else if cfg.Transport.Mode == config.TransportPlain {
+		log.Printf("Warning: GSSAPI bind to %s negotiated no signing or sealing", host)
+	}
+
+	return nil
+}
+
+// dial opens an unbound LDAP connection to host using the given transport. The socket is
+// wrapped in a saslConn so a SASL security layer can be installed after binding.
+// It returns the URL that was dialled for logging purposes.
+func dial(host string, transport config.LDAPTransport) (*ldap.Conn, *saslConn, string, error) {
+	url := LDAPURL(host, transport)
+	addr := strings.SplitN(url, "://", 2)[1]
+
+	var tlsConfig *tls.Config
+	if transport.Mode != config.TransportPlain {
+		var err error
+		tlsConfig, err = NewTLSConfig(host, transport)
+		if err != nil {
+			return nil, nil, url, err
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: ldap.DefaultTimeout}
+
+	var netConn net.Conn
+	var err error
+	if transport.Mode == config.TransportLDAPS {
+		netConn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		netConn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, nil, url, fmt.Errorf("failed to connect to %s: %w", url, err)
+	}
+
+	sasl := newSASLConn(netConn)
+	conn := ldap.NewConn(sasl, transport.Mode == config.TransportLDAPS)
+	conn.Start()
+
+	if transport.Mode == config.TransportStartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, nil, url, fmt.Errorf("failed to StartTLS with %s: %w", url, err)
+		}
+	}
+
+	return conn, sasl, url, nil
+}
+
+// LDAPURL builds the ldap:// or ldaps:// URL for host under the given transport.
+func LDAPURL(host string, transport config.LDAPTransport) string {
+	scheme, port := "ldap", 389
+	if transport.Mode == config.TransportLDAPS {
+		scheme, port = "ldaps", 636
+	}
+	if transport.Port != 0 {
+		port = transport.Port
+	}
+	return fmt.Sprintf("%s://%s:%d", scheme, host, port)
+}
+
+// NewTLSConfig builds the TLS client configuration for host from the transport settings.
+func NewTLSConfig(host string, transport config.LDAPTransport) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         host,
+		InsecureSkipVerify: transport.InsecureSkipVerify,
+	}
+
+	if transport.ServerName != "" {
+		tlsConfig.ServerName = transport.ServerName
+	}
+
+	if transport.CAFile != "" {
+		pem, err := os.ReadFile(transport.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle %s: %w", transport.CAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", transport.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if transport.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(transport.CertFile, transport.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if transport.InsecureSkipVerify {
+		log.Printf("Warning: TLS certificate verification is disabled for %s", host)
+	}
+
+	return tlsConfig, nil
+}
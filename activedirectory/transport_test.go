@@ -0,0 +1,169 @@
+package activedirectory
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"f0oster/adspy/config"
+)
+
+// writeTestCertificate writes a self-signed certificate and its key as PEM files and returns
+// their paths.
+func writeTestCertificate(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Root CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestLDAPURL(t *testing.T) {
+	tests := []struct {
+		transport config.LDAPTransport
+		want      string
+	}{
+		{config.LDAPTransport{Mode: config.TransportPlain}, "ldap://dc1.example.com:389"},
+		{config.LDAPTransport{Mode: config.TransportStartTLS}, "ldap://dc1.example.com:389"},
+		{config.LDAPTransport{Mode: config.TransportLDAPS}, "ldaps://dc1.example.com:636"},
+		{config.LDAPTransport{Mode: config.TransportLDAPS, Port: 3269}, "ldaps://dc1.example.com:3269"},
+		{config.LDAPTransport{Mode: config.TransportStartTLS, Port: 3268}, "ldap://dc1.example.com:3268"},
+	}
+
+	for _, test := range tests {
+		if got := LDAPURL("dc1.example.com", test.transport); got != test.want {
+			t.Errorf("LDAPURL(%+v) = %s, want %s", test.transport, got, test.want)
+		}
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t)
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	if err := os.WriteFile(empty, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		transport      config.LDAPTransport
+		wantErr        string
+		wantServerName string
+		wantRootCAs    bool
+		wantSkipVerify bool
+		wantClientCert bool
+	}{
+		{name: "verifies the host by default", wantServerName: "dc1.example.com"},
+		{name: "server name override", transport: config.LDAPTransport{ServerName: "ldap.example.com"}, wantServerName: "ldap.example.com"},
+		{name: "CA bundle", transport: config.LDAPTransport{CAFile: certFile}, wantServerName: "dc1.example.com", wantRootCAs: true},
+		{name: "skip verify", transport: config.LDAPTransport{InsecureSkipVerify: true}, wantServerName: "dc1.example.com", wantSkipVerify: true},
+		{name: "client certificate", transport: config.LDAPTransport{CertFile: certFile, KeyFile: keyFile}, wantServerName: "dc1.example.com", wantClientCert: true},
+		{name: "missing CA bundle", transport: config.LDAPTransport{CAFile: filepath.Join(t.TempDir(), "missing.pem")}, wantErr: "failed to read CA bundle"},
+		{name: "CA bundle without certificates", transport: config.LDAPTransport{CAFile: empty}, wantErr: "no certificates found"},
+		{name: "client key mismatch", transport: config.LDAPTransport{CertFile: certFile, KeyFile: empty}, wantErr: "failed to load client certificate"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tlsConfig, err := NewTLSConfig("dc1.example.com", test.transport)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("NewTLSConfig error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewTLSConfig failed: %v", err)
+			}
+
+			if tlsConfig.MinVersion != tls.VersionTLS12 {
+				t.Errorf("MinVersion = %x, want TLS 1.2", tlsConfig.MinVersion)
+			}
+			if tlsConfig.ServerName != test.wantServerName {
+				t.Errorf("ServerName = %s, want %s", tlsConfig.ServerName, test.wantServerName)
+			}
+			if (tlsConfig.RootCAs != nil) != test.wantRootCAs {
+				t.Errorf("RootCAs set = %v, want %v", tlsConfig.RootCAs != nil, test.wantRootCAs)
+			}
+			if tlsConfig.InsecureSkipVerify != test.wantSkipVerify {
+				t.Errorf("InsecureSkipVerify = %v, want %v", tlsConfig.InsecureSkipVerify, test.wantSkipVerify)
+			}
+			if (len(tlsConfig.Certificates) == 1) != test.wantClientCert {
+				t.Errorf("client certificates = %d, want one: %v", len(tlsConfig.Certificates), test.wantClientCert)
+			}
+		})
+	}
+}
+
+func TestConnect_RefusesSimpleBindOverPlain(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// the bind request would be the first thing the server reads
+	received := make(chan int, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _ := conn.Read(make([]byte, 1024))
+		received <- n
+	}()
+
+	cfg := config.DomainConfiguration{
+		Username:  "svc-adspy@example.com",
+		Password:  "secret",
+		Transport: config.LDAPTransport{Mode: config.TransportPlain, Port: listener.Addr().(*net.TCPAddr).Port},
+		Auth:      config.AuthSimple,
+	}
+	if _, _, err := Connect("127.0.0.1", cfg); err == nil || !strings.Contains(err.Error(), "refusing a simple bind") {
+		t.Fatalf("Connect error = %v, want a refused simple bind", err)
+	}
+	if n := <-received; n != 0 {
+		t.Errorf("server received %d bytes, want none", n)
+	}
+}
@@ -1,10 +1,12 @@
 package activedirectory
 
 import (
+	"time"
+
 	"f0oster/adspy/activedirectory/schema"
+	"f0oster/adspy/config"
 
 	"github.com/f0oster/gontsd"
-	"github.com/go-ldap/ldap/v3"
 	"github.com/google/uuid"
 )
 
@@ -12,11 +14,32 @@ type ActiveDirectoryInstance struct {
 	BaseDn               string
 	DomainControllerFQDN string
 	PageSize             uint32
-	HighestCommittedUSN  int64
+	NamingContexts       []NamingContext // monitored naming contexts, in polling order
+	Replica              ReplicaIdentity
+	replicaGeneration    uint64 // connection generation Replica was read on, 0 if never read
 	SchemaRegistry       *schema.SchemaRegistry
 	parser               *Parser // Internal parser for LDAP entries
-	ldapConnection       *ldap.Conn
+	connection           *connectionManager
 	DomainId             uuid.UUID
+	SchemaModified       time.Time // modifyTimeStamp of the subschema entry when the schema was loaded
+	schemaNamingContext  string
+	configNamingContext  string
+	subschemaSubentry    string // DN of the subschema entry (CN=Aggregate), updated on schema changes
+}
+
+// NamingContext is a naming context (partition) held by the domain controller. Objects are
+// searched per naming context, since a subtree search does not cross into another one.
+type NamingContext struct {
+	DN   string
+	Type config.NamingContextType
+}
+
+// ReplicaIdentity identifies the database instance on the domain controller that issued a USN.
+// USNs are only comparable while both values stay the same; a failover changes the
+// dsServiceName and a restore changes the invocationId.
+type ReplicaIdentity struct {
+	DsServiceName string
+	InvocationID  uuid.UUID
 }
 
 type ActiveDirectoryObject struct {
@@ -25,6 +48,8 @@ type ActiveDirectoryObject struct {
 	PrimaryObjectClass   string
 	NTSecurityDescriptor *gontsd.SecurityDescriptor
 	AttributeValues      map[string]*schema.AttributeValue
+	ReplicationMetadata  ReplicationMetadata // nil when msDS-ReplAttributeMetaData was not returned
+	ValueMetadata        LinkedValueMetadata // nil when msDS-ReplValueMetaData was not returned
 }
 
 type ADSnapshot struct {
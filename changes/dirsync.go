@@ -0,0 +1,106 @@
+package changes
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+
+	"f0oster/adspy/activedirectory"
+	"f0oster/adspy/database"
+)
+
+// DirSyncSource reads changes in one naming context with the DirSync control. After the initial
+// synchronisation only changed attributes are returned, so the batches it produces are partial.
+type DirSyncSource struct {
+	ad *activedirectory.ActiveDirectoryInstance
+	db *database.DBClient
+	nc activedirectory.NamingContext
+
+	cookie []byte
+	full   bool // the current synchronisation started without a cookie and returns objects in full
+}
+
+func NewDirSyncSource(ad *activedirectory.ActiveDirectoryInstance, db *database.DBClient, nc activedirectory.NamingContext) *DirSyncSource {
+	return &DirSyncSource{
+		ad: ad,
+		db: db,
+		nc: nc,
+	}
+}
+
+// Start loads the DirSync cookie persisted for the naming context.
+func (s *DirSyncSource) Start(ctx context.Context, fullResync bool) error {
+	if fullResync {
+		log.Printf("Full resynchronisation requested; discarding the DirSync cookie for %s", s.nc.DN)
+		if err := s.db.UpdateNamingContextDirSyncCookie(ctx, s.ad.DomainId, string(s.nc.Type), nil); err != nil {
+			return err
+		}
+		s.cookie, s.full = nil, true
+		return nil
+	}
+
+	cookie, err := s.db.GetNamingContextDirSyncCookie(ctx, s.ad.DomainId, string(s.nc.Type))
+	if err != nil {
+		return err
+	}
+
+	if len(cookie) == 0 {
+		log.Printf("No DirSync cookie recorded for %s; starting a full synchronisation", s.nc.DN)
+	} else {
+		log.Printf("Resuming DirSync of %s from the persisted cookie", s.nc.DN)
+	}
+
+	s.cookie, s.full = cookie, len(cookie) == 0
+	return nil
+}
+
+// Next reads the changes after the cookie. The entries' uSNChanged values are those of the
+// replica that answered, so a batch read across a reconnect is dropped and read again.
+func (s *DirSyncSource) Next(ctx context.Context) (*Batch, error) {
+	replica, generation, err := s.ad.CurrentReplica()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check domain controller replica: %w", err)
+	}
+
+	result, err := s.ad.DirSync(s.nc.DN, s.cookie)
+	if err != nil {
+		return nil, fmt.Errorf("LDAP query failed: %w", err)
+	}
+
+	if s.ad.ConnectionGeneration() != generation {
+		log.Printf("Warning: reconnected during the DirSync of %s; discarding %d entries", s.nc.DN, len(result.Entries))
+		return &Batch{
+			NamingContext: s.nc.Type,
+			More:          true,
+			Replica:       replica.InvocationID,
+			cookie:        s.cookie,
+		}, nil
+	}
+
+	return &Batch{
+		Entries:       result.Entries,
+		Partial:       !s.full,
+		More:          result.More,
+		NamingContext: s.nc.Type,
+		Replica:       replica.InvocationID,
+		cookie:        result.Cookie,
+	}, nil
+}
+
+func (s *DirSyncSource) Commit(ctx context.Context, batch *Batch) error {
+	if bytes.Equal(batch.cookie, s.cookie) {
+		// No changes - this is normal
+		return nil
+	}
+
+	if err := s.db.UpdateNamingContextDirSyncCookie(ctx, s.ad.DomainId, string(s.nc.Type), batch.cookie); err != nil {
+		return err
+	}
+
+	s.cookie = batch.cookie
+	if !batch.More {
+		s.full = false
+	}
+	return nil
+}
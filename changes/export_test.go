@@ -0,0 +1,4 @@
+package changes
+
+// NewUSNSourceFor builds a USNSource over a fake directory and watermark store.
+var NewUSNSourceFor = newUSNSource
@@ -0,0 +1,114 @@
+package changes
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+
+	"f0oster/adspy/activedirectory"
+)
+
+// Watcher is the part of an Active Directory instance the Waiter needs for change notifications.
+type Watcher interface {
+	// WatchChanges signals notify whenever an object changes, until ctx is cancelled or the
+	// notification search fails.
+	WatchChanges(ctx context.Context, notify chan<- struct{}) error
+}
+
+// Waiter decides when the poller next looks for changes. While a change notification search is
+// registered it wakes as soon as the directory reports a change. When notifications are disabled
+// or refused, and while a failed search is re-registered, it polls every interval plus jitter.
+type Waiter struct {
+	watcher  Watcher // nil when change notifications are disabled
+	interval time.Duration
+	jitter   time.Duration
+
+	notify    chan struct{}
+	listening atomic.Bool
+}
+
+func NewWaiter(watcher Watcher, interval, jitter time.Duration) *Waiter {
+	return &Waiter{
+		watcher:  watcher,
+		interval: interval,
+		jitter:   jitter,
+		notify:   make(chan struct{}, 1),
+	}
+}
+
+// Start registers the change notification search in the background. It is re-registered
+// whenever it fails, until ctx is cancelled or the domain controller refuses it.
+func (w *Waiter) Start(ctx context.Context) {
+	if w.watcher == nil {
+		log.Printf("Polling for changes every %s (jitter %s)", w.interval, w.jitter)
+		return
+	}
+	w.listening.Store(true)
+	go w.watch(ctx)
+}
+
+// Wait blocks until the poller should look for changes again.
+func (w *Waiter) Wait(ctx context.Context) {
+	if w.listening.Load() {
+		select {
+		case <-ctx.Done():
+		case <-w.notify:
+		}
+		return
+	}
+
+	timer := time.NewTimer(w.delay())
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	case <-w.notify:
+	}
+}
+
+func (w *Waiter) watch(ctx context.Context) {
+	for {
+		err := w.watcher.WatchChanges(ctx, w.notify)
+
+		// Wake a Wait that is blocked on notifications so it falls back to the timer
+		w.listening.Store(false)
+		w.signal()
+
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, activedirectory.ErrNotificationRefused) {
+			log.Printf("Warning: %v; falling back to polling every %s", err, w.interval)
+			return
+		}
+		log.Printf("Change notification search ended: %v; polling until it is re-registered", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(w.delay()):
+		}
+
+		// Changes made while the search was down are picked up by polling once more
+		w.listening.Store(true)
+		w.signal()
+	}
+}
+
+func (w *Waiter) signal() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (w *Waiter) delay() time.Duration {
+	if w.jitter <= 0 {
+		return w.interval
+	}
+	return w.interval + rand.N(w.jitter+1)
+}
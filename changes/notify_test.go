@@ -0,0 +1,116 @@
+package changes_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"f0oster/adspy/activedirectory"
+	"f0oster/adspy/changes"
+)
+
+// fakeWatcher stands in for a change notification search. Each call to WatchChanges takes the
+// next result from results; a nil result keeps the search registered until ctx is cancelled.
+type fakeWatcher struct {
+	results []error
+	changes chan struct{} // forwarded to notify while the search is registered
+}
+
+func (w *fakeWatcher) WatchChanges(ctx context.Context, notify chan<- struct{}) error {
+	if len(w.results) > 0 {
+		err := w.results[0]
+		w.results = w.results[1:]
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-w.changes:
+			notify <- struct{}{}
+		}
+	}
+}
+
+// startWait calls Wait in the background and returns a channel closed when it returns.
+func startWait(ctx context.Context, waiter *changes.Waiter) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		waiter.Wait(ctx)
+		close(done)
+	}()
+	return done
+}
+
+// returnsWithin reports whether done is closed within timeout.
+func returnsWithin(done <-chan struct{}, timeout time.Duration) bool {
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// waitReturns reports whether Wait returns within timeout.
+func waitReturns(ctx context.Context, waiter *changes.Waiter, timeout time.Duration) bool {
+	return returnsWithin(startWait(ctx, waiter), timeout)
+}
+
+func TestWaiter_NotificationWakesPoller(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	watcher := &fakeWatcher{changes: make(chan struct{})}
+	waiter := changes.NewWaiter(watcher, time.Hour, 0)
+	waiter.Start(ctx)
+
+	done := startWait(ctx, waiter)
+	if returnsWithin(done, 50*time.Millisecond) {
+		t.Fatal("Wait returned without a change notification")
+	}
+
+	watcher.changes <- struct{}{}
+	if !returnsWithin(done, time.Second) {
+		t.Fatal("Wait did not return after a change notification")
+	}
+}
+
+func TestWaiter_FallsBackToPollingWhenRefused(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	refused := fmt.Errorf("%w by dc1: Unavailable Critical Extension", activedirectory.ErrNotificationRefused)
+	watcher := &fakeWatcher{results: []error{refused}, changes: make(chan struct{})}
+	waiter := changes.NewWaiter(watcher, 20*time.Millisecond, 10*time.Millisecond)
+	waiter.Start(ctx)
+
+	// The first Wait is woken by the fallback itself, later ones by the polling interval
+	for i := range 3 {
+		if !waitReturns(ctx, waiter, time.Second) {
+			t.Fatalf("Wait %d did not return after notifications were refused", i)
+		}
+	}
+}
+
+func TestWaiter_PollsWhileSearchIsReRegistered(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dropped := fmt.Errorf("change notification search on dc1 failed: connection reset")
+	watcher := &fakeWatcher{results: []error{dropped}, changes: make(chan struct{})}
+	waiter := changes.NewWaiter(watcher, 20*time.Millisecond, 0)
+	waiter.Start(ctx)
+
+	// Woken once when the search fails and once when it is re-registered, so changes made
+	// while it was down are picked up
+	for i := range 2 {
+		if !waitReturns(ctx, waiter, time.Second) {
+			t.Fatalf("Wait %d did not return while the search was re-registered", i)
+		}
+	}
+}
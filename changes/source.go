@@ -0,0 +1,46 @@
+package changes
+
+import (
+	"context"
+
+	"f0oster/adspy/config"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/google/uuid"
+)
+
+// Batch is a set of changed entries read from a Source, along with the position the source
+// moves to once the batch has been committed.
+type Batch struct {
+	Entries []*ldap.Entry
+
+	// Partial is set when entries only carry the attributes that changed
+	Partial bool
+
+	// More is set when the source already has further changes ready
+	More bool
+
+	// NamingContext is the naming context the entries were read from
+	NamingContext config.NamingContextType
+
+	// Replica is the invocationId of the replica the entries were read from, whose USNs their
+	// uSNChanged values are
+	Replica uuid.UUID
+
+	usn    int64  // highestCommittedUSN the USN source advances to
+	cookie []byte // DirSync cookie the DirSync source advances to
+}
+
+// Source produces changed objects in one naming context for the snapshot and versioning pipeline.
+type Source interface {
+	// Start positions the source at its persisted position, or at the beginning when
+	// fullResync is set.
+	Start(ctx context.Context, fullResync bool) error
+
+	// Next reads the changes after the current position. It does not move the position.
+	Next(ctx context.Context) (*Batch, error)
+
+	// Commit persists the position reached by batch. It must only be called once the
+	// batch's entries have been stored, so a failed batch is read again by the next call to Next.
+	Commit(ctx context.Context, batch *Batch) error
+}
@@ -0,0 +1,293 @@
+package changes
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"f0oster/adspy/activedirectory"
+	"f0oster/adspy/database"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/google/uuid"
+)
+
+// Directory is the part of an Active Directory instance the USN poller needs.
+type Directory interface {
+	// ReadHighestUSN returns the replica's current highestCommittedUSN.
+	ReadHighestUSN() (int64, error)
+	// SearchUSNRange pages through every object with lowerUSN <= uSNChanged <= upperUSN.
+	SearchUSNRange(lowerUSN, upperUSN int64, pageSize uint32, pageHandler func(entries []*ldap.Entry) error) error
+}
+
+// USNPoller finds changed objects by polling uSNChanged.
+type USNPoller struct {
+	directory Directory
+	pageSize  uint32
+}
+
+func NewUSNPoller(directory Directory, pageSize uint32) *USNPoller {
+	return &USNPoller{
+		directory: directory,
+		pageSize:  pageSize,
+	}
+}
+
+// Poll returns every object changed after sinceUSN, along with the watermark to pass to the
+// next call. The watermark is the highestCommittedUSN read before the search started, so an
+// object changed while the search is paging is picked up by the next poll rather than skipped.
+func (p *USNPoller) Poll(sinceUSN int64) ([]*ldap.Entry, int64, error) {
+	highestUSN, err := p.directory.ReadHighestUSN()
+	if err != nil {
+		return nil, sinceUSN, err
+	}
+	return p.pollTo(sinceUSN, highestUSN)
+}
+
+// pollTo returns every object changed after sinceUSN up to highestUSN, a highestCommittedUSN
+// read before the search.
+func (p *USNPoller) pollTo(sinceUSN, highestUSN int64) ([]*ldap.Entry, int64, error) {
+	if highestUSN <= sinceUSN {
+		return nil, sinceUSN, nil
+	}
+
+	var entries []*ldap.Entry
+	err := p.directory.SearchUSNRange(sinceUSN+1, highestUSN, p.pageSize, func(page []*ldap.Entry) error {
+		entries = append(entries, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, sinceUSN, fmt.Errorf("USN search failed: %w", err)
+	}
+
+	return entries, highestUSN, nil
+}
+
+// ReplicaDirectory is a Directory that can also identify the replica answering its searches.
+type ReplicaDirectory interface {
+	Directory
+	// ReadReplica returns the identity and DNS name of the replica answering requests now, and
+	// the connection generation they apply to.
+	ReadReplica() (activedirectory.ReplicaIdentity, string, uint64, error)
+	// Generation changes whenever the connection is re-established, possibly to another replica.
+	Generation() uint64
+}
+
+// WatermarkStore persists the USN watermark of each naming context.
+type WatermarkStore interface {
+	GetNamingContextWatermark(ctx context.Context, domainID uuid.UUID, ncType string) (*database.NamingContextWatermark, error)
+	ResetNamingContextWatermark(ctx context.Context, domainID uuid.UUID, ncType string, domainController string, dsServiceName string, invocationID uuid.UUID, usn int64) error
+	UpdateNamingContextLastProcessedUSN(ctx context.Context, domainID uuid.UUID, ncType string, invocationID uuid.UUID, lastProcessedUSN int64) error
+	UpdateNamingContextHighestUSN(ctx context.Context, domainID uuid.UUID, ncType string, invocationID uuid.UUID, highestUSN int64) error
+}
+
+// namingContextDirectory limits an instance's USN searches to one naming context.
+type namingContextDirectory struct {
+	ad            *activedirectory.ActiveDirectoryInstance
+	namingContext string
+}
+
+func (d namingContextDirectory) ReadHighestUSN() (int64, error) {
+	return d.ad.ReadHighestUSN()
+}
+
+func (d namingContextDirectory) SearchUSNRange(lowerUSN, upperUSN int64, pageSize uint32, pageHandler func(entries []*ldap.Entry) error) error {
+	return d.ad.SearchUSNRange(d.namingContext, lowerUSN, upperUSN, pageSize, pageHandler)
+}
+
+func (d namingContextDirectory) ReadReplica() (activedirectory.ReplicaIdentity, string, uint64, error) {
+	replica, generation, err := d.ad.CurrentReplica()
+	if err != nil {
+		return activedirectory.ReplicaIdentity{}, "", 0, err
+	}
+	return replica, d.ad.DomainControllerFQDN, generation, nil
+}
+
+func (d namingContextDirectory) Generation() uint64 {
+	return d.ad.ConnectionGeneration()
+}
+
+// USNSource reads changes in one naming context by polling uSNChanged on the replica the
+// instance is bound to. highestCommittedUSN is replica-wide, but each naming context keeps its
+// own watermark so that it can be enabled, resynchronised or fail independently.
+// Batches are always complete objects.
+type USNSource struct {
+	directory ReplicaDirectory
+	db        WatermarkStore
+	domainID  uuid.UUID
+	nc        activedirectory.NamingContext
+	poller    *USNPoller
+	usn       int64 // highestCommittedUSN the last committed batch was read up to
+
+	replica          activedirectory.ReplicaIdentity // replica the watermark was last reconciled with
+	domainController string
+	generation       uint64 // connection generation replica was read on
+}
+
+func NewUSNSource(ad *activedirectory.ActiveDirectoryInstance, db *database.DBClient, nc activedirectory.NamingContext, pageSize uint32) *USNSource {
+	return newUSNSource(namingContextDirectory{ad: ad, namingContext: nc.DN}, db, ad.DomainId, nc, pageSize)
+}
+
+func newUSNSource(directory ReplicaDirectory, db WatermarkStore, domainID uuid.UUID, nc activedirectory.NamingContext, pageSize uint32) *USNSource {
+	return &USNSource{
+		directory: directory,
+		db:        db,
+		domainID:  domainID,
+		nc:        nc,
+		poller:    NewUSNPoller(directory, pageSize),
+	}
+}
+
+// readReplica records the replica answering requests now.
+func (s *USNSource) readReplica() error {
+	replica, domainController, generation, err := s.directory.ReadReplica()
+	if err != nil {
+		return err
+	}
+	s.replica, s.domainController, s.generation = replica, domainController, generation
+	return nil
+}
+
+// Start positions the poller at the persisted watermark so a restart continues
+// incrementally. The watermark is only used when it was read from the replica that is
+// answering now; otherwise reconcileReplica records the new replica and starts from zero.
+func (s *USNSource) Start(ctx context.Context, fullResync bool) error {
+	if err := s.readReplica(); err != nil {
+		return err
+	}
+	replica := s.replica
+
+	if fullResync {
+		log.Printf("Full resynchronisation requested; re-reading every object in %s from %s", s.nc.DN, s.domainController)
+		s.usn = 0
+		return s.db.ResetNamingContextWatermark(ctx, s.domainID, string(s.nc.Type), s.domainController,
+			replica.DsServiceName, replica.InvocationID, 0)
+	}
+
+	watermark, err := s.db.GetNamingContextWatermark(ctx, s.domainID, string(s.nc.Type))
+	if err != nil {
+		return err
+	}
+
+	if watermark.InvocationID == nil || *watermark.InvocationID != replica.InvocationID ||
+		!strings.EqualFold(watermark.DsServiceName, replica.DsServiceName) {
+		log.Printf("No watermark recorded for %s on replica %s (invocationId %s); starting from USN 0",
+			s.nc.DN, replica.DsServiceName, replica.InvocationID)
+		return nil
+	}
+
+	// last_processed_usn only advances once a batch has been committed, so resuming from it
+	// never skips an object
+	s.usn = watermark.LastProcessedUSN
+	log.Printf("Resuming %s from USN %d recorded for %s", s.nc.DN, watermark.LastProcessedUSN, watermark.DomainController)
+	return nil
+}
+
+// Next searches the USN range on the reconciled replica. A reconnect during the search can
+// fail over to another replica, whose USNs do not match the range, so the batch is dropped if
+// the connection changed; the next call then reconciles the watermark with the new replica.
+// The replica identity is only read again after a reconnect, so an idle poll costs a single
+// read of highestCommittedUSN.
+func (s *USNSource) Next(ctx context.Context) (*Batch, error) {
+	highestUSN, err := s.reconcileReplica(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check domain controller replica: %w", err)
+	}
+	searched := s.replica
+
+	entries, nextUSN, err := s.poller.pollTo(s.usn, highestUSN)
+	if err != nil {
+		return nil, fmt.Errorf("LDAP query failed: %w", err)
+	}
+
+	if s.directory.Generation() != s.generation {
+		log.Printf("Warning: reconnected during the search of %s on %s; discarding %d entries",
+			s.nc.DN, searched.DsServiceName, len(entries))
+		return &Batch{
+			NamingContext: s.nc.Type,
+			More:          true,
+			usn:           s.usn,
+			Replica:       searched.InvocationID,
+		}, nil
+	}
+
+	return &Batch{
+		Entries:       entries,
+		NamingContext: s.nc.Type,
+		usn:           nextUSN,
+		Replica:       searched.InvocationID,
+	}, nil
+}
+
+// Commit advances the watermark. The update is conditional on the replica the batch was read
+// from, so a batch read before a failover cannot move the new replica's watermark.
+func (s *USNSource) Commit(ctx context.Context, batch *Batch) error {
+	if batch.usn == s.usn {
+		// No changes - this is normal
+		return nil
+	}
+
+	ncType := string(s.nc.Type)
+	if err := s.db.UpdateNamingContextLastProcessedUSN(ctx, s.domainID, ncType, batch.Replica, batch.usn); err != nil {
+		return fmt.Errorf("failed to update naming context last processed USN: %w", err)
+	}
+	if err := s.db.UpdateNamingContextHighestUSN(ctx, s.domainID, ncType, batch.Replica, batch.usn); err != nil {
+		return fmt.Errorf("failed to update naming context highest USN: %w", err)
+	}
+
+	s.usn = batch.usn
+	return nil
+}
+
+// reconcileReplica compares the replica currently answering LDAP requests with the one the
+// persisted watermark was read from, and returns the replica's highestCommittedUSN. USNs are
+// local to a replica, so on failover, restore or a USN rollback the watermark is discarded and
+// every object is re-read. Each re-read object is compared with its stored version and only a
+// difference produces a new version, recorded against the new replica. The resync therefore
+// records the net change of each object since its stored version: an object changed more than
+// once in the meantime loses its intermediate states, and a change the new replica has not yet
+// received shows up once it replicates in.
+func (s *USNSource) reconcileReplica(ctx context.Context) (int64, error) {
+	if err := s.readReplica(); err != nil {
+		return 0, err
+	}
+
+	highestUSN, err := s.directory.ReadHighestUSN()
+	if err != nil {
+		return 0, err
+	}
+
+	ncType := string(s.nc.Type)
+	watermark, err := s.db.GetNamingContextWatermark(ctx, s.domainID, ncType)
+	if err != nil {
+		return 0, err
+	}
+
+	replica := s.replica
+
+	var reason string
+	switch {
+	case watermark.InvocationID == nil:
+		log.Printf("Recording replica %s (invocationId %s) on %s for the %s watermark",
+			replica.DsServiceName, replica.InvocationID, s.domainController, s.nc.DN)
+		return highestUSN, s.db.ResetNamingContextWatermark(ctx, s.domainID, ncType, s.domainController,
+			replica.DsServiceName, replica.InvocationID, s.usn)
+	case *watermark.InvocationID != replica.InvocationID && !strings.EqualFold(watermark.DsServiceName, replica.DsServiceName):
+		reason = fmt.Sprintf("domain controller changed from %s to %s", watermark.DomainController, s.domainController)
+	case *watermark.InvocationID != replica.InvocationID:
+		reason = fmt.Sprintf("invocationId of %s changed from %s to %s (database restored)", s.domainController, watermark.InvocationID, replica.InvocationID)
+	case !strings.EqualFold(watermark.DsServiceName, replica.DsServiceName):
+		reason = fmt.Sprintf("dsServiceName changed from %s to %s", watermark.DsServiceName, replica.DsServiceName)
+	case highestUSN < watermark.HighestUSN || highestUSN < watermark.LastProcessedUSN:
+		reason = fmt.Sprintf("highestCommittedUSN on %s rolled back from %d to %d", s.domainController, max(watermark.HighestUSN, watermark.LastProcessedUSN), highestUSN)
+	default:
+		return highestUSN, nil
+	}
+
+	log.Printf("Warning: %s; USN watermarks are not comparable, starting a full resynchronisation of %s", reason, s.nc.DN)
+
+	s.usn = 0
+	return highestUSN, s.db.ResetNamingContextWatermark(ctx, s.domainID, ncType, s.domainController,
+		replica.DsServiceName, replica.InvocationID, 0)
+}
@@ -0,0 +1,350 @@
+package changes_test
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"testing"
+
+	"f0oster/adspy/activedirectory"
+	"f0oster/adspy/changes"
+	"f0oster/adspy/config"
+	"f0oster/adspy/database"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/google/uuid"
+)
+
+// fakeDirectory evaluates each page of a USN range search against its current state, the way a
+// live directory does, so changes made between pages are visible to the remaining pages only.
+type fakeDirectory struct {
+	highestUSN int64
+	objects    map[string]int64 // DN -> uSNChanged
+
+	afterPage    func(page int) // called after each page has been handed to the caller
+	searches     int
+	highestReads int
+}
+
+func newFakeDirectory(dns ...string) *fakeDirectory {
+	d := &fakeDirectory{objects: make(map[string]int64)}
+	for _, dn := range dns {
+		d.modify(dn)
+	}
+	return d
+}
+
+// modify commits a change to dn, giving it the next USN.
+func (d *fakeDirectory) modify(dn string) {
+	d.highestUSN++
+	d.objects[dn] = d.highestUSN
+}
+
+func (d *fakeDirectory) ReadHighestUSN() (int64, error) {
+	d.highestReads++
+	return d.highestUSN, nil
+}
+
+func (d *fakeDirectory) SearchUSNRange(lowerUSN, upperUSN int64, pageSize uint32, pageHandler func([]*ldap.Entry) error) error {
+	d.searches++
+
+	dns := make([]string, 0, len(d.objects))
+	for dn := range d.objects {
+		dns = append(dns, dn)
+	}
+	sort.Strings(dns)
+
+	for page := 0; len(dns) > 0; page++ {
+		n := min(int(pageSize), len(dns))
+
+		var entries []*ldap.Entry
+		for _, dn := range dns[:n] {
+			if usn := d.objects[dn]; usn >= lowerUSN && usn <= upperUSN {
+				entries = append(entries, ldap.NewEntry(dn, map[string][]string{
+					"uSNChanged": {strconv.FormatInt(usn, 10)},
+				}))
+			}
+		}
+		dns = dns[n:]
+
+		if err := pageHandler(entries); err != nil {
+			return err
+		}
+		if d.afterPage != nil {
+			d.afterPage(page)
+		}
+	}
+	return nil
+}
+
+func seen(entries []*ldap.Entry) map[string]string {
+	result := make(map[string]string)
+	for _, entry := range entries {
+		result[entry.DN] = entry.GetAttributeValue("uSNChanged")
+	}
+	return result
+}
+
+func TestUSNPoller_ChangeDuringSearchIsNotLost(t *testing.T) {
+	directory := newFakeDirectory("CN=a", "CN=b", "CN=c")
+	poller := changes.NewUSNPoller(directory, 1)
+
+	// CN=a is modified after its page was returned, while CN=b and CN=c are still to come
+	directory.afterPage = func(page int) {
+		if page == 0 {
+			directory.modify("CN=a")
+		}
+	}
+
+	entries, watermark, err := poller.Poll(0)
+	if err != nil {
+		t.Fatalf("first poll failed: %v", err)
+	}
+	if got := seen(entries); len(got) != 3 || got["CN=a"] != "1" {
+		t.Fatalf("first poll returned %v, want CN=a, CN=b and CN=c at their original USNs", got)
+	}
+	if watermark != 3 {
+		t.Fatalf("watermark after first poll = %d, want the highestCommittedUSN read before searching (3)", watermark)
+	}
+
+	directory.afterPage = nil
+
+	entries, watermark, err = poller.Poll(watermark)
+	if err != nil {
+		t.Fatalf("second poll failed: %v", err)
+	}
+	if got := seen(entries); len(got) != 1 || got["CN=a"] != "4" {
+		t.Fatalf("second poll returned %v, want only the mid-search change to CN=a at USN 4", got)
+	}
+	if watermark != 4 {
+		t.Errorf("watermark after second poll = %d, want 4", watermark)
+	}
+}
+
+func TestUSNPoller_NoChanges(t *testing.T) {
+	directory := newFakeDirectory("CN=a")
+	poller := changes.NewUSNPoller(directory, 100)
+
+	entries, watermark, err := poller.Poll(1)
+	if err != nil {
+		t.Fatalf("poll failed: %v", err)
+	}
+	if len(entries) != 0 || watermark != 1 {
+		t.Errorf("Poll(1) = %d entries, watermark %d; want none and 1", len(entries), watermark)
+	}
+	if directory.searches != 0 {
+		t.Errorf("directory was searched %d times with nothing committed since the watermark", directory.searches)
+	}
+}
+
+// fakeReplica is a fakeDirectory answered by one replica at a time, over a connection that is
+// re-established on every failover or restore.
+type fakeReplica struct {
+	*fakeDirectory
+	replica          activedirectory.ReplicaIdentity
+	domainController string
+	generation       uint64
+	reads            int // calls to ReadReplica
+}
+
+func (r *fakeReplica) ReadReplica() (activedirectory.ReplicaIdentity, string, uint64, error) {
+	r.reads++
+	return r.replica, r.domainController, r.generation, nil
+}
+
+func (r *fakeReplica) Generation() uint64 {
+	return r.generation
+}
+
+// failOver reconnects the fake to another domain controller, whose USNs are unrelated.
+func (r *fakeReplica) failOver(dc string, directory *fakeDirectory) {
+	r.fakeDirectory = directory
+	r.domainController = dc
+	r.replica = activedirectory.ReplicaIdentity{DsServiceName: "CN=NTDS Settings,CN=" + dc, InvocationID: uuid.New()}
+	r.generation++
+}
+
+// restore reconnects the fake to the same domain controller after its database was restored.
+func (r *fakeReplica) restore() {
+	r.replica.InvocationID = uuid.New()
+	r.generation++
+}
+
+// fakeWatermarkStore keeps one naming context's watermark, updating it only for the recorded
+// replica as the database does.
+type fakeWatermarkStore struct {
+	watermark database.NamingContextWatermark
+}
+
+func (s *fakeWatermarkStore) GetNamingContextWatermark(ctx context.Context, domainID uuid.UUID, ncType string) (*database.NamingContextWatermark, error) {
+	watermark := s.watermark
+	return &watermark, nil
+}
+
+func (s *fakeWatermarkStore) ResetNamingContextWatermark(ctx context.Context, domainID uuid.UUID, ncType string, domainController string, dsServiceName string, invocationID uuid.UUID, usn int64) error {
+	s.watermark = database.NamingContextWatermark{
+		DomainController: domainController,
+		DsServiceName:    dsServiceName,
+		InvocationID:     &invocationID,
+		LastProcessedUSN: usn,
+		HighestUSN:       usn,
+	}
+	return nil
+}
+
+func (s *fakeWatermarkStore) UpdateNamingContextLastProcessedUSN(ctx context.Context, domainID uuid.UUID, ncType string, invocationID uuid.UUID, lastProcessedUSN int64) error {
+	if s.watermark.InvocationID != nil && *s.watermark.InvocationID == invocationID {
+		s.watermark.LastProcessedUSN = lastProcessedUSN
+	}
+	return nil
+}
+
+func (s *fakeWatermarkStore) UpdateNamingContextHighestUSN(ctx context.Context, domainID uuid.UUID, ncType string, invocationID uuid.UUID, highestUSN int64) error {
+	if s.watermark.InvocationID != nil && *s.watermark.InvocationID == invocationID {
+		s.watermark.HighestUSN = highestUSN
+	}
+	return nil
+}
+
+var domainNC = activedirectory.NamingContext{Type: config.NamingContextDomain, DN: "DC=example,DC=com"}
+
+// newReplicaSource starts a USN source on dc1, holding CN=a, CN=b and CN=c at USNs 1-3, with
+// the watermark committed up to USN 3.
+func newReplicaSource(t *testing.T) (*changes.USNSource, *fakeReplica, *fakeWatermarkStore) {
+	t.Helper()
+
+	directory := &fakeReplica{}
+	directory.failOver("dc1", newFakeDirectory("CN=a", "CN=b", "CN=c"))
+	invocationID := directory.replica.InvocationID
+	store := &fakeWatermarkStore{watermark: database.NamingContextWatermark{
+		DomainController: "dc1",
+		DsServiceName:    directory.replica.DsServiceName,
+		InvocationID:     &invocationID,
+		LastProcessedUSN: 3,
+		HighestUSN:       3,
+	}}
+
+	source := changes.NewUSNSourceFor(directory, store, uuid.New(), domainNC, 1)
+	if err := source.Start(context.Background(), false); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	return source, directory, store
+}
+
+// poll reads and commits the next batch, returning the DNs it held.
+func poll(t *testing.T, source *changes.USNSource) map[string]string {
+	t.Helper()
+
+	batch, err := source.Next(context.Background())
+	if err != nil {
+		t.Fatalf("Next failed: %v", err)
+	}
+	if err := source.Commit(context.Background(), batch); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	return seen(batch.Entries)
+}
+
+func TestUSNSource_ResumesFromWatermark(t *testing.T) {
+	source, directory, store := newReplicaSource(t)
+	directory.modify("CN=b")
+
+	if got := poll(t, source); len(got) != 1 || got["CN=b"] != "4" {
+		t.Errorf("poll returned %v, want only CN=b at USN 4", got)
+	}
+	if store.watermark.LastProcessedUSN != 4 {
+		t.Errorf("watermark = %d, want 4", store.watermark.LastProcessedUSN)
+	}
+}
+
+func TestUSNSource_OneRoundTripPerIdlePoll(t *testing.T) {
+	source, directory, _ := newReplicaSource(t)
+	directory.highestReads, directory.reads = 0, 0
+
+	if got := poll(t, source); len(got) != 0 {
+		t.Fatalf("poll returned %v, want nothing", got)
+	}
+	if directory.highestReads != 1 || directory.searches != 0 {
+		t.Errorf("idle poll read highestCommittedUSN %d times and searched %d times, want once and never",
+			directory.highestReads, directory.searches)
+	}
+	// the instance only reads the replica identity again after a reconnect
+	if directory.reads != 1 {
+		t.Errorf("idle poll asked for the replica %d times, want once", directory.reads)
+	}
+}
+
+func TestUSNSource_ResynchronisesOnReplicaChange(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(directory *fakeReplica)
+	}{
+		{"failover to another domain controller", func(directory *fakeReplica) {
+			directory.failOver("dc2", newFakeDirectory("CN=a", "CN=b", "CN=c", "CN=d", "CN=e"))
+		}},
+		{"database restored", func(directory *fakeReplica) {
+			directory.restore()
+		}},
+		{"highestCommittedUSN rolled back", func(directory *fakeReplica) {
+			directory.highestUSN = 2
+			directory.objects["CN=c"] = 2
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source, directory, store := newReplicaSource(t)
+			test.change(directory)
+
+			// every object is re-read from the start of the replica's USN range
+			got := poll(t, source)
+			if len(got) != len(directory.objects) {
+				t.Errorf("poll returned %v, want all %d objects", got, len(directory.objects))
+			}
+			if *store.watermark.InvocationID != directory.replica.InvocationID || store.watermark.DomainController != directory.domainController {
+				t.Errorf("watermark recorded for %s (%s), want %s (%s)", store.watermark.DomainController,
+					store.watermark.InvocationID, directory.domainController, directory.replica.InvocationID)
+			}
+			if store.watermark.LastProcessedUSN != directory.highestUSN {
+				t.Errorf("watermark = %d, want %d", store.watermark.LastProcessedUSN, directory.highestUSN)
+			}
+		})
+	}
+}
+
+func TestUSNSource_FailoverDuringSearchDropsBatch(t *testing.T) {
+	source, directory, store := newReplicaSource(t)
+	directory.modify("CN=a")
+	directory.modify("CN=b")
+
+	// a reconnect after the first page lands on dc2, so the rest of the range is searched there
+	dc2 := newFakeDirectory("CN=a", "CN=b", "CN=c", "CN=d", "CN=e", "CN=f")
+	dc1 := directory.fakeDirectory
+	dc1.afterPage = func(page int) {
+		if page == 0 {
+			directory.failOver("dc2", dc2)
+		}
+	}
+
+	batch, err := source.Next(context.Background())
+	if err != nil {
+		t.Fatalf("Next failed: %v", err)
+	}
+	if len(batch.Entries) != 0 || !batch.More {
+		t.Errorf("batch has %d entries, More %v; want it dropped and read again", len(batch.Entries), batch.More)
+	}
+	if err := source.Commit(context.Background(), batch); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if store.watermark.LastProcessedUSN != 3 || store.watermark.DomainController != "dc1" {
+		t.Errorf("watermark moved to %d on %s by a dropped batch", store.watermark.LastProcessedUSN, store.watermark.DomainController)
+	}
+
+	// the next poll reconciles with dc2 and re-reads everything from it
+	if got := poll(t, source); len(got) != 6 {
+		t.Errorf("poll after failover returned %v, want all 6 objects of dc2", got)
+	}
+	if store.watermark.DomainController != "dc2" || store.watermark.LastProcessedUSN != 6 {
+		t.Errorf("watermark = %d on %s, want 6 on dc2", store.watermark.LastProcessedUSN, store.watermark.DomainController)
+	}
+}
@@ -5,20 +5,19 @@ import (
 	"flag"
 	"fmt"
 	"log"
-	"time"
+	"sync"
 
 	"f0oster/adspy/activedirectory"
-	"f0oster/adspy/activedirectory/ldaphelpers"
+	"f0oster/adspy/changes"
 	"f0oster/adspy/config"
 	"f0oster/adspy/database"
 	"f0oster/adspy/snapshot"
 	"f0oster/adspy/versioning"
-
-	"github.com/go-ldap/ldap/v3"
 )
 
 func main() {
 	resetDB := flag.Bool("reset-db", false, "Reset database on startup (drops and recreates)")
+	fullResync := flag.Bool("full-resync", false, "Ignore the persisted watermark or DirSync cookie and re-read every object, reconciling against stored versions")
 	flag.Parse()
 
 	adSpyConfig := config.LoadEnvConfig("settings.env")
@@ -33,93 +32,60 @@ func main() {
 	}
 	defer db.Close()
 
-	adInstance, err := activedirectory.NewActiveDirectoryInstance(adSpyConfig)
-	if err != nil {
-		log.Fatalf("failed to initialize Active Directory instance: %v", err)
+	var wg sync.WaitGroup
+	for _, domain := range adSpyConfig.Domains {
+		worker := &domainWorker{
+			db:         db,
+			domain:     domain,
+			poll:       adSpyConfig.Poll,
+			fullResync: *fullResync,
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker.supervise(ctx)
+		}()
 	}
 
-	err = db.Client().InsertDomain(
-		ctx,
-		adInstance.DomainId,
-		adInstance.BaseDn,
-		adInstance.DomainControllerFQDN,
-		adInstance.HighestCommittedUSN,
-	)
+	log.Printf("adSpy poller started %d domain worker(s)", len(adSpyConfig.Domains))
+	wg.Wait()
+}
+
+// processChanges reads the next batch of changes, persists it, and only then commits the
+// source position, so a failed batch is retried on the next poll instead of being skipped.
+func processChanges(
+	ctx context.Context,
+	adInstance *activedirectory.ActiveDirectoryInstance,
+	source changes.Source,
+	snapshotService *snapshot.Service,
+	versioningService *versioning.Service,
+) (bool, error) {
+	batch, err := source.Next(ctx)
 	if err != nil {
-		log.Fatalf("failed to insert domain entry to database: %v", err)
+		return false, err
 	}
 
-	// Persist AD schema to database
-	schemas := adInstance.SchemaRegistry.GetAllSchemas()
-	for _, s := range schemas {
-		if err := db.Client().UpsertAttributeSchema(
-			ctx,
-			s.ObjectGUID,
-			adInstance.DomainId,
-			s.AttributeLDAPName,
-			s.AttributeName,
-			s.AttributeID,
-			s.AttributeSyntax,
-			s.AttributeOMSyntax,
-			s.AttributeFieldType.SyntaxName,
-			s.AttributeIsSingleValued,
-		); err != nil {
-			log.Fatalf("failed to persist attribute schema: %v", err)
-		}
+	if err := processEntries(ctx, adInstance, batch, snapshotService, versioningService); err != nil {
+		return false, err
 	}
-	log.Printf("Persisted %d attribute schemas", len(schemas))
 
-	snapshotService := snapshot.NewService()
-	versioningService := versioning.NewService(db.Client(), snapshotService, adInstance.DomainId, adInstance.SchemaRegistry)
-
-	log.Println("adSpy poller initialized - monitoring AD for changes")
-
-	for {
-		if err := processChanges(ctx, adInstance, snapshotService, versioningService, db.Client()); err != nil {
-			log.Printf("Error processing changes: %v", err)
-		}
-
-		if err := adInstance.FetchHighestUSN(); err != nil {
-			log.Printf("Error fetching highest USN: %v", err)
-		} else {
-			if err := db.Client().UpdateDomainHighestUSN(ctx, adInstance.DomainId, adInstance.HighestCommittedUSN); err != nil {
-				log.Printf("Error updating domain highest USN: %v", err)
-			}
-		}
-
-		time.Sleep(1 * time.Second)
+	if err := source.Commit(ctx, batch); err != nil {
+		return false, err
 	}
+
+	return batch.More, nil
 }
 
-// processChanges fetches LDAP entries, parses them, creates snapshots, and persists to database.
-func processChanges(
+// processEntries parses entries, creates snapshots, and persists them to the database.
+func processEntries(
 	ctx context.Context,
 	adInstance *activedirectory.ActiveDirectoryInstance,
+	batch *changes.Batch,
 	snapshotService *snapshot.Service,
 	versioningService *versioning.Service,
-	dbClient *database.DBClient,
 ) error {
-	// Note: LDAP doesn't support > operator, so we use >= with (USN + 1)
-	ldapFilter := ldaphelpers.And(
-		ldaphelpers.Or(
-			ldaphelpers.Eq("objectCategory", "*"), // Live objects
-			ldaphelpers.Eq("isDeleted", "TRUE"),   // Deleted objects
-		),
-		ldaphelpers.Ge("uSNChanged", adInstance.HighestCommittedUSN+1),
-	).String()
-
-	var allEntries []*ldap.Entry
-	err := adInstance.ForEachLDAPPage(ldapFilter, 1000,
-		func(ad *activedirectory.ActiveDirectoryInstance, entries []*ldap.Entry) error {
-			allEntries = append(allEntries, entries...)
-			return nil
-		})
-	if err != nil {
-		return fmt.Errorf("LDAP query failed: %w", err)
-	}
-
+	allEntries := batch.Entries
 	if len(allEntries) == 0 {
-		// No changes - this is normal
 		return nil
 	}
 
@@ -159,6 +125,9 @@ func processChanges(
 			snapshotErrors++
 			continue
 		}
+		snap.Partial = batch.Partial
+		snap.NamingContext = string(batch.NamingContext)
+		snap.InvocationID = batch.Replica
 		snapshots = append(snapshots, snap)
 	}
 
@@ -180,16 +149,5 @@ func processChanges(
 
 	log.Printf("Successfully processed %d objects", len(snapshots))
 
-	// Find the maximum USN from processed snapshots and update domain's last_processed_usn
-	var maxUSN int64
-	for _, snap := range snapshots {
-		if snap.USNChanged > maxUSN {
-			maxUSN = snap.USNChanged
-		}
-	}
-	if err := dbClient.UpdateDomainLastProcessedUSN(ctx, adInstance.DomainId, maxUSN); err != nil {
-		return fmt.Errorf("failed to update domain last processed USN: %w", err)
-	}
-
 	return nil
 }
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"f0oster/adspy/activedirectory"
+	"f0oster/adspy/activedirectory/schema"
+	"f0oster/adspy/changes"
+	"f0oster/adspy/config"
+	"f0oster/adspy/database"
+	"f0oster/adspy/snapshot"
+	"f0oster/adspy/versioning"
+
+	"github.com/google/uuid"
+)
+
+// domainWorker polls a single domain. Each domain has its own connection, schema registry and
+// change source position, and writes to the shared database keyed by its domain_id.
+type domainWorker struct {
+	db         *database.Database
+	domain     config.DomainConfiguration
+	poll       config.PollPolicy
+	fullResync bool // cleared once the change source has started, so restarts resume
+	polling    bool // set once the worker is initialised and polling for changes
+}
+
+// supervise runs the worker until ctx is cancelled, restarting it with backoff when it fails
+// so that one unreachable or misbehaving domain does not stop the others.
+func (w *domainWorker) supervise(ctx context.Context) {
+	backoff := w.domain.Reconnect.InitialBackoff
+	for {
+		w.polling = false
+		err := w.runSafely(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// A worker that got as far as polling recovered from any earlier failure, so start over
+		if w.polling {
+			backoff = w.domain.Reconnect.InitialBackoff
+		}
+
+		log.Printf("[%s] domain worker stopped: %v - restarting in %s", w.domain.Name, err, backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, w.domain.Reconnect.MaxBackoff)
+	}
+}
+
+// runSafely runs the worker, turning a panic into an error confined to this domain.
+func (w *domainWorker) runSafely(ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return w.run(ctx)
+}
+
+// run connects to the domain, persists its schema and polls for changes until ctx is cancelled.
+// It only returns early when the domain could not be initialised.
+func (w *domainWorker) run(ctx context.Context) error {
+	adInstance, err := activedirectory.NewActiveDirectoryInstance(ctx, w.domain)
+	if err != nil {
+		return fmt.Errorf("failed to initialize Active Directory instance: %w", err)
+	}
+	defer adInstance.Close()
+
+	err = w.db.Client().InsertDomain(
+		ctx,
+		adInstance.DomainId,
+		adInstance.BaseDn,
+		adInstance.DomainControllerFQDN,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert domain entry to database: %w", err)
+	}
+
+	// Persist AD schema to database
+	schemas := adInstance.SchemaRegistry.GetAllSchemas()
+	classes := adInstance.SchemaRegistry.GetAllClassSchemas()
+	if err := w.persistSchemas(ctx, adInstance, schemas, classes); err != nil {
+		return err
+	}
+	log.Printf("[%s] Persisted %d attribute schemas and %d class schemas", w.domain.Name, len(schemas), len(classes))
+
+	rights, err := w.persistExtendedRights(ctx, adInstance)
+	if err != nil {
+		return err
+	}
+	log.Printf("[%s] Persisted %d extended rights", w.domain.Name, rights)
+
+	adInstance.NamingContexts, err = claimNamingContexts(ctx, w.db.Client(), w.domain.Name, adInstance.DomainId, adInstance.NamingContexts)
+	if err != nil {
+		return err
+	}
+
+	// Each naming context is read by its own source, from its own watermark or DirSync cookie
+	sources := make([]changes.Source, 0, len(adInstance.NamingContexts))
+	for _, nc := range adInstance.NamingContexts {
+		var source changes.Source
+		switch w.domain.ChangeSource {
+		case config.ChangeSourceDirSync:
+			source = changes.NewDirSyncSource(adInstance, w.db.Client(), nc)
+		default:
+			source = changes.NewUSNSource(adInstance, w.db.Client(), nc, w.domain.PageSize)
+		}
+		if err := source.Start(ctx, w.fullResync); err != nil {
+			return fmt.Errorf("failed to load change source position for %s: %w", nc.DN, err)
+		}
+		sources = append(sources, source)
+	}
+	w.fullResync = false
+	log.Printf("[%s] Using %s change source for %d naming contexts", w.domain.Name, w.domain.ChangeSource, len(sources))
+
+	snapshotService := snapshot.NewService()
+	versioningService := versioning.NewService(w.db.Client(), snapshotService, adInstance.DomainId, adInstance.SchemaRegistry)
+
+	var watcher changes.Watcher
+	if w.poll.ChangeNotification {
+		watcher = adInstance
+	}
+	waiter := changes.NewWaiter(watcher, w.poll.Interval, w.poll.Jitter)
+
+	waitCtx, stopWaiter := context.WithCancel(ctx)
+	defer stopWaiter()
+	waiter.Start(waitCtx)
+
+	log.Printf("[%s] adSpy poller initialized - monitoring %s for changes", w.domain.Name, adInstance.BaseDn)
+	w.polling = true
+
+	for ctx.Err() == nil {
+		// Pick up schema extensions before reading objects that may use them
+		if err := w.reloadSchema(ctx, adInstance); err != nil {
+			log.Printf("[%s] Error reloading schema: %v", w.domain.Name, err)
+		}
+
+		var more, failed bool
+		for i, source := range sources {
+			sourceMore, err := processChanges(ctx, adInstance, source, snapshotService, versioningService)
+			if err != nil {
+				// A failing naming context does not hold up the others
+				log.Printf("[%s] Error processing changes in %s: %v", w.domain.Name, adInstance.NamingContexts[i].DN, err)
+				failed = true
+				continue
+			}
+			more = more || sourceMore
+		}
+
+		if failed {
+			// Retry on the polling interval rather than waiting for the next change notification
+			timer := time.NewTimer(w.poll.Interval)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+			case <-timer.C:
+			}
+			continue
+		}
+
+		// Keep draining while a source has further changes ready
+		if !more {
+			waiter.Wait(ctx)
+		}
+	}
+	return ctx.Err()
+}
+
+// namingContextStore records which domain polls each naming context.
+type namingContextStore interface {
+	UpsertNamingContext(ctx context.Context, domainID uuid.UUID, ncType string, dn string) error
+	ClaimNamingContext(ctx context.Context, domainID uuid.UUID, ncType string, dn string) (uuid.UUID, error)
+}
+
+// claimNamingContexts records the monitored naming contexts of the domain and returns those it
+// polls. The Configuration and Schema naming contexts are shared by every domain of a forest, so
+// only the first domain to claim them polls them; the objects in them would otherwise be
+// versioned from the USNs of several unrelated domain controllers.
+func claimNamingContexts(
+	ctx context.Context,
+	store namingContextStore,
+	domainName string,
+	domainID uuid.UUID,
+	namingContexts []activedirectory.NamingContext,
+) ([]activedirectory.NamingContext, error) {
+	var polled []activedirectory.NamingContext
+	for _, nc := range namingContexts {
+		if nc.Type == config.NamingContextDomain {
+			if err := store.UpsertNamingContext(ctx, domainID, string(nc.Type), nc.DN); err != nil {
+				return nil, fmt.Errorf("failed to record naming context %s: %w", nc.DN, err)
+			}
+			polled = append(polled, nc)
+			continue
+		}
+
+		owner, err := store.ClaimNamingContext(ctx, domainID, string(nc.Type), nc.DN)
+		if err != nil {
+			return nil, fmt.Errorf("failed to claim naming context %s: %w", nc.DN, err)
+		}
+		if owner != domainID {
+			log.Printf("[%s] %s is polled by domain %s of the same forest", domainName, nc.DN, owner)
+			continue
+		}
+		polled = append(polled, nc)
+	}
+	return polled, nil
+}
+
+// persistSchemas stores the attribute and class schemas of the domain, so attribute changes can
+// reference them and object categories resolve to class names.
+func (w *domainWorker) persistSchemas(
+	ctx context.Context,
+	adInstance *activedirectory.ActiveDirectoryInstance,
+	schemas []*schema.AttributeSchema,
+	classes []*schema.ClassSchema,
+) error {
+	for _, s := range schemas {
+		if err := w.db.Client().UpsertAttributeSchema(
+			ctx,
+			s.ObjectGUID,
+			adInstance.DomainId,
+			s.AttributeLDAPName,
+			s.AttributeName,
+			s.AttributeID,
+			s.AttributeSyntax,
+			s.AttributeOMSyntax,
+			s.AttributeFieldType.SyntaxName,
+			s.AttributeIsSingleValued,
+			s.SchemaIDGUID,
+			s.AttributeSecurityGUID,
+		); err != nil {
+			return fmt.Errorf("failed to persist attribute schema: %w", err)
+		}
+	}
+	for _, c := range classes {
+		if err := w.db.Client().UpsertClassSchema(ctx, adInstance.DomainId, database.ClassSchema{
+			ObjectGUID:                c.ObjectGUID,
+			LDAPDisplayName:           c.LDAPDisplayName,
+			ClassName:                 c.ClassName,
+			GovernsID:                 c.GovernsID,
+			SchemaIDGUID:              c.SchemaIDGUID,
+			DN:                        c.DN,
+			SubClassOf:                c.SubClassOf,
+			ObjectClassCategory:       c.ObjectClassCategory,
+			AuxiliaryClasses:          c.AuxiliaryClasses,
+			MustContain:               c.MustContain,
+			MayContain:                c.MayContain,
+			DefaultSecurityDescriptor: c.DefaultSecurityDescriptor,
+			DefaultObjectCategory:     c.DefaultObjectCategory,
+		}); err != nil {
+			return fmt.Errorf("failed to persist class schema: %w", err)
+		}
+	}
+	return nil
+}
+
+// persistExtendedRights stores the extended rights, validated writes and property sets of the
+// forest, so the web server can name the object types in ACEs without reaching a DC. It returns
+// the number stored.
+func (w *domainWorker) persistExtendedRights(ctx context.Context, adInstance *activedirectory.ActiveDirectoryInstance) (int, error) {
+	rights, err := adInstance.ReadExtendedRights()
+	if err != nil {
+		return 0, err
+	}
+	for _, right := range rights {
+		if err := w.db.Client().UpsertExtendedRight(ctx, adInstance.DomainId, database.ExtendedRight{
+			RightsGUID:    right.RightsGUID,
+			ObjectGUID:    right.ObjectGUID,
+			Name:          right.Name,
+			DisplayName:   right.DisplayName,
+			ValidAccesses: right.ValidAccesses,
+			AppliesTo:     right.AppliesTo,
+		}); err != nil {
+			return 0, fmt.Errorf("failed to persist extended right: %w", err)
+		}
+	}
+	return len(rights), nil
+}
+
+// reloadSchema reloads the schema when it has been updated since it was loaded. The reloaded
+// attribute and class schemas are persisted and the update recorded as a schema change event before the
+// registry is swapped, so a failed reload is retried in full on the next poll.
+// Polling carries on with the old schema meanwhile: only the attributes the update added are
+// unknown to it, and those are skipped rather than misread, while stopping would hold up every
+// other change in the domain.
+func (w *domainWorker) reloadSchema(ctx context.Context, adInstance *activedirectory.ActiveDirectoryInstance) error {
+	update, err := adInstance.ReadSchemaUpdate()
+	if err != nil || update == nil {
+		return err
+	}
+
+	if err := w.persistSchemas(ctx, adInstance, update.Schemas, update.Classes); err != nil {
+		return err
+	}
+	// Schema extensions commonly register extended rights and property sets alongside
+	if _, err := w.persistExtendedRights(ctx, adInstance); err != nil {
+		return err
+	}
+
+	change := update.Change
+	if err := w.db.Client().RecordSchemaChange(ctx, adInstance.DomainId, update.Modified, database.SchemaChange{
+		AddedAttributes:    change.Added,
+		ModifiedAttributes: change.Modified,
+		RemovedAttributes:  change.Removed,
+		AddedClasses:       change.AddedClasses,
+		ModifiedClasses:    change.ModifiedClasses,
+		RemovedClasses:     change.RemovedClasses,
+	}); err != nil {
+		return err
+	}
+
+	adInstance.ApplySchemaUpdate(update)
+
+	log.Printf("[%s] Schema updated at %s: reloaded %d attribute schemas (%d added, %d modified, %d removed) and %d class schemas (%d added, %d modified, %d removed)",
+		w.domain.Name, update.Modified.Format(time.RFC3339),
+		len(update.Schemas), len(change.Added), len(change.Modified), len(change.Removed),
+		len(update.Classes), len(change.AddedClasses), len(change.ModifiedClasses), len(change.RemovedClasses))
+	if len(change.Added) > 0 {
+		log.Printf("[%s] Attributes added to the schema: %s", w.domain.Name, strings.Join(change.Added, ", "))
+	}
+	if len(change.AddedClasses) > 0 {
+		log.Printf("[%s] Classes added to the schema: %s", w.domain.Name, strings.Join(change.AddedClasses, ", "))
+	}
+	return nil
+}
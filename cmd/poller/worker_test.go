@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"f0oster/adspy/activedirectory"
+	"f0oster/adspy/config"
+
+	"github.com/google/uuid"
+)
+
+type namingContextKey struct {
+	domainID uuid.UUID
+	ncType   string
+}
+
+// fakeNamingContextStore keeps the NamingContexts table in memory: one row per domain and type,
+// with each DN held by a single domain.
+type fakeNamingContextStore struct {
+	dns map[namingContextKey]string
+}
+
+func newFakeNamingContextStore() *fakeNamingContextStore {
+	return &fakeNamingContextStore{dns: make(map[namingContextKey]string)}
+}
+
+func (s *fakeNamingContextStore) owner(dn string) (uuid.UUID, bool) {
+	for key, owned := range s.dns {
+		if owned == dn {
+			return key.domainID, true
+		}
+	}
+	return uuid.Nil, false
+}
+
+func (s *fakeNamingContextStore) UpsertNamingContext(ctx context.Context, domainID uuid.UUID, ncType string, dn string) error {
+	s.dns[namingContextKey{domainID, ncType}] = dn
+	return nil
+}
+
+func (s *fakeNamingContextStore) ClaimNamingContext(ctx context.Context, domainID uuid.UUID, ncType string, dn string) (uuid.UUID, error) {
+	if owner, ok := s.owner(dn); ok {
+		return owner, nil
+	}
+	s.dns[namingContextKey{domainID, ncType}] = dn
+	return domainID, nil
+}
+
+func forestNamingContexts(domainDN, forestDN string) []activedirectory.NamingContext {
+	return []activedirectory.NamingContext{
+		{DN: domainDN, Type: config.NamingContextDomain},
+		{DN: "CN=Configuration," + forestDN, Type: config.NamingContextConfiguration},
+		{DN: "CN=Schema,CN=Configuration," + forestDN, Type: config.NamingContextSchema},
+	}
+}
+
+func TestClaimNamingContexts(t *testing.T) {
+	store := newFakeNamingContextStore()
+	root, child := uuid.New(), uuid.New()
+	rootNCs := forestNamingContexts("DC=example,DC=com", "DC=example,DC=com")
+	childNCs := forestNamingContexts("DC=child,DC=example,DC=com", "DC=example,DC=com")
+
+	claim := func(domainID uuid.UUID, namingContexts []activedirectory.NamingContext) []activedirectory.NamingContext {
+		t.Helper()
+		polled, err := claimNamingContexts(context.Background(), store, "test", domainID, namingContexts)
+		if err != nil {
+			t.Fatalf("claimNamingContexts failed: %v", err)
+		}
+		return polled
+	}
+
+	if polled := claim(root, rootNCs); !slices.Equal(polled, rootNCs) {
+		t.Errorf("first domain polls %v, want every naming context", polled)
+	}
+	// the child domain shares the forest, so it only polls its own domain naming context
+	if polled := claim(child, childNCs); !slices.Equal(polled, childNCs[:1]) {
+		t.Errorf("second domain polls %v, want only its domain naming context", polled)
+	}
+	// restarting keeps the claims as they were
+	if polled := claim(root, rootNCs); !slices.Equal(polled, rootNCs) {
+		t.Errorf("restarted first domain polls %v, want every naming context", polled)
+	}
+	if polled := claim(child, childNCs); !slices.Equal(polled, childNCs[:1]) {
+		t.Errorf("restarted second domain polls %v, want only its domain naming context", polled)
+	}
+	if dn := store.dns[namingContextKey{child, string(config.NamingContextDomain)}]; dn != "DC=child,DC=example,DC=com" {
+		t.Errorf("second domain naming context recorded as %q", dn)
+	}
+}
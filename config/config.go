@@ -3,19 +3,143 @@ package config
 import (
 	"log"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+// TransportMode selects how the LDAP connection to a domain controller is secured.
+type TransportMode string
+
+const (
+	TransportPlain    TransportMode = "plain"    // ldap:// on 389, no TLS
+	TransportStartTLS TransportMode = "starttls" // ldap:// on 389, upgraded with StartTLS
+	TransportLDAPS    TransportMode = "ldaps"    // ldaps:// on 636
+)
+
+// LDAPTransport describes the transport and TLS settings used for every LDAP
+// connection adSpy makes (poller and web resolver alike).
+type LDAPTransport struct {
+	Mode               TransportMode
+	Port               int    // 0 selects the default port for Mode
+	CAFile             string // PEM bundle used instead of the system roots
+	CertFile           string // client certificate (PEM) for mutual TLS
+	KeyFile            string // client private key (PEM) for mutual TLS
+	ServerName         string // overrides the name used for certificate verification
+	InsecureSkipVerify bool
+}
+
+// AuthMechanism selects how adSpy authenticates its LDAP connections.
+type AuthMechanism string
+
+const (
+	AuthSimple AuthMechanism = "simple" // LDAP simple bind with username and password
+	AuthGSSAPI AuthMechanism = "gssapi" // SASL/GSSAPI bind with Kerberos credentials
+)
+
+// SASLProtection selects the SASL security layer negotiated after a GSSAPI bind.
+type SASLProtection string
+
+const (
+	ProtectionNone SASLProtection = "none" // no security layer, only valid over TLS or when signing is not enforced
+	ProtectionSign SASLProtection = "sign" // integrity protection (LDAP signing)
+	ProtectionSeal SASLProtection = "seal" // integrity and confidentiality (LDAP sealing)
+)
+
+// KerberosConfiguration describes the Kerberos credentials used for a GSSAPI bind.
+// Exactly one of KeytabFile or CCacheFile is used; the keytab takes precedence.
+type KerberosConfiguration struct {
+	Krb5ConfigFile   string
+	KeytabFile       string
+	CCacheFile       string
+	Realm            string
+	ServicePrincipal string // defaults to ldap/<DC FQDN>
+	Protection       SASLProtection
+}
+
+// ReconnectPolicy controls how the poller recovers a lost LDAP connection.
+type ReconnectPolicy struct {
+	InitialBackoff time.Duration // delay after the first round of failed attempts
+	MaxBackoff     time.Duration // cap for the exponentially growing delay
+	Attempts       int           // rounds over every domain controller before giving up; 0 retries until stopped
+}
+
+// ChangeSource selects how the poller discovers changed objects.
+type ChangeSource string
+
+const (
+	ChangeSourceUSN     ChangeSource = "usn"     // poll uSNChanged and re-read changed objects in full
+	ChangeSourceDirSync ChangeSource = "dirsync" // DirSync control, returning only changed attributes
+)
+
+// NamingContextType identifies a naming context the poller can monitor.
+type NamingContextType string
+
+const (
+	NamingContextDomain        NamingContextType = "domain"        // the domain NC at BaseDN
+	NamingContextConfiguration NamingContextType = "configuration" // sites, services and partitions, shared by the forest
+	NamingContextSchema        NamingContextType = "schema"        // attributeSchema and classSchema, shared by the forest
+)
+
+// PollPolicy controls when the poller looks for changes.
+type PollPolicy struct {
+	ChangeNotification bool          // wake on AD change notifications instead of a timer
+	Interval           time.Duration // delay between polls when notifications are disabled or refused
+	Jitter             time.Duration // random delay of up to Jitter added to each Interval
+}
+
+// DomainConfiguration describes how adSpy reaches and reads one Active Directory domain.
+type DomainConfiguration struct {
+	Name              string // label used in logs and as the prefix of the domain's settings
+	BaseDN            string
+	DcFQDN            string
+	DomainControllers []string // DcFQDN followed by any failover DCs, in preference order
+	DiscoverDCs       bool     // also discover DCs from _ldap._tcp.dc._msdcs SRV records
+	Username          string
+	Password          string
+	PageSize          uint32
+	Transport         LDAPTransport
+	AllowInsecureBind bool // permit a simple bind, and so the password, over a plain transport
+	Auth              AuthMechanism
+	Kerberos          KerberosConfiguration
+	Reconnect         ReconnectPolicy
+	ChangeSource      ChangeSource
+	NamingContexts    []NamingContextType // naming contexts to poll, each with its own watermark
+}
+
 type ADSpyConfiguration struct {
-	BaseDN        string
-	DcFQDN        string
-	Username      string
-	Password      string
+	Domains       []DomainConfiguration // one polling worker runs per domain
 	ManagementDsn string
 	AdSpyDsn      string
-	PageSize      uint32
+	Poll          PollPolicy
+
+	// WebLDAPSIDLookup makes the web server also resolve SIDs missing from the database over
+	// LDAP, such as principals of trusted forests that are not monitored.
+	WebLDAPSIDLookup bool
+}
+
+// domainEnv reads the settings of one domain. For a named domain NAME_KEY takes precedence
+// over KEY, so settings shared by every domain only need to be set once.
+type domainEnv struct {
+	prefix string
+}
+
+// get returns the value of key, and the name of the variable it was read from for messages.
+func (e domainEnv) get(key string) (value, name string) {
+	if e.prefix != "" {
+		if value := os.Getenv(e.prefix + key); value != "" {
+			return value, e.prefix + key
+		}
+	}
+	return os.Getenv(key), key
+}
+
+func (e domainEnv) value(key string) string {
+	value, _ := e.get(key)
+	return value
 }
 
 func LoadEnvConfig(configName string) ADSpyConfiguration {
@@ -24,27 +148,324 @@ func LoadEnvConfig(configName string) ADSpyConfiguration {
 		log.Fatal("Error loading .env file")
 	}
 
-	baseDN := string(os.Getenv("LDAP_BASEDN"))
-	dcFQDN := string(os.Getenv("LDAP_DCFQDN"))
-	username := string(os.Getenv("LDAP_USERNAME"))
-	password := string(os.Getenv("LDAP_PASSWORD"))
 	managementDsn := string(os.Getenv("DB_MANAGEMENT_DSN"))
 	adSpyDsn := string(os.Getenv("DB_ADSPY_DSN"))
 
-	pageSize, err := strconv.ParseUint(os.Getenv("LDAP_PAGESIZE"), 10, 32)
+	webLDAPSIDLookup := false
+	if lookup := os.Getenv("ADSPY_WEB_LDAP_SID_LOOKUP"); lookup != "" {
+		parsed, err := strconv.ParseBool(lookup)
+		if err != nil {
+			log.Fatalf("failed to parse boolean for ADSPY_WEB_LDAP_SID_LOOKUP: %v", err)
+		}
+		webLDAPSIDLookup = parsed
+	}
+
+	return ADSpyConfiguration{
+		Domains:          loadDomains(),
+		ManagementDsn:    managementDsn,
+		AdSpyDsn:         adSpyDsn,
+		Poll:             loadPollPolicy(),
+		WebLDAPSIDLookup: webLDAPSIDLookup,
+	}
+
+}
+
+// loadDomains reads the domains listed in ADSPY_DOMAINS, or a single domain from the unprefixed
+// LDAP_* settings when it is not set.
+func loadDomains() []DomainConfiguration {
+	var names []string
+	for _, name := range strings.Split(os.Getenv("ADSPY_DOMAINS"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return []DomainConfiguration{loadDomain("", domainEnv{})}
+	}
+
+	domains := make([]DomainConfiguration, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		prefix := strings.ToUpper(name) + "_"
+		if seen[prefix] {
+			log.Fatalf("domain %q is listed more than once in ADSPY_DOMAINS", name)
+		}
+		seen[prefix] = true
+		domains = append(domains, loadDomain(name, domainEnv{prefix: prefix}))
+	}
+	return domains
+}
+
+func loadDomain(name string, env domainEnv) DomainConfiguration {
+	baseDN, baseDNVar := env.get("LDAP_BASEDN")
+	dcFQDN, dcFQDNVar := env.get("LDAP_DCFQDN")
+	username := env.value("LDAP_USERNAME")
+	password := env.value("LDAP_PASSWORD")
+
+	if baseDN == "" {
+		log.Fatalf("%s must be set", baseDNVar)
+	}
+	if dcFQDN == "" {
+		log.Fatalf("%s must be set", dcFQDNVar)
+	}
+	if name == "" {
+		name = baseDN
+	}
+
+	pageSizeValue, pageSizeVar := env.get("LDAP_PAGESIZE")
+	pageSize, err := strconv.ParseUint(pageSizeValue, 10, 32)
 
 	if err != nil {
-		log.Fatalf("failed to parse integer for LDAP_PAGESIZE: %v", err)
+		log.Fatalf("failed to parse integer for %s: %v", pageSizeVar, err)
 	}
 
-	return ADSpyConfiguration{
-		BaseDN:        baseDN,
-		DcFQDN:        dcFQDN,
-		Username:      username,
-		Password:      password,
-		ManagementDsn: managementDsn,
-		AdSpyDsn:      adSpyDsn,
-		PageSize:      uint32(pageSize),
+	transport := loadTransport(env)
+
+	domainControllers := []string{dcFQDN}
+	for _, dc := range strings.Split(env.value("LDAP_FAILOVER_DCS"), ",") {
+		if dc = strings.TrimSpace(dc); dc != "" {
+			domainControllers = append(domainControllers, dc)
+		}
+	}
+
+	var discoverDCs bool
+	if discover, discoverVar := env.get("LDAP_DISCOVER_DCS"); discover != "" {
+		discoverDCs, err = strconv.ParseBool(discover)
+		if err != nil {
+			log.Fatalf("failed to parse boolean for %s: %v", discoverVar, err)
+		}
+	}
+
+	auth := loadAuthMechanism(env)
+
+	var allowInsecureBind bool
+	if allow, allowVar := env.get("LDAP_ALLOW_INSECURE_BIND"); allow != "" {
+		allowInsecureBind, err = strconv.ParseBool(allow)
+		if err != nil {
+			log.Fatalf("failed to parse boolean for %s: %v", allowVar, err)
+		}
+	}
+	if auth == AuthSimple && transport.Mode == TransportPlain && !allowInsecureBind {
+		log.Fatalf("a simple bind over LDAP_TRANSPORT %q sends the password in cleartext: use starttls, ldaps or gssapi, or set %sLDAP_ALLOW_INSECURE_BIND=true",
+			transport.Mode, env.prefix)
+	}
+
+	return DomainConfiguration{
+		Name:              name,
+		BaseDN:            baseDN,
+		DcFQDN:            dcFQDN,
+		DomainControllers: domainControllers,
+		DiscoverDCs:       discoverDCs,
+		Username:          username,
+		Password:          password,
+		PageSize:          uint32(pageSize),
+		Transport:         transport,
+		AllowInsecureBind: allowInsecureBind,
+		Auth:              auth,
+		Kerberos:          loadKerberos(env, auth, transport),
+		Reconnect:         loadReconnectPolicy(env),
+		ChangeSource:      loadChangeSource(env),
+		NamingContexts:    loadNamingContexts(env),
+	}
+}
+
+func loadTransport(env domainEnv) LDAPTransport {
+	mode, modeVar := env.get("LDAP_TRANSPORT")
+	transport := LDAPTransport{
+		Mode:       TransportMode(mode),
+		CAFile:     env.value("LDAP_TLS_CA_FILE"),
+		CertFile:   env.value("LDAP_TLS_CERT_FILE"),
+		KeyFile:    env.value("LDAP_TLS_KEY_FILE"),
+		ServerName: env.value("LDAP_TLS_SERVER_NAME"),
+	}
+
+	switch transport.Mode {
+	case "":
+		transport.Mode = TransportStartTLS
+	case TransportPlain, TransportStartTLS, TransportLDAPS:
+	default:
+		log.Fatalf("invalid %s %q: expected plain, starttls or ldaps", modeVar, transport.Mode)
+	}
+
+	if port, portVar := env.get("LDAP_PORT"); port != "" {
+		parsed, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			log.Fatalf("failed to parse integer for %s: %v", portVar, err)
+		}
+		transport.Port = int(parsed)
+	}
+
+	if skip, skipVar := env.get("LDAP_TLS_INSECURE_SKIP_VERIFY"); skip != "" {
+		parsed, err := strconv.ParseBool(skip)
+		if err != nil {
+			log.Fatalf("failed to parse boolean for %s: %v", skipVar, err)
+		}
+		transport.InsecureSkipVerify = parsed
+	}
+
+	if (transport.CertFile == "") != (transport.KeyFile == "") {
+		log.Fatalf("%sLDAP_TLS_CERT_FILE and %sLDAP_TLS_KEY_FILE must be set together", env.prefix, env.prefix)
+	}
+
+	return transport
+}
+
+func loadAuthMechanism(env domainEnv) AuthMechanism {
+	value, name := env.get("LDAP_AUTH")
+	mechanism := AuthMechanism(value)
+	switch mechanism {
+	case "":
+		return AuthSimple
+	case AuthSimple, AuthGSSAPI:
+		return mechanism
+	default:
+		log.Fatalf("invalid %s %q: expected simple or gssapi", name, mechanism)
+	}
+	return mechanism
+}
+
+func loadKerberos(env domainEnv, auth AuthMechanism, transport LDAPTransport) KerberosConfiguration {
+	protection, protectionVar := env.get("LDAP_SASL_PROTECTION")
+	kerberos := KerberosConfiguration{
+		Krb5ConfigFile:   env.value("KRB5_CONFIG"),
+		KeytabFile:       env.value("KRB5_KEYTAB"),
+		CCacheFile:       env.value("KRB5_CCACHE"),
+		Realm:            env.value("KRB5_REALM"),
+		ServicePrincipal: env.value("LDAP_SPN"),
+		Protection:       SASLProtection(protection),
+	}
+
+	if kerberos.Krb5ConfigFile == "" {
+		kerberos.Krb5ConfigFile = "/etc/krb5.conf"
+	}
+
+	// The protection only applies to GSSAPI binds; simple binds ignore it
+	if auth != AuthGSSAPI {
+		return kerberos
+	}
+
+	// AD refuses a SASL security layer on top of TLS, so only default to sealing on plain connections
+	switch kerberos.Protection {
+	case "":
+		kerberos.Protection = ProtectionSeal
+		if transport.Mode != TransportPlain {
+			kerberos.Protection = ProtectionNone
+		}
+	case ProtectionNone, ProtectionSign, ProtectionSeal:
+		if kerberos.Protection != ProtectionNone && transport.Mode != TransportPlain {
+			log.Fatalf("%s %q cannot be combined with LDAP_TRANSPORT %q", protectionVar, kerberos.Protection, transport.Mode)
+		}
+	default:
+		log.Fatalf("invalid %s %q: expected none, sign or seal", protectionVar, kerberos.Protection)
+	}
+
+	return kerberos
+}
+
+func loadChangeSource(env domainEnv) ChangeSource {
+	value, name := env.get("ADSPY_CHANGE_SOURCE")
+	source := ChangeSource(value)
+	switch source {
+	case "":
+		return ChangeSourceUSN
+	case ChangeSourceUSN, ChangeSourceDirSync:
+		return source
+	default:
+		log.Fatalf("invalid %s %q: expected usn or dirsync", name, source)
+	}
+	return source
+}
+
+func loadNamingContexts(env domainEnv) []NamingContextType {
+	value, name := env.get("ADSPY_NAMING_CONTEXTS")
+	if value == "" {
+		return []NamingContextType{NamingContextDomain, NamingContextConfiguration, NamingContextSchema}
+	}
+
+	var namingContexts []NamingContextType
+	for _, nc := range strings.Split(value, ",") {
+		switch nc := NamingContextType(strings.ToLower(strings.TrimSpace(nc))); nc {
+		case "":
+		case NamingContextDomain, NamingContextConfiguration, NamingContextSchema:
+			if !slices.Contains(namingContexts, nc) {
+				namingContexts = append(namingContexts, nc)
+			}
+		default:
+			log.Fatalf("invalid naming context %q in %s: expected domain, configuration or schema", nc, name)
+		}
+	}
+	if len(namingContexts) == 0 {
+		log.Fatalf("%s lists no naming contexts", name)
+	}
+	return namingContexts
+}
+
+func loadPollPolicy() PollPolicy {
+	policy := PollPolicy{
+		Interval: time.Second,
+	}
+
+	if notification := os.Getenv("ADSPY_CHANGE_NOTIFICATION"); notification != "" {
+		parsed, err := strconv.ParseBool(notification)
+		if err != nil {
+			log.Fatalf("failed to parse boolean for ADSPY_CHANGE_NOTIFICATION: %v", err)
+		}
+		policy.ChangeNotification = parsed
+	}
+
+	if interval := os.Getenv("ADSPY_POLL_INTERVAL"); interval != "" {
+		parsed, err := time.ParseDuration(interval)
+		if err != nil || parsed <= 0 {
+			log.Fatalf("invalid duration for ADSPY_POLL_INTERVAL: %q", interval)
+		}
+		policy.Interval = parsed
+	}
+
+	if jitter := os.Getenv("ADSPY_POLL_JITTER"); jitter != "" {
+		parsed, err := time.ParseDuration(jitter)
+		if err != nil || parsed < 0 {
+			log.Fatalf("invalid duration for ADSPY_POLL_JITTER: %q", jitter)
+		}
+		policy.Jitter = parsed
+	}
+
+	return policy
+}
+
+func loadReconnectPolicy(env domainEnv) ReconnectPolicy {
+	policy := ReconnectPolicy{
+		InitialBackoff: time.Second,
+		MaxBackoff:     2 * time.Minute,
+		Attempts:       5,
+	}
+
+	if backoff, name := env.get("LDAP_RECONNECT_BACKOFF"); backoff != "" {
+		parsed, err := time.ParseDuration(backoff)
+		if err != nil || parsed <= 0 {
+			log.Fatalf("invalid duration for %s: %q", name, backoff)
+		}
+		policy.InitialBackoff = parsed
+	}
+
+	if backoff, name := env.get("LDAP_RECONNECT_MAX_BACKOFF"); backoff != "" {
+		parsed, err := time.ParseDuration(backoff)
+		if err != nil || parsed <= 0 {
+			log.Fatalf("invalid duration for %s: %q", name, backoff)
+		}
+		policy.MaxBackoff = parsed
+	}
+
+	if attempts, name := env.get("LDAP_RECONNECT_ATTEMPTS"); attempts != "" {
+		parsed, err := strconv.Atoi(attempts)
+		if err != nil || parsed < 0 {
+			log.Fatalf("invalid number for %s: %q", name, attempts)
+		}
+		policy.Attempts = parsed
+	}
+
+	if policy.MaxBackoff < policy.InitialBackoff {
+		log.Fatalf("%sLDAP_RECONNECT_MAX_BACKOFF must not be less than %sLDAP_RECONNECT_BACKOFF", env.prefix, env.prefix)
 	}
 
+	return policy
 }
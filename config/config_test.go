@@ -0,0 +1,93 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+// setEnv sets every variable for the duration of the test.
+func setEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+}
+
+func TestLoadDomains_Single(t *testing.T) {
+	setEnv(t, map[string]string{
+		"ADSPY_DOMAINS":     "",
+		"LDAP_BASEDN":       "DC=lab,DC=com",
+		"LDAP_DCFQDN":       "dc1.lab.com",
+		"LDAP_FAILOVER_DCS": "",
+		"LDAP_PAGESIZE":     "500",
+		"LDAP_TRANSPORT":    "",
+	})
+
+	domains := loadDomains()
+	if len(domains) != 1 {
+		t.Fatalf("loaded %d domains, want 1", len(domains))
+	}
+	domain := domains[0]
+	if domain.Name != "DC=lab,DC=com" {
+		t.Errorf("Name = %s, want the base DN", domain.Name)
+	}
+	if !slices.Equal(domain.DomainControllers, []string{"dc1.lab.com"}) {
+		t.Errorf("DomainControllers = %v, want [dc1.lab.com]", domain.DomainControllers)
+	}
+	if domain.Transport.Mode != TransportStartTLS {
+		t.Errorf("Transport = %s, want starttls", domain.Transport.Mode)
+	}
+}
+
+func TestLoadDomains_Multiple(t *testing.T) {
+	setEnv(t, map[string]string{
+		"ADSPY_DOMAINS":             " corp, partner ,",
+		"LDAP_PAGESIZE":             "500",
+		"LDAP_TRANSPORT":            "ldaps",
+		"LDAP_FAILOVER_DCS":         "",
+		"CORP_LDAP_BASEDN":          "DC=corp,DC=example,DC=com",
+		"CORP_LDAP_DCFQDN":          "dc1.corp.example.com",
+		"CORP_LDAP_FAILOVER_DCS":    "dc2.corp.example.com, dc3.corp.example.com",
+		"CORP_LDAP_PAGESIZE":        "",
+		"CORP_LDAP_TRANSPORT":       "",
+		"PARTNER_LDAP_BASEDN":       "DC=partner,DC=example,DC=net",
+		"PARTNER_LDAP_DCFQDN":       "dc1.partner.example.net",
+		"PARTNER_LDAP_FAILOVER_DCS": "",
+		"PARTNER_LDAP_PAGESIZE":     "100",
+		"PARTNER_LDAP_TRANSPORT":    "starttls",
+	})
+
+	domains := loadDomains()
+	if len(domains) != 2 {
+		t.Fatalf("loaded %d domains, want 2", len(domains))
+	}
+
+	tests := []struct {
+		name              string
+		baseDN            string
+		domainControllers []string
+		pageSize          uint32
+		transport         TransportMode
+	}{
+		{"corp", "DC=corp,DC=example,DC=com", []string{"dc1.corp.example.com", "dc2.corp.example.com", "dc3.corp.example.com"}, 500, TransportLDAPS},
+		{"partner", "DC=partner,DC=example,DC=net", []string{"dc1.partner.example.net"}, 100, TransportStartTLS},
+	}
+	for i, test := range tests {
+		domain := domains[i]
+		if domain.Name != test.name {
+			t.Errorf("domain %d Name = %s, want %s", i, domain.Name, test.name)
+		}
+		if domain.BaseDN != test.baseDN {
+			t.Errorf("%s BaseDN = %s, want %s", test.name, domain.BaseDN, test.baseDN)
+		}
+		if !slices.Equal(domain.DomainControllers, test.domainControllers) {
+			t.Errorf("%s DomainControllers = %v, want %v", test.name, domain.DomainControllers, test.domainControllers)
+		}
+		if domain.PageSize != test.pageSize {
+			t.Errorf("%s PageSize = %d, want %d", test.name, domain.PageSize, test.pageSize)
+		}
+		if domain.Transport.Mode != test.transport {
+			t.Errorf("%s Transport = %s, want %s", test.name, domain.Transport.Mode, test.transport)
+		}
+	}
+}
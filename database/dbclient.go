@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -25,7 +26,8 @@ func NewDBClient(pool *pgxpool.Pool) *DBClient {
 	}
 }
 
-// Returns the last processed USN (nil if this is a new object).
+// Returns the ID of the object's current version (nil if this is a new object) and the DN the
+// object had before this upsert (empty if this is a new object).
 func (r *DBClient) UpsertObject(
 	ctx context.Context,
 	tx pgx.Tx,
@@ -33,108 +35,347 @@ func (r *DBClient) UpsertObject(
 	objectType string,
 	dn string,
 	domainID uuid.UUID,
-) (*int64, error) {
+	namingContext string,
+) (*int64, string, error) {
 	txQueries := r.queries.WithTx(tx)
 
-	currentUSN, err := txQueries.UpsertObject(ctx, sqlcgen.UpsertObjectParams{
+	row, err := txQueries.UpsertObject(ctx, sqlcgen.UpsertObjectParams{
 		ObjectID:          uuidToPgtype(objectID),
 		ObjectType:        objectType,
 		Distinguishedname: dn,
 		DomainID:          uuidToPgtype(domainID),
+		NamingContext:     namingContext,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("upsert object query failed: %w", err)
+		return nil, "", fmt.Errorf("upsert object query failed: %w", err)
 	}
 
-	return pgtypeToInt64(currentUSN), nil
+	return pgtypeToInt64(row.CurrentVersionID), row.PreviousDn, nil
 }
 
-func (r *DBClient) GetVersionSnapshot(
+// UpdateDescendantDNs rewrites the stored DN of every object below oldDN to sit below newDN.
+// Descendants of a renamed or moved container change DN without their uSNChanged changing.
+func (r *DBClient) UpdateDescendantDNs(
 	ctx context.Context,
 	tx pgx.Tx,
+	domainID uuid.UUID,
+	oldDN string,
+	newDN string,
+) error {
+	txQueries := r.queries.WithTx(tx)
+
+	err := txQueries.UpdateDescendantDNs(ctx, sqlcgen.UpdateDescendantDNsParams{
+		Column1:  oldDN,
+		Column2:  newDN,
+		DomainID: uuidToPgtype(domainID),
+	})
+	if err != nil {
+		return fmt.Errorf("update descendant DNs query failed: %w", err)
+	}
+	return nil
+}
+
+// DNChange is a rename and/or move of an object.
+type DNChange struct {
+	Type      string // renamed, moved or renamed+moved
+	OldDN     string
+	NewDN     string
+	OldRDN    string
+	NewRDN    string
+	OldParent string
+	NewParent string
+	Time      time.Time // originating time of the change
+}
+
+func (r *DBClient) RecordDNChange(
+	ctx context.Context,
+	tx pgx.Tx,
+	versionID int64,
 	objectID uuid.UUID,
-	usnChanged int64,
-) ([]byte, error) {
+	change DNChange,
+) error {
 	txQueries := r.queries.WithTx(tx)
 
-	snapshotJSON, err := txQueries.GetPreviousSnapshot(ctx, sqlcgen.GetPreviousSnapshotParams{
+	err := txQueries.InsertDNChange(ctx, sqlcgen.InsertDNChangeParams{
+		VersionID:  versionID,
 		ObjectID:   uuidToPgtype(objectID),
-		UsnChanged: usnChanged,
+		ChangeType: change.Type,
+		OldDn:      change.OldDN,
+		NewDn:      change.NewDN,
+		OldRdn:     change.OldRDN,
+		NewRdn:     change.NewRDN,
+		OldParent:  change.OldParent,
+		NewParent:  change.NewParent,
+		ChangedAt:  pgtype.Timestamp{Time: change.Time, Valid: true},
 	})
 	if err != nil {
-		return nil, fmt.Errorf("get version snapshot query failed: %w", err)
+		return fmt.Errorf("record DN change query failed: %w", err)
+	}
+	return nil
+}
+
+// ObjectVersion is a stored version of an object. USNChanged is the object's uSNChanged on the
+// replica identified by InvocationID, and is not comparable with USNs read from other replicas.
+type ObjectVersion struct {
+	InvocationID uuid.UUID
+	USNChanged   int64
+	Attributes   []byte // JSON attribute snapshot
+}
+
+func (r *DBClient) GetVersion(
+	ctx context.Context,
+	tx pgx.Tx,
+	versionID int64,
+) (*ObjectVersion, error) {
+	txQueries := r.queries.WithTx(tx)
+
+	row, err := txQueries.GetVersion(ctx, versionID)
+	if err != nil {
+		return nil, fmt.Errorf("get version query failed: %w", err)
 	}
-	return snapshotJSON, nil
+	return &ObjectVersion{
+		InvocationID: uuid.UUID(row.InvocationID.Bytes),
+		USNChanged:   row.UsnChanged,
+		Attributes:   row.AttributesSnapshot,
+	}, nil
 }
 
+// CreateVersion stores a version of an object read at usnChanged from the replica identified by
+// invocationID, and returns its version ID.
 func (r *DBClient) CreateVersion(
 	ctx context.Context,
 	tx pgx.Tx,
 	objectID uuid.UUID,
+	invocationID uuid.UUID,
 	usnChanged int64,
 	timestamp time.Time,
 	attributesJSON []byte,
 	modifiedBy string,
-) error {
+) (int64, error) {
 	txQueries := r.queries.WithTx(tx)
 
-	err := txQueries.InsertVersion(ctx, sqlcgen.InsertVersionParams{
+	versionID, err := txQueries.InsertVersion(ctx, sqlcgen.InsertVersionParams{
 		ObjectID:           uuidToPgtype(objectID),
+		InvocationID:       uuidToPgtype(invocationID),
 		UsnChanged:         usnChanged,
 		Timestamp:          pgtype.Timestamp{Time: timestamp, Valid: true},
 		AttributesSnapshot: attributesJSON,
 		ModifiedBy:         pgtype.Text{String: modifiedBy, Valid: true},
 	})
 	if err != nil {
-		return fmt.Errorf("create version query failed: %w", err)
+		return 0, fmt.Errorf("create version query failed: %w", err)
 	}
-	return nil
+	return versionID, nil
 }
 
-func (r *DBClient) UpdateLastProcessedUSN(
+func (r *DBClient) UpdateCurrentVersion(
 	ctx context.Context,
 	tx pgx.Tx,
-	usnChanged int64,
+	versionID int64,
 	objectID uuid.UUID,
 ) error {
 	txQueries := r.queries.WithTx(tx)
 
-	err := txQueries.UpdateLastProcessedUSN(ctx, sqlcgen.UpdateLastProcessedUSNParams{
-		LastProcessedUsn: pgtype.Int8{Int64: usnChanged, Valid: true},
+	err := txQueries.UpdateCurrentVersion(ctx, sqlcgen.UpdateCurrentVersionParams{
+		CurrentVersionID: pgtype.Int8{Int64: versionID, Valid: true},
 		ObjectID:         uuidToPgtype(objectID),
 	})
 	if err != nil {
-		return fmt.Errorf("update last processed USN query failed: %w", err)
+		return fmt.Errorf("update current version query failed: %w", err)
 	}
 	return nil
 }
 
+// MarkObjectDeleted records that the object was deleted at deletedAt. lastLiveDN is the DN the
+// object had before it moved to Deleted Objects and lastKnownParent the DN of its former parent;
+// either is empty when unknown.
+func (r *DBClient) MarkObjectDeleted(
+	ctx context.Context,
+	tx pgx.Tx,
+	objectID uuid.UUID,
+	deletedAt time.Time,
+	lastLiveDN string,
+	lastKnownParent string,
+) error {
+	txQueries := r.queries.WithTx(tx)
+
+	err := txQueries.MarkObjectDeleted(ctx, sqlcgen.MarkObjectDeletedParams{
+		ObjectID:        uuidToPgtype(objectID),
+		DeletedAt:       pgtype.Timestamp{Time: deletedAt, Valid: true},
+		LastLiveDn:      pgtype.Text{String: lastLiveDN, Valid: lastLiveDN != ""},
+		LastKnownParent: pgtype.Text{String: lastKnownParent, Valid: lastKnownParent != ""},
+	})
+	if err != nil {
+		return fmt.Errorf("mark object deleted query failed: %w", err)
+	}
+	return nil
+}
+
+// MarkObjectRestored clears the deletion of an object that was restored.
+func (r *DBClient) MarkObjectRestored(
+	ctx context.Context,
+	tx pgx.Tx,
+	objectID uuid.UUID,
+) error {
+	txQueries := r.queries.WithTx(tx)
+
+	if err := txQueries.MarkObjectRestored(ctx, uuidToPgtype(objectID)); err != nil {
+		return fmt.Errorf("mark object restored query failed: %w", err)
+	}
+	return nil
+}
+
+// UpdateObjectLifecycleState sets the lifecycle state (live, deleted or recycled) of an object.
+func (r *DBClient) UpdateObjectLifecycleState(
+	ctx context.Context,
+	tx pgx.Tx,
+	objectID uuid.UUID,
+	state string,
+) error {
+	txQueries := r.queries.WithTx(tx)
+
+	err := txQueries.UpdateObjectLifecycleState(ctx, sqlcgen.UpdateObjectLifecycleStateParams{
+		ObjectID:       uuidToPgtype(objectID),
+		LifecycleState: state,
+	})
+	if err != nil {
+		return fmt.Errorf("update object lifecycle state query failed: %w", err)
+	}
+	return nil
+}
+
+// LifecycleEvent is a transition of an object between lifecycle states, recorded against the
+// version it was observed in.
+type LifecycleEvent struct {
+	Type            string    // deleted, recycled or restored
+	From            string    // state before the transition
+	To              string    // state after the transition
+	Time            time.Time // originating time of the transition
+	DN              string    // DN after the transition
+	LastKnownRDN    string    // msDS-LastKnownRDN, empty for live objects
+	LastKnownParent string    // lastKnownParent, empty when unknown
+}
+
+func (r *DBClient) RecordLifecycleEvent(
+	ctx context.Context,
+	tx pgx.Tx,
+	versionID int64,
+	objectID uuid.UUID,
+	event LifecycleEvent,
+) error {
+	txQueries := r.queries.WithTx(tx)
+
+	err := txQueries.InsertLifecycleEvent(ctx, sqlcgen.InsertLifecycleEventParams{
+		VersionID:         versionID,
+		ObjectID:          uuidToPgtype(objectID),
+		EventType:         event.Type,
+		FromState:         event.From,
+		ToState:           event.To,
+		EventTime:         pgtype.Timestamp{Time: event.Time, Valid: true},
+		Distinguishedname: event.DN,
+		LastKnownRdn:      pgtype.Text{String: event.LastKnownRDN, Valid: event.LastKnownRDN != ""},
+		LastKnownParent:   pgtype.Text{String: event.LastKnownParent, Valid: event.LastKnownParent != ""},
+	})
+	if err != nil {
+		return fmt.Errorf("record lifecycle event query failed: %w", err)
+	}
+	return nil
+}
+
+// AttributeOrigin identifies the originating write of an attribute change, as recorded in the
+// object's replication metadata.
+type AttributeOrigin struct {
+	DsaDN        string    // NTDS Settings DN of the DC the write originated on
+	InvocationID uuid.UUID // invocationId of the originating DC's database
+	USN          int64     // USN of the write on the originating DC
+	Time         time.Time // time of the originating write
+	Version      int32     // attribute version, incremented on every originating write
+}
+
+// RecordAttributeChange records the old and new values of a changed attribute. summary is the
+// change in words, such as the flags set and cleared in a bitmask, or empty.
 func (r *DBClient) RecordAttributeChange(
 	ctx context.Context,
 	tx pgx.Tx,
+	versionID int64,
 	objectID uuid.UUID,
-	usnChanged int64,
+	domainID uuid.UUID,
 	attributeSchemaID uuid.UUID,
 	oldValue []byte,
 	newValue []byte,
+	summary string,
 	timestamp time.Time,
+	origin *AttributeOrigin,
 ) error {
 	txQueries := r.queries.WithTx(tx)
 
-	err := txQueries.InsertAttributeChange(ctx, sqlcgen.InsertAttributeChangeParams{
+	params := sqlcgen.InsertAttributeChangeParams{
+		VersionID:         versionID,
 		ObjectID:          uuidToPgtype(objectID),
-		UsnChanged:        usnChanged,
+		DomainID:          uuidToPgtype(domainID),
 		AttributeSchemaID: uuidToPgtype(attributeSchemaID),
 		OldValue:          oldValue,
 		NewValue:          newValue,
+		Summary:           pgtype.Text{String: summary, Valid: summary != ""},
 		Timestamp:         pgtype.Timestamp{Time: timestamp, Valid: true},
-	})
+	}
+	if origin != nil {
+		params.OriginatingDsaDn = pgtype.Text{String: origin.DsaDN, Valid: origin.DsaDN != ""}
+		params.OriginatingInvocationID = uuidToPgtype(origin.InvocationID)
+		params.OriginatingUsn = pgtype.Int8{Int64: origin.USN, Valid: true}
+		params.OriginatingTime = pgtype.Timestamp{Time: origin.Time, Valid: true}
+		params.MetadataVersion = pgtype.Int4{Int32: origin.Version, Valid: true}
+	}
+
+	err := txQueries.InsertAttributeChange(ctx, params)
 	if err != nil {
 		return fmt.Errorf("record attribute change query failed: %w", err)
 	}
 	return nil
 }
 
+// RecordLinkedValueChange records a value added to or removed from a linked attribute.
+// valueData is the data tied to the DN of a DN-Binary or DN-String value, empty otherwise.
+// origin is nil for legacy values, which carry no replication metadata of their own.
+func (r *DBClient) RecordLinkedValueChange(
+	ctx context.Context,
+	tx pgx.Tx,
+	versionID int64,
+	objectID uuid.UUID,
+	domainID uuid.UUID,
+	attributeSchemaID uuid.UUID,
+	valueDN string,
+	valueData string,
+	changeType string,
+	legacy bool,
+	origin *AttributeOrigin,
+) error {
+	txQueries := r.queries.WithTx(tx)
+
+	params := sqlcgen.InsertLinkedValueChangeParams{
+		VersionID:         versionID,
+		ObjectID:          uuidToPgtype(objectID),
+		DomainID:          uuidToPgtype(domainID),
+		AttributeSchemaID: uuidToPgtype(attributeSchemaID),
+		ValueDn:           valueDN,
+		ValueData:         valueData,
+		ChangeType:        changeType,
+		Legacy:            legacy,
+	}
+	if origin != nil {
+		params.OriginatingTime = pgtype.Timestamp{Time: origin.Time, Valid: true}
+		params.OriginatingDsaDn = pgtype.Text{String: origin.DsaDN, Valid: origin.DsaDN != ""}
+		params.OriginatingInvocationID = uuidToPgtype(origin.InvocationID)
+		params.OriginatingUsn = pgtype.Int8{Int64: origin.USN, Valid: true}
+		params.MetadataVersion = pgtype.Int4{Int32: origin.Version, Valid: true}
+	}
+
+	if err := txQueries.InsertLinkedValueChange(ctx, params); err != nil {
+		return fmt.Errorf("record linked value change query failed: %w", err)
+	}
+	return nil
+}
+
 func (r *DBClient) GetAttributeSchemaByLDAPName(
 	ctx context.Context,
 	domainID uuid.UUID,
@@ -175,14 +416,11 @@ func (r *DBClient) InsertDomain(
 	domainID uuid.UUID,
 	domainName string,
 	domainController string,
-	highestUSN int64,
 ) error {
 	err := r.queries.InsertDomain(ctx, sqlcgen.InsertDomainParams{
 		DomainID:         uuidToPgtype(domainID),
 		DomainName:       domainName,
 		DomainController: domainController,
-		HighestUsn:       pgtype.Int8{Int64: highestUSN, Valid: true},
-		LastProcessedUsn: pgtype.Int8{Int64: 0, Valid: true},
 	})
 	if err != nil {
 		return fmt.Errorf("insert domain failed: %w", err)
@@ -190,6 +428,64 @@ func (r *DBClient) InsertDomain(
 	return nil
 }
 
+// UpsertNamingContext records a monitored naming context of the domain. Its watermark and
+// DirSync cookie are kept when it is already known.
+func (r *DBClient) UpsertNamingContext(
+	ctx context.Context,
+	domainID uuid.UUID,
+	ncType string,
+	dn string,
+) error {
+	err := r.queries.UpsertNamingContext(ctx, sqlcgen.UpsertNamingContextParams{
+		DomainID:          uuidToPgtype(domainID),
+		NcType:            ncType,
+		Distinguishedname: dn,
+	})
+	if err != nil {
+		return fmt.Errorf("upsert naming context failed: %w", err)
+	}
+	return nil
+}
+
+// ClaimNamingContext records a naming context shared by the forest for the domain, unless another
+// domain has claimed it already. It returns the domain that polls the naming context. A domain
+// that claimed the naming context type under another DN before has that claim moved to dn.
+func (r *DBClient) ClaimNamingContext(
+	ctx context.Context,
+	domainID uuid.UUID,
+	ncType string,
+	dn string,
+) (uuid.UUID, error) {
+	tx, err := r.BeginTx(ctx)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer r.RollbackTx(ctx, tx)
+
+	txQueries := r.queries.WithTx(tx)
+	err = txQueries.MoveNamingContext(ctx, sqlcgen.MoveNamingContextParams{
+		DomainID:          uuidToPgtype(domainID),
+		NcType:            ncType,
+		Distinguishedname: dn,
+	})
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("move naming context failed: %w", err)
+	}
+
+	owner, err := txQueries.ClaimNamingContext(ctx, sqlcgen.ClaimNamingContextParams{
+		DomainID:          uuidToPgtype(domainID),
+		NcType:            ncType,
+		Distinguishedname: dn,
+	})
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("claim naming context failed: %w", err)
+	}
+	if err := r.CommitTx(ctx, tx); err != nil {
+		return uuid.Nil, err
+	}
+	return uuid.UUID(owner.Bytes), nil
+}
+
 func (r *DBClient) UpsertAttributeSchema(
 	ctx context.Context,
 	objectGUID uuid.UUID,
@@ -201,6 +497,8 @@ func (r *DBClient) UpsertAttributeSchema(
 	omSyntax string,
 	syntaxName string,
 	isSingleValued bool,
+	schemaIDGUID uuid.UUID,
+	attributeSecurityGUID uuid.UUID,
 ) error {
 	err := r.queries.UpsertAttributeSchema(ctx, sqlcgen.UpsertAttributeSchemaParams{
 		ObjectGuid:      uuidToPgtype(objectGUID),
@@ -212,6 +510,11 @@ func (r *DBClient) UpsertAttributeSchema(
 		OmSyntax:        omSyntax,
 		SyntaxName:      pgtype.Text{String: syntaxName, Valid: syntaxName != ""},
 		IsSingleValued:  isSingleValued,
+		SchemaIDGuid:    uuidToPgtype(schemaIDGUID),
+		AttributeSecurityGuid: pgtype.UUID{
+			Bytes: attributeSecurityGUID,
+			Valid: attributeSecurityGUID != uuid.Nil,
+		},
 	})
 	if err != nil {
 		return fmt.Errorf("upsert attribute schema failed: %w", err)
@@ -219,32 +522,248 @@ func (r *DBClient) UpsertAttributeSchema(
 	return nil
 }
 
-func (r *DBClient) UpdateDomainLastProcessedUSN(
+// ClassSchema is the persisted definition of a classSchema object.
+type ClassSchema struct {
+	ObjectGUID                uuid.UUID
+	LDAPDisplayName           string
+	ClassName                 string
+	GovernsID                 string
+	SchemaIDGUID              uuid.UUID
+	DN                        string
+	SubClassOf                string
+	ObjectClassCategory       int
+	AuxiliaryClasses          []string
+	MustContain               []string
+	MayContain                []string
+	DefaultSecurityDescriptor string
+	DefaultObjectCategory     string
+}
+
+func (r *DBClient) UpsertClassSchema(
+	ctx context.Context,
+	domainID uuid.UUID,
+	class ClassSchema,
+) error {
+	err := r.queries.UpsertClassSchema(ctx, sqlcgen.UpsertClassSchemaParams{
+		ObjectGuid:                uuidToPgtype(class.ObjectGUID),
+		DomainID:                  uuidToPgtype(domainID),
+		LdapDisplayName:           class.LDAPDisplayName,
+		ClassName:                 class.ClassName,
+		GovernsID:                 class.GovernsID,
+		SchemaIDGuid:              uuidToPgtype(class.SchemaIDGUID),
+		Distinguishedname:         class.DN,
+		SubClassOf:                class.SubClassOf,
+		ObjectClassCategory:       int32(class.ObjectClassCategory),
+		AuxiliaryClasses:          nonNil(class.AuxiliaryClasses),
+		MustContain:               nonNil(class.MustContain),
+		MayContain:                nonNil(class.MayContain),
+		DefaultSecurityDescriptor: pgtype.Text{String: class.DefaultSecurityDescriptor, Valid: class.DefaultSecurityDescriptor != ""},
+		DefaultObjectCategory:     pgtype.Text{String: class.DefaultObjectCategory, Valid: class.DefaultObjectCategory != ""},
+	})
+	if err != nil {
+		return fmt.Errorf("upsert class schema failed: %w", err)
+	}
+	return nil
+}
+
+// ExtendedRight is the persisted definition of a controlAccessRight object.
+type ExtendedRight struct {
+	RightsGUID    uuid.UUID
+	ObjectGUID    uuid.UUID
+	Name          string
+	DisplayName   string
+	ValidAccesses int
+	AppliesTo     []string
+}
+
+func (r *DBClient) UpsertExtendedRight(
+	ctx context.Context,
+	domainID uuid.UUID,
+	right ExtendedRight,
+) error {
+	err := r.queries.UpsertExtendedRight(ctx, sqlcgen.UpsertExtendedRightParams{
+		DomainID:      uuidToPgtype(domainID),
+		RightsGuid:    uuidToPgtype(right.RightsGUID),
+		ObjectGuid:    uuidToPgtype(right.ObjectGUID),
+		Name:          right.Name,
+		DisplayName:   right.DisplayName,
+		ValidAccesses: int32(right.ValidAccesses),
+		AppliesTo:     nonNil(right.AppliesTo),
+	})
+	if err != nil {
+		return fmt.Errorf("upsert extended right failed: %w", err)
+	}
+	return nil
+}
+
+// SchemaChange is a schema update detected by the poller. Attributes and classes are
+// identified by lDAPDisplayName.
+type SchemaChange struct {
+	AddedAttributes    []string
+	ModifiedAttributes []string
+	RemovedAttributes  []string
+	AddedClasses       []string
+	ModifiedClasses    []string
+	RemovedClasses     []string
+}
+
+// RecordSchemaChange records a schema update detected by the poller.
+func (r *DBClient) RecordSchemaChange(
+	ctx context.Context,
+	domainID uuid.UUID,
+	schemaModifiedAt time.Time,
+	change SchemaChange,
+) error {
+	err := r.queries.InsertSchemaChangeEvent(ctx, sqlcgen.InsertSchemaChangeEventParams{
+		DomainID:           uuidToPgtype(domainID),
+		SchemaModifiedAt:   pgtype.Timestamp{Time: schemaModifiedAt, Valid: true},
+		AddedAttributes:    nonNil(change.AddedAttributes),
+		ModifiedAttributes: nonNil(change.ModifiedAttributes),
+		RemovedAttributes:  nonNil(change.RemovedAttributes),
+		AddedClasses:       nonNil(change.AddedClasses),
+		ModifiedClasses:    nonNil(change.ModifiedClasses),
+		RemovedClasses:     nonNil(change.RemovedClasses),
+	})
+	if err != nil {
+		return fmt.Errorf("insert schema change event failed: %w", err)
+	}
+	return nil
+}
+
+// ErrStaleWatermark is returned when a USN watermark update targets a replica that is no
+// longer the one recorded for the naming context.
+var ErrStaleWatermark = errors.New("naming context watermark belongs to a different replica")
+
+// NamingContextWatermark is the persisted USN progress for a naming context, along with the
+// identity of the domain controller replica that issued those USNs.
+type NamingContextWatermark struct {
+	DomainController string
+	DsServiceName    string
+	InvocationID     *uuid.UUID // nil until the first replica has been recorded
+	LastProcessedUSN int64
+	HighestUSN       int64
+}
+
+func (r *DBClient) GetNamingContextWatermark(
+	ctx context.Context,
+	domainID uuid.UUID,
+	ncType string,
+) (*NamingContextWatermark, error) {
+	row, err := r.queries.GetNamingContextWatermark(ctx, sqlcgen.GetNamingContextWatermarkParams{
+		DomainID: uuidToPgtype(domainID),
+		NcType:   ncType,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get naming context watermark failed: %w", err)
+	}
+	return &NamingContextWatermark{
+		DomainController: row.DomainController.String,
+		DsServiceName:    row.DsServiceName.String,
+		InvocationID:     pgtypeToUUID(row.InvocationID),
+		LastProcessedUSN: row.LastProcessedUsn.Int64,
+		HighestUSN:       row.HighestUsn.Int64,
+	}, nil
+}
+
+// ResetNamingContextWatermark records a new replica for the naming context and restarts both
+// USN watermarks from usn.
+func (r *DBClient) ResetNamingContextWatermark(
+	ctx context.Context,
+	domainID uuid.UUID,
+	ncType string,
+	domainController string,
+	dsServiceName string,
+	invocationID uuid.UUID,
+	usn int64,
+) error {
+	err := r.queries.ResetNamingContextWatermark(ctx, sqlcgen.ResetNamingContextWatermarkParams{
+		DomainID:         uuidToPgtype(domainID),
+		NcType:           ncType,
+		DomainController: pgtype.Text{String: domainController, Valid: true},
+		DsServiceName:    pgtype.Text{String: dsServiceName, Valid: true},
+		InvocationID:     uuidToPgtype(invocationID),
+		LastProcessedUsn: pgtype.Int8{Int64: usn, Valid: true},
+		HighestUsn:       pgtype.Int8{Int64: usn, Valid: true},
+	})
+	if err != nil {
+		return fmt.Errorf("reset naming context watermark failed: %w", err)
+	}
+	return nil
+}
+
+func (r *DBClient) UpdateNamingContextLastProcessedUSN(
 	ctx context.Context,
 	domainID uuid.UUID,
+	ncType string,
+	invocationID uuid.UUID,
 	lastProcessedUSN int64,
 ) error {
-	err := r.queries.UpdateDomainLastProcessedUSN(ctx, sqlcgen.UpdateDomainLastProcessedUSNParams{
+	rows, err := r.queries.UpdateNamingContextLastProcessedUSN(ctx, sqlcgen.UpdateNamingContextLastProcessedUSNParams{
 		DomainID:         uuidToPgtype(domainID),
+		NcType:           ncType,
+		InvocationID:     uuidToPgtype(invocationID),
 		LastProcessedUsn: pgtype.Int8{Int64: lastProcessedUSN, Valid: true},
 	})
 	if err != nil {
-		return fmt.Errorf("update domain last processed USN failed: %w", err)
+		return fmt.Errorf("update naming context last processed USN failed: %w", err)
+	}
+	if rows == 0 {
+		return ErrStaleWatermark
 	}
 	return nil
 }
 
-func (r *DBClient) UpdateDomainHighestUSN(
+func (r *DBClient) UpdateNamingContextHighestUSN(
 	ctx context.Context,
 	domainID uuid.UUID,
+	ncType string,
+	invocationID uuid.UUID,
 	highestUSN int64,
 ) error {
-	err := r.queries.UpdateDomainHighestUSN(ctx, sqlcgen.UpdateDomainHighestUSNParams{
-		DomainID:   uuidToPgtype(domainID),
-		HighestUsn: pgtype.Int8{Int64: highestUSN, Valid: true},
+	rows, err := r.queries.UpdateNamingContextHighestUSN(ctx, sqlcgen.UpdateNamingContextHighestUSNParams{
+		DomainID:     uuidToPgtype(domainID),
+		NcType:       ncType,
+		InvocationID: uuidToPgtype(invocationID),
+		HighestUsn:   pgtype.Int8{Int64: highestUSN, Valid: true},
 	})
 	if err != nil {
-		return fmt.Errorf("update domain highest USN failed: %w", err)
+		return fmt.Errorf("update naming context highest USN failed: %w", err)
+	}
+	if rows == 0 {
+		return ErrStaleWatermark
+	}
+	return nil
+}
+
+// GetNamingContextDirSyncCookie returns the persisted DirSync cookie, or nil if none has been stored.
+func (r *DBClient) GetNamingContextDirSyncCookie(
+	ctx context.Context,
+	domainID uuid.UUID,
+	ncType string,
+) ([]byte, error) {
+	cookie, err := r.queries.GetNamingContextDirSyncCookie(ctx, sqlcgen.GetNamingContextDirSyncCookieParams{
+		DomainID: uuidToPgtype(domainID),
+		NcType:   ncType,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get naming context DirSync cookie failed: %w", err)
+	}
+	return cookie, nil
+}
+
+func (r *DBClient) UpdateNamingContextDirSyncCookie(
+	ctx context.Context,
+	domainID uuid.UUID,
+	ncType string,
+	cookie []byte,
+) error {
+	err := r.queries.UpdateNamingContextDirSyncCookie(ctx, sqlcgen.UpdateNamingContextDirSyncCookieParams{
+		DomainID:      uuidToPgtype(domainID),
+		NcType:        ncType,
+		DirsyncCookie: cookie,
+	})
+	if err != nil {
+		return fmt.Errorf("update naming context DirSync cookie failed: %w", err)
 	}
 	return nil
 }
@@ -263,6 +782,14 @@ func pgtypeToUUID(id pgtype.UUID) *uuid.UUID {
 	return &result
 }
 
+// nonNil returns values, or an empty slice when it is nil, for NOT NULL array columns.
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
 func pgtypeToInt64(val pgtype.Int8) *int64 {
 	if !val.Valid {
 		return nil
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// newTestClient connects to the PostgreSQL instance named by ADSPY_TEST_DSN and creates the
+// schema in a schema of its own, which is dropped when the test ends.
+func newTestClient(t *testing.T) *DBClient {
+	t.Helper()
+	dsn := os.Getenv("ADSPY_TEST_DSN")
+	if dsn == "" {
+		t.Skip("ADSPY_TEST_DSN is not set")
+	}
+
+	ctx := context.Background()
+	schemaName := fmt.Sprintf("adspy_test_%d", time.Now().UnixNano())
+	admin, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(admin.Close)
+	if _, err := admin.Exec(ctx, "CREATE SCHEMA "+schemaName); err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		admin.Exec(context.Background(), "DROP SCHEMA "+schemaName+" CASCADE")
+	})
+
+	poolConfig, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		t.Fatalf("failed to parse ADSPY_TEST_DSN: %v", err)
+	}
+	poolConfig.ConnConfig.RuntimeParams["search_path"] = schemaName
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	if _, err := pool.Exec(ctx, schemaSQL); err != nil {
+		t.Fatalf("failed to create tables: %v", err)
+	}
+	return NewDBClient(pool)
+}
+
+func TestClaimNamingContext(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	root, child := uuid.New(), uuid.New()
+	for _, domainID := range []uuid.UUID{root, child} {
+		if err := client.InsertDomain(ctx, domainID, domainID.String(), "dc1.example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const (
+		configuration      = "CN=Configuration,DC=example,DC=com"
+		otherConfiguration = "CN=Configuration,DC=other,DC=net"
+	)
+	steps := []struct {
+		name      string
+		domainID  uuid.UUID
+		dn        string
+		wantOwner uuid.UUID
+	}{
+		{"first claim", root, configuration, root},
+		{"claim by another domain of the forest", child, configuration, root},
+		{"repeated claim", root, configuration, root},
+		{"claim under a new DN moves the existing claim", root, otherConfiguration, root},
+		{"the old DN is free again", child, configuration, child},
+		{"the new DN stays with its owner", child, otherConfiguration, root},
+	}
+
+	for _, step := range steps {
+		owner, err := client.ClaimNamingContext(ctx, step.domainID, "configuration", step.dn)
+		if err != nil {
+			t.Fatalf("%s: ClaimNamingContext failed: %v", step.name, err)
+		}
+		if owner != step.wantOwner {
+			t.Errorf("%s: owner = %s, want %s", step.name, owner, step.wantOwner)
+		}
+	}
+}
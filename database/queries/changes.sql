@@ -1,10 +1,64 @@
 -- name: InsertAttributeChange :exec
 INSERT INTO AttributeChanges (
+    version_id,
     object_id,
-    usn_changed,
+    domain_id,
     attribute_schema_id,
     old_value,
     new_value,
-    timestamp
+    summary,
+    timestamp,
+    originating_dsa_dn,
+    originating_invocation_id,
+    originating_usn,
+    originating_time,
+    metadata_version
 )
-VALUES ($1, $2, $3, $4, $5, $6);
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13);
+
+-- name: InsertDNChange :exec
+INSERT INTO DNHistory (
+    version_id,
+    object_id,
+    change_type,
+    old_dn,
+    new_dn,
+    old_rdn,
+    new_rdn,
+    old_parent,
+    new_parent,
+    changed_at
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);
+
+-- name: InsertLifecycleEvent :exec
+INSERT INTO LifecycleEvents (
+    version_id,
+    object_id,
+    event_type,
+    from_state,
+    to_state,
+    event_time,
+    distinguishedName,
+    last_known_rdn,
+    last_known_parent
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);
+
+-- name: InsertLinkedValueChange :exec
+INSERT INTO LinkedValueChanges (
+    version_id,
+    object_id,
+    domain_id,
+    attribute_schema_id,
+    value_dn,
+    value_data,
+    change_type,
+    legacy,
+    originating_time,
+    originating_dsa_dn,
+    originating_invocation_id,
+    originating_usn,
+    metadata_version
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13);
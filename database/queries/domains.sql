@@ -1,10 +1,57 @@
 -- name: InsertDomain :exec
-INSERT INTO Domains (domain_id, domain_name, domain_controller, highest_usn, last_processed_usn)
-VALUES ($1, $2, $3, $4, $5)
+INSERT INTO Domains (domain_id, domain_name, domain_controller)
+VALUES ($1, $2, $3)
 ON CONFLICT (domain_id) DO NOTHING;
 
--- name: UpdateDomainLastProcessedUSN :exec
-UPDATE Domains SET last_processed_usn = $1 WHERE domain_id = $2;
+-- name: UpsertNamingContext :exec
+INSERT INTO NamingContexts (domain_id, nc_type, distinguishedName)
+VALUES ($1, $2, $3)
+ON CONFLICT (domain_id, nc_type)
+DO UPDATE SET distinguishedName = EXCLUDED.distinguishedName;
 
--- name: UpdateDomainHighestUSN :exec
-UPDATE Domains SET highest_usn = $1 WHERE domain_id = $2;
+-- name: MoveNamingContext :exec
+-- A claim under a new DN (the domain joined another forest) takes the domain's existing row with
+-- it, restarting its watermark, unless another domain already polls the new DN.
+UPDATE NamingContexts
+SET distinguishedName = $3,
+    domain_controller = NULL,
+    invocation_id = NULL,
+    ds_service_name = NULL,
+    last_processed_usn = NULL,
+    highest_usn = NULL,
+    dirsync_cookie = NULL
+WHERE domain_id = $1 AND nc_type = $2 AND distinguishedName <> $3
+  AND NOT EXISTS (SELECT 1 FROM NamingContexts WHERE distinguishedName = $3);
+
+-- name: ClaimNamingContext :one
+INSERT INTO NamingContexts (domain_id, nc_type, distinguishedName)
+VALUES ($1, $2, $3)
+ON CONFLICT (distinguishedName)
+DO UPDATE SET distinguishedName = EXCLUDED.distinguishedName
+RETURNING domain_id;
+
+-- name: GetNamingContextWatermark :one
+SELECT domain_controller, invocation_id, ds_service_name, last_processed_usn, highest_usn
+FROM NamingContexts
+WHERE domain_id = $1 AND nc_type = $2;
+
+-- name: ResetNamingContextWatermark :exec
+UPDATE NamingContexts
+SET domain_controller = $1,
+    invocation_id = $2,
+    ds_service_name = $3,
+    last_processed_usn = $4,
+    highest_usn = $5
+WHERE domain_id = $6 AND nc_type = $7;
+
+-- name: UpdateNamingContextLastProcessedUSN :execrows
+UPDATE NamingContexts SET last_processed_usn = $1 WHERE domain_id = $2 AND nc_type = $3 AND invocation_id = $4;
+
+-- name: UpdateNamingContextHighestUSN :execrows
+UPDATE NamingContexts SET highest_usn = $1 WHERE domain_id = $2 AND nc_type = $3 AND invocation_id = $4;
+
+-- name: GetNamingContextDirSyncCookie :one
+SELECT dirsync_cookie FROM NamingContexts WHERE domain_id = $1 AND nc_type = $2;
+
+-- name: UpdateNamingContextDirSyncCookie :exec
+UPDATE NamingContexts SET dirsync_cookie = $1 WHERE domain_id = $2 AND nc_type = $3;
@@ -1,14 +1,41 @@
 -- name: UpsertObject :one
-INSERT INTO Objects (object_id, object_type, distinguishedName, domain_id)
-VALUES ($1, $2, $3, $4)
+WITH previous AS (
+    SELECT distinguishedName FROM Objects WHERE object_id = $1
+)
+INSERT INTO Objects (object_id, object_type, distinguishedName, domain_id, naming_context)
+VALUES ($1, $2, $3, $4, $5)
 ON CONFLICT (object_id)
 DO UPDATE SET
     updated_at = NOW(),
     distinguishedName = EXCLUDED.distinguishedName,
     object_type = EXCLUDED.object_type
-RETURNING last_processed_usn;
+RETURNING current_version_id, COALESCE((SELECT distinguishedName FROM previous), '')::text AS previous_dn;
 
--- name: UpdateLastProcessedUSN :exec
+-- name: UpdateCurrentVersion :exec
 UPDATE Objects
-SET last_processed_usn = $1
+SET current_version_id = $1
 WHERE object_id = $2;
+
+-- name: MarkObjectDeleted :exec
+UPDATE Objects
+SET deleted_at = $2,
+    last_live_dn = $3,
+    last_known_parent = $4
+WHERE object_id = $1;
+
+-- name: MarkObjectRestored :exec
+UPDATE Objects
+SET deleted_at = NULL
+WHERE object_id = $1;
+
+-- name: UpdateObjectLifecycleState :exec
+UPDATE Objects
+SET lifecycle_state = $2
+WHERE object_id = $1;
+
+-- name: UpdateDescendantDNs :exec
+UPDATE Objects
+SET distinguishedName = left(distinguishedName, length(distinguishedName) - length($1::text)) || $2::text
+WHERE domain_id = $3
+  AND length(distinguishedName) > length($1::text)
+  AND lower(right(distinguishedName, length($1::text) + 1)) = lower(',' || $1::text);
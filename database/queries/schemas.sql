@@ -1,21 +1,66 @@
 -- name: UpsertAttributeSchema :exec
 INSERT INTO AttributeSchemas (
     object_guid, domain_id, ldap_display_name, attribute_name, attribute_id,
-    attribute_syntax, om_syntax, syntax_name, is_single_valued
+    attribute_syntax, om_syntax, syntax_name, is_single_valued,
+    schema_id_guid, attribute_security_guid
 )
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-ON CONFLICT (object_guid)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+ON CONFLICT (domain_id, object_guid)
 DO UPDATE SET
-    domain_id = EXCLUDED.domain_id,
     ldap_display_name = EXCLUDED.ldap_display_name,
     attribute_name = EXCLUDED.attribute_name,
     attribute_id = EXCLUDED.attribute_id,
     attribute_syntax = EXCLUDED.attribute_syntax,
     om_syntax = EXCLUDED.om_syntax,
     syntax_name = EXCLUDED.syntax_name,
-    is_single_valued = EXCLUDED.is_single_valued;
+    is_single_valued = EXCLUDED.is_single_valued,
+    schema_id_guid = EXCLUDED.schema_id_guid,
+    attribute_security_guid = EXCLUDED.attribute_security_guid;
 
 -- name: GetAttributeSchemaByLDAPName :one
 SELECT object_guid
 FROM AttributeSchemas
 WHERE domain_id = $1 AND ldap_display_name = $2;
+
+-- name: UpsertClassSchema :exec
+INSERT INTO ClassSchemas (
+    object_guid, domain_id, ldap_display_name, class_name, governs_id, schema_id_guid,
+    distinguishedName, sub_class_of, object_class_category, auxiliary_classes,
+    must_contain, may_contain, default_security_descriptor, default_object_category
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+ON CONFLICT (domain_id, object_guid)
+DO UPDATE SET
+    ldap_display_name = EXCLUDED.ldap_display_name,
+    class_name = EXCLUDED.class_name,
+    governs_id = EXCLUDED.governs_id,
+    schema_id_guid = EXCLUDED.schema_id_guid,
+    distinguishedName = EXCLUDED.distinguishedName,
+    sub_class_of = EXCLUDED.sub_class_of,
+    object_class_category = EXCLUDED.object_class_category,
+    auxiliary_classes = EXCLUDED.auxiliary_classes,
+    must_contain = EXCLUDED.must_contain,
+    may_contain = EXCLUDED.may_contain,
+    default_security_descriptor = EXCLUDED.default_security_descriptor,
+    default_object_category = EXCLUDED.default_object_category;
+
+-- name: InsertSchemaChangeEvent :exec
+INSERT INTO SchemaChangeEvents (
+    domain_id, schema_modified_at, added_attributes, modified_attributes, removed_attributes,
+    added_classes, modified_classes, removed_classes
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (domain_id, schema_modified_at) DO NOTHING;
+
+-- name: UpsertExtendedRight :exec
+INSERT INTO ExtendedRights (
+    domain_id, rights_guid, object_guid, name, display_name, valid_accesses, applies_to
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (domain_id, rights_guid)
+DO UPDATE SET
+    object_guid = EXCLUDED.object_guid,
+    name = EXCLUDED.name,
+    display_name = EXCLUDED.display_name,
+    valid_accesses = EXCLUDED.valid_accesses,
+    applies_to = EXCLUDED.applies_to;
@@ -1,8 +1,9 @@
--- name: InsertVersion :exec
-INSERT INTO ObjectVersions (object_id, usn_changed, timestamp, attributes_snapshot, modified_by)
-VALUES ($1, $2, $3, $4, $5);
+-- name: InsertVersion :one
+INSERT INTO ObjectVersions (object_id, invocation_id, usn_changed, timestamp, attributes_snapshot, modified_by)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING version_id;
 
--- name: GetPreviousSnapshot :one
-SELECT attributes_snapshot
+-- name: GetVersion :one
+SELECT invocation_id, usn_changed, attributes_snapshot
 FROM ObjectVersions
-WHERE object_id = $1 AND usn_changed = $2;
+WHERE version_id = $1;
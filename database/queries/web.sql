@@ -1,39 +1,105 @@
 -- name: ListObjectsForWeb :many
-SELECT object_id, object_type, distinguishedName, updated_at, deleted_at
-FROM Objects
-WHERE deleted_at IS NULL
-  AND ($1::text = '' OR object_type = $1)
-  AND ($2::text = '' OR distinguishedName ILIKE '%' || $2 || '%')
-ORDER BY updated_at DESC
-LIMIT $3 OFFSET $4;
+SELECT o.object_id, o.object_type, o.distinguishedName, o.naming_context, o.updated_at, o.deleted_at, o.last_live_dn, o.last_known_parent,
+       c.ldap_display_name AS class_name
+FROM Objects o
+LEFT JOIN ClassSchemas c ON c.domain_id = o.domain_id AND c.distinguishedName = o.object_type
+WHERE CASE $3::text
+        WHEN 'deleted' THEN o.deleted_at IS NOT NULL
+        WHEN 'all' THEN TRUE
+        ELSE o.deleted_at IS NULL
+      END
+  AND ($1::text = '' OR o.object_type = $1)
+  AND ($6::text = '' OR o.naming_context = $6)
+  AND ($2::text = ''
+       OR o.distinguishedName ILIKE '%' || $2 || '%'
+       OR o.last_live_dn ILIKE '%' || $2 || '%'
+       OR EXISTS (
+           SELECT 1 FROM DNHistory h
+           WHERE h.object_id = o.object_id AND h.old_dn ILIKE '%' || $2 || '%'
+       ))
+ORDER BY o.updated_at DESC
+LIMIT $4 OFFSET $5;
 
 -- name: CountObjectsForWeb :one
 SELECT COUNT(*) as total
 FROM Objects
-WHERE deleted_at IS NULL
+WHERE CASE $3::text
+        WHEN 'deleted' THEN deleted_at IS NOT NULL
+        WHEN 'all' THEN TRUE
+        ELSE deleted_at IS NULL
+      END
   AND ($1::text = '' OR object_type = $1)
-  AND ($2::text = '' OR distinguishedName ILIKE '%' || $2 || '%');
+  AND ($4::text = '' OR naming_context = $4)
+  AND ($2::text = ''
+       OR distinguishedName ILIKE '%' || $2 || '%'
+       OR last_live_dn ILIKE '%' || $2 || '%'
+       OR EXISTS (
+           SELECT 1 FROM DNHistory h
+           WHERE h.object_id = Objects.object_id AND h.old_dn ILIKE '%' || $2 || '%'
+       ));
 
 -- name: GetObjectByID :one
-SELECT object_id, object_type, distinguishedName, updated_at, deleted_at
-FROM Objects
-WHERE object_id = $1;
+SELECT o.object_id, o.object_type, o.distinguishedName, o.naming_context, o.updated_at, o.deleted_at, o.last_live_dn, o.last_known_parent,
+       c.ldap_display_name AS class_name
+FROM Objects o
+LEFT JOIN ClassSchemas c ON c.domain_id = o.domain_id AND c.distinguishedName = o.object_type
+WHERE o.object_id = $1;
 
 -- name: GetObjectTimeline :many
-SELECT usn_changed, timestamp, attributes_snapshot, modified_by
-FROM ObjectVersions
-WHERE object_id = $1
-ORDER BY usn_changed DESC;
+SELECT v.version_id, v.invocation_id, v.usn_changed, v.timestamp, v.attributes_snapshot, v.modified_by,
+       le.event_type, le.from_state, le.to_state, le.event_time, le.distinguishedName AS event_dn, le.last_known_rdn, le.last_known_parent,
+       dh.change_type AS dn_change_type, dh.old_dn, dh.new_dn, dh.old_rdn, dh.new_rdn, dh.old_parent, dh.new_parent, dh.changed_at
+FROM ObjectVersions v
+LEFT JOIN LifecycleEvents le ON le.version_id = v.version_id
+LEFT JOIN DNHistory dh ON dh.version_id = v.version_id
+WHERE v.object_id = $1
+ORDER BY v.version_id DESC;
 
 -- name: GetVersionChanges :many
-SELECT ac.attribute_schema_id, s.ldap_display_name, ac.old_value, ac.new_value, ac.timestamp, s.is_single_valued
+SELECT ac.attribute_schema_id, s.ldap_display_name, ac.old_value, ac.new_value, ac.summary, ac.timestamp, s.is_single_valued,
+       ac.originating_dsa_dn, ac.originating_usn, ac.originating_time, ac.metadata_version
 FROM AttributeChanges ac
-JOIN AttributeSchemas s ON ac.attribute_schema_id = s.object_guid
-WHERE ac.object_id = $1 AND ac.usn_changed = $2
+JOIN AttributeSchemas s ON s.domain_id = ac.domain_id AND s.object_guid = ac.attribute_schema_id
+WHERE ac.object_id = $1 AND ac.version_id = $2
 ORDER BY s.ldap_display_name;
 
+-- name: GetVersionLinkedValueChanges :many
+SELECT lv.attribute_schema_id, lv.value_dn, lv.value_data, lv.change_type, lv.legacy, lv.originating_time, lv.originating_dsa_dn, lv.originating_usn
+FROM LinkedValueChanges lv
+WHERE lv.object_id = $1 AND lv.version_id = $2
+ORDER BY lv.originating_time, lv.value_dn, lv.value_data;
+
 -- name: GetObjectTypes :many
 SELECT DISTINCT object_type
 FROM Objects
 WHERE deleted_at IS NULL
 ORDER BY object_type;
+
+-- name: ListSchemaChangeEvents :many
+SELECT domain_id, schema_modified_at, detected_at, added_attributes, modified_attributes, removed_attributes,
+       added_classes, modified_classes, removed_classes
+FROM SchemaChangeEvents
+ORDER BY schema_modified_at DESC
+LIMIT $1;
+
+-- name: ListAttributeSchemaGUIDs :many
+SELECT DISTINCT schema_id_guid, ldap_display_name
+FROM AttributeSchemas;
+
+-- name: ListClassSchemaGUIDs :many
+SELECT DISTINCT schema_id_guid, ldap_display_name
+FROM ClassSchemas;
+
+-- name: ListExtendedRights :many
+SELECT rights_guid, display_name, valid_accesses, applies_to
+FROM ExtendedRights;
+
+-- name: GetPrincipalBySID :one
+SELECT o.distinguishedName, o.deleted_at, o.last_live_dn,
+       COALESCE(v.attributes_snapshot -> 'sAMAccountName' ->> 0, '')::text AS sam_account_name,
+       COALESCE(v.attributes_snapshot -> 'name' ->> 0, '')::text AS name
+FROM ObjectVersions v
+JOIN Objects o ON o.object_id = v.object_id
+WHERE v.attributes_snapshot -> 'objectSid' @> jsonb_build_array($1::text)
+ORDER BY v.timestamp DESC
+LIMIT 1;
@@ -1,44 +1,119 @@
 CREATE TABLE Domains (
     domain_id UUID PRIMARY KEY,
     domain_name VARCHAR(255) NOT NULL,
-    domain_controller VARCHAR NOT NULL,
+    domain_controller VARCHAR NOT NULL
+);
+
+-- Monitored naming contexts of a domain, each polled from its own watermark or DirSync cookie
+CREATE TABLE NamingContexts (
+    domain_id UUID NOT NULL,
+    nc_type VARCHAR(16) NOT NULL, -- domain, configuration or schema
+    distinguishedName TEXT NOT NULL, -- unique, so a forest-wide naming context is polled by one domain
+    domain_controller VARCHAR,
     last_processed_usn BIGINT,
-    highest_usn BIGINT
+    highest_usn BIGINT,
+    invocation_id UUID,
+    ds_service_name TEXT,
+    dirsync_cookie BYTEA,
+    PRIMARY KEY (domain_id, nc_type)
 );
 
 CREATE TABLE Objects (
     object_id UUID PRIMARY KEY,
     object_type VARCHAR(255) NOT NULL,
     distinguishedName TEXT NOT NULL,
-    last_processed_usn BIGINT,
+    current_version_id BIGINT, -- latest version of the object
     domain_id UUID NOT NULL,
+    naming_context VARCHAR(16) NOT NULL, -- nc_type of the naming context the object lives in
     created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-    deleted_at TIMESTAMP
+    deleted_at TIMESTAMP,
+    last_live_dn TEXT, -- DN before the object moved to Deleted Objects
+    last_known_parent TEXT, -- lastKnownParent of the deleted object
+    lifecycle_state VARCHAR(16) NOT NULL DEFAULT 'live' -- live, deleted or recycled
 );
 
+-- Versions of each object. uSNChanged is local to the replica it was read from, so a version is
+-- identified by version_id, which also orders the versions of an object as they were recorded
 CREATE TABLE ObjectVersions (
+    version_id BIGSERIAL PRIMARY KEY,
     object_id UUID NOT NULL,
+    invocation_id UUID NOT NULL, -- invocationId of the replica usn_changed was read from
     usn_changed BIGINT NOT NULL,
     timestamp TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
     attributes_snapshot JSONB NOT NULL,
     modified_by VARCHAR(255),
-    PRIMARY KEY (object_id, usn_changed)
+    UNIQUE (object_id, invocation_id, usn_changed)
 );
 
 CREATE TABLE AttributeChanges (
+    version_id BIGINT NOT NULL,
     object_id UUID NOT NULL,
-    usn_changed BIGINT NOT NULL,
+    domain_id UUID NOT NULL, -- domain whose attribute schema attribute_schema_id refers to
     attribute_schema_id UUID NOT NULL,
     old_value JSONB,
     new_value JSONB,
+    summary TEXT, -- the change in words, e.g. the flags set and cleared in a bitmask
     timestamp TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-    PRIMARY KEY (object_id, usn_changed, attribute_schema_id)
+    -- Originating write from msDS-ReplAttributeMetaData, NULL when the DC reported none
+    originating_dsa_dn TEXT,
+    originating_invocation_id UUID,
+    originating_usn BIGINT,
+    originating_time TIMESTAMP,
+    metadata_version INTEGER,
+    PRIMARY KEY (version_id, attribute_schema_id)
+);
+
+-- Linked value adds/removes (e.g. group membership), attributed per value from msDS-ReplValueMetaData
+CREATE TABLE LinkedValueChanges (
+    version_id BIGINT NOT NULL,
+    object_id UUID NOT NULL,
+    domain_id UUID NOT NULL, -- domain whose attribute schema attribute_schema_id refers to
+    attribute_schema_id UUID NOT NULL,
+    value_dn TEXT NOT NULL,
+    value_data TEXT NOT NULL DEFAULT '', -- binary data (hex) or string of a DN-Binary or DN-String value
+    change_type VARCHAR(16) NOT NULL, -- added or removed
+    legacy BOOLEAN NOT NULL, -- value predates linked value replication and has no metadata of its own
+    originating_time TIMESTAMP,
+    originating_dsa_dn TEXT,
+    originating_invocation_id UUID,
+    originating_usn BIGINT,
+    metadata_version INTEGER,
+    PRIMARY KEY (version_id, attribute_schema_id, value_dn, value_data)
+);
+
+-- Lifecycle transitions (deleted, recycled, restored), at most one per version
+CREATE TABLE LifecycleEvents (
+    version_id BIGINT NOT NULL,
+    object_id UUID NOT NULL,
+    event_type VARCHAR(16) NOT NULL,
+    from_state VARCHAR(16) NOT NULL,
+    to_state VARCHAR(16) NOT NULL,
+    event_time TIMESTAMP NOT NULL,
+    distinguishedName TEXT NOT NULL, -- DN after the transition
+    last_known_rdn TEXT, -- msDS-LastKnownRDN, set while the object is deleted
+    last_known_parent TEXT,
+    PRIMARY KEY (version_id)
 );
 
--- Attribute Schema Registry
+-- Renames and moves, at most one per version
+CREATE TABLE DNHistory (
+    version_id BIGINT NOT NULL,
+    object_id UUID NOT NULL,
+    change_type VARCHAR(16) NOT NULL, -- renamed, moved or renamed+moved
+    old_dn TEXT NOT NULL,
+    new_dn TEXT NOT NULL,
+    old_rdn TEXT NOT NULL,
+    new_rdn TEXT NOT NULL,
+    old_parent TEXT NOT NULL,
+    new_parent TEXT NOT NULL,
+    changed_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (version_id)
+);
+
+-- Attribute Schema Registry, kept per domain
 CREATE TABLE AttributeSchemas (
-    object_guid UUID PRIMARY KEY,
+    object_guid UUID NOT NULL,
     domain_id UUID NOT NULL,
     ldap_display_name VARCHAR(255) NOT NULL,
     attribute_name VARCHAR(255) NOT NULL,
@@ -46,31 +121,108 @@ CREATE TABLE AttributeSchemas (
     attribute_syntax VARCHAR(255) NOT NULL,
     om_syntax VARCHAR(50) NOT NULL,
     syntax_name VARCHAR(255),
-    is_single_valued BOOLEAN NOT NULL DEFAULT false
+    is_single_valued BOOLEAN NOT NULL DEFAULT false,
+    schema_id_guid UUID NOT NULL, -- object type GUID of the attribute in ACEs
+    attribute_security_guid UUID, -- property set the attribute belongs to
+    PRIMARY KEY (domain_id, object_guid)
 );
 
+-- Class Schema Registry; must_contain and may_contain include the system-only attributes
+CREATE TABLE ClassSchemas (
+    object_guid UUID NOT NULL,
+    domain_id UUID NOT NULL,
+    ldap_display_name VARCHAR(255) NOT NULL,
+    class_name VARCHAR(255) NOT NULL,
+    governs_id VARCHAR(255) NOT NULL,
+    schema_id_guid UUID NOT NULL,
+    distinguishedName TEXT NOT NULL, -- the objectCategory of objects of this class
+    sub_class_of VARCHAR(255) NOT NULL,
+    object_class_category INTEGER NOT NULL, -- 0 = 88 class, 1 = structural, 2 = abstract, 3 = auxiliary
+    auxiliary_classes TEXT[] NOT NULL,
+    must_contain TEXT[] NOT NULL,
+    may_contain TEXT[] NOT NULL,
+    default_security_descriptor TEXT,
+    default_object_category TEXT,
+    PRIMARY KEY (domain_id, object_guid)
+);
+
+-- Extended rights, validated writes and property sets (controlAccessRight objects)
+CREATE TABLE ExtendedRights (
+    domain_id UUID NOT NULL,
+    rights_guid UUID NOT NULL, -- object type GUID of the right in ACEs
+    object_guid UUID NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    display_name VARCHAR(255) NOT NULL,
+    valid_accesses INTEGER NOT NULL, -- 8 = validated write, 48 = property set, 256 = extended right
+    applies_to TEXT[] NOT NULL, -- schemaIDGUIDs of the classes the right applies to
+    PRIMARY KEY (domain_id, rights_guid)
+);
+
+-- Schema updates detected while polling, with the attributes and classes each one added, modified or removed
+CREATE TABLE SchemaChangeEvents (
+    domain_id UUID NOT NULL,
+    schema_modified_at TIMESTAMP NOT NULL, -- modifyTimeStamp of the subschema entry
+    detected_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    added_attributes TEXT[] NOT NULL,
+    modified_attributes TEXT[] NOT NULL,
+    removed_attributes TEXT[] NOT NULL,
+    added_classes TEXT[] NOT NULL,
+    modified_classes TEXT[] NOT NULL,
+    removed_classes TEXT[] NOT NULL,
+    PRIMARY KEY (domain_id, schema_modified_at)
+);
 
 -- Foreign key constraints
+ALTER TABLE NamingContexts
+ADD CONSTRAINT fk_naming_contexts_domain_id FOREIGN KEY (domain_id) REFERENCES Domains(domain_id);
+
 ALTER TABLE Objects
-ADD CONSTRAINT fk_objects_domain_id FOREIGN KEY (domain_id) REFERENCES Domains(domain_id);
+ADD CONSTRAINT fk_objects_domain_id FOREIGN KEY (domain_id) REFERENCES Domains(domain_id),
+ADD CONSTRAINT fk_objects_naming_context FOREIGN KEY (domain_id, naming_context) REFERENCES NamingContexts(domain_id, nc_type);
 
 ALTER TABLE ObjectVersions
 ADD CONSTRAINT fk_object_versions_object_id FOREIGN KEY (object_id) REFERENCES Objects(object_id);
 
 ALTER TABLE AttributeChanges
-ADD CONSTRAINT fk_attribute_changes_version FOREIGN KEY (object_id, usn_changed) REFERENCES ObjectVersions(object_id, usn_changed),
-ADD CONSTRAINT fk_attribute_changes_schema FOREIGN KEY (attribute_schema_id) REFERENCES AttributeSchemas(object_guid);
+ADD CONSTRAINT fk_attribute_changes_version FOREIGN KEY (version_id) REFERENCES ObjectVersions(version_id),
+ADD CONSTRAINT fk_attribute_changes_schema FOREIGN KEY (domain_id, attribute_schema_id) REFERENCES AttributeSchemas(domain_id, object_guid);
+
+ALTER TABLE LinkedValueChanges
+ADD CONSTRAINT fk_linked_value_changes_version FOREIGN KEY (version_id) REFERENCES ObjectVersions(version_id),
+ADD CONSTRAINT fk_linked_value_changes_schema FOREIGN KEY (domain_id, attribute_schema_id) REFERENCES AttributeSchemas(domain_id, object_guid);
+
+ALTER TABLE LifecycleEvents
+ADD CONSTRAINT fk_lifecycle_events_version FOREIGN KEY (version_id) REFERENCES ObjectVersions(version_id);
+
+ALTER TABLE DNHistory
+ADD CONSTRAINT fk_dn_history_version FOREIGN KEY (version_id) REFERENCES ObjectVersions(version_id);
+
+ALTER TABLE SchemaChangeEvents
+ADD CONSTRAINT fk_schema_change_events_domain_id FOREIGN KEY (domain_id) REFERENCES Domains(domain_id);
 
 ALTER TABLE AttributeSchemas
 ADD CONSTRAINT fk_attribute_schemas_domain_id FOREIGN KEY (domain_id) REFERENCES Domains(domain_id);
 
+ALTER TABLE ClassSchemas
+ADD CONSTRAINT fk_class_schemas_domain_id FOREIGN KEY (domain_id) REFERENCES Domains(domain_id);
+
+ALTER TABLE ExtendedRights
+ADD CONSTRAINT fk_extended_rights_domain_id FOREIGN KEY (domain_id) REFERENCES Domains(domain_id);
+
 -- Indexes
+CREATE UNIQUE INDEX idx_naming_contexts_dn ON NamingContexts(distinguishedName);
 CREATE INDEX idx_objects_domain_id ON Objects(domain_id);
 CREATE INDEX idx_objects_object_type ON Objects(object_type);
+CREATE INDEX idx_objects_naming_context ON Objects(naming_context);
 CREATE INDEX idx_objects_dn ON Objects(distinguishedName);
 CREATE INDEX idx_object_versions_object_id ON ObjectVersions(object_id);
 CREATE INDEX idx_object_versions_timestamp ON ObjectVersions(timestamp);
+CREATE INDEX idx_object_versions_object_sid ON ObjectVersions USING GIN ((attributes_snapshot -> 'objectSid'));
 CREATE INDEX idx_attribute_changes_object_id ON AttributeChanges(object_id);
-CREATE INDEX idx_attribute_changes_usn ON AttributeChanges(usn_changed);
-CREATE INDEX idx_attribute_changes_schema_id ON AttributeChanges(attribute_schema_id);
+CREATE INDEX idx_attribute_changes_schema_id ON AttributeChanges(domain_id, attribute_schema_id);
+CREATE INDEX idx_dn_history_object_id ON DNHistory(object_id);
+CREATE INDEX idx_lifecycle_events_type ON LifecycleEvents(event_type);
+CREATE INDEX idx_linked_value_changes_value_dn ON LinkedValueChanges(value_dn);
 CREATE UNIQUE INDEX idx_attribute_schemas_domain_ldap ON AttributeSchemas(domain_id, ldap_display_name);
+CREATE UNIQUE INDEX idx_class_schemas_domain_ldap ON ClassSchemas(domain_id, ldap_display_name);
+CREATE INDEX idx_class_schemas_dn ON ClassSchemas(distinguishedName);
@@ -13,33 +13,195 @@ import (
 
 const insertAttributeChange = `-- name: InsertAttributeChange :exec
 INSERT INTO AttributeChanges (
+    version_id,
     object_id,
-    usn_changed,
+    domain_id,
     attribute_schema_id,
     old_value,
     new_value,
-    timestamp
+    summary,
+    timestamp,
+    originating_dsa_dn,
+    originating_invocation_id,
+    originating_usn,
+    originating_time,
+    metadata_version
 )
-VALUES ($1, $2, $3, $4, $5, $6)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
 `
 
 type InsertAttributeChangeParams struct {
-	ObjectID          pgtype.UUID      `json:"object_id"`
-	UsnChanged        int64            `json:"usn_changed"`
-	AttributeSchemaID pgtype.UUID      `json:"attribute_schema_id"`
-	OldValue          []byte           `json:"old_value"`
-	NewValue          []byte           `json:"new_value"`
-	Timestamp         pgtype.Timestamp `json:"timestamp"`
+	VersionID               int64            `json:"version_id"`
+	ObjectID                pgtype.UUID      `json:"object_id"`
+	DomainID                pgtype.UUID      `json:"domain_id"`
+	AttributeSchemaID       pgtype.UUID      `json:"attribute_schema_id"`
+	OldValue                []byte           `json:"old_value"`
+	NewValue                []byte           `json:"new_value"`
+	Summary                 pgtype.Text      `json:"summary"`
+	Timestamp               pgtype.Timestamp `json:"timestamp"`
+	OriginatingDsaDn        pgtype.Text      `json:"originating_dsa_dn"`
+	OriginatingInvocationID pgtype.UUID      `json:"originating_invocation_id"`
+	OriginatingUsn          pgtype.Int8      `json:"originating_usn"`
+	OriginatingTime         pgtype.Timestamp `json:"originating_time"`
+	MetadataVersion         pgtype.Int4      `json:"metadata_version"`
 }
 
 func (q *Queries) InsertAttributeChange(ctx context.Context, arg InsertAttributeChangeParams) error {
 	_, err := q.db.Exec(ctx, insertAttributeChange,
+		arg.VersionID,
 		arg.ObjectID,
-		arg.UsnChanged,
+		arg.DomainID,
 		arg.AttributeSchemaID,
 		arg.OldValue,
 		arg.NewValue,
+		arg.Summary,
 		arg.Timestamp,
+		arg.OriginatingDsaDn,
+		arg.OriginatingInvocationID,
+		arg.OriginatingUsn,
+		arg.OriginatingTime,
+		arg.MetadataVersion,
+	)
+	return err
+}
+
+const insertDNChange = `-- name: InsertDNChange :exec
+INSERT INTO DNHistory (
+    version_id,
+    object_id,
+    change_type,
+    old_dn,
+    new_dn,
+    old_rdn,
+    new_rdn,
+    old_parent,
+    new_parent,
+    changed_at
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+`
+
+type InsertDNChangeParams struct {
+	VersionID  int64            `json:"version_id"`
+	ObjectID   pgtype.UUID      `json:"object_id"`
+	ChangeType string           `json:"change_type"`
+	OldDn      string           `json:"old_dn"`
+	NewDn      string           `json:"new_dn"`
+	OldRdn     string           `json:"old_rdn"`
+	NewRdn     string           `json:"new_rdn"`
+	OldParent  string           `json:"old_parent"`
+	NewParent  string           `json:"new_parent"`
+	ChangedAt  pgtype.Timestamp `json:"changed_at"`
+}
+
+func (q *Queries) InsertDNChange(ctx context.Context, arg InsertDNChangeParams) error {
+	_, err := q.db.Exec(ctx, insertDNChange,
+		arg.VersionID,
+		arg.ObjectID,
+		arg.ChangeType,
+		arg.OldDn,
+		arg.NewDn,
+		arg.OldRdn,
+		arg.NewRdn,
+		arg.OldParent,
+		arg.NewParent,
+		arg.ChangedAt,
+	)
+	return err
+}
+
+const insertLifecycleEvent = `-- name: InsertLifecycleEvent :exec
+INSERT INTO LifecycleEvents (
+    version_id,
+    object_id,
+    event_type,
+    from_state,
+    to_state,
+    event_time,
+    distinguishedName,
+    last_known_rdn,
+    last_known_parent
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+`
+
+type InsertLifecycleEventParams struct {
+	VersionID         int64            `json:"version_id"`
+	ObjectID          pgtype.UUID      `json:"object_id"`
+	EventType         string           `json:"event_type"`
+	FromState         string           `json:"from_state"`
+	ToState           string           `json:"to_state"`
+	EventTime         pgtype.Timestamp `json:"event_time"`
+	Distinguishedname string           `json:"distinguishedname"`
+	LastKnownRdn      pgtype.Text      `json:"last_known_rdn"`
+	LastKnownParent   pgtype.Text      `json:"last_known_parent"`
+}
+
+func (q *Queries) InsertLifecycleEvent(ctx context.Context, arg InsertLifecycleEventParams) error {
+	_, err := q.db.Exec(ctx, insertLifecycleEvent,
+		arg.VersionID,
+		arg.ObjectID,
+		arg.EventType,
+		arg.FromState,
+		arg.ToState,
+		arg.EventTime,
+		arg.Distinguishedname,
+		arg.LastKnownRdn,
+		arg.LastKnownParent,
+	)
+	return err
+}
+
+const insertLinkedValueChange = `-- name: InsertLinkedValueChange :exec
+INSERT INTO LinkedValueChanges (
+    version_id,
+    object_id,
+    domain_id,
+    attribute_schema_id,
+    value_dn,
+    value_data,
+    change_type,
+    legacy,
+    originating_time,
+    originating_dsa_dn,
+    originating_invocation_id,
+    originating_usn,
+    metadata_version
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+`
+
+type InsertLinkedValueChangeParams struct {
+	VersionID               int64            `json:"version_id"`
+	ObjectID                pgtype.UUID      `json:"object_id"`
+	DomainID                pgtype.UUID      `json:"domain_id"`
+	AttributeSchemaID       pgtype.UUID      `json:"attribute_schema_id"`
+	ValueDn                 string           `json:"value_dn"`
+	ValueData               string           `json:"value_data"`
+	ChangeType              string           `json:"change_type"`
+	Legacy                  bool             `json:"legacy"`
+	OriginatingTime         pgtype.Timestamp `json:"originating_time"`
+	OriginatingDsaDn        pgtype.Text      `json:"originating_dsa_dn"`
+	OriginatingInvocationID pgtype.UUID      `json:"originating_invocation_id"`
+	OriginatingUsn          pgtype.Int8      `json:"originating_usn"`
+	MetadataVersion         pgtype.Int4      `json:"metadata_version"`
+}
+
+func (q *Queries) InsertLinkedValueChange(ctx context.Context, arg InsertLinkedValueChangeParams) error {
+	_, err := q.db.Exec(ctx, insertLinkedValueChange,
+		arg.VersionID,
+		arg.ObjectID,
+		arg.DomainID,
+		arg.AttributeSchemaID,
+		arg.ValueDn,
+		arg.ValueData,
+		arg.ChangeType,
+		arg.Legacy,
+		arg.OriginatingTime,
+		arg.OriginatingDsaDn,
+		arg.OriginatingInvocationID,
+		arg.OriginatingUsn,
+		arg.MetadataVersion,
 	)
 	return err
 }
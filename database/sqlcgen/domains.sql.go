@@ -11,9 +11,78 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const claimNamingContext = `-- name: ClaimNamingContext :one
+INSERT INTO NamingContexts (domain_id, nc_type, distinguishedName)
+VALUES ($1, $2, $3)
+ON CONFLICT (distinguishedName)
+DO UPDATE SET distinguishedName = EXCLUDED.distinguishedName
+RETURNING domain_id
+`
+
+type ClaimNamingContextParams struct {
+	DomainID          pgtype.UUID `json:"domain_id"`
+	NcType            string      `json:"nc_type"`
+	Distinguishedname string      `json:"distinguishedname"`
+}
+
+func (q *Queries) ClaimNamingContext(ctx context.Context, arg ClaimNamingContextParams) (pgtype.UUID, error) {
+	row := q.db.QueryRow(ctx, claimNamingContext, arg.DomainID, arg.NcType, arg.Distinguishedname)
+	var domain_id pgtype.UUID
+	err := row.Scan(&domain_id)
+	return domain_id, err
+}
+
+const getNamingContextDirSyncCookie = `-- name: GetNamingContextDirSyncCookie :one
+SELECT dirsync_cookie FROM NamingContexts WHERE domain_id = $1 AND nc_type = $2
+`
+
+type GetNamingContextDirSyncCookieParams struct {
+	DomainID pgtype.UUID `json:"domain_id"`
+	NcType   string      `json:"nc_type"`
+}
+
+func (q *Queries) GetNamingContextDirSyncCookie(ctx context.Context, arg GetNamingContextDirSyncCookieParams) ([]byte, error) {
+	row := q.db.QueryRow(ctx, getNamingContextDirSyncCookie, arg.DomainID, arg.NcType)
+	var dirsync_cookie []byte
+	err := row.Scan(&dirsync_cookie)
+	return dirsync_cookie, err
+}
+
+const getNamingContextWatermark = `-- name: GetNamingContextWatermark :one
+SELECT domain_controller, invocation_id, ds_service_name, last_processed_usn, highest_usn
+FROM NamingContexts
+WHERE domain_id = $1 AND nc_type = $2
+`
+
+type GetNamingContextWatermarkParams struct {
+	DomainID pgtype.UUID `json:"domain_id"`
+	NcType   string      `json:"nc_type"`
+}
+
+type GetNamingContextWatermarkRow struct {
+	DomainController pgtype.Text `json:"domain_controller"`
+	InvocationID     pgtype.UUID `json:"invocation_id"`
+	DsServiceName    pgtype.Text `json:"ds_service_name"`
+	LastProcessedUsn pgtype.Int8 `json:"last_processed_usn"`
+	HighestUsn       pgtype.Int8 `json:"highest_usn"`
+}
+
+func (q *Queries) GetNamingContextWatermark(ctx context.Context, arg GetNamingContextWatermarkParams) (GetNamingContextWatermarkRow, error) {
+	row := q.db.QueryRow(ctx, getNamingContextWatermark, arg.DomainID, arg.NcType)
+	var i GetNamingContextWatermarkRow
+	err := row.Scan(
+		&i.DomainController,
+		&i.InvocationID,
+		&i.DsServiceName,
+		&i.LastProcessedUsn,
+		&i.HighestUsn,
+	)
+	return i, err
+}
+
 const insertDomain = `-- name: InsertDomain :exec
-INSERT INTO Domains (domain_id, domain_name, domain_controller, highest_usn, last_processed_usn)
-VALUES ($1, $2, $3, $4, $5)
+INSERT INTO Domains (domain_id, domain_name, domain_controller)
+VALUES ($1, $2, $3)
 ON CONFLICT (domain_id) DO NOTHING
 `
 
@@ -21,45 +90,149 @@ type InsertDomainParams struct {
 	DomainID         pgtype.UUID `json:"domain_id"`
 	DomainName       string      `json:"domain_name"`
 	DomainController string      `json:"domain_controller"`
-	HighestUsn       pgtype.Int8 `json:"highest_usn"`
-	LastProcessedUsn pgtype.Int8 `json:"last_processed_usn"`
 }
 
 func (q *Queries) InsertDomain(ctx context.Context, arg InsertDomainParams) error {
-	_, err := q.db.Exec(ctx, insertDomain,
-		arg.DomainID,
-		arg.DomainName,
+	_, err := q.db.Exec(ctx, insertDomain, arg.DomainID, arg.DomainName, arg.DomainController)
+	return err
+}
+
+const moveNamingContext = `-- name: MoveNamingContext :exec
+UPDATE NamingContexts
+SET distinguishedName = $3,
+    domain_controller = NULL,
+    invocation_id = NULL,
+    ds_service_name = NULL,
+    last_processed_usn = NULL,
+    highest_usn = NULL,
+    dirsync_cookie = NULL
+WHERE domain_id = $1 AND nc_type = $2 AND distinguishedName <> $3
+  AND NOT EXISTS (SELECT 1 FROM NamingContexts WHERE distinguishedName = $3)
+`
+
+type MoveNamingContextParams struct {
+	DomainID          pgtype.UUID `json:"domain_id"`
+	NcType            string      `json:"nc_type"`
+	Distinguishedname string      `json:"distinguishedname"`
+}
+
+// A claim under a new DN (the domain joined another forest) takes the domain's existing row with
+// it, restarting its watermark, unless another domain already polls the new DN.
+func (q *Queries) MoveNamingContext(ctx context.Context, arg MoveNamingContextParams) error {
+	_, err := q.db.Exec(ctx, moveNamingContext, arg.DomainID, arg.NcType, arg.Distinguishedname)
+	return err
+}
+
+const resetNamingContextWatermark = `-- name: ResetNamingContextWatermark :exec
+UPDATE NamingContexts
+SET domain_controller = $1,
+    invocation_id = $2,
+    ds_service_name = $3,
+    last_processed_usn = $4,
+    highest_usn = $5
+WHERE domain_id = $6 AND nc_type = $7
+`
+
+type ResetNamingContextWatermarkParams struct {
+	DomainController pgtype.Text `json:"domain_controller"`
+	InvocationID     pgtype.UUID `json:"invocation_id"`
+	DsServiceName    pgtype.Text `json:"ds_service_name"`
+	LastProcessedUsn pgtype.Int8 `json:"last_processed_usn"`
+	HighestUsn       pgtype.Int8 `json:"highest_usn"`
+	DomainID         pgtype.UUID `json:"domain_id"`
+	NcType           string      `json:"nc_type"`
+}
+
+func (q *Queries) ResetNamingContextWatermark(ctx context.Context, arg ResetNamingContextWatermarkParams) error {
+	_, err := q.db.Exec(ctx, resetNamingContextWatermark,
 		arg.DomainController,
-		arg.HighestUsn,
+		arg.InvocationID,
+		arg.DsServiceName,
 		arg.LastProcessedUsn,
+		arg.HighestUsn,
+		arg.DomainID,
+		arg.NcType,
 	)
 	return err
 }
 
-const updateDomainHighestUSN = `-- name: UpdateDomainHighestUSN :exec
-UPDATE Domains SET highest_usn = $1 WHERE domain_id = $2
+const updateNamingContextDirSyncCookie = `-- name: UpdateNamingContextDirSyncCookie :exec
+UPDATE NamingContexts SET dirsync_cookie = $1 WHERE domain_id = $2 AND nc_type = $3
 `
 
-type UpdateDomainHighestUSNParams struct {
-	HighestUsn pgtype.Int8 `json:"highest_usn"`
-	DomainID   pgtype.UUID `json:"domain_id"`
+type UpdateNamingContextDirSyncCookieParams struct {
+	DirsyncCookie []byte      `json:"dirsync_cookie"`
+	DomainID      pgtype.UUID `json:"domain_id"`
+	NcType        string      `json:"nc_type"`
 }
 
-func (q *Queries) UpdateDomainHighestUSN(ctx context.Context, arg UpdateDomainHighestUSNParams) error {
-	_, err := q.db.Exec(ctx, updateDomainHighestUSN, arg.HighestUsn, arg.DomainID)
+func (q *Queries) UpdateNamingContextDirSyncCookie(ctx context.Context, arg UpdateNamingContextDirSyncCookieParams) error {
+	_, err := q.db.Exec(ctx, updateNamingContextDirSyncCookie, arg.DirsyncCookie, arg.DomainID, arg.NcType)
 	return err
 }
 
-const updateDomainLastProcessedUSN = `-- name: UpdateDomainLastProcessedUSN :exec
-UPDATE Domains SET last_processed_usn = $1 WHERE domain_id = $2
+const updateNamingContextHighestUSN = `-- name: UpdateNamingContextHighestUSN :execrows
+UPDATE NamingContexts SET highest_usn = $1 WHERE domain_id = $2 AND nc_type = $3 AND invocation_id = $4
+`
+
+type UpdateNamingContextHighestUSNParams struct {
+	HighestUsn   pgtype.Int8 `json:"highest_usn"`
+	DomainID     pgtype.UUID `json:"domain_id"`
+	NcType       string      `json:"nc_type"`
+	InvocationID pgtype.UUID `json:"invocation_id"`
+}
+
+func (q *Queries) UpdateNamingContextHighestUSN(ctx context.Context, arg UpdateNamingContextHighestUSNParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateNamingContextHighestUSN,
+		arg.HighestUsn,
+		arg.DomainID,
+		arg.NcType,
+		arg.InvocationID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const updateNamingContextLastProcessedUSN = `-- name: UpdateNamingContextLastProcessedUSN :execrows
+UPDATE NamingContexts SET last_processed_usn = $1 WHERE domain_id = $2 AND nc_type = $3 AND invocation_id = $4
 `
 
-type UpdateDomainLastProcessedUSNParams struct {
+type UpdateNamingContextLastProcessedUSNParams struct {
 	LastProcessedUsn pgtype.Int8 `json:"last_processed_usn"`
 	DomainID         pgtype.UUID `json:"domain_id"`
+	NcType           string      `json:"nc_type"`
+	InvocationID     pgtype.UUID `json:"invocation_id"`
+}
+
+func (q *Queries) UpdateNamingContextLastProcessedUSN(ctx context.Context, arg UpdateNamingContextLastProcessedUSNParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateNamingContextLastProcessedUSN,
+		arg.LastProcessedUsn,
+		arg.DomainID,
+		arg.NcType,
+		arg.InvocationID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const upsertNamingContext = `-- name: UpsertNamingContext :exec
+INSERT INTO NamingContexts (domain_id, nc_type, distinguishedName)
+VALUES ($1, $2, $3)
+ON CONFLICT (domain_id, nc_type)
+DO UPDATE SET distinguishedName = EXCLUDED.distinguishedName
+`
+
+type UpsertNamingContextParams struct {
+	DomainID          pgtype.UUID `json:"domain_id"`
+	NcType            string      `json:"nc_type"`
+	Distinguishedname string      `json:"distinguishedname"`
 }
 
-func (q *Queries) UpdateDomainLastProcessedUSN(ctx context.Context, arg UpdateDomainLastProcessedUSNParams) error {
-	_, err := q.db.Exec(ctx, updateDomainLastProcessedUSN, arg.LastProcessedUsn, arg.DomainID)
+func (q *Queries) UpsertNamingContext(ctx context.Context, arg UpsertNamingContextParams) error {
+	_, err := q.db.Exec(ctx, upsertNamingContext, arg.DomainID, arg.NcType, arg.Distinguishedname)
 	return err
 }
@@ -9,49 +9,154 @@ import (
 )
 
 type Attributechange struct {
-	ObjectID          pgtype.UUID      `json:"object_id"`
-	UsnChanged        int64            `json:"usn_changed"`
-	AttributeSchemaID pgtype.UUID      `json:"attribute_schema_id"`
-	OldValue          []byte           `json:"old_value"`
-	NewValue          []byte           `json:"new_value"`
-	Timestamp         pgtype.Timestamp `json:"timestamp"`
+	VersionID               int64            `json:"version_id"`
+	ObjectID                pgtype.UUID      `json:"object_id"`
+	DomainID                pgtype.UUID      `json:"domain_id"`
+	AttributeSchemaID       pgtype.UUID      `json:"attribute_schema_id"`
+	OldValue                []byte           `json:"old_value"`
+	NewValue                []byte           `json:"new_value"`
+	Summary                 pgtype.Text      `json:"summary"`
+	Timestamp               pgtype.Timestamp `json:"timestamp"`
+	OriginatingDsaDn        pgtype.Text      `json:"originating_dsa_dn"`
+	OriginatingInvocationID pgtype.UUID      `json:"originating_invocation_id"`
+	OriginatingUsn          pgtype.Int8      `json:"originating_usn"`
+	OriginatingTime         pgtype.Timestamp `json:"originating_time"`
+	MetadataVersion         pgtype.Int4      `json:"metadata_version"`
 }
 
 type Attributeschema struct {
-	ObjectGuid      pgtype.UUID `json:"object_guid"`
-	DomainID        pgtype.UUID `json:"domain_id"`
-	LdapDisplayName string      `json:"ldap_display_name"`
-	AttributeName   string      `json:"attribute_name"`
-	AttributeID     string      `json:"attribute_id"`
-	AttributeSyntax string      `json:"attribute_syntax"`
-	OmSyntax        string      `json:"om_syntax"`
-	SyntaxName      pgtype.Text `json:"syntax_name"`
-	IsSingleValued  bool        `json:"is_single_valued"`
+	ObjectGuid            pgtype.UUID `json:"object_guid"`
+	DomainID              pgtype.UUID `json:"domain_id"`
+	LdapDisplayName       string      `json:"ldap_display_name"`
+	AttributeName         string      `json:"attribute_name"`
+	AttributeID           string      `json:"attribute_id"`
+	AttributeSyntax       string      `json:"attribute_syntax"`
+	OmSyntax              string      `json:"om_syntax"`
+	SyntaxName            pgtype.Text `json:"syntax_name"`
+	IsSingleValued        bool        `json:"is_single_valued"`
+	SchemaIDGuid          pgtype.UUID `json:"schema_id_guid"`
+	AttributeSecurityGuid pgtype.UUID `json:"attribute_security_guid"`
+}
+
+type Classschema struct {
+	ObjectGuid                pgtype.UUID `json:"object_guid"`
+	DomainID                  pgtype.UUID `json:"domain_id"`
+	LdapDisplayName           string      `json:"ldap_display_name"`
+	ClassName                 string      `json:"class_name"`
+	GovernsID                 string      `json:"governs_id"`
+	SchemaIDGuid              pgtype.UUID `json:"schema_id_guid"`
+	Distinguishedname         string      `json:"distinguishedname"`
+	SubClassOf                string      `json:"sub_class_of"`
+	ObjectClassCategory       int32       `json:"object_class_category"`
+	AuxiliaryClasses          []string    `json:"auxiliary_classes"`
+	MustContain               []string    `json:"must_contain"`
+	MayContain                []string    `json:"may_contain"`
+	DefaultSecurityDescriptor pgtype.Text `json:"default_security_descriptor"`
+	DefaultObjectCategory     pgtype.Text `json:"default_object_category"`
 }
 
 type Domain struct {
 	DomainID         pgtype.UUID `json:"domain_id"`
 	DomainName       string      `json:"domain_name"`
 	DomainController string      `json:"domain_controller"`
-	LastProcessedUsn pgtype.Int8 `json:"last_processed_usn"`
-	HighestUsn       pgtype.Int8 `json:"highest_usn"`
+}
+
+type Dnhistory struct {
+	VersionID  int64            `json:"version_id"`
+	ObjectID   pgtype.UUID      `json:"object_id"`
+	ChangeType string           `json:"change_type"`
+	OldDn      string           `json:"old_dn"`
+	NewDn      string           `json:"new_dn"`
+	OldRdn     string           `json:"old_rdn"`
+	NewRdn     string           `json:"new_rdn"`
+	OldParent  string           `json:"old_parent"`
+	NewParent  string           `json:"new_parent"`
+	ChangedAt  pgtype.Timestamp `json:"changed_at"`
+}
+
+type Extendedright struct {
+	DomainID      pgtype.UUID `json:"domain_id"`
+	RightsGuid    pgtype.UUID `json:"rights_guid"`
+	ObjectGuid    pgtype.UUID `json:"object_guid"`
+	Name          string      `json:"name"`
+	DisplayName   string      `json:"display_name"`
+	ValidAccesses int32       `json:"valid_accesses"`
+	AppliesTo     []string    `json:"applies_to"`
+}
+
+type Lifecycleevent struct {
+	VersionID         int64            `json:"version_id"`
+	ObjectID          pgtype.UUID      `json:"object_id"`
+	EventType         string           `json:"event_type"`
+	FromState         string           `json:"from_state"`
+	ToState           string           `json:"to_state"`
+	EventTime         pgtype.Timestamp `json:"event_time"`
+	Distinguishedname string           `json:"distinguishedname"`
+	LastKnownRdn      pgtype.Text      `json:"last_known_rdn"`
+	LastKnownParent   pgtype.Text      `json:"last_known_parent"`
+}
+
+type Linkedvaluechange struct {
+	VersionID               int64            `json:"version_id"`
+	ObjectID                pgtype.UUID      `json:"object_id"`
+	DomainID                pgtype.UUID      `json:"domain_id"`
+	AttributeSchemaID       pgtype.UUID      `json:"attribute_schema_id"`
+	ValueDn                 string           `json:"value_dn"`
+	ValueData               string           `json:"value_data"`
+	ChangeType              string           `json:"change_type"`
+	Legacy                  bool             `json:"legacy"`
+	OriginatingTime         pgtype.Timestamp `json:"originating_time"`
+	OriginatingDsaDn        pgtype.Text      `json:"originating_dsa_dn"`
+	OriginatingInvocationID pgtype.UUID      `json:"originating_invocation_id"`
+	OriginatingUsn          pgtype.Int8      `json:"originating_usn"`
+	MetadataVersion         pgtype.Int4      `json:"metadata_version"`
+}
+
+type Namingcontext struct {
+	DomainID          pgtype.UUID `json:"domain_id"`
+	NcType            string      `json:"nc_type"`
+	Distinguishedname string      `json:"distinguishedname"`
+	DomainController  pgtype.Text `json:"domain_controller"`
+	LastProcessedUsn  pgtype.Int8 `json:"last_processed_usn"`
+	HighestUsn        pgtype.Int8 `json:"highest_usn"`
+	InvocationID      pgtype.UUID `json:"invocation_id"`
+	DsServiceName     pgtype.Text `json:"ds_service_name"`
+	DirsyncCookie     []byte      `json:"dirsync_cookie"`
 }
 
 type Object struct {
 	ObjectID          pgtype.UUID      `json:"object_id"`
 	ObjectType        string           `json:"object_type"`
 	Distinguishedname string           `json:"distinguishedname"`
-	LastProcessedUsn  pgtype.Int8      `json:"last_processed_usn"`
+	CurrentVersionID  pgtype.Int8      `json:"current_version_id"`
 	DomainID          pgtype.UUID      `json:"domain_id"`
+	NamingContext     string           `json:"naming_context"`
 	CreatedAt         pgtype.Timestamp `json:"created_at"`
 	UpdatedAt         pgtype.Timestamp `json:"updated_at"`
 	DeletedAt         pgtype.Timestamp `json:"deleted_at"`
+	LastLiveDn        pgtype.Text      `json:"last_live_dn"`
+	LastKnownParent   pgtype.Text      `json:"last_known_parent"`
+	LifecycleState    string           `json:"lifecycle_state"`
 }
 
 type Objectversion struct {
+	VersionID          int64            `json:"version_id"`
 	ObjectID           pgtype.UUID      `json:"object_id"`
+	InvocationID       pgtype.UUID      `json:"invocation_id"`
 	UsnChanged         int64            `json:"usn_changed"`
 	Timestamp          pgtype.Timestamp `json:"timestamp"`
 	AttributesSnapshot []byte           `json:"attributes_snapshot"`
 	ModifiedBy         pgtype.Text      `json:"modified_by"`
 }
+
+type Schemachangeevent struct {
+	DomainID           pgtype.UUID      `json:"domain_id"`
+	SchemaModifiedAt   pgtype.Timestamp `json:"schema_modified_at"`
+	DetectedAt         pgtype.Timestamp `json:"detected_at"`
+	AddedAttributes    []string         `json:"added_attributes"`
+	ModifiedAttributes []string         `json:"modified_attributes"`
+	RemovedAttributes  []string         `json:"removed_attributes"`
+	AddedClasses       []string         `json:"added_classes"`
+	ModifiedClasses    []string         `json:"modified_classes"`
+	RemovedClasses     []string         `json:"removed_classes"`
+}
@@ -11,31 +11,105 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
-const updateLastProcessedUSN = `-- name: UpdateLastProcessedUSN :exec
+const markObjectDeleted = `-- name: MarkObjectDeleted :exec
 UPDATE Objects
-SET last_processed_usn = $1
+SET deleted_at = $2,
+    last_live_dn = $3,
+    last_known_parent = $4
+WHERE object_id = $1
+`
+
+type MarkObjectDeletedParams struct {
+	ObjectID        pgtype.UUID      `json:"object_id"`
+	DeletedAt       pgtype.Timestamp `json:"deleted_at"`
+	LastLiveDn      pgtype.Text      `json:"last_live_dn"`
+	LastKnownParent pgtype.Text      `json:"last_known_parent"`
+}
+
+func (q *Queries) MarkObjectDeleted(ctx context.Context, arg MarkObjectDeletedParams) error {
+	_, err := q.db.Exec(ctx, markObjectDeleted,
+		arg.ObjectID,
+		arg.DeletedAt,
+		arg.LastLiveDn,
+		arg.LastKnownParent,
+	)
+	return err
+}
+
+const markObjectRestored = `-- name: MarkObjectRestored :exec
+UPDATE Objects
+SET deleted_at = NULL
+WHERE object_id = $1
+`
+
+func (q *Queries) MarkObjectRestored(ctx context.Context, objectID pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, markObjectRestored, objectID)
+	return err
+}
+
+const updateCurrentVersion = `-- name: UpdateCurrentVersion :exec
+UPDATE Objects
+SET current_version_id = $1
 WHERE object_id = $2
 `
 
-type UpdateLastProcessedUSNParams struct {
-	LastProcessedUsn pgtype.Int8 `json:"last_processed_usn"`
+type UpdateCurrentVersionParams struct {
+	CurrentVersionID pgtype.Int8 `json:"current_version_id"`
 	ObjectID         pgtype.UUID `json:"object_id"`
 }
 
-func (q *Queries) UpdateLastProcessedUSN(ctx context.Context, arg UpdateLastProcessedUSNParams) error {
-	_, err := q.db.Exec(ctx, updateLastProcessedUSN, arg.LastProcessedUsn, arg.ObjectID)
+func (q *Queries) UpdateCurrentVersion(ctx context.Context, arg UpdateCurrentVersionParams) error {
+	_, err := q.db.Exec(ctx, updateCurrentVersion, arg.CurrentVersionID, arg.ObjectID)
+	return err
+}
+
+const updateDescendantDNs = `-- name: UpdateDescendantDNs :exec
+UPDATE Objects
+SET distinguishedName = left(distinguishedName, length(distinguishedName) - length($1::text)) || $2::text
+WHERE domain_id = $3
+  AND length(distinguishedName) > length($1::text)
+  AND lower(right(distinguishedName, length($1::text) + 1)) = lower(',' || $1::text)
+`
+
+type UpdateDescendantDNsParams struct {
+	Column1  string      `json:"column_1"`
+	Column2  string      `json:"column_2"`
+	DomainID pgtype.UUID `json:"domain_id"`
+}
+
+func (q *Queries) UpdateDescendantDNs(ctx context.Context, arg UpdateDescendantDNsParams) error {
+	_, err := q.db.Exec(ctx, updateDescendantDNs, arg.Column1, arg.Column2, arg.DomainID)
+	return err
+}
+
+const updateObjectLifecycleState = `-- name: UpdateObjectLifecycleState :exec
+UPDATE Objects
+SET lifecycle_state = $2
+WHERE object_id = $1
+`
+
+type UpdateObjectLifecycleStateParams struct {
+	ObjectID       pgtype.UUID `json:"object_id"`
+	LifecycleState string      `json:"lifecycle_state"`
+}
+
+func (q *Queries) UpdateObjectLifecycleState(ctx context.Context, arg UpdateObjectLifecycleStateParams) error {
+	_, err := q.db.Exec(ctx, updateObjectLifecycleState, arg.ObjectID, arg.LifecycleState)
 	return err
 }
 
 const upsertObject = `-- name: UpsertObject :one
-INSERT INTO Objects (object_id, object_type, distinguishedName, domain_id)
-VALUES ($1, $2, $3, $4)
+WITH previous AS (
+    SELECT distinguishedName FROM Objects WHERE object_id = $1
+)
+INSERT INTO Objects (object_id, object_type, distinguishedName, domain_id, naming_context)
+VALUES ($1, $2, $3, $4, $5)
 ON CONFLICT (object_id)
 DO UPDATE SET
     updated_at = NOW(),
     distinguishedName = EXCLUDED.distinguishedName,
     object_type = EXCLUDED.object_type
-RETURNING last_processed_usn
+RETURNING current_version_id, COALESCE((SELECT distinguishedName FROM previous), '')::text AS previous_dn
 `
 
 type UpsertObjectParams struct {
@@ -43,16 +117,23 @@ type UpsertObjectParams struct {
 	ObjectType        string      `json:"object_type"`
 	Distinguishedname string      `json:"distinguishedname"`
 	DomainID          pgtype.UUID `json:"domain_id"`
+	NamingContext     string      `json:"naming_context"`
+}
+
+type UpsertObjectRow struct {
+	CurrentVersionID pgtype.Int8 `json:"current_version_id"`
+	PreviousDn       string      `json:"previous_dn"`
 }
 
-func (q *Queries) UpsertObject(ctx context.Context, arg UpsertObjectParams) (pgtype.Int8, error) {
+func (q *Queries) UpsertObject(ctx context.Context, arg UpsertObjectParams) (UpsertObjectRow, error) {
 	row := q.db.QueryRow(ctx, upsertObject,
 		arg.ObjectID,
 		arg.ObjectType,
 		arg.Distinguishedname,
 		arg.DomainID,
+		arg.NamingContext,
 	)
-	var last_processed_usn pgtype.Int8
-	err := row.Scan(&last_processed_usn)
-	return last_processed_usn, err
+	var i UpsertObjectRow
+	err := row.Scan(&i.CurrentVersionID, &i.PreviousDn)
+	return i, err
 }
@@ -11,22 +11,47 @@ import (
 )
 
 type Querier interface {
+	ClaimNamingContext(ctx context.Context, arg ClaimNamingContextParams) (pgtype.UUID, error)
 	CountObjectsForWeb(ctx context.Context, arg CountObjectsForWebParams) (int64, error)
 	GetAttributeSchemaByLDAPName(ctx context.Context, arg GetAttributeSchemaByLDAPNameParams) (pgtype.UUID, error)
+	GetNamingContextDirSyncCookie(ctx context.Context, arg GetNamingContextDirSyncCookieParams) ([]byte, error)
+	GetNamingContextWatermark(ctx context.Context, arg GetNamingContextWatermarkParams) (GetNamingContextWatermarkRow, error)
 	GetObjectByID(ctx context.Context, objectID pgtype.UUID) (GetObjectByIDRow, error)
 	GetObjectTimeline(ctx context.Context, objectID pgtype.UUID) ([]GetObjectTimelineRow, error)
 	GetObjectTypes(ctx context.Context) ([]string, error)
-	GetPreviousSnapshot(ctx context.Context, arg GetPreviousSnapshotParams) ([]byte, error)
+	GetPrincipalBySID(ctx context.Context, dollar_1 string) (GetPrincipalBySIDRow, error)
+	GetVersion(ctx context.Context, versionID int64) (GetVersionRow, error)
 	GetVersionChanges(ctx context.Context, arg GetVersionChangesParams) ([]GetVersionChangesRow, error)
+	GetVersionLinkedValueChanges(ctx context.Context, arg GetVersionLinkedValueChangesParams) ([]GetVersionLinkedValueChangesRow, error)
 	InsertAttributeChange(ctx context.Context, arg InsertAttributeChangeParams) error
+	InsertDNChange(ctx context.Context, arg InsertDNChangeParams) error
 	InsertDomain(ctx context.Context, arg InsertDomainParams) error
-	InsertVersion(ctx context.Context, arg InsertVersionParams) error
+	InsertLifecycleEvent(ctx context.Context, arg InsertLifecycleEventParams) error
+	InsertLinkedValueChange(ctx context.Context, arg InsertLinkedValueChangeParams) error
+	InsertSchemaChangeEvent(ctx context.Context, arg InsertSchemaChangeEventParams) error
+	InsertVersion(ctx context.Context, arg InsertVersionParams) (int64, error)
+	ListAttributeSchemaGUIDs(ctx context.Context) ([]ListAttributeSchemaGUIDsRow, error)
+	ListClassSchemaGUIDs(ctx context.Context) ([]ListClassSchemaGUIDsRow, error)
+	ListExtendedRights(ctx context.Context) ([]ListExtendedRightsRow, error)
 	ListObjectsForWeb(ctx context.Context, arg ListObjectsForWebParams) ([]ListObjectsForWebRow, error)
-	UpdateDomainHighestUSN(ctx context.Context, arg UpdateDomainHighestUSNParams) error
-	UpdateDomainLastProcessedUSN(ctx context.Context, arg UpdateDomainLastProcessedUSNParams) error
-	UpdateLastProcessedUSN(ctx context.Context, arg UpdateLastProcessedUSNParams) error
+	ListSchemaChangeEvents(ctx context.Context, limit int32) ([]Schemachangeevent, error)
+	MarkObjectDeleted(ctx context.Context, arg MarkObjectDeletedParams) error
+	MarkObjectRestored(ctx context.Context, objectID pgtype.UUID) error
+	// A claim under a new DN (the domain joined another forest) takes the domain's existing row with
+	// it, restarting its watermark, unless another domain already polls the new DN.
+	MoveNamingContext(ctx context.Context, arg MoveNamingContextParams) error
+	ResetNamingContextWatermark(ctx context.Context, arg ResetNamingContextWatermarkParams) error
+	UpdateCurrentVersion(ctx context.Context, arg UpdateCurrentVersionParams) error
+	UpdateDescendantDNs(ctx context.Context, arg UpdateDescendantDNsParams) error
+	UpdateNamingContextDirSyncCookie(ctx context.Context, arg UpdateNamingContextDirSyncCookieParams) error
+	UpdateNamingContextHighestUSN(ctx context.Context, arg UpdateNamingContextHighestUSNParams) (int64, error)
+	UpdateNamingContextLastProcessedUSN(ctx context.Context, arg UpdateNamingContextLastProcessedUSNParams) (int64, error)
+	UpdateObjectLifecycleState(ctx context.Context, arg UpdateObjectLifecycleStateParams) error
 	UpsertAttributeSchema(ctx context.Context, arg UpsertAttributeSchemaParams) error
-	UpsertObject(ctx context.Context, arg UpsertObjectParams) (pgtype.Int8, error)
+	UpsertClassSchema(ctx context.Context, arg UpsertClassSchemaParams) error
+	UpsertExtendedRight(ctx context.Context, arg UpsertExtendedRightParams) error
+	UpsertNamingContext(ctx context.Context, arg UpsertNamingContextParams) error
+	UpsertObject(ctx context.Context, arg UpsertObjectParams) (UpsertObjectRow, error)
 }
 
 var _ Querier = (*Queries)(nil)
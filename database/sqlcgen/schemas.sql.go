@@ -29,34 +29,72 @@ func (q *Queries) GetAttributeSchemaByLDAPName(ctx context.Context, arg GetAttri
 	return object_guid, err
 }
 
+const insertSchemaChangeEvent = `-- name: InsertSchemaChangeEvent :exec
+INSERT INTO SchemaChangeEvents (
+    domain_id, schema_modified_at, added_attributes, modified_attributes, removed_attributes,
+    added_classes, modified_classes, removed_classes
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (domain_id, schema_modified_at) DO NOTHING
+`
+
+type InsertSchemaChangeEventParams struct {
+	DomainID           pgtype.UUID      `json:"domain_id"`
+	SchemaModifiedAt   pgtype.Timestamp `json:"schema_modified_at"`
+	AddedAttributes    []string         `json:"added_attributes"`
+	ModifiedAttributes []string         `json:"modified_attributes"`
+	RemovedAttributes  []string         `json:"removed_attributes"`
+	AddedClasses       []string         `json:"added_classes"`
+	ModifiedClasses    []string         `json:"modified_classes"`
+	RemovedClasses     []string         `json:"removed_classes"`
+}
+
+func (q *Queries) InsertSchemaChangeEvent(ctx context.Context, arg InsertSchemaChangeEventParams) error {
+	_, err := q.db.Exec(ctx, insertSchemaChangeEvent,
+		arg.DomainID,
+		arg.SchemaModifiedAt,
+		arg.AddedAttributes,
+		arg.ModifiedAttributes,
+		arg.RemovedAttributes,
+		arg.AddedClasses,
+		arg.ModifiedClasses,
+		arg.RemovedClasses,
+	)
+	return err
+}
+
 const upsertAttributeSchema = `-- name: UpsertAttributeSchema :exec
 INSERT INTO AttributeSchemas (
     object_guid, domain_id, ldap_display_name, attribute_name, attribute_id,
-    attribute_syntax, om_syntax, syntax_name, is_single_valued
+    attribute_syntax, om_syntax, syntax_name, is_single_valued,
+    schema_id_guid, attribute_security_guid
 )
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-ON CONFLICT (object_guid)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+ON CONFLICT (domain_id, object_guid)
 DO UPDATE SET
-    domain_id = EXCLUDED.domain_id,
     ldap_display_name = EXCLUDED.ldap_display_name,
     attribute_name = EXCLUDED.attribute_name,
     attribute_id = EXCLUDED.attribute_id,
     attribute_syntax = EXCLUDED.attribute_syntax,
     om_syntax = EXCLUDED.om_syntax,
     syntax_name = EXCLUDED.syntax_name,
-    is_single_valued = EXCLUDED.is_single_valued
+    is_single_valued = EXCLUDED.is_single_valued,
+    schema_id_guid = EXCLUDED.schema_id_guid,
+    attribute_security_guid = EXCLUDED.attribute_security_guid
 `
 
 type UpsertAttributeSchemaParams struct {
-	ObjectGuid      pgtype.UUID `json:"object_guid"`
-	DomainID        pgtype.UUID `json:"domain_id"`
-	LdapDisplayName string      `json:"ldap_display_name"`
-	AttributeName   string      `json:"attribute_name"`
-	AttributeID     string      `json:"attribute_id"`
-	AttributeSyntax string      `json:"attribute_syntax"`
-	OmSyntax        string      `json:"om_syntax"`
-	SyntaxName      pgtype.Text `json:"syntax_name"`
-	IsSingleValued  bool        `json:"is_single_valued"`
+	ObjectGuid            pgtype.UUID `json:"object_guid"`
+	DomainID              pgtype.UUID `json:"domain_id"`
+	LdapDisplayName       string      `json:"ldap_display_name"`
+	AttributeName         string      `json:"attribute_name"`
+	AttributeID           string      `json:"attribute_id"`
+	AttributeSyntax       string      `json:"attribute_syntax"`
+	OmSyntax              string      `json:"om_syntax"`
+	SyntaxName            pgtype.Text `json:"syntax_name"`
+	IsSingleValued        bool        `json:"is_single_valued"`
+	SchemaIDGuid          pgtype.UUID `json:"schema_id_guid"`
+	AttributeSecurityGuid pgtype.UUID `json:"attribute_security_guid"`
 }
 
 func (q *Queries) UpsertAttributeSchema(ctx context.Context, arg UpsertAttributeSchemaParams) error {
@@ -70,6 +108,105 @@ func (q *Queries) UpsertAttributeSchema(ctx context.Context, arg UpsertAttribute
 		arg.OmSyntax,
 		arg.SyntaxName,
 		arg.IsSingleValued,
+		arg.SchemaIDGuid,
+		arg.AttributeSecurityGuid,
+	)
+	return err
+}
+
+const upsertClassSchema = `-- name: UpsertClassSchema :exec
+INSERT INTO ClassSchemas (
+    object_guid, domain_id, ldap_display_name, class_name, governs_id, schema_id_guid,
+    distinguishedName, sub_class_of, object_class_category, auxiliary_classes,
+    must_contain, may_contain, default_security_descriptor, default_object_category
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+ON CONFLICT (domain_id, object_guid)
+DO UPDATE SET
+    ldap_display_name = EXCLUDED.ldap_display_name,
+    class_name = EXCLUDED.class_name,
+    governs_id = EXCLUDED.governs_id,
+    schema_id_guid = EXCLUDED.schema_id_guid,
+    distinguishedName = EXCLUDED.distinguishedName,
+    sub_class_of = EXCLUDED.sub_class_of,
+    object_class_category = EXCLUDED.object_class_category,
+    auxiliary_classes = EXCLUDED.auxiliary_classes,
+    must_contain = EXCLUDED.must_contain,
+    may_contain = EXCLUDED.may_contain,
+    default_security_descriptor = EXCLUDED.default_security_descriptor,
+    default_object_category = EXCLUDED.default_object_category
+`
+
+type UpsertClassSchemaParams struct {
+	ObjectGuid                pgtype.UUID `json:"object_guid"`
+	DomainID                  pgtype.UUID `json:"domain_id"`
+	LdapDisplayName           string      `json:"ldap_display_name"`
+	ClassName                 string      `json:"class_name"`
+	GovernsID                 string      `json:"governs_id"`
+	SchemaIDGuid              pgtype.UUID `json:"schema_id_guid"`
+	Distinguishedname         string      `json:"distinguishedname"`
+	SubClassOf                string      `json:"sub_class_of"`
+	ObjectClassCategory       int32       `json:"object_class_category"`
+	AuxiliaryClasses          []string    `json:"auxiliary_classes"`
+	MustContain               []string    `json:"must_contain"`
+	MayContain                []string    `json:"may_contain"`
+	DefaultSecurityDescriptor pgtype.Text `json:"default_security_descriptor"`
+	DefaultObjectCategory     pgtype.Text `json:"default_object_category"`
+}
+
+func (q *Queries) UpsertClassSchema(ctx context.Context, arg UpsertClassSchemaParams) error {
+	_, err := q.db.Exec(ctx, upsertClassSchema,
+		arg.ObjectGuid,
+		arg.DomainID,
+		arg.LdapDisplayName,
+		arg.ClassName,
+		arg.GovernsID,
+		arg.SchemaIDGuid,
+		arg.Distinguishedname,
+		arg.SubClassOf,
+		arg.ObjectClassCategory,
+		arg.AuxiliaryClasses,
+		arg.MustContain,
+		arg.MayContain,
+		arg.DefaultSecurityDescriptor,
+		arg.DefaultObjectCategory,
+	)
+	return err
+}
+
+const upsertExtendedRight = `-- name: UpsertExtendedRight :exec
+INSERT INTO ExtendedRights (
+    domain_id, rights_guid, object_guid, name, display_name, valid_accesses, applies_to
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (domain_id, rights_guid)
+DO UPDATE SET
+    object_guid = EXCLUDED.object_guid,
+    name = EXCLUDED.name,
+    display_name = EXCLUDED.display_name,
+    valid_accesses = EXCLUDED.valid_accesses,
+    applies_to = EXCLUDED.applies_to
+`
+
+type UpsertExtendedRightParams struct {
+	DomainID      pgtype.UUID `json:"domain_id"`
+	RightsGuid    pgtype.UUID `json:"rights_guid"`
+	ObjectGuid    pgtype.UUID `json:"object_guid"`
+	Name          string      `json:"name"`
+	DisplayName   string      `json:"display_name"`
+	ValidAccesses int32       `json:"valid_accesses"`
+	AppliesTo     []string    `json:"applies_to"`
+}
+
+func (q *Queries) UpsertExtendedRight(ctx context.Context, arg UpsertExtendedRightParams) error {
+	_, err := q.db.Exec(ctx, upsertExtendedRight,
+		arg.DomainID,
+		arg.RightsGuid,
+		arg.ObjectGuid,
+		arg.Name,
+		arg.DisplayName,
+		arg.ValidAccesses,
+		arg.AppliesTo,
 	)
 	return err
 }
@@ -11,44 +11,50 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
-const getPreviousSnapshot = `-- name: GetPreviousSnapshot :one
-SELECT attributes_snapshot
+const getVersion = `-- name: GetVersion :one
+SELECT invocation_id, usn_changed, attributes_snapshot
 FROM ObjectVersions
-WHERE object_id = $1 AND usn_changed = $2
+WHERE version_id = $1
 `
 
-type GetPreviousSnapshotParams struct {
-	ObjectID   pgtype.UUID `json:"object_id"`
-	UsnChanged int64       `json:"usn_changed"`
+type GetVersionRow struct {
+	InvocationID       pgtype.UUID `json:"invocation_id"`
+	UsnChanged         int64       `json:"usn_changed"`
+	AttributesSnapshot []byte      `json:"attributes_snapshot"`
 }
 
-func (q *Queries) GetPreviousSnapshot(ctx context.Context, arg GetPreviousSnapshotParams) ([]byte, error) {
-	row := q.db.QueryRow(ctx, getPreviousSnapshot, arg.ObjectID, arg.UsnChanged)
-	var attributes_snapshot []byte
-	err := row.Scan(&attributes_snapshot)
-	return attributes_snapshot, err
+func (q *Queries) GetVersion(ctx context.Context, versionID int64) (GetVersionRow, error) {
+	row := q.db.QueryRow(ctx, getVersion, versionID)
+	var i GetVersionRow
+	err := row.Scan(&i.InvocationID, &i.UsnChanged, &i.AttributesSnapshot)
+	return i, err
 }
 
-const insertVersion = `-- name: InsertVersion :exec
-INSERT INTO ObjectVersions (object_id, usn_changed, timestamp, attributes_snapshot, modified_by)
-VALUES ($1, $2, $3, $4, $5)
+const insertVersion = `-- name: InsertVersion :one
+INSERT INTO ObjectVersions (object_id, invocation_id, usn_changed, timestamp, attributes_snapshot, modified_by)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING version_id
 `
 
 type InsertVersionParams struct {
 	ObjectID           pgtype.UUID      `json:"object_id"`
+	InvocationID       pgtype.UUID      `json:"invocation_id"`
 	UsnChanged         int64            `json:"usn_changed"`
 	Timestamp          pgtype.Timestamp `json:"timestamp"`
 	AttributesSnapshot []byte           `json:"attributes_snapshot"`
 	ModifiedBy         pgtype.Text      `json:"modified_by"`
 }
 
-func (q *Queries) InsertVersion(ctx context.Context, arg InsertVersionParams) error {
-	_, err := q.db.Exec(ctx, insertVersion,
+func (q *Queries) InsertVersion(ctx context.Context, arg InsertVersionParams) (int64, error) {
+	row := q.db.QueryRow(ctx, insertVersion,
 		arg.ObjectID,
+		arg.InvocationID,
 		arg.UsnChanged,
 		arg.Timestamp,
 		arg.AttributesSnapshot,
 		arg.ModifiedBy,
 	)
-	return err
+	var version_id int64
+	err := row.Scan(&version_id)
+	return version_id, err
 }
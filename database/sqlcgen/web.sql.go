@@ -14,35 +14,59 @@ import (
 const countObjectsForWeb = `-- name: CountObjectsForWeb :one
 SELECT COUNT(*) as total
 FROM Objects
-WHERE deleted_at IS NULL
+WHERE CASE $3::text
+        WHEN 'deleted' THEN deleted_at IS NOT NULL
+        WHEN 'all' THEN TRUE
+        ELSE deleted_at IS NULL
+      END
   AND ($1::text = '' OR object_type = $1)
-  AND ($2::text = '' OR distinguishedName ILIKE '%' || $2 || '%')
+  AND ($4::text = '' OR naming_context = $4)
+  AND ($2::text = ''
+       OR distinguishedName ILIKE '%' || $2 || '%'
+       OR last_live_dn ILIKE '%' || $2 || '%'
+       OR EXISTS (
+           SELECT 1 FROM DNHistory h
+           WHERE h.object_id = Objects.object_id AND h.old_dn ILIKE '%' || $2 || '%'
+       ))
 `
 
 type CountObjectsForWebParams struct {
 	Column1 string `json:"column_1"`
 	Column2 string `json:"column_2"`
+	Column3 string `json:"column_3"`
+	Column4 string `json:"column_4"`
 }
 
 func (q *Queries) CountObjectsForWeb(ctx context.Context, arg CountObjectsForWebParams) (int64, error) {
-	row := q.db.QueryRow(ctx, countObjectsForWeb, arg.Column1, arg.Column2)
+	row := q.db.QueryRow(ctx, countObjectsForWeb,
+		arg.Column1,
+		arg.Column2,
+		arg.Column3,
+		arg.Column4,
+	)
 	var total int64
 	err := row.Scan(&total)
 	return total, err
 }
 
 const getObjectByID = `-- name: GetObjectByID :one
-SELECT object_id, object_type, distinguishedName, updated_at, deleted_at
-FROM Objects
-WHERE object_id = $1
+SELECT o.object_id, o.object_type, o.distinguishedName, o.naming_context, o.updated_at, o.deleted_at, o.last_live_dn, o.last_known_parent,
+       c.ldap_display_name AS class_name
+FROM Objects o
+LEFT JOIN ClassSchemas c ON c.domain_id = o.domain_id AND c.distinguishedName = o.object_type
+WHERE o.object_id = $1
 `
 
 type GetObjectByIDRow struct {
 	ObjectID          pgtype.UUID      `json:"object_id"`
 	ObjectType        string           `json:"object_type"`
 	Distinguishedname string           `json:"distinguishedname"`
+	NamingContext     string           `json:"naming_context"`
 	UpdatedAt         pgtype.Timestamp `json:"updated_at"`
 	DeletedAt         pgtype.Timestamp `json:"deleted_at"`
+	LastLiveDn        pgtype.Text      `json:"last_live_dn"`
+	LastKnownParent   pgtype.Text      `json:"last_known_parent"`
+	ClassName         pgtype.Text      `json:"class_name"`
 }
 
 func (q *Queries) GetObjectByID(ctx context.Context, objectID pgtype.UUID) (GetObjectByIDRow, error) {
@@ -52,24 +76,49 @@ func (q *Queries) GetObjectByID(ctx context.Context, objectID pgtype.UUID) (GetO
 		&i.ObjectID,
 		&i.ObjectType,
 		&i.Distinguishedname,
+		&i.NamingContext,
 		&i.UpdatedAt,
 		&i.DeletedAt,
+		&i.LastLiveDn,
+		&i.LastKnownParent,
+		&i.ClassName,
 	)
 	return i, err
 }
 
 const getObjectTimeline = `-- name: GetObjectTimeline :many
-SELECT usn_changed, timestamp, attributes_snapshot, modified_by
-FROM ObjectVersions
-WHERE object_id = $1
-ORDER BY usn_changed DESC
+SELECT v.version_id, v.invocation_id, v.usn_changed, v.timestamp, v.attributes_snapshot, v.modified_by,
+       le.event_type, le.from_state, le.to_state, le.event_time, le.distinguishedName AS event_dn, le.last_known_rdn, le.last_known_parent,
+       dh.change_type AS dn_change_type, dh.old_dn, dh.new_dn, dh.old_rdn, dh.new_rdn, dh.old_parent, dh.new_parent, dh.changed_at
+FROM ObjectVersions v
+LEFT JOIN LifecycleEvents le ON le.version_id = v.version_id
+LEFT JOIN DNHistory dh ON dh.version_id = v.version_id
+WHERE v.object_id = $1
+ORDER BY v.version_id DESC
 `
 
 type GetObjectTimelineRow struct {
+	VersionID          int64            `json:"version_id"`
+	InvocationID       pgtype.UUID      `json:"invocation_id"`
 	UsnChanged         int64            `json:"usn_changed"`
 	Timestamp          pgtype.Timestamp `json:"timestamp"`
 	AttributesSnapshot []byte           `json:"attributes_snapshot"`
 	ModifiedBy         pgtype.Text      `json:"modified_by"`
+	EventType          pgtype.Text      `json:"event_type"`
+	FromState          pgtype.Text      `json:"from_state"`
+	ToState            pgtype.Text      `json:"to_state"`
+	EventTime          pgtype.Timestamp `json:"event_time"`
+	EventDn            pgtype.Text      `json:"event_dn"`
+	LastKnownRdn       pgtype.Text      `json:"last_known_rdn"`
+	LastKnownParent    pgtype.Text      `json:"last_known_parent"`
+	DnChangeType       pgtype.Text      `json:"dn_change_type"`
+	OldDn              pgtype.Text      `json:"old_dn"`
+	NewDn              pgtype.Text      `json:"new_dn"`
+	OldRdn             pgtype.Text      `json:"old_rdn"`
+	NewRdn             pgtype.Text      `json:"new_rdn"`
+	OldParent          pgtype.Text      `json:"old_parent"`
+	NewParent          pgtype.Text      `json:"new_parent"`
+	ChangedAt          pgtype.Timestamp `json:"changed_at"`
 }
 
 func (q *Queries) GetObjectTimeline(ctx context.Context, objectID pgtype.UUID) ([]GetObjectTimelineRow, error) {
@@ -82,10 +131,27 @@ func (q *Queries) GetObjectTimeline(ctx context.Context, objectID pgtype.UUID) (
 	for rows.Next() {
 		var i GetObjectTimelineRow
 		if err := rows.Scan(
+			&i.VersionID,
+			&i.InvocationID,
 			&i.UsnChanged,
 			&i.Timestamp,
 			&i.AttributesSnapshot,
 			&i.ModifiedBy,
+			&i.EventType,
+			&i.FromState,
+			&i.ToState,
+			&i.EventTime,
+			&i.EventDn,
+			&i.LastKnownRdn,
+			&i.LastKnownParent,
+			&i.DnChangeType,
+			&i.OldDn,
+			&i.NewDn,
+			&i.OldRdn,
+			&i.NewRdn,
+			&i.OldParent,
+			&i.NewParent,
+			&i.ChangedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -124,17 +190,50 @@ func (q *Queries) GetObjectTypes(ctx context.Context) ([]string, error) {
 	return items, nil
 }
 
+const getPrincipalBySID = `-- name: GetPrincipalBySID :one
+SELECT o.distinguishedName, o.deleted_at, o.last_live_dn,
+       COALESCE(v.attributes_snapshot -> 'sAMAccountName' ->> 0, '')::text AS sam_account_name,
+       COALESCE(v.attributes_snapshot -> 'name' ->> 0, '')::text AS name
+FROM ObjectVersions v
+JOIN Objects o ON o.object_id = v.object_id
+WHERE v.attributes_snapshot -> 'objectSid' @> jsonb_build_array($1::text)
+ORDER BY v.timestamp DESC
+LIMIT 1
+`
+
+type GetPrincipalBySIDRow struct {
+	Distinguishedname string           `json:"distinguishedname"`
+	DeletedAt         pgtype.Timestamp `json:"deleted_at"`
+	LastLiveDn        pgtype.Text      `json:"last_live_dn"`
+	SamAccountName    string           `json:"sam_account_name"`
+	Name              string           `json:"name"`
+}
+
+func (q *Queries) GetPrincipalBySID(ctx context.Context, dollar_1 string) (GetPrincipalBySIDRow, error) {
+	row := q.db.QueryRow(ctx, getPrincipalBySID, dollar_1)
+	var i GetPrincipalBySIDRow
+	err := row.Scan(
+		&i.Distinguishedname,
+		&i.DeletedAt,
+		&i.LastLiveDn,
+		&i.SamAccountName,
+		&i.Name,
+	)
+	return i, err
+}
+
 const getVersionChanges = `-- name: GetVersionChanges :many
-SELECT ac.attribute_schema_id, s.ldap_display_name, ac.old_value, ac.new_value, ac.timestamp, s.is_single_valued
+SELECT ac.attribute_schema_id, s.ldap_display_name, ac.old_value, ac.new_value, ac.summary, ac.timestamp, s.is_single_valued,
+       ac.originating_dsa_dn, ac.originating_usn, ac.originating_time, ac.metadata_version
 FROM AttributeChanges ac
-JOIN AttributeSchemas s ON ac.attribute_schema_id = s.object_guid
-WHERE ac.object_id = $1 AND ac.usn_changed = $2
+JOIN AttributeSchemas s ON s.domain_id = ac.domain_id AND s.object_guid = ac.attribute_schema_id
+WHERE ac.object_id = $1 AND ac.version_id = $2
 ORDER BY s.ldap_display_name
 `
 
 type GetVersionChangesParams struct {
-	ObjectID   pgtype.UUID `json:"object_id"`
-	UsnChanged int64       `json:"usn_changed"`
+	ObjectID  pgtype.UUID `json:"object_id"`
+	VersionID int64       `json:"version_id"`
 }
 
 type GetVersionChangesRow struct {
@@ -142,12 +241,17 @@ type GetVersionChangesRow struct {
 	LdapDisplayName   string           `json:"ldap_display_name"`
 	OldValue          []byte           `json:"old_value"`
 	NewValue          []byte           `json:"new_value"`
+	Summary           pgtype.Text      `json:"summary"`
 	Timestamp         pgtype.Timestamp `json:"timestamp"`
 	IsSingleValued    bool             `json:"is_single_valued"`
+	OriginatingDsaDn  pgtype.Text      `json:"originating_dsa_dn"`
+	OriginatingUsn    pgtype.Int8      `json:"originating_usn"`
+	OriginatingTime   pgtype.Timestamp `json:"originating_time"`
+	MetadataVersion   pgtype.Int4      `json:"metadata_version"`
 }
 
 func (q *Queries) GetVersionChanges(ctx context.Context, arg GetVersionChangesParams) ([]GetVersionChangesRow, error) {
-	rows, err := q.db.Query(ctx, getVersionChanges, arg.ObjectID, arg.UsnChanged)
+	rows, err := q.db.Query(ctx, getVersionChanges, arg.ObjectID, arg.VersionID)
 	if err != nil {
 		return nil, err
 	}
@@ -160,8 +264,162 @@ func (q *Queries) GetVersionChanges(ctx context.Context, arg GetVersionChangesPa
 			&i.LdapDisplayName,
 			&i.OldValue,
 			&i.NewValue,
+			&i.Summary,
 			&i.Timestamp,
 			&i.IsSingleValued,
+			&i.OriginatingDsaDn,
+			&i.OriginatingUsn,
+			&i.OriginatingTime,
+			&i.MetadataVersion,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getVersionLinkedValueChanges = `-- name: GetVersionLinkedValueChanges :many
+SELECT lv.attribute_schema_id, lv.value_dn, lv.value_data, lv.change_type, lv.legacy, lv.originating_time, lv.originating_dsa_dn, lv.originating_usn
+FROM LinkedValueChanges lv
+WHERE lv.object_id = $1 AND lv.version_id = $2
+ORDER BY lv.originating_time, lv.value_dn, lv.value_data
+`
+
+type GetVersionLinkedValueChangesParams struct {
+	ObjectID  pgtype.UUID `json:"object_id"`
+	VersionID int64       `json:"version_id"`
+}
+
+type GetVersionLinkedValueChangesRow struct {
+	AttributeSchemaID pgtype.UUID      `json:"attribute_schema_id"`
+	ValueDn           string           `json:"value_dn"`
+	ValueData         string           `json:"value_data"`
+	ChangeType        string           `json:"change_type"`
+	Legacy            bool             `json:"legacy"`
+	OriginatingTime   pgtype.Timestamp `json:"originating_time"`
+	OriginatingDsaDn  pgtype.Text      `json:"originating_dsa_dn"`
+	OriginatingUsn    pgtype.Int8      `json:"originating_usn"`
+}
+
+func (q *Queries) GetVersionLinkedValueChanges(ctx context.Context, arg GetVersionLinkedValueChangesParams) ([]GetVersionLinkedValueChangesRow, error) {
+	rows, err := q.db.Query(ctx, getVersionLinkedValueChanges, arg.ObjectID, arg.VersionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetVersionLinkedValueChangesRow
+	for rows.Next() {
+		var i GetVersionLinkedValueChangesRow
+		if err := rows.Scan(
+			&i.AttributeSchemaID,
+			&i.ValueDn,
+			&i.ValueData,
+			&i.ChangeType,
+			&i.Legacy,
+			&i.OriginatingTime,
+			&i.OriginatingDsaDn,
+			&i.OriginatingUsn,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAttributeSchemaGUIDs = `-- name: ListAttributeSchemaGUIDs :many
+SELECT DISTINCT schema_id_guid, ldap_display_name
+FROM AttributeSchemas
+`
+
+type ListAttributeSchemaGUIDsRow struct {
+	SchemaIDGuid    pgtype.UUID `json:"schema_id_guid"`
+	LdapDisplayName string      `json:"ldap_display_name"`
+}
+
+func (q *Queries) ListAttributeSchemaGUIDs(ctx context.Context) ([]ListAttributeSchemaGUIDsRow, error) {
+	rows, err := q.db.Query(ctx, listAttributeSchemaGUIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListAttributeSchemaGUIDsRow
+	for rows.Next() {
+		var i ListAttributeSchemaGUIDsRow
+		if err := rows.Scan(&i.SchemaIDGuid, &i.LdapDisplayName); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listClassSchemaGUIDs = `-- name: ListClassSchemaGUIDs :many
+SELECT DISTINCT schema_id_guid, ldap_display_name
+FROM ClassSchemas
+`
+
+type ListClassSchemaGUIDsRow struct {
+	SchemaIDGuid    pgtype.UUID `json:"schema_id_guid"`
+	LdapDisplayName string      `json:"ldap_display_name"`
+}
+
+func (q *Queries) ListClassSchemaGUIDs(ctx context.Context) ([]ListClassSchemaGUIDsRow, error) {
+	rows, err := q.db.Query(ctx, listClassSchemaGUIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListClassSchemaGUIDsRow
+	for rows.Next() {
+		var i ListClassSchemaGUIDsRow
+		if err := rows.Scan(&i.SchemaIDGuid, &i.LdapDisplayName); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listExtendedRights = `-- name: ListExtendedRights :many
+SELECT rights_guid, display_name, valid_accesses, applies_to
+FROM ExtendedRights
+`
+
+type ListExtendedRightsRow struct {
+	RightsGuid    pgtype.UUID `json:"rights_guid"`
+	DisplayName   string      `json:"display_name"`
+	ValidAccesses int32       `json:"valid_accesses"`
+	AppliesTo     []string    `json:"applies_to"`
+}
+
+func (q *Queries) ListExtendedRights(ctx context.Context) ([]ListExtendedRightsRow, error) {
+	rows, err := q.db.Query(ctx, listExtendedRights)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListExtendedRightsRow
+	for rows.Next() {
+		var i ListExtendedRightsRow
+		if err := rows.Scan(
+			&i.RightsGuid,
+			&i.DisplayName,
+			&i.ValidAccesses,
+			&i.AppliesTo,
 		); err != nil {
 			return nil, err
 		}
@@ -174,36 +432,57 @@ func (q *Queries) GetVersionChanges(ctx context.Context, arg GetVersionChangesPa
 }
 
 const listObjectsForWeb = `-- name: ListObjectsForWeb :many
-SELECT object_id, object_type, distinguishedName, updated_at, deleted_at
-FROM Objects
-WHERE deleted_at IS NULL
-  AND ($1::text = '' OR object_type = $1)
-  AND ($2::text = '' OR distinguishedName ILIKE '%' || $2 || '%')
-ORDER BY updated_at DESC
-LIMIT $3 OFFSET $4
+SELECT o.object_id, o.object_type, o.distinguishedName, o.naming_context, o.updated_at, o.deleted_at, o.last_live_dn, o.last_known_parent,
+       c.ldap_display_name AS class_name
+FROM Objects o
+LEFT JOIN ClassSchemas c ON c.domain_id = o.domain_id AND c.distinguishedName = o.object_type
+WHERE CASE $3::text
+        WHEN 'deleted' THEN o.deleted_at IS NOT NULL
+        WHEN 'all' THEN TRUE
+        ELSE o.deleted_at IS NULL
+      END
+  AND ($1::text = '' OR o.object_type = $1)
+  AND ($6::text = '' OR o.naming_context = $6)
+  AND ($2::text = ''
+       OR o.distinguishedName ILIKE '%' || $2 || '%'
+       OR o.last_live_dn ILIKE '%' || $2 || '%'
+       OR EXISTS (
+           SELECT 1 FROM DNHistory h
+           WHERE h.object_id = o.object_id AND h.old_dn ILIKE '%' || $2 || '%'
+       ))
+ORDER BY o.updated_at DESC
+LIMIT $4 OFFSET $5
 `
 
 type ListObjectsForWebParams struct {
 	Column1 string `json:"column_1"`
 	Column2 string `json:"column_2"`
+	Column3 string `json:"column_3"`
 	Limit   int32  `json:"limit"`
 	Offset  int32  `json:"offset"`
+	Column6 string `json:"column_6"`
 }
 
 type ListObjectsForWebRow struct {
 	ObjectID          pgtype.UUID      `json:"object_id"`
 	ObjectType        string           `json:"object_type"`
 	Distinguishedname string           `json:"distinguishedname"`
+	NamingContext     string           `json:"naming_context"`
 	UpdatedAt         pgtype.Timestamp `json:"updated_at"`
 	DeletedAt         pgtype.Timestamp `json:"deleted_at"`
+	LastLiveDn        pgtype.Text      `json:"last_live_dn"`
+	LastKnownParent   pgtype.Text      `json:"last_known_parent"`
+	ClassName         pgtype.Text      `json:"class_name"`
 }
 
 func (q *Queries) ListObjectsForWeb(ctx context.Context, arg ListObjectsForWebParams) ([]ListObjectsForWebRow, error) {
 	rows, err := q.db.Query(ctx, listObjectsForWeb,
 		arg.Column1,
 		arg.Column2,
+		arg.Column3,
 		arg.Limit,
 		arg.Offset,
+		arg.Column6,
 	)
 	if err != nil {
 		return nil, err
@@ -216,8 +495,50 @@ func (q *Queries) ListObjectsForWeb(ctx context.Context, arg ListObjectsForWebPa
 			&i.ObjectID,
 			&i.ObjectType,
 			&i.Distinguishedname,
+			&i.NamingContext,
 			&i.UpdatedAt,
 			&i.DeletedAt,
+			&i.LastLiveDn,
+			&i.LastKnownParent,
+			&i.ClassName,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSchemaChangeEvents = `-- name: ListSchemaChangeEvents :many
+SELECT domain_id, schema_modified_at, detected_at, added_attributes, modified_attributes, removed_attributes,
+       added_classes, modified_classes, removed_classes
+FROM SchemaChangeEvents
+ORDER BY schema_modified_at DESC
+LIMIT $1
+`
+
+func (q *Queries) ListSchemaChangeEvents(ctx context.Context, limit int32) ([]Schemachangeevent, error) {
+	rows, err := q.db.Query(ctx, listSchemaChangeEvents, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Schemachangeevent
+	for rows.Next() {
+		var i Schemachangeevent
+		if err := rows.Scan(
+			&i.DomainID,
+			&i.SchemaModifiedAt,
+			&i.DetectedAt,
+			&i.AddedAttributes,
+			&i.ModifiedAttributes,
+			&i.RemovedAttributes,
+			&i.AddedClasses,
+			&i.ModifiedClasses,
+			&i.RemovedClasses,
 		); err != nil {
 			return nil, err
 		}
@@ -52,3 +52,27 @@ func compareAsStringOrSlice(a, b interface{}) bool {
 	}
 	return true
 }
+
+// DescribeChanges sets the Summary of each change whose attribute has a ChangeDescriber.
+// describerFor returns nil for attributes that have none. Values that cannot be described
+// leave the Summary empty, as the old and new values are still recorded.
+func DescribeChanges(changes []AttributeChange, describerFor func(name string) ChangeDescriber) {
+	for i := range changes {
+		describer := describerFor(changes[i].Name)
+		if describer == nil {
+			continue
+		}
+
+		var oldValues, newValues []string
+		if changes[i].Old != nil {
+			oldValues, _ = AssertStringSlice(changes[i].Old)
+		}
+		if changes[i].New != nil {
+			newValues, _ = AssertStringSlice(changes[i].New)
+		}
+
+		if summary, err := describer.DescribeChange(oldValues, newValues); err == nil {
+			changes[i].Summary = summary
+		}
+	}
+}
@@ -2,7 +2,14 @@ package diff
 
 // AttributeChange represents a change between two snapshots of an attribute.
 type AttributeChange struct {
-	Name string
-	Old  interface{}
-	New  interface{}
+	Name    string
+	Old     interface{}
+	New     interface{}
+	Summary string // the change in words, for attributes with a ChangeDescriber
+}
+
+// ChangeDescriber describes the change of an attribute between two normalized values in
+// words, such as the flags set and cleared in a bitmask.
+type ChangeDescriber interface {
+	DescribeChange(oldValues, newValues []string) (string, error)
 }
@@ -45,19 +45,19 @@ func (s *Service) CreateSnapshot(obj *activedirectory.ActiveDirectoryObject) (*S
 	}
 
 	// Check if object is deleted
-	isDeleted := false
-	if deletedAttr, ok := obj.GetNormalizedAttribute("isDeleted"); ok && deletedAttr == "TRUE" {
-		isDeleted = true
-	}
+	isDeleted, isRecycled := DeletionState(attributes)
 
 	return &Snapshot{
-		ObjectGUID: obj.ObjectGUID,
-		ObjectType: objectType,
-		DN:         obj.DN,
-		IsDeleted:  isDeleted,
-		USNChanged: usnChanged,
-		Attributes: attributes,
-		Timestamp:  time.Now(),
+		ObjectGUID:    obj.ObjectGUID,
+		ObjectType:    objectType,
+		DN:            obj.DN,
+		IsDeleted:     isDeleted,
+		IsRecycled:    isRecycled,
+		USNChanged:    usnChanged,
+		Attributes:    attributes,
+		Metadata:      obj.ReplicationMetadata,
+		ValueMetadata: obj.ValueMetadata,
+		Timestamp:     time.Now(),
 	}, nil
 }
 
@@ -78,6 +78,20 @@ func (s *Service) CompareSnapshots(oldAttributes, newAttributes map[string][]str
 	return diff.FindChanges(oldMap, newMap)
 }
 
+// DeletionState reports whether a normalized attribute map describes a deleted object, and
+// whether that object has also been recycled.
+func DeletionState(attributes map[string][]string) (isDeleted, isRecycled bool) {
+	isDeleted = hasTrueValue(attributes, "isDeleted")
+	isRecycled = isDeleted && hasTrueValue(attributes, "isRecycled")
+	return isDeleted, isRecycled
+}
+
+// hasTrueValue reports whether the Boolean attribute attrName is present and TRUE.
+func hasTrueValue(attributes map[string][]string, attrName string) bool {
+	values, ok := attributes[attrName]
+	return ok && len(values) > 0 && values[0] == "TRUE"
+}
+
 // extractObjectType determines the object type from an ActiveDirectoryObject.
 // For deleted objects (missing objectCategory), it returns "deletedObject".
 // Otherwise, it returns the normalized objectCategory value.
@@ -3,6 +3,8 @@ package snapshot
 import (
 	"time"
 
+	"f0oster/adspy/activedirectory"
+
 	"github.com/google/uuid"
 )
 
@@ -18,16 +20,38 @@ type Snapshot struct {
 	// DN is the Distinguished Name of the object
 	DN string
 
+	// NamingContext is the type of naming context the object was read from (domain,
+	// configuration or schema)
+	NamingContext string
+
 	// IsDeleted indicates if this object is in the Deleted Objects container
 	IsDeleted bool
 
+	// IsRecycled indicates the object was stripped to a recycled object (Recycle Bin) and can no
+	// longer be restored
+	IsRecycled bool
+
 	// USNChanged is the AD-native update sequence number for this version
 	USNChanged int64
 
+	// InvocationID identifies the replica the snapshot was read from. USNChanged is local to
+	// that replica and only comparable with USNs read from it.
+	InvocationID uuid.UUID
+
 	// Attributes contains the normalized string representation of all object attributes
 	// Key: attribute name, Value: attribute values as string slice
 	Attributes map[string][]string
 
+	// Partial indicates Attributes only holds the attributes that changed, as returned by an
+	// incremental DirSync. An attribute with no values was cleared.
+	Partial bool
+
+	// Metadata records the originating write of each attribute, used to attribute changes
+	Metadata activedirectory.ReplicationMetadata
+
+	// ValueMetadata records the originating write of each linked attribute value
+	ValueMetadata activedirectory.LinkedValueMetadata
+
 	// Timestamp records when this snapshot was created
 	Timestamp time.Time
 }
@@ -0,0 +1,98 @@
+package versioning
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"f0oster/adspy/database"
+	"f0oster/adspy/snapshot"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// findDNChange classifies the change from oldDN to newDN as a rename (new RDN), a move (new
+// parent) or both. ok is false when the object kept its RDN and parent.
+// Business logic: a parent that differs only in case was renamed itself; that is recorded
+// against the parent, not as a move of this object.
+func findDNChange(oldDN, newDN string) (change database.DNChange, ok bool) {
+	if oldDN == "" || oldDN == newDN {
+		return database.DNChange{}, false
+	}
+
+	oldRDN, oldParent := splitDN(oldDN)
+	newRDN, newParent := splitDN(newDN)
+
+	renamed := oldRDN != newRDN
+	moved := !strings.EqualFold(oldParent, newParent)
+
+	var changeType string
+	switch {
+	case renamed && moved:
+		changeType = DNRenamedAndMoved
+	case renamed:
+		changeType = DNRenamed
+	case moved:
+		changeType = DNMoved
+	default:
+		return database.DNChange{}, false
+	}
+
+	return database.DNChange{
+		Type:      changeType,
+		OldDN:     oldDN,
+		NewDN:     newDN,
+		OldRDN:    oldRDN,
+		NewRDN:    newRDN,
+		OldParent: oldParent,
+		NewParent: newParent,
+	}, true
+}
+
+// splitDN splits a DN into its RDN and the DN of its parent. Escaped commas (\, and \2C) are
+// part of the RDN.
+func splitDN(dn string) (rdn, parent string) {
+	for i := 0; i < len(dn); i++ {
+		switch dn[i] {
+		case '\\':
+			i++ // skip the escaped character; a hex pair never contains a comma
+		case ',':
+			return dn[:i], strings.TrimLeft(dn[i+1:], " ")
+		}
+	}
+	return dn, ""
+}
+
+// recordDNChange records a rename or move of a live object and moves the stored DNs of its
+// descendants along with it, since their uSNChanged does not change when a parent does.
+func (s *Service) recordDNChange(
+	ctx context.Context,
+	tx pgx.Tx,
+	versionID int64,
+	snap *snapshot.Snapshot,
+	previousDN string,
+	domainID uuid.UUID,
+) error {
+	change, ok := findDNChange(previousDN, snap.DN)
+	if !ok {
+		return nil
+	}
+
+	// Moves are replicated as a write of the RDN attribute, name
+	change.Time = snap.Timestamp
+	if metadata, ok := snap.Metadata.Get("name"); ok && !metadata.OriginatingChangeTime.IsZero() {
+		change.Time = metadata.OriginatingChangeTime
+	}
+
+	if err := s.dbClient.RecordDNChange(ctx, tx, versionID, snap.ObjectGUID, change); err != nil {
+		return fmt.Errorf("failed to record DN change: %w", err)
+	}
+	if err := s.dbClient.UpdateDescendantDNs(ctx, tx, domainID, change.OldDN, change.NewDN); err != nil {
+		return fmt.Errorf("failed to update descendant DNs: %w", err)
+	}
+
+	log.Printf("Object %s %s: %s -> %s", snap.ObjectGUID, change.Type, change.OldDN, change.NewDN)
+	return nil
+}
@@ -0,0 +1,45 @@
+package versioning
+
+import "testing"
+
+func TestFindDNChange(t *testing.T) {
+	tests := []struct {
+		name         string
+		oldDN, newDN string
+		wantType     string
+		wantOK       bool
+	}{
+		{"unchanged", "CN=Alice,OU=Users,DC=example,DC=com", "CN=Alice,OU=Users,DC=example,DC=com", "", false},
+		{"new object", "", "CN=Alice,OU=Users,DC=example,DC=com", "", false},
+		{"renamed", "CN=Alice,OU=Users,DC=example,DC=com", "CN=Alice Smith,OU=Users,DC=example,DC=com", DNRenamed, true},
+		{"case-only rename", "CN=alice,OU=Users,DC=example,DC=com", "CN=Alice,OU=Users,DC=example,DC=com", DNRenamed, true},
+		{"moved", "CN=Alice,OU=Users,DC=example,DC=com", "CN=Alice,OU=Staff,DC=example,DC=com", DNMoved, true},
+		{"renamed and moved", "CN=Alice,OU=Users,DC=example,DC=com", "CN=Alice Smith,OU=Staff,DC=example,DC=com", DNRenamedAndMoved, true},
+		{"parent renamed in case only", "CN=Alice,OU=users,DC=example,DC=com", "CN=Alice,OU=Users,DC=example,DC=com", "", false},
+	}
+
+	for _, tt := range tests {
+		change, ok := findDNChange(tt.oldDN, tt.newDN)
+		if ok != tt.wantOK || change.Type != tt.wantType {
+			t.Errorf("%s: got %q, %v; want %q, %v", tt.name, change.Type, ok, tt.wantType, tt.wantOK)
+		}
+	}
+}
+
+func TestSplitDN(t *testing.T) {
+	tests := []struct {
+		dn, rdn, parent string
+	}{
+		{"CN=Alice,OU=Users,DC=example,DC=com", "CN=Alice", "OU=Users,DC=example,DC=com"},
+		{`CN=Smith\, Alice,OU=Users,DC=example,DC=com`, `CN=Smith\, Alice`, "OU=Users,DC=example,DC=com"},
+		{`CN=Smith\2C Alice, OU=Users,DC=example,DC=com`, `CN=Smith\2C Alice`, "OU=Users,DC=example,DC=com"},
+		{"DC=com", "DC=com", ""},
+	}
+
+	for _, tt := range tests {
+		rdn, parent := splitDN(tt.dn)
+		if rdn != tt.rdn || parent != tt.parent {
+			t.Errorf("splitDN(%q) = %q, %q; want %q, %q", tt.dn, rdn, parent, tt.rdn, tt.parent)
+		}
+	}
+}
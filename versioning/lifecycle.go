@@ -0,0 +1,147 @@
+package versioning
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"f0oster/adspy/database"
+	"f0oster/adspy/snapshot"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// lifecycleState returns the lifecycle state of an object from its deletion flags.
+//
+// With the Recycle Bin enabled an object goes live -> deleted (attributes retained, renamed with
+// msDS-LastKnownRDN kept) -> recycled (attributes stripped), and can be restored while deleted.
+// Without it a deleted object is a tombstone, which is reported as deleted.
+func lifecycleState(isDeleted, isRecycled bool) string {
+	switch {
+	case isRecycled:
+		return StateRecycled
+	case isDeleted:
+		return StateDeleted
+	default:
+		return StateLive
+	}
+}
+
+// classifyLifecycle returns the event for a transition between two lifecycle states.
+// Business logic: a live object that is already recycled when first seen deleted was deleted
+// and recycled between two polls, and is recorded as deleted. An object that returns to live
+// from either state was restored (reanimated tombstones and authoritative restores included).
+func classifyLifecycle(from, to string) (event string, ok bool) {
+	switch {
+	case from == to:
+		return "", false
+	case to == StateLive:
+		return EventRestored, true
+	case from == StateLive:
+		return EventDeleted, true
+	case from == StateDeleted && to == StateRecycled:
+		return EventRecycled, true
+	default:
+		// recycled -> deleted is not a transition Active Directory makes
+		return "", false
+	}
+}
+
+// recordLifecycle classifies the snapshot against the previous state of the object and records
+// the transition, keeping the object's lifecycle state and deleted_at in step.
+// previousDN is the DN of the previous version.
+func (s *Service) recordLifecycle(
+	ctx context.Context,
+	tx pgx.Tx,
+	versionID int64,
+	snap *snapshot.Snapshot,
+	from string,
+	previousDN string,
+) error {
+	to := lifecycleState(snap.IsDeleted, snap.IsRecycled)
+	eventType, ok := classifyLifecycle(from, to)
+	if !ok {
+		if from != to {
+			log.Printf("Warning: unexpected lifecycle transition %s -> %s for %s (DN: %s)", from, to, snap.ObjectGUID, snap.DN)
+		}
+		return nil
+	}
+
+	lastKnownParent := firstValue(attributeValues(snap.Attributes, "lastKnownParent"))
+	event := database.LifecycleEvent{
+		Type:            eventType,
+		From:            from,
+		To:              to,
+		Time:            lifecycleEventTime(snap, eventType),
+		DN:              snap.DN,
+		LastKnownRDN:    firstValue(attributeValues(snap.Attributes, "msDS-LastKnownRDN")),
+		LastKnownParent: lastKnownParent,
+	}
+	if err := s.dbClient.RecordLifecycleEvent(ctx, tx, versionID, snap.ObjectGUID, event); err != nil {
+		return fmt.Errorf("failed to record %s event: %w", eventType, err)
+	}
+	if err := s.dbClient.UpdateObjectLifecycleState(ctx, tx, snap.ObjectGUID, to); err != nil {
+		return fmt.Errorf("failed to update lifecycle state: %w", err)
+	}
+
+	switch eventType {
+	case EventDeleted:
+		if err := s.dbClient.MarkObjectDeleted(ctx, tx, snap.ObjectGUID, event.Time, previousDN, lastKnownParent); err != nil {
+			return fmt.Errorf("failed to mark object deleted: %w", err)
+		}
+	case EventRestored:
+		if err := s.dbClient.MarkObjectRestored(ctx, tx, snap.ObjectGUID); err != nil {
+			return fmt.Errorf("failed to mark object restored: %w", err)
+		}
+	}
+
+	log.Printf("Object %s %s (%s -> %s, DN: %s)", snap.ObjectGUID, eventType, from, to, snap.DN)
+	return nil
+}
+
+// recordInitialLifecycle records the state of an object first seen deleted or recycled, such as
+// by a full resync after it was deleted.
+// Business decision: the deletion itself was not observed, so no event is recorded and the
+// object has no last live DN.
+func (s *Service) recordInitialLifecycle(
+	ctx context.Context,
+	tx pgx.Tx,
+	snap *snapshot.Snapshot,
+) error {
+	state := lifecycleState(snap.IsDeleted, snap.IsRecycled)
+	if state == StateLive {
+		return nil
+	}
+
+	if err := s.dbClient.UpdateObjectLifecycleState(ctx, tx, snap.ObjectGUID, state); err != nil {
+		return fmt.Errorf("failed to update lifecycle state: %w", err)
+	}
+	lastKnownParent := firstValue(attributeValues(snap.Attributes, "lastKnownParent"))
+	if err := s.dbClient.MarkObjectDeleted(ctx, tx, snap.ObjectGUID, lifecycleEventTime(snap, EventDeleted), "", lastKnownParent); err != nil {
+		return fmt.Errorf("failed to mark object deleted: %w", err)
+	}
+	return nil
+}
+
+// lifecycleEventTime returns when a transition happened: the originating write of the flag it
+// changed when the DC reported replication metadata, otherwise the time it was observed.
+// A restore removes isDeleted, which is itself an originating write of isDeleted.
+func lifecycleEventTime(snap *snapshot.Snapshot, eventType string) time.Time {
+	attrName := "isDeleted"
+	if eventType == EventRecycled {
+		attrName = "isRecycled"
+	}
+	if metadata, ok := snap.Metadata.Get(attrName); ok && !metadata.OriginatingChangeTime.IsZero() {
+		return metadata.OriginatingChangeTime
+	}
+	return snap.Timestamp
+}
+
+// firstValue returns the first of values, or an empty string.
+func firstValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
@@ -0,0 +1,44 @@
+package versioning
+
+import "testing"
+
+func TestLifecycleState(t *testing.T) {
+	tests := []struct {
+		isDeleted, isRecycled bool
+		want                  string
+	}{
+		{false, false, StateLive},
+		{true, false, StateDeleted},
+		{true, true, StateRecycled},
+	}
+
+	for _, tt := range tests {
+		if got := lifecycleState(tt.isDeleted, tt.isRecycled); got != tt.want {
+			t.Errorf("lifecycleState(%v, %v) = %q, want %q", tt.isDeleted, tt.isRecycled, got, tt.want)
+		}
+	}
+}
+
+func TestClassifyLifecycle(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     string
+		ok       bool
+	}{
+		{StateLive, StateLive, "", false},
+		{StateLive, StateDeleted, EventDeleted, true},
+		{StateLive, StateRecycled, EventDeleted, true}, // deleted and recycled between polls
+		{StateDeleted, StateDeleted, "", false},
+		{StateDeleted, StateRecycled, EventRecycled, true},
+		{StateDeleted, StateLive, EventRestored, true},
+		{StateRecycled, StateLive, EventRestored, true},
+		{StateRecycled, StateDeleted, "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := classifyLifecycle(tt.from, tt.to)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("classifyLifecycle(%s, %s) = %q, %v; want %q, %v", tt.from, tt.to, got, ok, tt.want, tt.ok)
+		}
+	}
+}
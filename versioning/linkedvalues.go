@@ -0,0 +1,183 @@
+package versioning
+
+import (
+	"encoding/hex"
+	"sort"
+	"strings"
+
+	"f0oster/adspy/activedirectory"
+	"f0oster/adspy/activedirectory/transformers"
+)
+
+// linkedValueChange is a single value added to or removed from a linked attribute.
+type linkedValueChange struct {
+	ValueDN  string
+	Data     string // binary data (hex) or string of a DN-Binary or DN-String value
+	Type     string
+	Metadata *activedirectory.ValueMetadata // nil for legacy values
+	Shared   bool                           // other values link to the same DN, so Metadata is unknown
+}
+
+// Legacy reports whether the value has no replication metadata of its own.
+func (c linkedValueChange) Legacy() bool {
+	return c.Metadata == nil && !c.Shared
+}
+
+// linkedValue is a value of a DN-valued attribute split into the DN and the data tied to it.
+type linkedValue struct {
+	DN   string
+	Data string
+}
+
+// key identifies the value: DNs compare case-insensitively, the data exactly.
+func (v linkedValue) key() string {
+	return strings.ToLower(v.DN) + "\x00" + v.Data
+}
+
+// isDNSyntax reports whether values of the attributeSyntax link to objects.
+func isDNSyntax(syntax string) bool {
+	return syntax == dnSyntax || syntax == dnBinarySyntax || syntax == dnStringSyntax
+}
+
+// splitLinkedValue splits DN-Binary (B:<count>:<hex>:<DN>) and DN-String (S:<count>:<string>:<DN>)
+// values so they are compared per DN rather than as text; other values are the DN itself.
+func splitLinkedValue(value string) linkedValue {
+	if v, err := transformers.ParseDNBinary(value); err == nil {
+		return linkedValue{DN: v.DN, Data: strings.ToUpper(hex.EncodeToString(v.Binary))}
+	}
+	if v, err := transformers.ParseDNString(value); err == nil {
+		return linkedValue{DN: v.DN, Data: v.String}
+	}
+	return linkedValue{DN: value}
+}
+
+// findLinkedValueChanges works out which values of a linked attribute were added or removed
+// since the previous version, attributing each to its own originating write.
+//
+// Business logic:
+//   - Values that differ between the stored and the current value sets are always reported.
+//     A value with LVR metadata is attributed to it; a legacy value has none.
+//   - Values whose metadata was written locally after sinceUSN are reported too, even if the
+//     value sets agree, so a value that was removed and re-added between two polls still shows
+//     both its latest state and when it happened.
+//   - DN-Binary and DN-String values are compared by DN and data. Value metadata only names the
+//     DN, so it is attributed only where a single value links to that DN; the keys of
+//     msDS-KeyCredentialLink, which all link to the object itself, are reported as shared.
+func findLinkedValueChanges(
+	oldValues, newValues []string,
+	metadata []activedirectory.ValueMetadata,
+	sinceUSN int64,
+) []linkedValueChange {
+	oldSplit := splitLinkedValues(oldValues)
+	newSplit := splitLinkedValues(newValues)
+
+	// values per DN, to tell whether metadata identifies a single value
+	byDNValues := make(map[string][]linkedValue)
+	seen := make(map[string]bool)
+	for _, v := range append(append([]linkedValue{}, newSplit...), oldSplit...) {
+		if seen[v.key()] {
+			continue
+		}
+		seen[v.key()] = true
+		dn := strings.ToLower(v.DN)
+		byDNValues[dn] = append(byDNValues[dn], v)
+	}
+
+	byDN := make(map[string]*activedirectory.ValueMetadata, len(metadata))
+	for i := range metadata {
+		if metadata[i].Legacy() {
+			continue
+		}
+		byDN[strings.ToLower(metadata[i].ObjectDN)] = &metadata[i]
+	}
+	change := func(v linkedValue, changeType string) linkedValueChange {
+		dn := strings.ToLower(v.DN)
+		if len(byDNValues[dn]) > 1 {
+			return linkedValueChange{ValueDN: v.DN, Data: v.Data, Type: changeType, Shared: true}
+		}
+		return linkedValueChange{ValueDN: v.DN, Data: v.Data, Type: changeType, Metadata: byDN[dn]}
+	}
+
+	oldSet := keySet(oldSplit)
+	newSet := keySet(newSplit)
+
+	var changes []linkedValueChange
+	reported := make(map[string]bool)
+
+	for _, v := range newSplit {
+		key := v.key()
+		if oldSet[key] || reported[key] {
+			continue
+		}
+		reported[key] = true
+		changes = append(changes, change(v, LinkedValueAdded))
+	}
+
+	for _, v := range oldSplit {
+		key := v.key()
+		if newSet[key] || reported[key] {
+			continue
+		}
+		reported[key] = true
+		changes = append(changes, change(v, LinkedValueRemoved))
+	}
+
+	for dn, value := range byDN {
+		if value.LocalUSN <= sinceUSN {
+			continue
+		}
+		// the value the metadata describes, or the bare DN if it was added and removed again
+		v := linkedValue{DN: value.ObjectDN}
+		switch values := byDNValues[dn]; len(values) {
+		case 0:
+		case 1:
+			v = values[0]
+		default:
+			continue
+		}
+		if reported[v.key()] {
+			continue
+		}
+		changeType := LinkedValueAdded
+		if value.Removed() {
+			changeType = LinkedValueRemoved
+		}
+		changes = append(changes, linkedValueChange{ValueDN: value.ObjectDN, Data: v.Data, Type: changeType, Metadata: value})
+	}
+
+	// Order by when each change happened, legacy values (no time) first
+	sort.Slice(changes, func(i, j int) bool {
+		if ti, tj := changeTime(changes[i]), changeTime(changes[j]); ti != tj {
+			return ti < tj
+		}
+		if changes[i].ValueDN != changes[j].ValueDN {
+			return changes[i].ValueDN < changes[j].ValueDN
+		}
+		return changes[i].Data < changes[j].Data
+	})
+
+	return changes
+}
+
+func splitLinkedValues(values []string) []linkedValue {
+	split := make([]linkedValue, len(values))
+	for i, value := range values {
+		split[i] = splitLinkedValue(value)
+	}
+	return split
+}
+
+func changeTime(c linkedValueChange) int64 {
+	if c.Metadata == nil {
+		return 0
+	}
+	return c.Metadata.OriginatingChangeTime.UnixNano()
+}
+
+func keySet(values []linkedValue) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v.key()] = true
+	}
+	return set
+}
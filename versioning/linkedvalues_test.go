@@ -0,0 +1,139 @@
+package versioning
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"f0oster/adspy/activedirectory"
+)
+
+const (
+	alice = "CN=Alice,OU=Users,DC=example,DC=com"
+	bob   = "CN=Bob,OU=Users,DC=example,DC=com"
+	carol = "CN=Carol,OU=Users,DC=example,DC=com"
+	dave  = "CN=Dave,OU=Users,DC=example,DC=com"
+)
+
+func valueMetadata(dn string, localUSN int64, changed time.Time, removed bool) activedirectory.ValueMetadata {
+	value := activedirectory.ValueMetadata{
+		AttributeName:         "member",
+		ObjectDN:              dn,
+		Version:               1,
+		OriginatingChangeTime: changed,
+		OriginatingUSN:        localUSN,
+		LocalUSN:              localUSN,
+		OriginatingDsaDN:      "CN=NTDS Settings,CN=DC1,CN=Servers,CN=Default-First-Site-Name,CN=Sites,CN=Configuration,DC=example,DC=com",
+	}
+	if removed {
+		value.Deleted = changed
+	}
+	return value
+}
+
+func TestFindLinkedValueChanges_AttributesEachValue(t *testing.T) {
+	base := time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC)
+
+	// Between two polls Bob was added at 09:05 and Alice removed at 09:01; Carol is a legacy value
+	oldValues := []string{alice, carol}
+	newValues := []string{carol, bob}
+	metadata := []activedirectory.ValueMetadata{
+		valueMetadata(alice, 120, base.Add(1*time.Minute), true),
+		valueMetadata(bob, 125, base.Add(5*time.Minute), false),
+		{AttributeName: "member", ObjectDN: carol}, // legacy: no metadata of its own
+	}
+
+	changes := findLinkedValueChanges(oldValues, newValues, metadata, 100)
+	if len(changes) != 2 {
+		t.Fatalf("got %d changes, want 2: %+v", len(changes), changes)
+	}
+
+	if changes[0].ValueDN != alice || changes[0].Type != LinkedValueRemoved || changes[0].Legacy() {
+		t.Errorf("first change = %+v, want Alice removed with metadata", changes[0])
+	}
+	if !changes[0].Metadata.OriginatingChangeTime.Equal(base.Add(time.Minute)) {
+		t.Errorf("Alice removed at %s, want %s", changes[0].Metadata.OriginatingChangeTime, base.Add(time.Minute))
+	}
+	if changes[1].ValueDN != bob || changes[1].Type != LinkedValueAdded || changes[1].Legacy() {
+		t.Errorf("second change = %+v, want Bob added with metadata", changes[1])
+	}
+}
+
+func TestFindLinkedValueChanges_LegacyValues(t *testing.T) {
+	metadata := []activedirectory.ValueMetadata{
+		{AttributeName: "member", ObjectDN: alice},
+	}
+
+	changes := findLinkedValueChanges([]string{alice, carol}, []string{alice}, metadata, 100)
+	if len(changes) != 1 {
+		t.Fatalf("got %d changes, want 1: %+v", len(changes), changes)
+	}
+	if changes[0].ValueDN != carol || changes[0].Type != LinkedValueRemoved || !changes[0].Legacy() {
+		t.Errorf("change = %+v, want legacy removal of Carol", changes[0])
+	}
+}
+
+func TestFindLinkedValueChanges_ChangesWithinOnePoll(t *testing.T) {
+	base := time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC)
+
+	// Dave was added and removed again, Alice removed and re-added, all since USN 100, so
+	// neither shows up in the value sets
+	metadata := []activedirectory.ValueMetadata{
+		valueMetadata(alice, 130, base.Add(3*time.Minute), false),
+		valueMetadata(dave, 110, base.Add(2*time.Minute), true),
+		valueMetadata(bob, 90, base, false), // unchanged since the previous version
+	}
+
+	changes := findLinkedValueChanges([]string{alice, bob}, []string{alice, bob}, metadata, 100)
+	if len(changes) != 2 {
+		t.Fatalf("got %d changes, want 2: %+v", len(changes), changes)
+	}
+	if changes[0].ValueDN != dave || changes[0].Type != LinkedValueRemoved {
+		t.Errorf("first change = %+v, want Dave removed", changes[0])
+	}
+	if changes[1].ValueDN != alice || changes[1].Type != LinkedValueAdded {
+		t.Errorf("second change = %+v, want Alice added", changes[1])
+	}
+}
+
+func TestFindLinkedValueChanges_DNBinaryValues(t *testing.T) {
+	base := time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC)
+	self := "CN=Alice,OU=Users,DC=example,DC=com"
+
+	// Two keys of msDS-KeyCredentialLink link to the object itself; a third replaced one of them.
+	// Only the data tells them apart, so none can be attributed from value metadata by DN.
+	keyA := "B:8:0002AAAA:" + self
+	keyB := "B:8:0002BBBB:" + self
+	keyC := "B:8:0002CCCC:" + self
+	metadata := []activedirectory.ValueMetadata{valueMetadata(self, 130, base, false)}
+
+	changes := findLinkedValueChanges([]string{keyA, keyB}, []string{keyA, keyC}, metadata, 100)
+	if len(changes) != 2 {
+		t.Fatalf("got %d changes, want 2: %+v", len(changes), changes)
+	}
+	if changes[0].ValueDN != self || changes[0].Data != "0002BBBB" || changes[0].Type != LinkedValueRemoved {
+		t.Errorf("first change = %+v, want key BBBB removed", changes[0])
+	}
+	if changes[1].ValueDN != self || changes[1].Data != "0002CCCC" || changes[1].Type != LinkedValueAdded {
+		t.Errorf("second change = %+v, want key CCCC added", changes[1])
+	}
+	for _, change := range changes {
+		if change.Metadata != nil || change.Legacy() {
+			t.Errorf("change = %+v, want shared value without metadata that is not legacy", change)
+		}
+	}
+
+	// A DN-String value is the only value linking to Bob, so it is attributed; DNs compare
+	// case-insensitively, so the unchanged value of Carol is not reported
+	metadata = []activedirectory.ValueMetadata{valueMetadata(bob, 140, base.Add(time.Minute), false)}
+	changes = findLinkedValueChanges(
+		[]string{"S:5:Staff:" + carol},
+		[]string{"S:5:Staff:" + strings.ToUpper(carol), "S:5:Admin:" + bob},
+		metadata, 100)
+	if len(changes) != 1 {
+		t.Fatalf("got %d changes, want 1: %+v", len(changes), changes)
+	}
+	if changes[0].ValueDN != bob || changes[0].Data != "Admin" || changes[0].Type != LinkedValueAdded || changes[0].Metadata == nil {
+		t.Errorf("change = %+v, want Bob added as Admin with metadata", changes[0])
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
+	"strings"
+	"time"
 
 	"f0oster/adspy/activedirectory/schema"
 	"f0oster/adspy/database"
@@ -15,17 +18,41 @@ import (
 	"github.com/jackc/pgx/v5"
 )
 
+// Store persists objects, their versions and the changes between them. It is implemented by
+// database.DBClient.
+type Store interface {
+	BeginTx(ctx context.Context) (pgx.Tx, error)
+	CommitTx(ctx context.Context, tx pgx.Tx) error
+	RollbackTx(ctx context.Context, tx pgx.Tx) error
+
+	UpsertObject(ctx context.Context, tx pgx.Tx, objectID uuid.UUID, objectType string, dn string, domainID uuid.UUID, namingContext string) (*int64, string, error)
+	GetVersion(ctx context.Context, tx pgx.Tx, versionID int64) (*database.ObjectVersion, error)
+	CreateVersion(ctx context.Context, tx pgx.Tx, objectID uuid.UUID, invocationID uuid.UUID, usnChanged int64, timestamp time.Time, attributesJSON []byte, modifiedBy string) (int64, error)
+	UpdateCurrentVersion(ctx context.Context, tx pgx.Tx, versionID int64, objectID uuid.UUID) error
+
+	RecordAttributeChange(ctx context.Context, tx pgx.Tx, versionID int64, objectID uuid.UUID, domainID uuid.UUID, attributeSchemaID uuid.UUID, oldValue []byte, newValue []byte, summary string, timestamp time.Time, origin *database.AttributeOrigin) error
+	RecordLinkedValueChange(ctx context.Context, tx pgx.Tx, versionID int64, objectID uuid.UUID, domainID uuid.UUID, attributeSchemaID uuid.UUID, valueDN string, valueData string, changeType string, legacy bool, origin *database.AttributeOrigin) error
+
+	RecordLifecycleEvent(ctx context.Context, tx pgx.Tx, versionID int64, objectID uuid.UUID, event database.LifecycleEvent) error
+	UpdateObjectLifecycleState(ctx context.Context, tx pgx.Tx, objectID uuid.UUID, state string) error
+	MarkObjectDeleted(ctx context.Context, tx pgx.Tx, objectID uuid.UUID, deletedAt time.Time, lastLiveDN string, lastKnownParent string) error
+	MarkObjectRestored(ctx context.Context, tx pgx.Tx, objectID uuid.UUID) error
+
+	RecordDNChange(ctx context.Context, tx pgx.Tx, versionID int64, objectID uuid.UUID, change database.DNChange) error
+	UpdateDescendantDNs(ctx context.Context, tx pgx.Tx, domainID uuid.UUID, oldDN string, newDN string) error
+}
+
 // Service handles versioning business logic for Active Directory objects.
 // It orchestrates snapshot comparison, version creation, and change tracking.
 type Service struct {
-	dbClient        *database.DBClient
+	dbClient        Store
 	snapshotService *snapshot.Service
 	domainID        uuid.UUID
 	schemaRegistry  *schema.SchemaRegistry
 }
 
 func NewService(
-	client *database.DBClient,
+	client Store,
 	snapSvc *snapshot.Service,
 	domainID uuid.UUID,
 	schemaRegistry *schema.SchemaRegistry,
@@ -85,24 +112,25 @@ func (s *Service) processSnapshot(
 	domainID uuid.UUID,
 ) error {
 	// Upsert the object record
-	currentUSN, err := s.dbClient.UpsertObject(
+	currentVersionID, previousDN, err := s.dbClient.UpsertObject(
 		ctx, tx,
 		snap.ObjectGUID,
 		snap.ObjectType,
 		snap.DN,
 		domainID,
+		snap.NamingContext,
 	)
 	if err != nil {
 		return fmt.Errorf("upsert object failed: %w", err)
 	}
 
-	// Business decision: New object (no current USN) or existing object?
-	if currentUSN == nil {
+	// Business decision: New object (no current version) or existing object?
+	if currentVersionID == nil {
 		return s.createInitialVersion(ctx, tx, snap)
 	}
 
 	// Existing object - check for changes
-	return s.updateIfChanged(ctx, tx, snap, *currentUSN)
+	return s.updateIfChanged(ctx, tx, snap, *currentVersionID, previousDN, domainID)
 }
 
 // createInitialVersion creates the first version for a new object.
@@ -118,21 +146,27 @@ func (s *Service) createInitialVersion(
 		return fmt.Errorf("failed to marshal attributes: %w", err)
 	}
 
-	// Create new version using the object's USN
-	if err := s.dbClient.CreateVersion(
+	// Create new version using the object's USN on the replica it was read from
+	versionID, err := s.dbClient.CreateVersion(
 		ctx, tx,
 		snap.ObjectGUID,
+		snap.InvocationID,
 		snap.USNChanged,
 		snap.Timestamp,
 		snapshotJSON,
 		ModifiedBySystem,
-	); err != nil {
+	)
+	if err != nil {
 		return fmt.Errorf("failed to create version: %w", err)
 	}
 
-	// Update object to point to this version's USN
-	if err := s.dbClient.UpdateLastProcessedUSN(ctx, tx, snap.USNChanged, snap.ObjectGUID); err != nil {
-		return fmt.Errorf("failed to update current USN: %w", err)
+	// Update object to point to this version
+	if err := s.dbClient.UpdateCurrentVersion(ctx, tx, versionID, snap.ObjectGUID); err != nil {
+		return fmt.Errorf("failed to update current version: %w", err)
+	}
+
+	if err := s.recordInitialLifecycle(ctx, tx, snap); err != nil {
+		return err
 	}
 
 	log.Printf("Created new object %s (DN: %s) with USN %d", snap.ObjectGUID, snap.DN, snap.USNChanged)
@@ -145,20 +179,37 @@ func (s *Service) updateIfChanged(
 	ctx context.Context,
 	tx pgx.Tx,
 	snap *snapshot.Snapshot,
-	currentUSN int64,
+	currentVersionID int64,
+	previousDN string,
+	domainID uuid.UUID,
 ) error {
-	// Load previous snapshot from database using composite key
-	previousJSON, err := s.dbClient.GetVersionSnapshot(ctx, tx, snap.ObjectGUID, currentUSN)
+	// Load the current version of the object
+	previous, err := s.dbClient.GetVersion(ctx, tx, currentVersionID)
 	if err != nil {
 		return fmt.Errorf("failed to load previous snapshot: %w", err)
 	}
 
+	// Business decision: the same USN on the same replica as the stored version = the object
+	// was re-read (restart or full resync) without changing in the directory. USNs are local to
+	// each replica, so a snapshot read from another replica is always compared.
+	sameReplica := previous.InvocationID == snap.InvocationID
+	if sameReplica && previous.USNChanged == snap.USNChanged {
+		return nil
+	}
+
 	// Unmarshal previous attributes
-	previousAttributes, err := s.unmarshalAttributes(previousJSON)
+	previousAttributes, err := s.unmarshalAttributes(previous.Attributes)
 	if err != nil {
 		return fmt.Errorf("failed to unmarshal previous snapshot: %w", err)
 	}
 
+	// Business logic: A partial snapshot only carries changed attributes, so complete it from
+	// the previous version before comparing and storing it
+	if snap.Partial {
+		snap.Attributes = mergeAttributes(previousAttributes, snap.Attributes)
+		snap.IsDeleted, snap.IsRecycled = snapshot.DeletionState(snap.Attributes)
+	}
+
 	// Business logic: Compare snapshots to detect changes
 	changes, err := s.detectChanges(previousAttributes, snap.Attributes)
 	if err != nil {
@@ -176,21 +227,23 @@ func (s *Service) updateIfChanged(
 		return fmt.Errorf("failed to marshal attributes: %w", err)
 	}
 
-	// Create new version using the snapshot's USN
-	if err := s.dbClient.CreateVersion(
+	// Create new version using the snapshot's USN on the replica it was read from
+	versionID, err := s.dbClient.CreateVersion(
 		ctx, tx,
 		snap.ObjectGUID,
+		snap.InvocationID,
 		snap.USNChanged,
 		snap.Timestamp,
 		snapshotJSON,
 		ModifiedBySystem,
-	); err != nil {
+	)
+	if err != nil {
 		return fmt.Errorf("failed to create new version: %w", err)
 	}
 
-	// Update current USN pointer
-	if err := s.dbClient.UpdateLastProcessedUSN(ctx, tx, snap.USNChanged, snap.ObjectGUID); err != nil {
-		return fmt.Errorf("failed to update current USN: %w", err)
+	// Update current version pointer
+	if err := s.dbClient.UpdateCurrentVersion(ctx, tx, versionID, snap.ObjectGUID); err != nil {
+		return fmt.Errorf("failed to update current version: %w", err)
 	}
 
 	// Record individual attribute changes
@@ -214,24 +267,163 @@ func (s *Service) updateIfChanged(
 
 		if err := s.dbClient.RecordAttributeChange(
 			ctx, tx,
+			versionID,
 			snap.ObjectGUID,
-			snap.USNChanged,
+			s.domainID,
 			attrSchema.ObjectGUID,
 			oldJSON,
 			newJSON,
+			change.Summary,
 			snap.Timestamp,
+			attributeOrigin(snap, change.Name),
 		); err != nil {
 			return fmt.Errorf("failed to record attribute change for %s: %w", change.Name, err)
 		}
 
+		if change.Summary != "" {
+			log.Printf("Attribute change for %s (DN: %s) - %s: %s",
+				snap.ObjectGUID, snap.DN, change.Name, change.Summary)
+			continue
+		}
 		log.Printf("Attribute change for %s (DN: %s) - %s: %v -> %v",
 			snap.ObjectGUID, snap.DN, change.Name, change.Old, change.New)
 	}
 
+	// Business logic: value metadata USNs are local to the replica too, so a value whose value
+	// set is unchanged can only be recognised as re-written since the previous version when both
+	// were read from the same replica
+	sinceUSN := int64(math.MaxInt64)
+	if sameReplica {
+		sinceUSN = previous.USNChanged
+	}
+	if err := s.recordLinkedValueChanges(ctx, tx, versionID, snap, previousAttributes, changes, sinceUSN); err != nil {
+		return err
+	}
+
+	// Business logic: Classify deletes, recycles and restores against the previous version
+	previousState := lifecycleState(snapshot.DeletionState(previousAttributes))
+	if err := s.recordLifecycle(ctx, tx, versionID, snap, previousState, previousDN); err != nil {
+		return err
+	}
+
+	// Business decision: The DN change of a delete or restore is part of the lifecycle event,
+	// so only a live object that stays live is renamed or moved
+	if previousState == StateLive && !snap.IsDeleted {
+		if err := s.recordDNChange(ctx, tx, versionID, snap, previousDN, domainID); err != nil {
+			return err
+		}
+	}
+
 	log.Printf("Updated object %s (DN: %s) with %d changes at USN %d", snap.ObjectGUID, snap.DN, len(changes), snap.USNChanged)
 	return nil
 }
 
+// recordLinkedValueChanges records each value added to or removed from a linked attribute
+// (such as member) with its own originating time and DC.
+// Business logic: linked attributes are those the DC reported value metadata for, plus changed
+// multi-valued DN, DN-Binary and DN-String attributes, whose values may all be legacy values
+// without metadata or not linked at all (wellKnownObjects).
+func (s *Service) recordLinkedValueChanges(
+	ctx context.Context,
+	tx pgx.Tx,
+	versionID int64,
+	snap *snapshot.Snapshot,
+	previousAttributes map[string][]string,
+	changes []diff.AttributeChange,
+	sinceUSN int64,
+) error {
+	linked := make(map[string]string) // lower-cased name -> name
+	for _, values := range snap.ValueMetadata {
+		if len(values) > 0 {
+			linked[strings.ToLower(values[0].AttributeName)] = values[0].AttributeName
+		}
+	}
+	for _, change := range changes {
+		attrSchema, ok := s.schemaRegistry.GetAttributeSchema(change.Name)
+		if ok && isDNSyntax(attrSchema.AttributeSyntax) && !attrSchema.AttributeIsSingleValued {
+			linked[strings.ToLower(change.Name)] = change.Name
+		}
+	}
+
+	for _, name := range linked {
+		attrSchema, ok := s.schemaRegistry.GetAttributeSchema(name)
+		if !ok {
+			log.Printf("Warning: unknown linked attribute '%s' - skipping value changes", name)
+			continue
+		}
+
+		valueChanges := findLinkedValueChanges(
+			attributeValues(previousAttributes, name),
+			attributeValues(snap.Attributes, name),
+			snap.ValueMetadata.Get(name),
+			sinceUSN,
+		)
+
+		for _, change := range valueChanges {
+			var origin *database.AttributeOrigin
+			if change.Metadata != nil {
+				origin = &database.AttributeOrigin{
+					DsaDN:        change.Metadata.OriginatingDsaDN,
+					InvocationID: change.Metadata.OriginatingInvocationID,
+					USN:          change.Metadata.OriginatingUSN,
+					Time:         change.Metadata.OriginatingChangeTime,
+					Version:      change.Metadata.Version,
+				}
+			}
+
+			if err := s.dbClient.RecordLinkedValueChange(
+				ctx, tx,
+				versionID,
+				snap.ObjectGUID,
+				s.domainID,
+				attrSchema.ObjectGUID,
+				change.ValueDN,
+				change.Data,
+				change.Type,
+				change.Legacy(),
+				origin,
+			); err != nil {
+				return fmt.Errorf("failed to record %s value change for %s: %w", name, change.ValueDN, err)
+			}
+
+			log.Printf("Linked value change for %s (DN: %s) - %s %s: %s %s",
+				snap.ObjectGUID, snap.DN, name, change.Type, change.ValueDN, change.Data)
+		}
+	}
+
+	return nil
+}
+
+// attributeValues returns the values of attrName, matching the name case-insensitively.
+func attributeValues(attributes map[string][]string, attrName string) []string {
+	if values, ok := attributes[attrName]; ok {
+		return values
+	}
+	for name, values := range attributes {
+		if strings.EqualFold(name, attrName) {
+			return values
+		}
+	}
+	return nil
+}
+
+// attributeOrigin looks up the originating write of an attribute in the snapshot's replication
+// metadata. Business logic: attribution is optional - linked attributes tracked per value and
+// objects read without metadata are recorded without an origin.
+func attributeOrigin(snap *snapshot.Snapshot, attrName string) *database.AttributeOrigin {
+	metadata, ok := snap.Metadata.Get(attrName)
+	if !ok {
+		return nil
+	}
+	return &database.AttributeOrigin{
+		DsaDN:        metadata.OriginatingDsaDN,
+		InvocationID: metadata.OriginatingInvocationID,
+		USN:          metadata.OriginatingUSN,
+		Time:         metadata.OriginatingChangeTime,
+		Version:      metadata.Version,
+	}
+}
+
 // detectChanges uses the snapshot service to compare old and new attributes.
 // Returns a list of detected attribute changes.
 func (s *Service) detectChanges(
@@ -240,9 +432,21 @@ func (s *Service) detectChanges(
 ) ([]diff.AttributeChange, error) {
 	// Use snapshot service for comparison logic
 	changes := s.snapshotService.CompareSnapshots(previousAttributes, currentAttributes)
+	diff.DescribeChanges(changes, s.describerFor)
 	return changes, nil
 }
 
+// describerFor returns the ChangeDescriber of an attribute, such as the FlagFormatter of
+// userAccountControl, or nil if its interpreter cannot describe changes.
+func (s *Service) describerFor(attrName string) diff.ChangeDescriber {
+	attrSchema, ok := s.schemaRegistry.GetAttributeSchema(attrName)
+	if !ok {
+		return nil
+	}
+	describer, _ := attrSchema.AttributeFieldType.Interpreter.(diff.ChangeDescriber)
+	return describer
+}
+
 // marshalAttributes converts attribute map to JSON bytes.
 func (s *Service) marshalAttributes(attributes map[string][]string) ([]byte, error) {
 	return json.Marshal(attributes)
@@ -260,6 +464,23 @@ func (s *Service) unmarshalAttributes(jsonData []byte) (map[string][]string, err
 	return convertToStringMap(rawAttributes), nil
 }
 
+// mergeAttributes overlays changed attributes on the previous attribute map.
+// An attribute with no values in changed was cleared and is removed.
+func mergeAttributes(previous, changed map[string][]string) map[string][]string {
+	merged := make(map[string][]string, len(previous)+len(changed))
+	for k, v := range previous {
+		merged[k] = v
+	}
+	for k, v := range changed {
+		if len(v) == 0 {
+			delete(merged, k)
+			continue
+		}
+		merged[k] = v
+	}
+	return merged
+}
+
 // convertToStringMap converts a map[string]interface{} to map[string][]string.
 // This handles the type conversion needed after JSON unmarshaling.
 func convertToStringMap(m map[string]interface{}) map[string][]string {
@@ -0,0 +1,219 @@
+package versioning
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"f0oster/adspy/activedirectory/schema"
+	"f0oster/adspy/database"
+	"f0oster/adspy/snapshot"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+type storedVersion struct {
+	objectID uuid.UUID
+	database.ObjectVersion
+}
+
+type storedObject struct {
+	dn               string
+	currentVersionID *int64
+}
+
+// fakeStore keeps objects and versions in memory, enforcing the unique key of ObjectVersions
+// as the database does.
+type fakeStore struct {
+	objects          map[uuid.UUID]*storedObject
+	versions         []storedVersion // version_id - 1 -> version
+	attributeChanges map[int64][]uuid.UUID
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		objects:          make(map[uuid.UUID]*storedObject),
+		attributeChanges: make(map[int64][]uuid.UUID),
+	}
+}
+
+func (s *fakeStore) BeginTx(ctx context.Context) (pgx.Tx, error)     { return nil, nil }
+func (s *fakeStore) CommitTx(ctx context.Context, tx pgx.Tx) error   { return nil }
+func (s *fakeStore) RollbackTx(ctx context.Context, tx pgx.Tx) error { return nil }
+
+func (s *fakeStore) UpsertObject(ctx context.Context, tx pgx.Tx, objectID uuid.UUID, objectType string, dn string, domainID uuid.UUID, namingContext string) (*int64, string, error) {
+	object, ok := s.objects[objectID]
+	if !ok {
+		s.objects[objectID] = &storedObject{dn: dn}
+		return nil, "", nil
+	}
+	previousDN := object.dn
+	object.dn = dn
+	return object.currentVersionID, previousDN, nil
+}
+
+func (s *fakeStore) GetVersion(ctx context.Context, tx pgx.Tx, versionID int64) (*database.ObjectVersion, error) {
+	version := s.versions[versionID-1].ObjectVersion
+	return &version, nil
+}
+
+func (s *fakeStore) CreateVersion(ctx context.Context, tx pgx.Tx, objectID uuid.UUID, invocationID uuid.UUID, usnChanged int64, timestamp time.Time, attributesJSON []byte, modifiedBy string) (int64, error) {
+	for _, version := range s.versions {
+		if version.objectID == objectID && version.InvocationID == invocationID && version.USNChanged == usnChanged {
+			return 0, fmt.Errorf("duplicate version %d of %s on %s", usnChanged, objectID, invocationID)
+		}
+	}
+	s.versions = append(s.versions, storedVersion{
+		objectID:      objectID,
+		ObjectVersion: database.ObjectVersion{InvocationID: invocationID, USNChanged: usnChanged, Attributes: attributesJSON},
+	})
+	return int64(len(s.versions)), nil
+}
+
+func (s *fakeStore) UpdateCurrentVersion(ctx context.Context, tx pgx.Tx, versionID int64, objectID uuid.UUID) error {
+	s.objects[objectID].currentVersionID = &versionID
+	return nil
+}
+
+func (s *fakeStore) RecordAttributeChange(ctx context.Context, tx pgx.Tx, versionID int64, objectID uuid.UUID, domainID uuid.UUID, attributeSchemaID uuid.UUID, oldValue []byte, newValue []byte, summary string, timestamp time.Time, origin *database.AttributeOrigin) error {
+	s.attributeChanges[versionID] = append(s.attributeChanges[versionID], attributeSchemaID)
+	return nil
+}
+
+func (s *fakeStore) RecordLinkedValueChange(ctx context.Context, tx pgx.Tx, versionID int64, objectID uuid.UUID, domainID uuid.UUID, attributeSchemaID uuid.UUID, valueDN string, valueData string, changeType string, legacy bool, origin *database.AttributeOrigin) error {
+	return nil
+}
+
+func (s *fakeStore) RecordLifecycleEvent(ctx context.Context, tx pgx.Tx, versionID int64, objectID uuid.UUID, event database.LifecycleEvent) error {
+	return nil
+}
+
+func (s *fakeStore) UpdateObjectLifecycleState(ctx context.Context, tx pgx.Tx, objectID uuid.UUID, state string) error {
+	return nil
+}
+
+func (s *fakeStore) MarkObjectDeleted(ctx context.Context, tx pgx.Tx, objectID uuid.UUID, deletedAt time.Time, lastLiveDN string, lastKnownParent string) error {
+	return nil
+}
+
+func (s *fakeStore) MarkObjectRestored(ctx context.Context, tx pgx.Tx, objectID uuid.UUID) error {
+	return nil
+}
+
+func (s *fakeStore) RecordDNChange(ctx context.Context, tx pgx.Tx, versionID int64, objectID uuid.UUID, change database.DNChange) error {
+	return nil
+}
+
+func (s *fakeStore) UpdateDescendantDNs(ctx context.Context, tx pgx.Tx, domainID uuid.UUID, oldDN string, newDN string) error {
+	return nil
+}
+
+func newTestService(store Store) (*Service, *schema.AttributeSchema) {
+	registry := schema.NewSchemaRegistry()
+	description := &schema.AttributeSchema{
+		ObjectGUID:              uuid.New(),
+		AttributeLDAPName:       "description",
+		AttributeSyntax:         "2.5.5.12",
+		AttributeIsSingleValued: true,
+	}
+	registry.RegisterAttributeSchema(description)
+	return NewService(store, snapshot.NewService(), uuid.New(), registry), description
+}
+
+func TestProcessSnapshots_SameUSNOnDifferentReplicas(t *testing.T) {
+	store := newFakeStore()
+	service, description := newTestService(store)
+	objectGUID := uuid.New()
+	dc1, dc2 := uuid.New(), uuid.New()
+
+	snap := func(replica uuid.UUID, usn int64, value string) *snapshot.Snapshot {
+		return &snapshot.Snapshot{
+			ObjectGUID:   objectGUID,
+			ObjectType:   "CN=Person,CN=Schema,CN=Configuration,DC=example,DC=com",
+			DN:           "CN=alice,DC=example,DC=com",
+			USNChanged:   usn,
+			InvocationID: replica,
+			Attributes:   map[string][]string{"description": {value}},
+			Timestamp:    time.Now(),
+		}
+	}
+
+	// dc2 happens to number a later change with the USN dc1 gave the first version
+	steps := []struct {
+		name         string
+		snapshot     *snapshot.Snapshot
+		wantVersions int
+	}{
+		{"first read on dc1", snap(dc1, 100, "first"), 1},
+		{"re-read on dc1", snap(dc1, 100, "first"), 1},
+		{"changed, read at the same USN on dc2", snap(dc2, 100, "second"), 2},
+		{"re-read on dc2", snap(dc2, 100, "second"), 2},
+		{"unchanged, read at the same USN back on dc1", snap(dc1, 100, "second"), 2},
+	}
+
+	for _, step := range steps {
+		if err := service.ProcessSnapshots(context.Background(), []*snapshot.Snapshot{step.snapshot}, uuid.New()); err != nil {
+			t.Fatalf("%s: ProcessSnapshots failed: %v", step.name, err)
+		}
+		if len(store.versions) != step.wantVersions {
+			t.Fatalf("%s: %d versions stored, want %d", step.name, len(store.versions), step.wantVersions)
+		}
+	}
+
+	second := store.versions[1]
+	if second.InvocationID != dc2 || second.USNChanged != 100 {
+		t.Errorf("second version recorded at USN %d on %s, want 100 on dc2 (%s)", second.USNChanged, second.InvocationID, dc2)
+	}
+	if got := store.attributeChanges[2]; len(got) != 1 || got[0] != description.ObjectGUID {
+		t.Errorf("second version recorded attribute changes %v, want only description", got)
+	}
+	if current := store.objects[objectGUID].currentVersionID; current == nil || *current != 2 {
+		t.Errorf("current version = %v, want 2", current)
+	}
+}
+
+func TestProcessSnapshots_FailoverResync(t *testing.T) {
+	store := newFakeStore()
+	service, _ := newTestService(store)
+	dc1, dc2 := uuid.New(), uuid.New()
+	unchanged, changed := uuid.New(), uuid.New()
+
+	snap := func(objectGUID, replica uuid.UUID, usn int64, value string) *snapshot.Snapshot {
+		return &snapshot.Snapshot{
+			ObjectGUID:   objectGUID,
+			ObjectType:   "CN=Person,CN=Schema,CN=Configuration,DC=example,DC=com",
+			DN:           "CN=" + objectGUID.String() + ",DC=example,DC=com",
+			USNChanged:   usn,
+			InvocationID: replica,
+			Attributes:   map[string][]string{"description": {value}},
+			Timestamp:    time.Now(),
+		}
+	}
+
+	process := func(snapshots ...*snapshot.Snapshot) {
+		t.Helper()
+		if err := service.ProcessSnapshots(context.Background(), snapshots, uuid.New()); err != nil {
+			t.Fatalf("ProcessSnapshots failed: %v", err)
+		}
+	}
+
+	process(snap(unchanged, dc1, 500, "same"), snap(changed, dc1, 501, "before"))
+
+	// the resync after failing over to dc2 re-reads both objects at dc2's much lower USNs
+	process(snap(unchanged, dc2, 40, "same"), snap(changed, dc2, 41, "after"))
+
+	if len(store.versions) != 3 {
+		t.Fatalf("%d versions stored, want the two initial versions and one for the changed object", len(store.versions))
+	}
+	latest := store.versions[2]
+	if latest.objectID != changed || latest.InvocationID != dc2 || latest.USNChanged != 41 {
+		t.Errorf("resync stored USN %d on %s for %s, want USN 41 on dc2 for the changed object",
+			latest.USNChanged, latest.InvocationID, latest.objectID)
+	}
+	// versions are ordered by version ID, so the lower USN from dc2 is still the latest
+	if current := store.objects[changed].currentVersionID; current == nil || *current != 3 {
+		t.Errorf("current version of the changed object = %v, want 3", current)
+	}
+}
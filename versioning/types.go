@@ -5,3 +5,37 @@ const (
 	// ModifiedBySystem indicates that a change was made by the system (not a user)
 	ModifiedBySystem = "system"
 )
+
+// attributeSyntax of DN-valued attributes
+const (
+	dnSyntax       = "2.5.5.1"  // Object(DS-DN)
+	dnBinarySyntax = "2.5.5.7"  // Object(DN-Binary), e.g. wellKnownObjects, msDS-KeyCredentialLink
+	dnStringSyntax = "2.5.5.14" // Object(DN-String)
+)
+
+// Linked value change types recorded in LinkedValueChanges
+const (
+	LinkedValueAdded   = "added"
+	LinkedValueRemoved = "removed"
+)
+
+// Lifecycle states of an object, derived from isDeleted and isRecycled
+const (
+	StateLive     = "live"
+	StateDeleted  = "deleted"  // deleted, attributes retained (Recycle Bin) or a tombstone
+	StateRecycled = "recycled" // attributes stripped, can no longer be restored
+)
+
+// Lifecycle event types recorded in LifecycleEvents
+const (
+	EventDeleted  = "deleted"
+	EventRecycled = "recycled"
+	EventRestored = "restored"
+)
+
+// DN change types recorded in DNHistory
+const (
+	DNRenamed         = "renamed"
+	DNMoved           = "moved"
+	DNRenamedAndMoved = "renamed+moved"
+)
@@ -36,10 +36,12 @@ async function handleResponse<T>(response: Response, endpoint: string): Promise<
 }
 
 export async function fetchObjects(params: FetchObjectsParams = {}): Promise<ObjectsResponse> {
-  const { type = '', search = '', limit = 50, offset = 0 } = params;
+  const { type = '', search = '', status = 'live', namingContext = '', limit = 50, offset = 0 } = params;
   const urlParams = new URLSearchParams();
   if (type) urlParams.set('type', type);
   if (search) urlParams.set('search', search);
+  if (status !== 'live') urlParams.set('status', status);
+  if (namingContext) urlParams.set('nc', namingContext);
   urlParams.set('limit', limit.toString());
   urlParams.set('offset', offset.toString());
 
@@ -54,8 +56,8 @@ export async function fetchObjectTimeline(id: string): Promise<TimelineEntry[]>
   return handleResponse<TimelineEntry[]>(response, endpoint);
 }
 
-export async function fetchVersionChanges(objectId: string, usn: number): Promise<AttributeChange[]> {
-  const endpoint = `${API_BASE}/objects/${objectId}/versions/${usn}/changes`;
+export async function fetchVersionChanges(objectId: string, versionId: number): Promise<AttributeChange[]> {
+  const endpoint = `${API_BASE}/objects/${objectId}/versions/${versionId}/changes`;
   const response = await fetch(endpoint);
   return handleResponse<AttributeChange[]>(response, endpoint);
 }
@@ -5,6 +5,11 @@ export interface ADObject {
   dn: string;
   type: string;
   guid: string;
+  naming_context: NamingContext;
+  class_name?: string;
+  deleted_at?: string;
+  last_live_dn?: string;
+  last_known_parent?: string;
 }
 
 export interface ObjectsResponse {
@@ -13,16 +18,70 @@ export interface ObjectsResponse {
 }
 
 export interface TimelineEntry {
+  version_id: number;
+  invocation_id: string; // replica usn_changed was read from
   usn_changed: number;
   timestamp: string;
   modified_by?: string;
+  lifecycle_event?: LifecycleEvent;
+  dn_change?: DNChange;
+}
+
+export interface DNChange {
+  type: 'renamed' | 'moved' | 'renamed+moved';
+  old_dn: string;
+  new_dn: string;
+  old_rdn: string;
+  new_rdn: string;
+  old_parent: string;
+  new_parent: string;
+  time: string;
+}
+
+export type LifecycleState = 'live' | 'deleted' | 'recycled';
+
+export interface LifecycleEvent {
+  type: 'deleted' | 'recycled' | 'restored';
+  from: LifecycleState;
+  to: LifecycleState;
+  time: string;
+  dn: string;
+  last_known_rdn?: string;
+  last_known_parent?: string;
 }
 
 export interface AttributeChange {
   attribute: string;
   old_value: unknown;
   new_value: unknown;
+  summary?: string;
   is_single_valued: boolean;
+  originating_dsa?: string;
+  originating_usn?: number;
+  originating_time?: string;
+  version?: number;
+  value_changes?: LinkedValueChange[];
+}
+
+export interface LinkedValueChange {
+  value: string;
+  data?: string;
+  change: 'added' | 'removed';
+  legacy: boolean;
+  originating_time?: string;
+  originating_dsa?: string;
+  originating_usn?: number;
+  key_credential?: KeyCredential;
+}
+
+// A decoded msDS-KeyCredentialLink key
+export interface KeyCredential {
+  key_id: string;
+  key_usage?: string;
+  key_source?: string;
+  device_id?: string;
+  creation_time?: string;
+  last_logon_time?: string;
 }
 
 export interface SIDInfo {
@@ -70,9 +129,15 @@ export interface SDDiffResponse {
   dacl_diff?: ACLDiff;
 }
 
+export type ObjectStatus = 'live' | 'deleted' | 'all';
+
+export type NamingContext = 'domain' | 'configuration' | 'schema';
+
 export interface FetchObjectsParams {
   type?: string;
   search?: string;
+  status?: ObjectStatus;
+  namingContext?: NamingContext | '';
   limit?: number;
   offset?: number;
 }
@@ -124,3 +124,20 @@ export function formatDNValue(value: string): string {
 export function getErrorMessage(error: unknown): string {
   return error instanceof Error ? error.message : 'Unknown error';
 }
+
+// Describes where and when an attribute change originated, e.g. "DC1 · 2024-01-02T03:04:05Z · v3".
+// The DSA DN is the NTDS Settings object, whose parent is named after the domain controller.
+export function formatOrigin(change: { originating_dsa?: string; originating_time?: string; version?: number }): string {
+  const parts: string[] = [];
+  if (change.originating_dsa) {
+    const server = change.originating_dsa.match(/^CN=NTDS Settings,CN=([^,]+),/i);
+    parts.push(server ? server[1] : change.originating_dsa);
+  }
+  if (change.originating_time) {
+    parts.push(change.originating_time);
+  }
+  if (change.version) {
+    parts.push(`v${change.version}`);
+  }
+  return parts.join(' · ');
+}
@@ -2,10 +2,13 @@ package web
 
 import (
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"time"
 
+	"f0oster/adspy/activedirectory/transformers"
 	"f0oster/adspy/database/sqlcgen"
 	"f0oster/adspy/web/sddiff"
 
@@ -16,11 +19,17 @@ import (
 // Response types for JSON serialization
 
 type ObjectResponse struct {
-	ID        string  `json:"id"`
-	Type      string  `json:"type"`
-	DN        string  `json:"dn"`
-	UpdatedAt string  `json:"updated_at"`
-	DeletedAt *string `json:"deleted_at,omitempty"`
+	ID            string  `json:"id"`
+	Type          string  `json:"type"`
+	DN            string  `json:"dn"`
+	NamingContext string  `json:"naming_context"`       // domain, configuration or schema
+	ClassName     string  `json:"class_name,omitempty"` // lDAPDisplayName of the objectCategory class
+	UpdatedAt     string  `json:"updated_at"`
+	DeletedAt     *string `json:"deleted_at,omitempty"`
+
+	// Set for deleted objects, whose DN is mangled once they move to Deleted Objects
+	LastLiveDN      string `json:"last_live_dn,omitempty"`
+	LastKnownParent string `json:"last_known_parent,omitempty"`
 }
 
 type ObjectListResponse struct {
@@ -31,10 +40,39 @@ type ObjectListResponse struct {
 }
 
 type TimelineEntry struct {
-	USNChanged int64           `json:"usn_changed"`
-	Timestamp  string          `json:"timestamp"`
-	Snapshot   json.RawMessage `json:"snapshot"`
-	ModifiedBy string          `json:"modified_by,omitempty"`
+	VersionID    int64           `json:"version_id"`
+	InvocationID string          `json:"invocation_id"` // replica usn_changed was read from
+	USNChanged   int64           `json:"usn_changed"`
+	Timestamp    string          `json:"timestamp"`
+	Snapshot     json.RawMessage `json:"snapshot"`
+	ModifiedBy   string          `json:"modified_by,omitempty"`
+
+	// Set when the object was deleted, recycled or restored in this version
+	LifecycleEvent *LifecycleEvent `json:"lifecycle_event,omitempty"`
+
+	// Set when the object was renamed or moved in this version
+	DNChange *DNChange `json:"dn_change,omitempty"`
+}
+
+type DNChange struct {
+	Type      string `json:"type"` // renamed, moved or renamed+moved
+	OldDN     string `json:"old_dn"`
+	NewDN     string `json:"new_dn"`
+	OldRDN    string `json:"old_rdn"`
+	NewRDN    string `json:"new_rdn"`
+	OldParent string `json:"old_parent"`
+	NewParent string `json:"new_parent"`
+	Time      string `json:"time"`
+}
+
+type LifecycleEvent struct {
+	Type            string `json:"type"` // deleted, recycled or restored
+	From            string `json:"from"`
+	To              string `json:"to"`
+	Time            string `json:"time"`
+	DN              string `json:"dn"`
+	LastKnownRDN    string `json:"last_known_rdn,omitempty"`
+	LastKnownParent string `json:"last_known_parent,omitempty"`
 }
 
 type AttributeChange struct {
@@ -42,8 +80,57 @@ type AttributeChange struct {
 	Attribute      string          `json:"attribute"`
 	OldValue       json.RawMessage `json:"old_value"`
 	NewValue       json.RawMessage `json:"new_value"`
+	Summary        string          `json:"summary,omitempty"` // e.g. the flags set and cleared
 	Timestamp      string          `json:"timestamp"`
 	IsSingleValued bool            `json:"is_single_valued"`
+
+	// Originating write, from the object's replication metadata
+	OriginatingDsa  string `json:"originating_dsa,omitempty"`
+	OriginatingUSN  int64  `json:"originating_usn,omitempty"`
+	OriginatingTime string `json:"originating_time,omitempty"`
+	Version         int32  `json:"version,omitempty"`
+
+	// Per-value history for linked attributes such as member
+	ValueChanges []LinkedValueChange `json:"value_changes,omitempty"`
+}
+
+type LinkedValueChange struct {
+	Value           string `json:"value"`
+	Data            string `json:"data,omitempty"` // DN-Binary (hex) or DN-String data tied to the value
+	Change          string `json:"change"`         // added or removed
+	Legacy          bool   `json:"legacy"`
+	OriginatingTime string `json:"originating_time,omitempty"`
+	OriginatingDsa  string `json:"originating_dsa,omitempty"`
+	OriginatingUSN  int64  `json:"originating_usn,omitempty"`
+
+	KeyCredential *KeyCredential `json:"key_credential,omitempty"` // decoded msDS-KeyCredentialLink value
+}
+
+// KeyCredential is a decoded msDS-KeyCredentialLink key, used to spot shadow credentials.
+type KeyCredential struct {
+	KeyID         string `json:"key_id"` // base64 SHA-256 of the key material
+	KeyUsage      string `json:"key_usage,omitempty"`
+	KeySource     string `json:"key_source,omitempty"`
+	DeviceID      string `json:"device_id,omitempty"`
+	CreationTime  string `json:"creation_time,omitempty"`
+	LastLogonTime string `json:"last_logon_time,omitempty"`
+}
+
+// keyCredentialAttribute is the attribute whose value changes carry a decoded KeyCredential.
+const keyCredentialAttribute = "msDS-KeyCredentialLink"
+
+// SchemaChangeEvent is a schema update detected by the poller.
+type SchemaChangeEvent struct {
+	DomainID   string   `json:"domain_id"`
+	ModifiedAt string   `json:"modified_at"`
+	DetectedAt string   `json:"detected_at"`
+	Added      []string `json:"added"`
+	Modified   []string `json:"modified"`
+	Removed    []string `json:"removed"`
+
+	AddedClasses    []string `json:"added_classes"`
+	ModifiedClasses []string `json:"modified_classes"`
+	RemovedClasses  []string `json:"removed_classes"`
 }
 
 type SDDiffRequest struct {
@@ -85,6 +172,35 @@ func formatUUID(id pgtype.UUID) string {
 	return uuid.UUID(id.Bytes).String()
 }
 
+// decodeKeyCredential decodes the hex data of a msDS-KeyCredentialLink value, or returns nil
+// when it is not a key credential adSpy can read.
+func decodeKeyCredential(data string) *KeyCredential {
+	blob, err := hex.DecodeString(data)
+	if err != nil {
+		return nil
+	}
+	credential, err := transformers.ParseKeyCredential(blob)
+	if err != nil {
+		return nil
+	}
+
+	decoded := &KeyCredential{
+		KeyID:     base64.StdEncoding.EncodeToString(credential.KeyID),
+		KeyUsage:  credential.KeyUsage,
+		KeySource: credential.KeySource,
+	}
+	if credential.DeviceID != uuid.Nil {
+		decoded.DeviceID = credential.DeviceID.String()
+	}
+	if credential.CreationTime != nil {
+		decoded.CreationTime = credential.CreationTime.Format(time.RFC3339)
+	}
+	if credential.LastLogonTime != nil {
+		decoded.LastLogonTime = credential.LastLogonTime.Format(time.RFC3339)
+	}
+	return decoded
+}
+
 // Handlers
 
 func (s *Server) handleListObjects(w http.ResponseWriter, r *http.Request) {
@@ -94,6 +210,11 @@ func (s *Server) handleListObjects(w http.ResponseWriter, r *http.Request) {
 	// Parse query parameters
 	typeFilter := q.Get("type")
 	dnSearch := q.Get("search")
+	status := q.Get("status") // live (default), deleted or all
+	if status != "deleted" && status != "all" {
+		status = "live"
+	}
+	namingContext := q.Get("nc") // domain, configuration or schema; all when empty
 	limit := 50
 	offset := 0
 
@@ -114,8 +235,10 @@ func (s *Server) handleListObjects(w http.ResponseWriter, r *http.Request) {
 	rows, err := queries.ListObjectsForWeb(ctx, sqlcgen.ListObjectsForWebParams{
 		Column1: typeFilter,
 		Column2: dnSearch,
+		Column3: status,
 		Limit:   int32(limit),
 		Offset:  int32(offset),
+		Column6: namingContext,
 	})
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "Failed to list objects")
@@ -126,6 +249,8 @@ func (s *Server) handleListObjects(w http.ResponseWriter, r *http.Request) {
 	total, err := queries.CountObjectsForWeb(ctx, sqlcgen.CountObjectsForWebParams{
 		Column1: typeFilter,
 		Column2: dnSearch,
+		Column3: status,
+		Column4: namingContext,
 	})
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "Failed to count objects")
@@ -136,15 +261,19 @@ func (s *Server) handleListObjects(w http.ResponseWriter, r *http.Request) {
 	objects := make([]ObjectResponse, 0, len(rows))
 	for _, row := range rows {
 		obj := ObjectResponse{
-			ID:        formatUUID(row.ObjectID),
-			Type:      row.ObjectType,
-			DN:        row.Distinguishedname,
-			UpdatedAt: formatTimestamp(row.UpdatedAt),
+			ID:            formatUUID(row.ObjectID),
+			Type:          row.ObjectType,
+			DN:            row.Distinguishedname,
+			NamingContext: row.NamingContext,
+			ClassName:     row.ClassName.String,
+			UpdatedAt:     formatTimestamp(row.UpdatedAt),
 		}
 		if row.DeletedAt.Valid {
 			ts := formatTimestamp(row.DeletedAt)
 			obj.DeletedAt = &ts
 		}
+		obj.LastLiveDN = row.LastLiveDn.String
+		obj.LastKnownParent = row.LastKnownParent.String
 		objects = append(objects, obj)
 	}
 
@@ -174,15 +303,19 @@ func (s *Server) handleGetObject(w http.ResponseWriter, r *http.Request) {
 	}
 
 	obj := ObjectResponse{
-		ID:        formatUUID(row.ObjectID),
-		Type:      row.ObjectType,
-		DN:        row.Distinguishedname,
-		UpdatedAt: formatTimestamp(row.UpdatedAt),
+		ID:            formatUUID(row.ObjectID),
+		Type:          row.ObjectType,
+		DN:            row.Distinguishedname,
+		NamingContext: row.NamingContext,
+		ClassName:     row.ClassName.String,
+		UpdatedAt:     formatTimestamp(row.UpdatedAt),
 	}
 	if row.DeletedAt.Valid {
 		ts := formatTimestamp(row.DeletedAt)
 		obj.DeletedAt = &ts
 	}
+	obj.LastLiveDN = row.LastLiveDn.String
+	obj.LastKnownParent = row.LastKnownParent.String
 
 	writeJSON(w, http.StatusOK, obj)
 }
@@ -207,13 +340,38 @@ func (s *Server) handleGetObjectTimeline(w http.ResponseWriter, r *http.Request)
 	timeline := make([]TimelineEntry, 0, len(rows))
 	for _, row := range rows {
 		entry := TimelineEntry{
-			USNChanged: row.UsnChanged,
-			Timestamp:  formatTimestamp(row.Timestamp),
-			Snapshot:   row.AttributesSnapshot,
+			VersionID:    row.VersionID,
+			InvocationID: formatUUID(row.InvocationID),
+			USNChanged:   row.UsnChanged,
+			Timestamp:    formatTimestamp(row.Timestamp),
+			Snapshot:     row.AttributesSnapshot,
 		}
 		if row.ModifiedBy.Valid {
 			entry.ModifiedBy = row.ModifiedBy.String
 		}
+		if row.EventType.Valid {
+			entry.LifecycleEvent = &LifecycleEvent{
+				Type:            row.EventType.String,
+				From:            row.FromState.String,
+				To:              row.ToState.String,
+				Time:            formatTimestamp(row.EventTime),
+				DN:              row.EventDn.String,
+				LastKnownRDN:    row.LastKnownRdn.String,
+				LastKnownParent: row.LastKnownParent.String,
+			}
+		}
+		if row.DnChangeType.Valid {
+			entry.DNChange = &DNChange{
+				Type:      row.DnChangeType.String,
+				OldDN:     row.OldDn.String,
+				NewDN:     row.NewDn.String,
+				OldRDN:    row.OldRdn.String,
+				NewRDN:    row.NewRdn.String,
+				OldParent: row.OldParent.String,
+				NewParent: row.NewParent.String,
+				Time:      formatTimestamp(row.ChangedAt),
+			}
+		}
 		timeline = append(timeline, entry)
 	}
 
@@ -223,7 +381,7 @@ func (s *Server) handleGetObjectTimeline(w http.ResponseWriter, r *http.Request)
 func (s *Server) handleGetVersionChanges(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	idStr := r.PathValue("id")
-	usnStr := r.PathValue("usn")
+	versionStr := r.PathValue("version")
 
 	objectID, err := parseUUID(idStr)
 	if err != nil {
@@ -231,31 +389,76 @@ func (s *Server) handleGetVersionChanges(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	usn, err := strconv.ParseInt(usnStr, 10, 64)
+	versionID, err := strconv.ParseInt(versionStr, 10, 64)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid USN")
+		writeError(w, http.StatusBadRequest, "Invalid version ID")
 		return
 	}
 
 	queries := sqlcgen.New(s.db.Pool())
 	rows, err := queries.GetVersionChanges(ctx, sqlcgen.GetVersionChangesParams{
-		ObjectID:   objectID,
-		UsnChanged: usn,
+		ObjectID:  objectID,
+		VersionID: versionID,
 	})
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "Failed to get changes")
 		return
 	}
 
+	valueRows, err := queries.GetVersionLinkedValueChanges(ctx, sqlcgen.GetVersionLinkedValueChangesParams{
+		ObjectID:  objectID,
+		VersionID: versionID,
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to get linked value changes")
+		return
+	}
+
+	valueChanges := make(map[string][]LinkedValueChange)
+	for _, row := range valueRows {
+		change := LinkedValueChange{
+			Value:           row.ValueDn,
+			Data:            row.ValueData,
+			Change:          row.ChangeType,
+			Legacy:          row.Legacy,
+			OriginatingTime: formatTimestamp(row.OriginatingTime),
+		}
+		if row.OriginatingDsaDn.Valid {
+			change.OriginatingDsa = row.OriginatingDsaDn.String
+		}
+		if row.OriginatingUsn.Valid {
+			change.OriginatingUSN = row.OriginatingUsn.Int64
+		}
+		schemaID := formatUUID(row.AttributeSchemaID)
+		valueChanges[schemaID] = append(valueChanges[schemaID], change)
+	}
+
 	changes := make([]AttributeChange, 0, len(rows))
 	for _, row := range rows {
 		change := AttributeChange{
-			SchemaID:       formatUUID(row.AttributeSchemaID),
-			Attribute:      row.LdapDisplayName,
-			OldValue:       row.OldValue,
-			NewValue:       row.NewValue,
-			Timestamp:      formatTimestamp(row.Timestamp),
-			IsSingleValued: row.IsSingleValued,
+			SchemaID:        formatUUID(row.AttributeSchemaID),
+			Attribute:       row.LdapDisplayName,
+			OldValue:        row.OldValue,
+			NewValue:        row.NewValue,
+			Summary:         row.Summary.String,
+			Timestamp:       formatTimestamp(row.Timestamp),
+			IsSingleValued:  row.IsSingleValued,
+			OriginatingTime: formatTimestamp(row.OriginatingTime),
+		}
+		if row.OriginatingDsaDn.Valid {
+			change.OriginatingDsa = row.OriginatingDsaDn.String
+		}
+		if row.OriginatingUsn.Valid {
+			change.OriginatingUSN = row.OriginatingUsn.Int64
+		}
+		if row.MetadataVersion.Valid {
+			change.Version = row.MetadataVersion.Int32
+		}
+		change.ValueChanges = valueChanges[change.SchemaID]
+		if change.Attribute == keyCredentialAttribute {
+			for i := range change.ValueChanges {
+				change.ValueChanges[i].KeyCredential = decodeKeyCredential(change.ValueChanges[i].Data)
+			}
 		}
 		changes = append(changes, change)
 	}
@@ -304,3 +507,39 @@ func (s *Server) handleGetObjectTypes(w http.ResponseWriter, r *http.Request) {
 
 	writeJSON(w, http.StatusOK, types)
 }
+
+func (s *Server) handleListSchemaChanges(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	limit := 50
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 100 {
+			limit = parsed
+		}
+	}
+
+	queries := sqlcgen.New(s.db.Pool())
+	rows, err := queries.ListSchemaChangeEvents(ctx, int32(limit))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to list schema changes")
+		return
+	}
+
+	events := make([]SchemaChangeEvent, 0, len(rows))
+	for _, row := range rows {
+		events = append(events, SchemaChangeEvent{
+			DomainID:   formatUUID(row.DomainID),
+			ModifiedAt: formatTimestamp(row.SchemaModifiedAt),
+			DetectedAt: formatTimestamp(row.DetectedAt),
+			Added:      row.AddedAttributes,
+			Modified:   row.ModifiedAttributes,
+			Removed:    row.RemovedAttributes,
+
+			AddedClasses:    row.AddedClasses,
+			ModifiedClasses: row.ModifiedClasses,
+			RemovedClasses:  row.RemovedClasses,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, events)
+}
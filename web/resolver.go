@@ -0,0 +1,321 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"f0oster/adspy/activedirectory"
+	"f0oster/adspy/database/sqlcgen"
+
+	"github.com/f0oster/gontsd"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/jackc/pgx/v5"
+)
+
+// sidCacheTTL bounds how long a resolved principal name is reused, so renames stored by the
+// poller show up without restarting the web server.
+const sidCacheTTL = 5 * time.Minute
+
+// principalStore looks up the principals stored by the poller.
+type principalStore interface {
+	GetPrincipalBySID(ctx context.Context, sid string) (sqlcgen.GetPrincipalBySIDRow, error)
+}
+
+// dbSIDResolver resolves SIDs to the principals stored by the poller. Every version of every
+// object is searched, so principals that have since been deleted, and SIDs that have since
+// moved to another object, still resolve to the last object that held them.
+type dbSIDResolver struct {
+	store principalStore
+	mu    sync.RWMutex
+	cache map[string]cachedPrincipal
+}
+
+type cachedPrincipal struct {
+	name       string
+	found      bool // false for SIDs no stored object holds, so each lookup does not query again
+	resolvedAt time.Time
+}
+
+var _ gontsd.SIDResolver = (*dbSIDResolver)(nil)
+
+func newDBSIDResolver(store principalStore) *dbSIDResolver {
+	return &dbSIDResolver{
+		store: store,
+		cache: make(map[string]cachedPrincipal),
+	}
+}
+
+func (r *dbSIDResolver) Resolve(sid *gontsd.SID) (string, error) {
+	if sid == nil {
+		return "", fmt.Errorf("nil SID")
+	}
+
+	r.mu.RLock()
+	cached, ok := r.cache[sid.Value]
+	r.mu.RUnlock()
+	if ok && time.Since(cached.resolvedAt) < sidCacheTTL {
+		if !cached.found {
+			return "", fmt.Errorf("SID not found in stored objects: %s", sid.Value)
+		}
+		return cached.name, nil
+	}
+
+	row, err := r.store.GetPrincipalBySID(context.Background(), sid.Value)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			r.remember(sid.Value, cachedPrincipal{})
+			return "", fmt.Errorf("SID not found in stored objects: %s", sid.Value)
+		}
+		return "", fmt.Errorf("principal lookup failed: %w", err)
+	}
+
+	name := principalName(row)
+	r.remember(sid.Value, cachedPrincipal{name: name, found: true})
+	return name, nil
+}
+
+// remember caches the outcome of a lookup for sidCacheTTL.
+func (r *dbSIDResolver) remember(sid string, principal cachedPrincipal) {
+	principal.resolvedAt = time.Now()
+	r.mu.Lock()
+	r.cache[sid] = principal
+	r.mu.Unlock()
+}
+
+// principalName names a stored principal by its sAMAccountName, falling back to its name and
+// then its DN. Deleted principals are marked, as their SID can no longer be used to sign in.
+func principalName(row sqlcgen.GetPrincipalBySIDRow) string {
+	name := row.SamAccountName
+	if name == "" {
+		name = row.Name
+	}
+	if name == "" {
+		name = row.Distinguishedname
+		if row.LastLiveDn.Valid {
+			name = row.LastLiveDn.String
+		}
+	}
+	if row.DeletedAt.Valid {
+		// the name of a tombstone is mangled to "<name>\nDEL:<objectGUID>"
+		name, _, _ = strings.Cut(name, "\nDEL:")
+		return name + " (deleted)"
+	}
+	return name
+}
+
+// ldapSearcher runs searches against a domain, such as an activedirectory.ManagedConnection.
+type ldapSearcher interface {
+	Search(request *ldap.SearchRequest) (*ldap.SearchResult, error)
+}
+
+// ldapSIDResolver resolves SIDs against the directory over an adSpy-managed connection,
+// so that the transport settings and reconnect handling of the poller also apply to the web
+// server.
+type ldapSIDResolver struct {
+	conn   ldapSearcher
+	baseDN string
+	mu     sync.RWMutex
+	cache  map[string]string
+}
+
+var _ gontsd.SIDResolver = (*ldapSIDResolver)(nil)
+
+func newLDAPSIDResolver(conn ldapSearcher, baseDN string) *ldapSIDResolver {
+	return &ldapSIDResolver{
+		conn:   conn,
+		baseDN: baseDN,
+		cache:  make(map[string]string),
+	}
+}
+
+func (r *ldapSIDResolver) Resolve(sid *gontsd.SID) (string, error) {
+	if sid == nil {
+		return "", fmt.Errorf("nil SID")
+	}
+
+	r.mu.RLock()
+	name, ok := r.cache[sid.Value]
+	r.mu.RUnlock()
+	if ok {
+		return name, nil
+	}
+
+	searchRequest := ldap.NewSearchRequest(
+		r.baseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		fmt.Sprintf("(objectSid=%s)", escapeBinary(sid.Raw)),
+		[]string{"sAMAccountName", "name", "distinguishedName"},
+		nil,
+	)
+
+	results, err := r.conn.Search(searchRequest)
+	if err != nil {
+		return "", fmt.Errorf("LDAP search failed: %w", err)
+	}
+	if len(results.Entries) == 0 {
+		return "", fmt.Errorf("SID not found in AD: %s", sid.Value)
+	}
+
+	entry := results.Entries[0]
+	for _, attr := range []string{"sAMAccountName", "name", "distinguishedName"} {
+		if name = entry.GetAttributeValue(attr); name != "" {
+			break
+		}
+	}
+	if name == "" {
+		return "", fmt.Errorf("no name attributes found for SID: %s", sid.Value)
+	}
+
+	r.mu.Lock()
+	r.cache[sid.Value] = name
+	r.mu.Unlock()
+
+	return name, nil
+}
+
+// multiSIDResolver tries each resolver in turn, such as the stored principals followed by each
+// monitored domain, since a security descriptor can reference principals from any domain in the
+// forest or a trusted forest.
+type multiSIDResolver []gontsd.SIDResolver
+
+var _ gontsd.SIDResolver = multiSIDResolver(nil)
+
+func (m multiSIDResolver) Resolve(sid *gontsd.SID) (string, error) {
+	var lastErr error
+	for _, r := range m {
+		name, err := r.Resolve(sid)
+		if err == nil {
+			return name, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no resolvers to resolve SID against")
+	}
+	return "", lastErr
+}
+
+// catalogRefreshInterval limits how often an unknown GUID reloads the GUID catalog, so the web
+// server picks up schema extensions stored by the poller after it started.
+const catalogRefreshInterval = time.Minute
+
+// guidCatalogStore lists the schema and extended rights GUIDs stored by the poller.
+type guidCatalogStore interface {
+	ListAttributeSchemaGUIDs(ctx context.Context) ([]sqlcgen.ListAttributeSchemaGUIDsRow, error)
+	ListClassSchemaGUIDs(ctx context.Context) ([]sqlcgen.ListClassSchemaGUIDsRow, error)
+	ListExtendedRights(ctx context.Context) ([]sqlcgen.ListExtendedRightsRow, error)
+}
+
+// dbSchemaGUIDResolver resolves ACE object-type GUIDs from the schemaIDGUIDs, extended rights,
+// validated writes and property sets the poller stores, so it works without reaching a DC.
+// These GUIDs are the same throughout a forest, so the catalogs of all monitored domains are
+// merged into one.
+type dbSchemaGUIDResolver struct {
+	store    guidCatalogStore
+	mu       sync.RWMutex
+	guids    map[string]gontsd.SchemaGUIDInfo // upper-case GUID -> info
+	loadedAt time.Time
+}
+
+var _ gontsd.SchemaGUIDResolver = (*dbSchemaGUIDResolver)(nil)
+
+func newDBSchemaGUIDResolver(store guidCatalogStore) *dbSchemaGUIDResolver {
+	return &dbSchemaGUIDResolver{
+		store: store,
+		guids: make(map[string]gontsd.SchemaGUIDInfo),
+	}
+}
+
+// load reads the GUID catalog from the database, replacing the one held.
+func (r *dbSchemaGUIDResolver) load(ctx context.Context) error {
+	guids := make(map[string]gontsd.SchemaGUIDInfo)
+
+	attributes, err := r.store.ListAttributeSchemaGUIDs(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list attribute schema GUIDs: %w", err)
+	}
+	for _, row := range attributes {
+		guid := strings.ToUpper(formatUUID(row.SchemaIDGuid))
+		guids[guid] = gontsd.SchemaGUIDInfo{Name: row.LdapDisplayName, Type: gontsd.GUIDTypeAttribute, GUID: guid}
+	}
+
+	classes, err := r.store.ListClassSchemaGUIDs(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list class schema GUIDs: %w", err)
+	}
+	for _, row := range classes {
+		guid := strings.ToUpper(formatUUID(row.SchemaIDGuid))
+		guids[guid] = gontsd.SchemaGUIDInfo{Name: row.LdapDisplayName, Type: gontsd.GUIDTypeClass, GUID: guid}
+	}
+
+	rights, err := r.store.ListExtendedRights(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list extended rights: %w", err)
+	}
+	for _, row := range rights {
+		guid := strings.ToUpper(formatUUID(row.RightsGuid))
+
+		guidType := gontsd.GUIDTypeExtendedRight
+		switch row.ValidAccesses {
+		case activedirectory.ValidAccessesValidatedWrite:
+			guidType = gontsd.GUIDTypeValidatedWrite
+		case activedirectory.ValidAccessesPropertySet:
+			guidType = gontsd.GUIDTypePropertySet
+		}
+
+		// appliesTo holds class schemaIDGUIDs, which are all in the catalog by now
+		appliesTo := make([]gontsd.AppliesToEntry, 0, len(row.AppliesTo))
+		for _, classGUID := range row.AppliesTo {
+			entry := gontsd.AppliesToEntry{GUID: strings.ToUpper(classGUID)}
+			if class, ok := guids[entry.GUID]; ok {
+				entry.Name = class.Name
+			}
+			appliesTo = append(appliesTo, entry)
+		}
+
+		guids[guid] = gontsd.SchemaGUIDInfo{Name: row.DisplayName, Type: guidType, GUID: guid, AppliesTo: appliesTo}
+	}
+
+	r.mu.Lock()
+	r.guids = guids
+	r.loadedAt = time.Now()
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *dbSchemaGUIDResolver) ResolveGUID(guid string) (*gontsd.SchemaGUIDInfo, error) {
+	guid = strings.ToUpper(guid)
+
+	r.mu.RLock()
+	info, ok := r.guids[guid]
+	stale := time.Since(r.loadedAt) > catalogRefreshInterval
+	r.mu.RUnlock()
+
+	if !ok && stale {
+		if err := r.load(context.Background()); err != nil {
+			return nil, err
+		}
+		r.mu.RLock()
+		info, ok = r.guids[guid]
+		r.mu.RUnlock()
+	}
+	if !ok {
+		return nil, fmt.Errorf("GUID not found: %s", guid)
+	}
+	return &info, nil
+}
+
+// escapeBinary renders raw bytes in the \xx form accepted by LDAP filters.
+func escapeBinary(raw []byte) string {
+	var sb strings.Builder
+	for _, b := range raw {
+		fmt.Fprintf(&sb, "\\%02x", b)
+	}
+	return sb.String()
+}
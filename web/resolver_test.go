@@ -0,0 +1,314 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"f0oster/adspy/database/sqlcgen"
+
+	"github.com/f0oster/gontsd"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func testSID(t *testing.T, value string) *gontsd.SID {
+	t.Helper()
+	raw, err := gontsd.SIDFromString(value)
+	if err != nil {
+		t.Fatalf("invalid SID %s: %v", value, err)
+	}
+	return &gontsd.SID{Raw: raw, Value: value}
+}
+
+func TestPrincipalName(t *testing.T) {
+	deletedAt := pgtype.Timestamp{Time: time.Now(), Valid: true}
+	tests := []struct {
+		name string
+		row  sqlcgen.GetPrincipalBySIDRow
+		want string
+	}{
+		{"sAMAccountName first", sqlcgen.GetPrincipalBySIDRow{SamAccountName: "alice", Name: "Alice", Distinguishedname: "CN=Alice,DC=example,DC=com"}, "alice"},
+		{"falls back to name", sqlcgen.GetPrincipalBySIDRow{Name: "Domain Admins", Distinguishedname: "CN=Domain Admins,DC=example,DC=com"}, "Domain Admins"},
+		{"falls back to the DN", sqlcgen.GetPrincipalBySIDRow{Distinguishedname: "CN=gMSA,DC=example,DC=com"}, "CN=gMSA,DC=example,DC=com"},
+		{
+			"deleted principal by name",
+			sqlcgen.GetPrincipalBySIDRow{SamAccountName: "bob", DeletedAt: deletedAt},
+			"bob (deleted)",
+		},
+		{
+			"deleted principal with a mangled name",
+			sqlcgen.GetPrincipalBySIDRow{Name: "Bob\nDEL:5a8c6bd2-43a4-4d1d-9e8e-6cf6f0c0a4a1", DeletedAt: deletedAt},
+			"Bob (deleted)",
+		},
+		{
+			"deleted principal by its last live DN",
+			sqlcgen.GetPrincipalBySIDRow{
+				Distinguishedname: "CN=Bob\\0ADEL:5a8c6bd2-43a4-4d1d-9e8e-6cf6f0c0a4a1,CN=Deleted Objects,DC=example,DC=com",
+				LastLiveDn:        pgtype.Text{String: "CN=Bob,OU=Staff,DC=example,DC=com", Valid: true},
+				DeletedAt:         deletedAt,
+			},
+			"CN=Bob,OU=Staff,DC=example,DC=com (deleted)",
+		},
+	}
+
+	for _, test := range tests {
+		if got := principalName(test.row); got != test.want {
+			t.Errorf("%s: principalName = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+// fakePrincipalStore holds principals by SID and counts its lookups.
+type fakePrincipalStore struct {
+	principals map[string]sqlcgen.GetPrincipalBySIDRow
+	lookups    int
+}
+
+func (s *fakePrincipalStore) GetPrincipalBySID(ctx context.Context, sid string) (sqlcgen.GetPrincipalBySIDRow, error) {
+	s.lookups++
+	row, ok := s.principals[sid]
+	if !ok {
+		return sqlcgen.GetPrincipalBySIDRow{}, pgx.ErrNoRows
+	}
+	return row, nil
+}
+
+func TestDBSIDResolver_CachesLookups(t *testing.T) {
+	store := &fakePrincipalStore{principals: map[string]sqlcgen.GetPrincipalBySIDRow{
+		"S-1-5-21-1-2-3-1104": {SamAccountName: "alice"},
+	}}
+	resolver := newDBSIDResolver(store)
+	known, unknown := testSID(t, "S-1-5-21-1-2-3-1104"), testSID(t, "S-1-5-21-9-9-9-500")
+
+	for range 3 {
+		if name, err := resolver.Resolve(known); err != nil || name != "alice" {
+			t.Fatalf("Resolve(%s) = %q, %v, want alice", known.Value, name, err)
+		}
+		if _, err := resolver.Resolve(unknown); err == nil {
+			t.Fatalf("Resolve(%s) succeeded, want not found", unknown.Value)
+		}
+	}
+	if store.lookups != 2 {
+		t.Errorf("queried the database %d times, want once per SID", store.lookups)
+	}
+
+	// expired entries are looked up again
+	for sid, cached := range resolver.cache {
+		cached.resolvedAt = time.Now().Add(-sidCacheTTL)
+		resolver.cache[sid] = cached
+	}
+	resolver.Resolve(known)
+	resolver.Resolve(unknown)
+	if store.lookups != 4 {
+		t.Errorf("queried the database %d times after expiry, want 4", store.lookups)
+	}
+}
+
+func TestDBSIDResolver_DoesNotCacheFailures(t *testing.T) {
+	store := &failingPrincipalStore{}
+	resolver := newDBSIDResolver(store)
+	sid := testSID(t, "S-1-5-21-1-2-3-1104")
+
+	for range 2 {
+		if _, err := resolver.Resolve(sid); err == nil || !strings.Contains(err.Error(), "principal lookup failed") {
+			t.Fatalf("Resolve error = %v, want the lookup failure", err)
+		}
+	}
+	if store.lookups != 2 {
+		t.Errorf("queried the database %d times, want every failed lookup retried", store.lookups)
+	}
+}
+
+type failingPrincipalStore struct {
+	lookups int
+}
+
+func (s *failingPrincipalStore) GetPrincipalBySID(ctx context.Context, sid string) (sqlcgen.GetPrincipalBySIDRow, error) {
+	s.lookups++
+	return sqlcgen.GetPrincipalBySIDRow{}, errors.New("connection refused")
+}
+
+// fakeSearcher answers objectSid searches from the entries it holds, keyed by LDAP filter.
+type fakeSearcher struct {
+	entries  map[string]*ldap.Entry
+	searches int
+	err      error
+}
+
+func (s *fakeSearcher) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	s.searches++
+	if s.err != nil {
+		return nil, s.err
+	}
+	result := &ldap.SearchResult{}
+	if entry, ok := s.entries[request.Filter]; ok {
+		result.Entries = append(result.Entries, entry)
+	}
+	return result, nil
+}
+
+func TestLDAPSIDResolver(t *testing.T) {
+	user, group, unnamed := testSID(t, "S-1-5-21-1-2-3-1104"), testSID(t, "S-1-5-21-1-2-3-512"), testSID(t, "S-1-5-21-1-2-3-1105")
+	filter := func(sid *gontsd.SID) string { return "(objectSid=" + escapeBinary(sid.Raw) + ")" }
+	searcher := &fakeSearcher{entries: map[string]*ldap.Entry{
+		filter(user): ldap.NewEntry("CN=Alice,DC=example,DC=com", map[string][]string{
+			"sAMAccountName": {"alice"}, "name": {"Alice"}, "distinguishedName": {"CN=Alice,DC=example,DC=com"},
+		}),
+		filter(group): ldap.NewEntry("CN=Domain Admins,DC=example,DC=com", map[string][]string{
+			"name": {"Domain Admins"}, "distinguishedName": {"CN=Domain Admins,DC=example,DC=com"},
+		}),
+		filter(unnamed): ldap.NewEntry("", nil),
+	}}
+	resolver := newLDAPSIDResolver(searcher, "DC=example,DC=com")
+
+	tests := []struct {
+		sid     *gontsd.SID
+		want    string
+		wantErr string
+	}{
+		{user, "alice", ""},
+		{group, "Domain Admins", ""},
+		{unnamed, "", "no name attributes"},
+		{testSID(t, "S-1-5-21-9-9-9-500"), "", "SID not found"},
+	}
+	for _, test := range tests {
+		name, err := resolver.Resolve(test.sid)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("Resolve(%s) error = %v, want %q", test.sid.Value, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil || name != test.want {
+			t.Errorf("Resolve(%s) = %q, %v, want %q", test.sid.Value, name, err, test.want)
+		}
+	}
+
+	searches := searcher.searches
+	resolver.Resolve(user)
+	if searcher.searches != searches {
+		t.Errorf("resolved principal searched again")
+	}
+
+	searcher.err = ldap.NewError(ldap.ErrorNetwork, errors.New("connection reset"))
+	if _, err := resolver.Resolve(testSID(t, "S-1-5-21-1-2-3-1106")); err == nil || !strings.Contains(err.Error(), "LDAP search failed") {
+		t.Errorf("Resolve error = %v, want the search failure", err)
+	}
+}
+
+// fakeGUIDCatalog serves a GUID catalog and counts how often it is listed.
+type fakeGUIDCatalog struct {
+	attributes []sqlcgen.ListAttributeSchemaGUIDsRow
+	classes    []sqlcgen.ListClassSchemaGUIDsRow
+	rights     []sqlcgen.ListExtendedRightsRow
+	loads      int
+}
+
+func (c *fakeGUIDCatalog) ListAttributeSchemaGUIDs(ctx context.Context) ([]sqlcgen.ListAttributeSchemaGUIDsRow, error) {
+	c.loads++
+	return c.attributes, nil
+}
+
+func (c *fakeGUIDCatalog) ListClassSchemaGUIDs(ctx context.Context) ([]sqlcgen.ListClassSchemaGUIDsRow, error) {
+	return c.classes, nil
+}
+
+func (c *fakeGUIDCatalog) ListExtendedRights(ctx context.Context) ([]sqlcgen.ListExtendedRightsRow, error) {
+	return c.rights, nil
+}
+
+func pgUUID(t *testing.T, value string) pgtype.UUID {
+	t.Helper()
+	var id pgtype.UUID
+	if err := id.Scan(value); err != nil {
+		t.Fatalf("invalid UUID %s: %v", value, err)
+	}
+	return id
+}
+
+func TestDBSchemaGUIDResolver(t *testing.T) {
+	const (
+		member        = "bf9679c0-0de6-11d0-a285-00aa003049e2"
+		user          = "bf967aba-0de6-11d0-a285-00aa003049e2"
+		resetPassword = "00299570-246d-11d0-a768-00aa006e0529"
+		selfMember    = "bf9679c0-0de6-11d0-a285-00aa003049e3"
+		personalInfo  = "77b5b886-944a-11d1-aebd-0000f80367c1"
+	)
+	catalog := &fakeGUIDCatalog{
+		attributes: []sqlcgen.ListAttributeSchemaGUIDsRow{{SchemaIDGuid: pgUUID(t, member), LdapDisplayName: "member"}},
+		classes:    []sqlcgen.ListClassSchemaGUIDsRow{{SchemaIDGuid: pgUUID(t, user), LdapDisplayName: "user"}},
+		rights: []sqlcgen.ListExtendedRightsRow{
+			{RightsGuid: pgUUID(t, resetPassword), DisplayName: "Reset Password", ValidAccesses: 256, AppliesTo: []string{user, "4828cc14-1437-45bc-9b07-ad6f015e5f28"}},
+			{RightsGuid: pgUUID(t, selfMember), DisplayName: "Add/Remove self as member", ValidAccesses: 8},
+			{RightsGuid: pgUUID(t, personalInfo), DisplayName: "Personal Information", ValidAccesses: 48},
+		},
+	}
+	resolver := newDBSchemaGUIDResolver(catalog)
+	if err := resolver.load(context.Background()); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+
+	tests := []struct {
+		guid     string
+		wantName string
+		wantType gontsd.GUIDType
+	}{
+		{member, "member", gontsd.GUIDTypeAttribute},
+		{strings.ToUpper(user), "user", gontsd.GUIDTypeClass},
+		{resetPassword, "Reset Password", gontsd.GUIDTypeExtendedRight},
+		{selfMember, "Add/Remove self as member", gontsd.GUIDTypeValidatedWrite},
+		{personalInfo, "Personal Information", gontsd.GUIDTypePropertySet},
+	}
+	for _, test := range tests {
+		info, err := resolver.ResolveGUID(test.guid)
+		if err != nil {
+			t.Errorf("ResolveGUID(%s) failed: %v", test.guid, err)
+			continue
+		}
+		if info.Name != test.wantName || info.Type != test.wantType {
+			t.Errorf("ResolveGUID(%s) = %s (%v), want %s (%v)", test.guid, info.Name, info.Type, test.wantName, test.wantType)
+		}
+		if info.GUID != strings.ToUpper(test.guid) {
+			t.Errorf("ResolveGUID(%s) GUID = %s, want it upper-cased", test.guid, info.GUID)
+		}
+	}
+
+	// classes the right applies to are named when the catalog holds them
+	info, _ := resolver.ResolveGUID(resetPassword)
+	want := []gontsd.AppliesToEntry{{GUID: strings.ToUpper(user), Name: "user"}, {GUID: "4828CC14-1437-45BC-9B07-AD6F015E5F28"}}
+	if len(info.AppliesTo) != len(want) || info.AppliesTo[0] != want[0] || info.AppliesTo[1] != want[1] {
+		t.Errorf("AppliesTo = %v, want %v", info.AppliesTo, want)
+	}
+}
+
+func TestDBSchemaGUIDResolver_ReloadsForUnknownGUIDs(t *testing.T) {
+	const extension = "6f9ab5a5-1b5e-4b0e-8e0c-3c8e5a1f2d7b"
+	catalog := &fakeGUIDCatalog{}
+	resolver := newDBSchemaGUIDResolver(catalog)
+	if err := resolver.load(context.Background()); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+
+	// the poller stores a schema extension after the catalog was loaded
+	catalog.attributes = append(catalog.attributes, sqlcgen.ListAttributeSchemaGUIDsRow{SchemaIDGuid: pgUUID(t, extension), LdapDisplayName: "contoso-BadgeID"})
+
+	if _, err := resolver.ResolveGUID(extension); err == nil {
+		t.Fatal("resolved a GUID added after a fresh catalog was loaded")
+	}
+	if catalog.loads != 1 {
+		t.Errorf("loaded the catalog %d times, want no reload while it is fresh", catalog.loads)
+	}
+
+	resolver.loadedAt = time.Now().Add(-catalogRefreshInterval - time.Second)
+	info, err := resolver.ResolveGUID(extension)
+	if err != nil || info.Name != "contoso-BadgeID" {
+		t.Fatalf("ResolveGUID = %v, %v, want the extension after a reload", info, err)
+	}
+	if catalog.loads != 2 {
+		t.Errorf("loaded the catalog %d times, want 2", catalog.loads)
+	}
+}
@@ -1,14 +1,16 @@
 package web
 
 import (
+	"context"
 	"embed"
 	"io/fs"
 	"log"
 	"net/http"
-	"strings"
 
+	"f0oster/adspy/activedirectory"
 	"f0oster/adspy/config"
 	"f0oster/adspy/database"
+	"f0oster/adspy/database/sqlcgen"
 
 	"github.com/f0oster/gontsd"
 )
@@ -18,49 +20,46 @@ var frontendFS embed.FS
 
 // Server handles HTTP requests for the web interface.
 type Server struct {
-	db          *database.Database
-	mux         *http.ServeMux
-	addr        string
+	db       *database.Database
+	mux      *http.ServeMux
+	addr     string
 	resolver *gontsd.Resolver
 }
 
 // NewServer creates a new web server instance.
 func NewServer(db *database.Database, addr string, cfg config.ADSpyConfiguration) *Server {
-	// Create LDAP client for SID/GUID resolution
-	// gontsd expects full URL with scheme
-	ldapServer := cfg.DcFQDN
-	if !strings.HasPrefix(ldapServer, "ldap://") && !strings.HasPrefix(ldapServer, "ldaps://") {
-		ldapServer = "ldap://" + ldapServer + ":389"
+	// SIDs are resolved from the principals stored by the poller. Optionally, SIDs missing from
+	// the database are looked up over LDAP against each domain in turn, using the same transport
+	// as the poller.
+	sidResolvers := multiSIDResolver{newDBSIDResolver(sqlcgen.New(db.Pool()))}
+	if cfg.WebLDAPSIDLookup {
+		for _, domain := range cfg.Domains {
+			// A lookup tries each domain controller once instead of holding up the request with
+			// reconnect backoff
+			domain.Reconnect.Attempts = 1
+			conn := activedirectory.NewManagedConnection(context.Background(), domain)
+			if err := conn.Connect(); err != nil {
+				log.Printf("Warning: [%s] Could not connect for SID resolution, retrying on lookup: %v", domain.Name, err)
+			} else {
+				log.Printf("[%s] Connected for SID resolution: BaseDN=%s, BindDN=%s", domain.Name, domain.BaseDN, domain.Username)
+			}
+			sidResolvers = append(sidResolvers, newLDAPSIDResolver(conn, domain.BaseDN))
+		}
+		log.Printf("LDAP SID lookup enabled for %d domain(s)", len(sidResolvers)-1)
 	}
-	log.Printf("Creating LDAP client: Server=%s, BaseDN=%s, BindDN=%s", ldapServer, cfg.BaseDN, cfg.Username)
-	ldapClient, err := gontsd.NewLDAPClient(gontsd.LDAPConfig{
-		Server:   ldapServer,
-		BaseDN:   cfg.BaseDN,
-		BindDN:   cfg.Username,
-		Password: cfg.Password,
-		UseTLS:   false,
-	})
 
-	var resolver *gontsd.Resolver
-	if err != nil {
-		log.Printf("Warning: Could not create LDAP client for SID resolution: %v", err)
-		log.Printf("Falling back to well-known SID/GUID resolution only")
-		resolver = gontsd.NewResolver()
-	} else {
-		resolver, err = gontsd.NewLDAPResolver(ldapClient)
-		if err != nil {
-			log.Printf("Warning: Could not create LDAP resolver: %v", err)
-			log.Printf("Falling back to well-known SID/GUID resolution only")
-			resolver = gontsd.NewResolver()
-		} else {
-			log.Printf("LDAP resolver initialized successfully")
-		}
+	// Schema and extended rights GUIDs are resolved from the catalog stored by the poller,
+	// so they resolve even when no DC is reachable
+	guidResolver := newDBSchemaGUIDResolver(sqlcgen.New(db.Pool()))
+	if err := guidResolver.load(context.Background()); err != nil {
+		log.Printf("Warning: Could not load the schema GUID catalog: %v", err)
 	}
+	resolver := gontsd.NewResolverWith(sidResolvers, guidResolver)
 
 	s := &Server{
-		db:          db,
-		mux:         http.NewServeMux(),
-		addr:        addr,
+		db:       db,
+		mux:      http.NewServeMux(),
+		addr:     addr,
 		resolver: resolver,
 	}
 	s.registerRoutes()
@@ -73,9 +72,10 @@ func (s *Server) registerRoutes() {
 	s.mux.HandleFunc("GET /api/objects", s.handleListObjects)
 	s.mux.HandleFunc("GET /api/objects/{id}", s.handleGetObject)
 	s.mux.HandleFunc("GET /api/objects/{id}/timeline", s.handleGetObjectTimeline)
-	s.mux.HandleFunc("GET /api/objects/{id}/versions/{usn}/changes", s.handleGetVersionChanges)
+	s.mux.HandleFunc("GET /api/objects/{id}/versions/{version}/changes", s.handleGetVersionChanges)
 	s.mux.HandleFunc("POST /api/sddiff", s.handleSDDiff)
 	s.mux.HandleFunc("GET /api/object-types", s.handleGetObjectTypes)
+	s.mux.HandleFunc("GET /api/schema-changes", s.handleListSchemaChanges)
 
 	// Static file serving for Svelte app
 	distFS, err := fs.Sub(frontendFS, "frontend/dist")